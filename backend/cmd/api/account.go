@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/export"
+	"github.com/celebthumb-ai/internal/models"
+)
+
+// handleDeleteAccount permanently deletes the caller's account and all its
+// data. The body must be {"confirm": true}. A deletion that fails partway is
+// answered with 202 and finished later by the purge job; calling this again
+// resumes it immediately.
+func (api *API) handleDeleteAccount(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		Confirm bool `json:"confirm"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil || !req.Confirm {
+		return errorResponse(http.StatusBadRequest, "account deletion must be confirmed"), nil
+	}
+
+	deletion, err := api.accountService.DeleteAccount(ctx, user.ID)
+	if deletion == nil {
+		log.Printf("failed to delete account %s: %v", user.ID, err)
+		return errorResponse(http.StatusInternalServerError, "failed to delete account"), nil
+	}
+	if err != nil {
+		log.Printf("account deletion for %s incomplete: %v", user.ID, err)
+	}
+
+	if deletion.Status != models.AccountDeletionCompleted {
+		return jsonResponse(http.StatusAccepted, deletion)
+	}
+	return jsonResponse(http.StatusOK, deletion)
+}
+
+// handleCreateAccountExport starts a "download my data" export: the whole
+// library plus the caller's profile, billing history and collections in
+// account.json. It is tracked like any other export.
+func (api *API) handleCreateAccountExport(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		Notify bool `json:"notify"`
+	}
+	if request.Body != "" {
+		if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+			return errorResponse(http.StatusBadRequest, "invalid request"), nil
+		}
+	}
+
+	e, err := api.exportService.CreateExport(ctx, user, export.Request{
+		Kind:   models.ExportKindAccount,
+		Notify: req.Notify,
+	})
+	if err != nil {
+		return serviceErrorResponse(err, "failed to create export"), nil
+	}
+
+	return jsonResponse(http.StatusAccepted, e)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/apierror"
+	"github.com/celebthumb-ai/internal/audit"
+	"github.com/celebthumb-ai/internal/auth"
+	"github.com/celebthumb-ai/internal/billing"
+	"github.com/celebthumb-ai/internal/models"
+)
+
+// adminAction is the audit context of an in-flight admin request
+type adminAction struct {
+	api   *API
+	actor *models.User
+	entry audit.Entry
+}
+
+// authorizeAdmin authenticates the caller and checks permission, auditing
+// denied attempts as well
+func (api *API) authorizeAdmin(ctx context.Context, request events.APIGatewayProxyRequest, action string, permission auth.Permission) (*adminAction, *events.APIGatewayProxyResponse) {
+	actor, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	a := &adminAction{
+		api:   api,
+		actor: actor,
+		entry: audit.Entry{
+			ActorID:      actor.ID,
+			ActorEmail:   actor.Email,
+			Action:       action,
+			TargetUserID: request.PathParameters["id"],
+		},
+	}
+
+	if err := auth.Authorize(actor, permission); err != nil {
+		a.record(ctx, audit.OutcomeDenied)
+		resp := errorResponse(http.StatusForbidden, "forbidden")
+		return nil, &resp
+	}
+
+	return a, nil
+}
+
+func (a *adminAction) record(ctx context.Context, outcome string) {
+	a.entry.Outcome = outcome
+	if err := a.api.auditLogger.Record(ctx, &a.entry); err != nil {
+		log.Printf("failed to record audit entry: %v", err)
+	}
+}
+
+func (api *API) handleAdminFindUser(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	action, errResp := api.authorizeAdmin(ctx, request, "users.find", auth.PermissionReadUsers)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	email := request.QueryStringParameters["email"]
+	if email == "" {
+		return validationResponse(apierror.Field("email", "is required")), nil
+	}
+	action.entry.Details = map[string]string{"email": email}
+
+	user, err := api.billingService.FindUserByEmail(ctx, email)
+	if errors.Is(err, billing.ErrUserNotFound) {
+		user, err = api.authService.FindUserByEmail(ctx, email)
+		accountWithoutBilling(user)
+	}
+	if err != nil {
+		action.record(ctx, audit.OutcomeFailed)
+		return adminErrorResponse(err, "failed to find user"), nil
+	}
+
+	action.entry.TargetUserID = user.ID
+	action.record(ctx, audit.OutcomeSuccess)
+	return jsonResponse(http.StatusOK, user)
+}
+
+func (api *API) handleAdminGetUser(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	action, errResp := api.authorizeAdmin(ctx, request, "users.get", auth.PermissionReadUsers)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	user, err := api.billingService.GetUser(ctx, request.PathParameters["id"])
+	if errors.Is(err, billing.ErrUserNotFound) {
+		user, err = api.authService.GetUser(ctx, request.PathParameters["id"])
+		accountWithoutBilling(user)
+	}
+	if err != nil {
+		action.record(ctx, audit.OutcomeFailed)
+		return adminErrorResponse(err, "failed to get user"), nil
+	}
+
+	action.record(ctx, audit.OutcomeSuccess)
+	return jsonResponse(http.StatusOK, user)
+}
+
+func (api *API) handleAdminAdjustCredits(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	action, errResp := api.authorizeAdmin(ctx, request, "credits.adjust", auth.PermissionAdjustCredits)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		Amount int    `json:"amount"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+	if fields := requiredFields(map[string]bool{"amount": req.Amount != 0, "reason": req.Reason != ""}); fields != nil {
+		return validationResponse(fields...), nil
+	}
+	action.entry.Reason = req.Reason
+	action.entry.Details = map[string]string{"amount": strconv.Itoa(req.Amount)}
+
+	credits, err := api.billingService.AdjustCredits(ctx, request.PathParameters["id"], req.Amount, req.Reason, action.actor.ID)
+	if err != nil {
+		action.record(ctx, audit.OutcomeFailed)
+		return adminErrorResponse(err, "failed to adjust credits"), nil
+	}
+
+	action.entry.Details["balance"] = strconv.Itoa(credits)
+	action.record(ctx, audit.OutcomeSuccess)
+	return jsonResponse(http.StatusOK, map[string]int{"credits": credits})
+}
+
+func (api *API) handleAdminChangePlan(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	action, errResp := api.authorizeAdmin(ctx, request, "plans.change", auth.PermissionChangePlan)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		PlanID string `json:"planId"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+	if fields := requiredFields(map[string]bool{"planId": req.PlanID != "", "reason": req.Reason != ""}); fields != nil {
+		return validationResponse(fields...), nil
+	}
+	action.entry.Reason = req.Reason
+	action.entry.Details = map[string]string{"planId": req.PlanID}
+
+	user, err := api.billingService.ChangePlan(ctx, request.PathParameters["id"], req.PlanID)
+	if err != nil {
+		action.record(ctx, audit.OutcomeFailed)
+		return adminErrorResponse(err, "failed to change plan"), nil
+	}
+
+	action.record(ctx, audit.OutcomeSuccess)
+	return jsonResponse(http.StatusOK, user)
+}
+
+// handleAdminDisableUser stops the account from signing in or refreshing.
+// With Cognito, ID tokens already issued stay valid until they expire.
+func (api *API) handleAdminDisableUser(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	action, errResp := api.authorizeAdmin(ctx, request, "users.disable", auth.PermissionDisableUsers)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+	if req.Reason == "" {
+		return validationResponse(apierror.Field("reason", "is required")), nil
+	}
+	action.entry.Reason = req.Reason
+
+	if err := api.authService.DisableUser(ctx, request.PathParameters["id"]); err != nil {
+		action.record(ctx, audit.OutcomeFailed)
+		return adminErrorResponse(err, "failed to disable user"), nil
+	}
+
+	action.record(ctx, audit.OutcomeSuccess)
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+	}, nil
+}
+
+func (api *API) handleAdminListThumbnails(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	action, errResp := api.authorizeAdmin(ctx, request, "thumbnails.list", auth.PermissionReadThumbnails)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	thumbnails, err := api.storageService.ListUserThumbnails(ctx, request.PathParameters["id"])
+	if err != nil {
+		action.record(ctx, audit.OutcomeFailed)
+		return adminErrorResponse(err, "failed to list thumbnails"), nil
+	}
+
+	action.record(ctx, audit.OutcomeSuccess)
+	return jsonResponse(http.StatusOK, thumbnails)
+}
+
+// accountWithoutBilling fills in what the billing service reports for users
+// who never subscribed, who only exist in the identity provider
+func accountWithoutBilling(user *models.User) {
+	if user != nil {
+		user.Plan = billing.Plans["free"].ID
+	}
+}
+
+// adminErrors overrides serviceErrors where an admin action means something
+// else: an adjustment that would overdraw the balance is a conflict, not a
+// payment problem
+var adminErrors = []apierror.Mapping{
+	{Err: billing.ErrInsufficientCredits, Status: http.StatusConflict, Code: apierror.CodeInsufficientCredits, Message: "credits can't go below zero"},
+}
+
+func adminErrorResponse(err error, message string) events.APIGatewayProxyResponse {
+	return translateError(err, message, adminErrors, serviceErrors).Response()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/apierror"
+	"github.com/celebthumb-ai/internal/asset"
+	"github.com/celebthumb-ai/internal/storage"
+)
+
+// maxSourceAssets caps the source images one generation request can use
+const maxSourceAssets = 5
+
+// handleCreateAsset validates a finished upload and adds it to the caller's
+// asset library with its metadata stripped. An image already stored by anyone
+// is kept once and shared.
+func (api *API) handleCreateAsset(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+	// Only the caller's own uploads can be imported
+	if !strings.HasPrefix(req.Key, fmt.Sprintf("uploads/%s/", user.ID)) {
+		return errorResponse(http.StatusBadRequest, "invalid upload key"), nil
+	}
+
+	a, err := api.assetService.Import(ctx, asset.UserRef(user.ID), req.Key)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to create asset"), nil
+	}
+
+	return jsonResponse(http.StatusCreated, a)
+}
+
+func (api *API) handleListAssets(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	assets, err := api.assetService.List(ctx, asset.UserRef(user.ID))
+	if err != nil {
+		return serviceErrorResponse(err, "failed to list assets"), nil
+	}
+
+	return jsonResponse(http.StatusOK, assets)
+}
+
+// handleDeleteAsset removes an asset from the caller's library. The stored
+// image is only deleted once no thumbnail or other library still uses it.
+func (api *API) handleDeleteAsset(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+	hash := request.PathParameters["id"]
+
+	if err := api.checkAssets(ctx, user.ID, []string{hash}); err != nil {
+		return serviceErrorResponse(err, "failed to delete asset"), nil
+	}
+	if err := api.assetService.RemoveRef(ctx, asset.UserRef(user.ID), hash); err != nil {
+		return serviceErrorResponse(err, "failed to delete asset"), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+	}, nil
+}
+
+// checkAssets fails with ErrAssetNotFound unless every id is in the user's
+// asset library
+func (api *API) checkAssets(ctx context.Context, userID string, ids []string) error {
+	for _, id := range ids {
+		if !asset.IsHash(id) {
+			return asset.ErrAssetNotFound
+		}
+		ok, err := api.assetService.HasRef(ctx, asset.UserRef(userID), id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return asset.ErrAssetNotFound
+		}
+	}
+	return nil
+}
+
+// sourceImages reads the source assets a thumbnail is generated from
+func (api *API) sourceImages(ctx context.Context, ids []string) ([][]byte, error) {
+	images := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		object, err := api.assetService.Open(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(object.Body)
+		object.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read asset: %w", err)
+		}
+		images = append(images, data)
+	}
+	return images, nil
+}
+
+// releaseAssets undoes the asset references of a thumbnail that wasn't saved
+func (api *API) releaseAssets(ctx context.Context, thumbnailID string) {
+	if err := api.assetService.ReleaseThumbnail(ctx, thumbnailID); err != nil {
+		log.Printf("failed to release assets of thumbnail %s: %v", thumbnailID, err)
+	}
+}
+
+// handleListSimilar finds thumbnails in the caller's library that look like
+// the given one. ?distance= widens or narrows the match.
+func (api *API) handleListSimilar(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	distance := storage.NearDuplicateDistance
+	if value := request.QueryStringParameters["distance"]; value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > storage.MaxSimilarDistance {
+			return validationResponse(apierror.Field("distance", fmt.Sprintf("must be between 0 and %d", storage.MaxSimilarDistance))), nil
+		}
+		distance = n
+	}
+
+	similar, err := api.storageService.FindSimilar(ctx, user.ID, request.PathParameters["id"], distance)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to find similar thumbnails"), nil
+	}
+
+	return jsonResponse(http.StatusOK, similar)
+}
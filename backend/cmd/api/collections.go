@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/collection"
+)
+
+func (api *API) handleCreateCollection(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil || req.Name == "" {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	c, err := api.collectionService.CreateCollection(ctx, user.ID, req.Name, req.Description)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to create collection"), nil
+	}
+
+	return jsonResponse(http.StatusCreated, c)
+}
+
+func (api *API) handleListCollections(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	collections, err := api.collectionService.ListCollections(ctx, user.ID)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to list collections"), nil
+	}
+
+	return jsonResponse(http.StatusOK, collections)
+}
+
+func (api *API) handleGetCollection(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	c, err := api.collectionService.GetCollection(ctx, user.ID, request.PathParameters["id"])
+	if err != nil {
+		return serviceErrorResponse(err, "failed to get collection"), nil
+	}
+
+	return jsonResponse(http.StatusOK, c)
+}
+
+// handleUpdateCollection renames a collection, changes its description or sets
+// its cover; omitted fields are left unchanged
+func (api *API) handleUpdateCollection(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		Name             *string `json:"name"`
+		Description      *string `json:"description"`
+		CoverThumbnailID *string `json:"coverThumbnailId"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil || (req.Name != nil && *req.Name == "") {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	c, err := api.collectionService.UpdateCollection(ctx, user.ID, request.PathParameters["id"], collection.Update{
+		Name:             req.Name,
+		Description:      req.Description,
+		CoverThumbnailID: req.CoverThumbnailID,
+	})
+	if err != nil {
+		return serviceErrorResponse(err, "failed to update collection"), nil
+	}
+
+	return jsonResponse(http.StatusOK, c)
+}
+
+// handleReorderCollections takes every collection id in the order to show them
+func (api *API) handleReorderCollections(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		CollectionIDs []string `json:"collectionIds"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	collections, err := api.collectionService.ReorderCollections(ctx, user.ID, req.CollectionIDs)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to reorder collections"), nil
+	}
+
+	return jsonResponse(http.StatusOK, collections)
+}
+
+// handleDeleteCollection removes the collection but keeps its thumbnails
+func (api *API) handleDeleteCollection(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	if err := api.collectionService.DeleteCollection(ctx, user.ID, request.PathParameters["id"]); err != nil {
+		return serviceErrorResponse(err, "failed to delete collection"), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+	}, nil
+}
+
+// handleCollectionThumbnails adds (POST) or removes (DELETE) thumbnails in bulk
+func (api *API) handleCollectionThumbnails(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		ThumbnailIDs []string `json:"thumbnailIds"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil || len(req.ThumbnailIDs) == 0 {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	collectionID := request.PathParameters["id"]
+	var err error
+	if request.HTTPMethod == "DELETE" {
+		err = api.collectionService.RemoveThumbnails(ctx, user.ID, collectionID, req.ThumbnailIDs)
+	} else {
+		err = api.collectionService.AddThumbnails(ctx, user.ID, collectionID, req.ThumbnailIDs)
+	}
+	if err != nil {
+		return serviceErrorResponse(err, "failed to update collection"), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+	}, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/apierror"
+	"github.com/celebthumb-ai/internal/asset"
+	"github.com/celebthumb-ai/internal/auth"
+	"github.com/celebthumb-ai/internal/billing"
+	"github.com/celebthumb-ai/internal/collection"
+	"github.com/celebthumb-ai/internal/export"
+	"github.com/celebthumb-ai/internal/organization"
+	"github.com/celebthumb-ai/internal/rendition"
+	"github.com/celebthumb-ai/internal/router"
+	"github.com/celebthumb-ai/internal/search"
+	"github.com/celebthumb-ai/internal/share"
+	"github.com/celebthumb-ai/internal/storage"
+)
+
+// serviceErrors translates the services' sentinel errors. Where one error
+// wraps another, the more specific one has to come first.
+var serviceErrors = []apierror.Mapping{
+	{Err: auth.ErrInvalidToken, Status: http.StatusUnauthorized, Code: apierror.CodeInvalidToken, Message: "invalid token"},
+	{Err: auth.ErrExpiredToken, Status: http.StatusUnauthorized, Code: apierror.CodeTokenExpired, Message: "token expired"},
+	{Err: auth.ErrInvalidCredentials, Status: http.StatusUnauthorized, Code: apierror.CodeInvalidCredentials, Message: "invalid credentials"},
+	{Err: auth.ErrInvalidPassword, Status: http.StatusBadRequest, Code: apierror.CodeWeakPassword, Message: "password does not meet requirements"},
+	{Err: auth.ErrUserExists, Status: http.StatusConflict, Code: apierror.CodeUserExists, Message: "user already exists"},
+	{Err: auth.ErrUserNotFound, Status: http.StatusNotFound, Code: apierror.CodeUserNotFound, Message: "user not found"},
+	{Err: auth.ErrInvalidMFACode, Status: http.StatusUnauthorized, Code: apierror.CodeInvalidMFACode, Message: "invalid MFA code"},
+	{Err: auth.ErrInvalidSession, Status: http.StatusUnauthorized, Code: apierror.CodeInvalidSession, Message: "challenge session expired"},
+	{Err: auth.ErrMFASetupRequired, Status: http.StatusForbidden, Code: apierror.CodeMFASetupRequired, Message: "MFA setup required; log in again to enroll"},
+	{Err: auth.ErrUnsupportedChallenge, Status: http.StatusBadRequest, Code: apierror.CodeUnsupportedChallenge, Message: "unsupported challenge"},
+	{Err: auth.ErrInvalidOIDCState, Status: http.StatusBadRequest, Code: apierror.CodeInvalidSignInState, Message: "missing or invalid sign-in state"},
+	{Err: auth.ErrUnknownOIDCProvider, Status: http.StatusNotFound, Code: apierror.CodeUnknownProvider, Message: "unknown identity provider"},
+	{Err: auth.ErrPasswordSignInRequired, Status: http.StatusForbidden, Code: apierror.CodePasswordSignInRequired, Message: "this account has MFA enabled; sign in with your password"},
+	{Err: auth.ErrForbidden, Status: http.StatusForbidden, Code: apierror.CodeForbidden, Message: "forbidden"},
+
+	{Err: billing.ErrInsufficientCredits, Status: http.StatusPaymentRequired, Code: apierror.CodeInsufficientCredits, Message: "insufficient credits"},
+	{Err: billing.ErrInvalidPlan, Status: http.StatusBadRequest, Code: apierror.CodeInvalidPlan, Message: "invalid plan"},
+	{Err: billing.ErrUserNotFound, Status: http.StatusNotFound, Code: apierror.CodeUserNotFound, Message: "user not found"},
+
+	{Err: storage.ErrThumbnailNotFound, Status: http.StatusNotFound, Code: apierror.CodeThumbnailNotFound, Message: "thumbnail not found"},
+	{Err: storage.ErrRevisionNotFound, Status: http.StatusNotFound, Code: apierror.CodeRevisionNotFound, Message: "revision not found"},
+	{Err: storage.ErrRevisionConflict, Status: http.StatusConflict, Code: apierror.CodeRevisionConflict, Message: "thumbnail was modified, try again"},
+	{Err: storage.ErrQuotaExceeded, Status: http.StatusInsufficientStorage, Code: apierror.CodeQuotaExceeded, Message: "storage quota exceeded; delete thumbnails or upgrade your plan"},
+	{Err: storage.ErrInvalidCursor, Status: http.StatusBadRequest, Code: apierror.CodeInvalidCursor, Message: "invalid cursor"},
+	{Err: storage.ErrInvalidRange, Status: http.StatusRequestedRangeNotSatisfiable, Code: apierror.CodeRangeNotSatisfiable, Message: "range not satisfiable"},
+	{Err: storage.ErrInvalidTags, Status: http.StatusBadRequest, Code: apierror.CodeInvalidTags, Message: "invalid tags"},
+	{Err: search.ErrEmptyQuery, Status: http.StatusBadRequest, Code: apierror.CodeEmptyQuery, Message: "query has no searchable words"},
+
+	{Err: asset.ErrAssetNotFound, Status: http.StatusNotFound, Code: apierror.CodeAssetNotFound, Message: "asset not found"},
+	{Err: asset.ErrInvalidUpload, Status: http.StatusBadRequest, Code: apierror.CodeUploadNotFound, Message: "upload not found"},
+	{Err: rendition.ErrUnsupportedFormat, Status: http.StatusUnsupportedMediaType, Code: apierror.CodeUnsupportedMediaType, Message: "image must be a JPEG or PNG"},
+	{Err: rendition.ErrContentTypeMismatch, Status: http.StatusBadRequest, Code: apierror.CodeContentTypeMismatch, Message: "image content does not match its content type"},
+	{Err: rendition.ErrImageTooLarge, Status: http.StatusBadRequest, Code: apierror.CodeImageTooLarge, Message: fmt.Sprintf("image must be at most %d pixels on each side and %d megapixels in total", rendition.MaxSourceDimension, rendition.MaxSourcePixels/1_000_000)},
+	{Err: asset.ErrInvalidImage, Status: http.StatusBadRequest, Code: apierror.CodeInvalidImage, Message: "invalid image"},
+
+	{Err: collection.ErrCollectionNotFound, Status: http.StatusNotFound, Code: apierror.CodeCollectionNotFound, Message: "collection not found"},
+	{Err: collection.ErrThumbnailNotFound, Status: http.StatusNotFound, Code: apierror.CodeThumbnailNotFound, Message: "thumbnail not found"},
+	{Err: collection.ErrTooManyThumbnails, Status: http.StatusBadRequest, Code: apierror.CodeTooManyThumbnails, Message: "too many thumbnails"},
+	{Err: collection.ErrInvalidOrder, Status: http.StatusBadRequest, Code: apierror.CodeInvalidOrder, Message: "order must list every collection once"},
+
+	{Err: export.ErrExportNotFound, Status: http.StatusNotFound, Code: apierror.CodeExportNotFound, Message: "export not found"},
+	{Err: export.ErrExportInProgress, Status: http.StatusConflict, Code: apierror.CodeExportInProgress, Message: "an export is already in progress"},
+
+	{Err: organization.ErrOrganizationNotFound, Status: http.StatusNotFound, Code: apierror.CodeOrganizationNotFound, Message: "organization not found"},
+	{Err: organization.ErrNotOrganizationAdmin, Status: http.StatusForbidden, Code: apierror.CodeNotOrganizationAdmin, Message: "organization admin required"},
+	{Err: organization.ErrUserNotFound, Status: http.StatusNotFound, Code: apierror.CodeUserNotFound, Message: "user not found"},
+	{Err: organization.ErrAlreadyMember, Status: http.StatusConflict, Code: apierror.CodeAlreadyInOrganization, Message: "user already belongs to an organization"},
+	{Err: organization.ErrInviteNotFound, Status: http.StatusNotFound, Code: apierror.CodeInviteNotFound, Message: "no invite to this organization"},
+	{Err: organization.ErrNotMember, Status: http.StatusNotFound, Code: apierror.CodeNotOrganizationMember, Message: "you don't belong to this organization"},
+	{Err: organization.ErrLastAdmin, Status: http.StatusConflict, Code: apierror.CodeLastOrganizationAdmin, Message: "the last admin can't leave the organization"},
+
+	{Err: share.ErrShareNotFound, Status: http.StatusNotFound, Code: apierror.CodeShareNotFound, Message: "share link not found"},
+	{Err: share.ErrTargetNotFound, Status: http.StatusNotFound, Code: apierror.CodeSharedItemNotFound, Message: "shared item not found"},
+	{Err: share.ErrShareExpired, Status: http.StatusGone, Code: apierror.CodeShareExpired, Message: "share link has expired"},
+	{Err: share.ErrViewLimitReached, Status: http.StatusGone, Code: apierror.CodeViewLimitReached, Message: "share link view limit reached"},
+	{Err: share.ErrPasswordRequired, Status: http.StatusUnauthorized, Code: apierror.CodePasswordRequired, Message: "password required"},
+	{Err: share.ErrInvalidPassword, Status: http.StatusForbidden, Code: apierror.CodeInvalidSharePassword, Message: "invalid password"},
+	{Err: share.ErrInvalidShare, Status: http.StatusBadRequest, Code: apierror.CodeInvalidShare, Message: "invalid share link settings"},
+}
+
+// errorResponse answers with the generic code for statusCode. Prefer
+// serviceErrorResponse for service errors, which carry specific codes.
+func errorResponse(statusCode int, message string) events.APIGatewayProxyResponse {
+	return apierror.FromStatus(statusCode, message).Response()
+}
+
+// serviceErrorResponse translates a service error through serviceErrors;
+// unknown errors become a 500 with message
+func serviceErrorResponse(err error, message string) events.APIGatewayProxyResponse {
+	return translateError(err, message, serviceErrors).Response()
+}
+
+// translateError is apierror.Translate for handlers. The client only sees
+// message for a server error, so the error behind it is logged here.
+func translateError(err error, message string, tables ...[]apierror.Mapping) *apierror.Error {
+	apiErr := apierror.Translate(err, message, tables...)
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Printf("%s: %v", message, err)
+	}
+	return apiErr
+}
+
+// validationResponse reports invalid request fields
+func validationResponse(fields ...apierror.FieldError) events.APIGatewayProxyResponse {
+	return apierror.Validation(fields...).Response()
+}
+
+// requiredFields reports the fields whose presence check failed, in name
+// order, or nil when all are present
+func requiredFields(present map[string]bool) []apierror.FieldError {
+	var fields []apierror.FieldError
+	for name, ok := range present {
+		if !ok {
+			fields = append(fields, apierror.Field(name, "is required"))
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return fields
+}
+
+// withRequestID tags every response, and the body of every error, with the
+// gateway's request ID so that client reports can be matched to logs
+func withRequestID(next router.Handler) router.Handler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		response, err := next(ctx, request)
+		return apierror.WithRequestID(response, request.RequestContext.RequestID), err
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/apierror"
+	"github.com/celebthumb-ai/internal/export"
+)
+
+// handleCreateExport starts a ZIP export of the caller's library, optionally
+// limited to a collection and an RFC 3339 creation date range. With notify set
+// the download link is also emailed when the archive is ready.
+func (api *API) handleCreateExport(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		CollectionID string     `json:"collectionId"`
+		From         *time.Time `json:"from"`
+		To           *time.Time `json:"to"`
+		Notify       bool       `json:"notify"`
+	}
+	if request.Body != "" {
+		if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+			return errorResponse(http.StatusBadRequest, "invalid request"), nil
+		}
+	}
+	if req.From != nil && req.To != nil && req.From.After(*req.To) {
+		return validationResponse(apierror.Field("from", "must not be after to")), nil
+	}
+
+	if req.CollectionID != "" {
+		_, err := api.collectionService.GetCollection(ctx, user.ID, req.CollectionID)
+		if err != nil {
+			return serviceErrorResponse(err, "failed to create export"), nil
+		}
+	}
+
+	e, err := api.exportService.CreateExport(ctx, user, export.Request{
+		CollectionID: req.CollectionID,
+		From:         req.From,
+		To:           req.To,
+		Notify:       req.Notify,
+	})
+	if err != nil {
+		return serviceErrorResponse(err, "failed to create export"), nil
+	}
+
+	return jsonResponse(http.StatusAccepted, e)
+}
+
+func (api *API) handleListExports(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	exports, err := api.exportService.ListExports(ctx, user.ID)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to list exports"), nil
+	}
+
+	return jsonResponse(http.StatusOK, exports)
+}
+
+// handleGetExport reports an export's progress, with a download link once it
+// has completed
+func (api *API) handleGetExport(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	e, err := api.exportService.GetExport(ctx, user.ID, request.PathParameters["id"])
+	if err != nil {
+		return serviceErrorResponse(err, "failed to get export"), nil
+	}
+
+	return jsonResponse(http.StatusOK, e)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// localTable describes a table to create in DynamoDB Local. Keys and indexes
+// mirror infrastructure/stacks/storage.ts.
+type localTable struct {
+	env     string
+	key     []localKey
+	indexes map[string][]localKey
+}
+
+type localKey struct {
+	name string
+	kind types.ScalarAttributeType
+}
+
+func str(name string) localKey { return localKey{name, types.ScalarAttributeTypeS} }
+func num(name string) localKey { return localKey{name, types.ScalarAttributeTypeN} }
+
+var localTables = []localTable{
+	{env: "USERS_TABLE", key: []localKey{str("id")}, indexes: map[string][]localKey{
+		"byEmail": {str("email")},
+	}},
+	{env: "THUMBNAILS_TABLE", key: []localKey{str("id")}, indexes: map[string][]localKey{
+		"byUser": {str("userId"), str("createdAt")},
+		"trash":  {str("status"), str("deletedAt")},
+	}},
+	{env: "THUMBNAIL_REVISIONS_TABLE", key: []localKey{str("thumbnailId"), num("revision")}},
+	{env: "COLLECTIONS_TABLE", key: []localKey{str("id")}, indexes: map[string][]localKey{
+		"byUser": {str("userId"), num("position")},
+	}},
+	{env: "SEARCH_INDEX_TABLE", key: []localKey{str("userId"), str("entry")}, indexes: map[string][]localKey{
+		"byThumbnail": {str("thumbnailId")},
+	}},
+	{env: "EXPORTS_TABLE", key: []localKey{str("id")}, indexes: map[string][]localKey{
+		"byUser": {str("userId"), str("createdAt")},
+	}},
+	{env: "SHARES_TABLE", key: []localKey{str("token")}, indexes: map[string][]localKey{
+		"byUser": {str("userId"), str("createdAt")},
+	}},
+	{env: "ORGANIZATIONS_TABLE", key: []localKey{str("id")}},
+	{env: "RATE_LIMIT_TABLE", key: []localKey{str("id")}},
+	{env: "AUDIT_LOG_TABLE", key: []localKey{str("id")}, indexes: map[string][]localKey{
+		"byTarget": {str("targetUserId"), str("createdAt")},
+	}},
+	{env: "CREDIT_LEDGER_TABLE", key: []localKey{str("userId"), str("id")}},
+	{env: "ACCOUNT_DELETIONS_TABLE", key: []localKey{str("userId")}, indexes: map[string][]localKey{
+		"byStatus": {str("status")},
+	}},
+	{env: "USAGE_TABLE", key: []localKey{str("userId")}},
+	{env: "ASSETS_TABLE", key: []localKey{str("hash")}},
+	{env: "ASSET_REFS_TABLE", key: []localKey{str("ref"), str("hash")}},
+}
+
+// bootstrapLocal creates the bucket and tables the local profile points at,
+// skipping any that already exist
+func bootstrapLocal(ctx context.Context) error {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	if os.Getenv("DYNAMODB_ENDPOINT") != "" {
+		dynamoClient := newDynamoClient(cfg)
+		for _, table := range localTables {
+			if err := createLocalTable(ctx, dynamoClient, table); err != nil {
+				return err
+			}
+		}
+	}
+
+	bucket := os.Getenv("THUMBNAIL_BUCKET")
+	if os.Getenv("S3_ENDPOINT") != "" && bucket != "" {
+		s3Client := newS3Client(cfg)
+		if _, err := s3Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)}); err != nil {
+			if _, err := s3Client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+				return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+			}
+			log.Printf("created bucket %s", bucket)
+		}
+	}
+
+	return nil
+}
+
+func createLocalTable(ctx context.Context, dynamoClient *dynamodb.Client, table localTable) error {
+	name := os.Getenv(table.env)
+	if name == "" {
+		return nil
+	}
+
+	definitions := map[string]types.ScalarAttributeType{}
+	keySchema := func(keys []localKey) []types.KeySchemaElement {
+		schema := []types.KeySchemaElement{}
+		for i, key := range keys {
+			keyType := types.KeyTypeHash
+			if i > 0 {
+				keyType = types.KeyTypeRange
+			}
+			schema = append(schema, types.KeySchemaElement{
+				AttributeName: aws.String(key.name),
+				KeyType:       keyType,
+			})
+			definitions[key.name] = key.kind
+		}
+		return schema
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName:   aws.String(name),
+		BillingMode: types.BillingModePayPerRequest,
+		KeySchema:   keySchema(table.key),
+	}
+	for indexName, keys := range table.indexes {
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, types.GlobalSecondaryIndex{
+			IndexName:  aws.String(indexName),
+			KeySchema:  keySchema(keys),
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		})
+	}
+	for attribute, kind := range definitions {
+		input.AttributeDefinitions = append(input.AttributeDefinitions, types.AttributeDefinition{
+			AttributeName: aws.String(attribute),
+			AttributeType: kind,
+		})
+	}
+
+	_, err := dynamoClient.CreateTable(ctx, input)
+	var inUse *types.ResourceInUseException
+	if errors.As(err, &inUse) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", name, err)
+	}
+
+	log.Printf("created table %s", name)
+	return nil
+}
@@ -2,156 +2,531 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/rekognition"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/sagemaker"
+	"github.com/celebthumb-ai/internal/account"
 	"github.com/celebthumb-ai/internal/ai"
+	"github.com/celebthumb-ai/internal/apierror"
+	"github.com/celebthumb-ai/internal/asset"
+	"github.com/celebthumb-ai/internal/audit"
 	"github.com/celebthumb-ai/internal/auth"
 	"github.com/celebthumb-ai/internal/billing"
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/celebthumb-ai/internal/collection"
+	"github.com/celebthumb-ai/internal/export"
 	"github.com/celebthumb-ai/internal/models"
+	"github.com/celebthumb-ai/internal/organization"
+	"github.com/celebthumb-ai/internal/ratelimit"
+	"github.com/celebthumb-ai/internal/router"
+	"github.com/celebthumb-ai/internal/search"
+	"github.com/celebthumb-ai/internal/share"
 	"github.com/celebthumb-ai/internal/storage"
 )
 
+// anonymousLimit applies to requests that can only be identified by client IP
+var anonymousLimit = ratelimit.Limit{RequestsPerMinute: 20, Burst: 5}
+
 type API struct {
-	aiService      *ai.AIService
-	storageService *storage.StorageService
-	billingService *billing.BillingService
-	authService    *auth.AuthService
+	aiService         *ai.AIService
+	storageService    *storage.StorageService
+	billingService    *billing.BillingService
+	authService       *auth.AuthService
+	orgService        *organization.OrganizationService
+	collectionService *collection.CollectionService
+	searchIndex       *search.Index
+	exportService     *export.ExportService
+	accountService    *account.AccountService
+	assetService      *asset.AssetService
+	shareService      *share.ShareService
+	auditLogger       *audit.AuditLogger
+	rateLimiter       *ratelimit.Limiter
+	// oidcStateKey signs the federated login flow cookie
+	oidcStateKey []byte
+	// blobStore is nil when images are stored in S3
+	blobStore blob.Store
+	router    *router.Router
 }
 
-func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// Initialize AWS SDK clients
+// newAPI builds the clients and services once per cold start. Warm
+// invocations reuse them, along with the cached JWK set and rate limit
+// buckets.
+func newAPI(ctx context.Context) (*API, error) {
 	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
-		return errorResponse(http.StatusInternalServerError, "failed to load AWS config"), nil
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	dynamoClient := newDynamoClient(cfg)
+
+	identityProvider, err := newIdentityProvider()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize identity provider: %w", err)
+	}
+
+	blobStore, err := newBlobStore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize blob store: %w", err)
 	}
 
+	orgService := organization.NewOrganizationService(organization.OrganizationConfig{
+		DynamoClient: dynamoClient,
+		TableName:    os.Getenv("ORGANIZATIONS_TABLE"),
+		UsersTable:   os.Getenv("USERS_TABLE"),
+	})
+
+	searchIndex := search.NewIndex(search.IndexConfig{
+		DynamoClient: dynamoClient,
+		TableName:    os.Getenv("SEARCH_INDEX_TABLE"),
+	})
+
+	billingService := billing.NewBillingService(billing.BillingConfig{
+		DynamoClient: dynamoClient,
+		TableName:    os.Getenv("USERS_TABLE"),
+		StripeKey:    os.Getenv("STRIPE_SECRET_KEY"),
+		LedgerTable:  os.Getenv("CREDIT_LEDGER_TABLE"),
+	})
+
+	assetService := asset.NewAssetService(asset.AssetConfig{
+		DynamoClient: dynamoClient,
+		Store:        blobStore,
+		TableName:    os.Getenv("ASSETS_TABLE"),
+		RefsTable:    os.Getenv("ASSET_REFS_TABLE"),
+	})
+
+	storageService := storage.NewStorageService(storage.StorageConfig{
+		Store:           blobStore,
+		S3Client:        newS3Client(cfg),
+		Bucket:          os.Getenv("THUMBNAIL_BUCKET"),
+		DynamoClient:    dynamoClient,
+		ThumbnailsTable: os.Getenv("THUMBNAILS_TABLE"),
+		RevisionsTable:  os.Getenv("THUMBNAIL_REVISIONS_TABLE"),
+		Indexer:         searchIndex,
+		UsageTable:      os.Getenv("USAGE_TABLE"),
+		Quotas:          billingService,
+		Assets:          assetService,
+	})
+
 	// Initialize services
 	api := &API{
 		aiService: ai.NewAIService(ai.AIConfig{
 			RekognitionClient: rekognition.NewFromConfig(cfg),
-			SagemakerClient:  sagemaker.NewFromConfig(cfg),
-		}),
-		storageService: storage.NewStorageService(storage.StorageConfig{
-			S3Client: s3.NewFromConfig(cfg),
-			Bucket:   os.Getenv("THUMBNAIL_BUCKET"),
-		}),
-		billingService: billing.NewBillingService(billing.BillingConfig{
-			DynamoClient: dynamodb.NewFromConfig(cfg),
-			TableName:    os.Getenv("USERS_TABLE"),
-			StripeKey:    os.Getenv("STRIPE_SECRET_KEY"),
 		}),
+		storageService: storageService,
+		billingService: billingService,
 		authService: auth.NewAuthService(auth.AuthConfig{
+			Provider:      identityProvider,
 			CognitoClient: cognitoidentityprovider.NewFromConfig(cfg),
 			UserPoolID:    os.Getenv("USER_POOL_ID"),
 			ClientID:      os.Getenv("USER_POOL_CLIENT_ID"),
+			MFAPolicy:     orgService,
+			OIDCClients:   newOIDCClients(),
+			RoleStore: auth.NewDynamoRoleStore(auth.DynamoRoleStoreConfig{
+				DynamoClient: dynamoClient,
+				TableName:    os.Getenv("USERS_TABLE"),
+			}),
+		}),
+		orgService:   orgService,
+		oidcStateKey: newOIDCStateKey(),
+		collectionService: collection.NewCollectionService(collection.CollectionConfig{
+			DynamoClient:    dynamoClient,
+			TableName:       os.Getenv("COLLECTIONS_TABLE"),
+			ThumbnailsTable: os.Getenv("THUMBNAILS_TABLE"),
+		}),
+		searchIndex:  searchIndex,
+		assetService: assetService,
+		exportService: export.NewExportService(export.ExportConfig{
+			DynamoClient: dynamoClient,
+			TableName:    os.Getenv("EXPORTS_TABLE"),
+			Storage:      storageService,
+		}),
+		auditLogger: audit.NewAuditLogger(audit.AuditConfig{
+			DynamoClient: dynamoClient,
+			TableName:    os.Getenv("AUDIT_LOG_TABLE"),
+		}),
+		rateLimiter: newRateLimiter(dynamoClient),
+		blobStore:   blobStore,
+	}
+	api.shareService = share.NewShareService(share.ShareConfig{
+		DynamoClient: dynamoClient,
+		TableName:    os.Getenv("SHARES_TABLE"),
+		Storage:      storageService,
+		Collections:  api.collectionService,
+	})
+	api.accountService = account.NewAccountService(account.AccountConfig{
+		DynamoClient:  dynamoClient,
+		TableName:     os.Getenv("ACCOUNT_DELETIONS_TABLE"),
+		Auth:          api.authService,
+		Billing:       api.billingService,
+		Storage:       storageService,
+		Collections:   api.collectionService,
+		Exports:       api.exportService,
+		Search:        searchIndex,
+		Organizations: orgService,
+		Assets:        assetService,
+		Shares:        api.shareService,
+	})
+
+	api.router = api.newRouter()
+
+	return api, nil
+}
+
+func (api *API) handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return api.router.Handle(ctx, request)
+}
+
+func newRateLimiter(dynamoClient *dynamodb.Client) *ratelimit.Limiter {
+	if os.Getenv("RATE_LIMIT_TABLE") == "" {
+		return ratelimit.NewLimiter(ratelimit.LimiterConfig{Store: ratelimit.NewMemoryStore()})
+	}
+
+	return ratelimit.NewLimiter(ratelimit.LimiterConfig{
+		Store: ratelimit.NewDynamoStore(ratelimit.DynamoStoreConfig{
+			DynamoClient: dynamoClient,
+			TableName:    os.Getenv("RATE_LIMIT_TABLE"),
 		}),
+	})
+}
+
+// principal is the verified caller of a non-public route. The rate limiter
+// resolves it once and handlers pick it up through authenticate.
+type principal struct {
+	user *models.User
+	// plan is nil when the plan lookup failed
+	plan *billing.Plan
+}
+
+type principalKey struct{}
+
+func principalFromContext(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// withPrincipal verifies the request's token, if any, and stores the caller
+// and their plan in the context. A missing or invalid token is left for
+// authenticate to reject.
+func (api *API) withPrincipal(ctx context.Context, request events.APIGatewayProxyRequest) context.Context {
+	if route := router.RouteFromContext(ctx); route != nil && route.Public {
+		return ctx
+	}
+
+	token, err := auth.ExtractTokenFromRequest(request)
+	if err != nil {
+		return ctx
+	}
+	user, err := api.authService.VerifyToken(ctx, token)
+	if err != nil {
+		return ctx
+	}
+
+	p := &principal{user: user}
+	if plan, err := api.billingService.GetUserPlan(ctx, user.ID); err == nil {
+		p.plan = &plan
+	} else {
+		log.Printf("failed to get plan for %s: %v", user.ID, err)
+	}
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// rateLimit enforces rate limits before a handler does any work
+func (api *API) rateLimit(next router.Handler) router.Handler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		ctx = api.withPrincipal(ctx, request)
+		key, limit := api.resolveRateLimit(ctx, request)
+		result, err := api.rateLimiter.Allow(ctx, key, limit)
+		if err != nil {
+			// Fail open: a broken limiter shouldn't take the API down with it
+			log.Printf("rate limiter unavailable: %v", err)
+			return next(ctx, request)
+		}
+
+		if !result.Allowed {
+			return withHeaders(errorResponse(http.StatusTooManyRequests, "rate limit exceeded"), result.Headers()), nil
+		}
+
+		response, err := next(ctx, request)
+		return withHeaders(response, result.Headers()), err
+	}
+}
+
+// resolveRateLimit picks the bucket key and limit for the request's principal:
+// the signed-in user with their plan's limit, then the API key, then client IP.
+// Only principals that have been verified get their own bucket: the API key
+// counts once the gateway has validated it against a usage plan, which leaves
+// its ID in the request context. Anything else, including public routes, is
+// limited by client IP so that requests can't be spread across made-up tokens
+// or API keys.
+func (api *API) resolveRateLimit(ctx context.Context, request events.APIGatewayProxyRequest) (string, ratelimit.Limit) {
+	ipKey := "ip:" + request.RequestContext.Identity.SourceIP
+	if route := router.RouteFromContext(ctx); route != nil && route.Public {
+		return ipKey, anonymousLimit
+	}
+
+	if p := principalFromContext(ctx); p != nil {
+		plan := billing.Plans["free"]
+		if p.plan != nil {
+			plan = *p.plan
+		}
+		return "user:" + p.user.ID, planLimit(plan)
+	}
+
+	if apiKeyID := request.RequestContext.Identity.APIKeyID; apiKeyID != "" {
+		return "apikey:" + apiKeyID, planLimit(billing.Plans["free"])
+	}
+
+	return ipKey, anonymousLimit
+}
+
+// newIdentityProvider returns the provider selected by AUTH_PROVIDER, or nil to
+// use Cognito
+func newIdentityProvider() (auth.IdentityProvider, error) {
+	if os.Getenv("AUTH_PROVIDER") != "local" {
+		return nil, nil
+	}
+
+	return auth.NewLocalProvider(auth.LocalProviderConfig{
+		Path: os.Getenv("LOCAL_AUTH_FILE"),
+	})
+}
+
+// newBlobStore returns the store selected by STORAGE_BACKEND, or nil to use S3.
+// The filesystem store's presigned URLs are only reachable when its Handler is
+// mounted by a local HTTP server.
+func newBlobStore() (blob.Store, error) {
+	if os.Getenv("STORAGE_BACKEND") != "filesystem" {
+		return nil, nil
 	}
 
-	// Route request
-	switch {
-	case request.HTTPMethod == "POST" && request.Path == "/thumbnails":
-		return api.handleGenerateThumbnail(ctx, request)
-	case request.HTTPMethod == "GET" && request.Path == "/thumbnails":
-		return api.handleListThumbnails(ctx, request)
-	case request.HTTPMethod == "GET" && request.Resource == "/thumbnails/{id}":
-		return api.handleGetThumbnail(ctx, request)
-	case request.HTTPMethod == "DELETE" && request.Resource == "/thumbnails/{id}":
-		return api.handleDeleteThumbnail(ctx, request)
-	case request.HTTPMethod == "POST" && request.Path == "/subscriptions":
-		return api.handleCreateSubscription(ctx, request)
-	case request.HTTPMethod == "GET" && request.Path == "/credits":
-		return api.handleGetCredits(ctx, request)
-	case request.HTTPMethod == "POST" && request.Path == "/register":
-		return api.handleRegister(ctx, request)
-	case request.HTTPMethod == "POST" && request.Path == "/login":
-		return api.handleLogin(ctx, request)
-	default:
-		return errorResponse(http.StatusNotFound, "not found"), nil
+	return blob.NewFSStore(blob.FSStoreConfig{})
+}
+
+// newOIDCClients builds the social login providers configured through
+// OIDC_PROVIDERS
+func newOIDCClients() []*auth.OIDCClient {
+	configs := auth.OIDCConfigsFromEnv()
+	clients := make([]*auth.OIDCClient, 0, len(configs))
+	for _, config := range configs {
+		clients = append(clients, auth.NewOIDCClient(config))
+	}
+	return clients
+}
+
+// newOIDCStateKey reads the flow cookie signing key from OIDC_STATE_SECRET.
+// Without one a random key is used, which only works while the start and
+// callback requests reach the same instance.
+func newOIDCStateKey() []byte {
+	if secret := os.Getenv("OIDC_STATE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("failed to generate OIDC state key: %v", err)
+	}
+	return key
+}
+
+// refundCredit gives back a credit deducted for a generation that failed
+func (api *API) refundCredit(ctx context.Context, userID string) {
+	if err := api.billingService.AddCredits(ctx, userID, 1); err != nil {
+		log.Printf("failed to refund credit to %s: %v", userID, err)
+	}
+}
+
+func planLimit(plan billing.Plan) ratelimit.Limit {
+	return ratelimit.Limit{
+		RequestsPerMinute: plan.RequestsPerMinute,
+		Burst:             plan.BurstRequests,
 	}
 }
 
 func (api *API) handleGenerateThumbnail(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
 	var req models.ThumbnailRequest
 	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
 		return errorResponse(http.StatusBadRequest, "invalid request"), nil
 	}
+	req.UserID = user.ID
+
+	tags, err := storage.NormalizeTags(req.Tags)
+	if err != nil {
+		return validationResponse(apierror.Field("tags", fmt.Sprintf("at most %d tags of up to %d characters", storage.MaxTags, storage.MaxTagLength))), nil
+	}
+
+	if len(req.AssetIDs) > maxSourceAssets {
+		return validationResponse(apierror.Field("assetIds", fmt.Sprintf("at most %d source assets", maxSourceAssets))), nil
+	}
+	if err := api.checkAssets(ctx, req.UserID, req.AssetIDs); err != nil {
+		return serviceErrorResponse(err, "failed to generate thumbnail"), nil
+	}
+	sources, err := api.sourceImages(ctx, req.AssetIDs)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to generate thumbnail"), nil
+	}
+
+	// Don't charge for a thumbnail there's no room to store
+	if err := api.storageService.CheckQuota(ctx, req.UserID); errors.Is(err, storage.ErrQuotaExceeded) {
+		return quotaExceededResponse(), nil
+	} else if err != nil {
+		log.Printf("failed to check storage quota: %v", err)
+	}
 
 	// Check credits
 	if err := api.billingService.DeductCredits(ctx, req.UserID, 1); err != nil {
-		return errorResponse(http.StatusPaymentRequired, "insufficient credits"), nil
+		return serviceErrorResponse(err, "failed to deduct credits"), nil
 	}
+	// The credit is only spent on a saved thumbnail
+	charged := true
+	defer func() {
+		if charged {
+			api.refundCredit(ctx, req.UserID)
+		}
+	}()
 
 	// Generate thumbnail
-	thumbnail, err := api.aiService.GenerateThumbnail(ctx, ai.GenerationParams{
+	params := ai.GenerationParams{
 		VideoTitle:  req.VideoTitle,
 		Description: req.Description,
 		Style:       req.Style,
-	})
+	}
+	thumbnail, err := api.aiService.GenerateThumbnail(ctx, params)
 	if err != nil {
-		return errorResponse(http.StatusInternalServerError, "failed to generate thumbnail"), nil
+		return serviceErrorResponse(err, "failed to generate thumbnail"), nil
+	}
+	thumbnail.UserID = req.UserID
+	thumbnail.TemplateID = req.TemplateID
+	thumbnail.Tags = tags
+	thumbnail.AssetIDs = req.AssetIDs
+
+	// The people in the source images are named in the prompt; detection is
+	// best effort like the analysis below
+	celebrities, err := api.aiService.DetectSourceCelebrities(ctx, sources)
+	if err != nil {
+		log.Printf("failed to detect celebrities in source assets: %v", err)
+	}
+	prompt := fmt.Sprintf("%s thumbnail for %q: %s", req.Style, req.VideoTitle, req.Description)
+	if len(celebrities) > 0 {
+		prompt += " featuring " + strings.Join(celebrities, ", ")
+	}
+
+	imageData, err := api.aiService.GenerateImage(ctx, prompt)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to generate thumbnail"), nil
+	}
+
+	// Automatic tags are a nicety; a failed analysis doesn't fail generation
+	analysis, err := api.aiService.AnalyzeContent(ctx, params, imageData)
+	if err != nil {
+		log.Printf("failed to analyze thumbnail content: %v", err)
+	}
+	if analysis != nil {
+		analysis.AddCelebrities(celebrities...)
+	}
+	thumbnail.AITags = aiTags(analysis)
+
+	// The thumbnail keeps its source assets alive even if they are removed
+	// from the library
+	for _, hash := range thumbnail.AssetIDs {
+		if err := api.assetService.AddRef(ctx, asset.ThumbnailRef(thumbnail.ID), hash); err != nil {
+			api.releaseAssets(ctx, thumbnail.ID)
+			return serviceErrorResponse(err, "failed to save thumbnail"), nil
+		}
 	}
 
-	// Save thumbnail
-	// Note: In a real implementation, we would get the image data from the AI service
-	dummyData := []byte("dummy image data")
-	if err := api.storageService.SaveThumbnail(ctx, thumbnail, dummyData); err != nil {
-		return errorResponse(http.StatusInternalServerError, "failed to save thumbnail"), nil
+	// Save thumbnail and its renditions
+	if err := api.storageService.SaveThumbnail(ctx, thumbnail, imageData); err != nil {
+		api.releaseAssets(ctx, thumbnail.ID)
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			return quotaExceededResponse(), nil
+		}
+		return serviceErrorResponse(err, "failed to save thumbnail"), nil
+	}
+	charged = false
+
+	// Flagging near-duplicates is advisory, so a failed lookup is only logged
+	if thumbnail.NearDuplicates, err = api.storageService.NearDuplicates(ctx, thumbnail); err != nil {
+		log.Printf("failed to find near-duplicates of %s: %v", thumbnail.ID, err)
 	}
 
 	return jsonResponse(http.StatusCreated, thumbnail)
 }
 
 func (api *API) handleListThumbnails(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	userID := request.QueryStringParameters["userId"]
-	if userID == "" {
-		return errorResponse(http.StatusBadRequest, "userId is required"), nil
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	opts, err := parseListOptions(request.QueryStringParameters)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err.Error()), nil
 	}
 
-	thumbnails, err := api.storageService.ListUserThumbnails(ctx, userID)
+	page, err := api.storageService.ListThumbnails(ctx, user.ID, opts)
 	if err != nil {
-		return errorResponse(http.StatusInternalServerError, "failed to list thumbnails"), nil
+		return serviceErrorResponse(err, "failed to list thumbnails"), nil
 	}
 
-	return jsonResponse(http.StatusOK, thumbnails)
+	return jsonResponse(http.StatusOK, page)
 }
 
 func (api *API) handleGetThumbnail(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	userID := request.QueryStringParameters["userId"]
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
 	thumbnailID := request.PathParameters["id"]
 
-	data, err := api.storageService.GetThumbnail(ctx, userID, thumbnailID)
+	// ?redirect=true sends the client straight to a presigned S3 URL instead
+	// of proxying the image through Lambda
+	if request.QueryStringParameters["redirect"] == "true" {
+		return api.redirectToThumbnail(ctx, user.ID, thumbnailID)
+	}
+
+	object, err := api.storageService.OpenThumbnail(ctx, user.ID, thumbnailID, storage.ReadOptions{
+		IfNoneMatch: headerValue(request, "If-None-Match"),
+		Range:       headerValue(request, "Range"),
+	})
 	if err != nil {
-		return errorResponse(http.StatusInternalServerError, "failed to get thumbnail"), nil
+		return serviceErrorResponse(err, "failed to get thumbnail"), nil
+	}
+	if !object.NotModified && object.ContentLength > maxInlineThumbnail {
+		object.Body.Close()
+		return api.redirectToThumbnail(ctx, user.ID, thumbnailID)
 	}
 
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusOK,
-		Headers: map[string]string{
-			"Content-Type": "image/jpeg",
-		},
-		Body:            string(data),
-		IsBase64Encoded: true,
-	}, nil
+	return thumbnailResponse(object)
 }
 
 func (api *API) handleDeleteThumbnail(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	userID := request.QueryStringParameters["userId"]
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
 	thumbnailID := request.PathParameters["id"]
 
-	if err := api.storageService.DeleteThumbnail(ctx, userID, thumbnailID); err != nil {
-		return errorResponse(http.StatusInternalServerError, "failed to delete thumbnail"), nil
+	err := api.storageService.DeleteThumbnail(ctx, user.ID, thumbnailID)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to delete thumbnail"), nil
 	}
 
 	return events.APIGatewayProxyResponse{
@@ -159,23 +534,27 @@ func (api *API) handleDeleteThumbnail(ctx context.Context, request events.APIGat
 	}, nil
 }
 
+// handleCreateSubscription subscribes the signed-in user to a plan
 func (api *API) handleCreateSubscription(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	principal, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
 	var req struct {
-		UserID  string `json:"userId"`
-		PlanID  string `json:"planId"`
-		Email   string `json:"email"`
+		PlanID string `json:"planId"`
 	}
 	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
 		return errorResponse(http.StatusBadRequest, "invalid request"), nil
 	}
 
 	user := &models.User{
-		ID:    req.UserID,
-		Email: req.Email,
+		ID:    principal.ID,
+		Email: principal.Email,
 	}
 
 	if err := api.billingService.CreateSubscription(ctx, user, req.PlanID); err != nil {
-		return errorResponse(http.StatusInternalServerError, "failed to create subscription"), nil
+		return serviceErrorResponse(err, "failed to create subscription"), nil
 	}
 
 	return jsonResponse(http.StatusCreated, user)
@@ -193,10 +572,7 @@ func (api *API) handleRegister(ctx context.Context, request events.APIGatewayPro
 
 	user, err := api.authService.RegisterUser(ctx, req.Email, req.Username, req.Password)
 	if err != nil {
-		if err == auth.ErrUserExists {
-			return errorResponse(http.StatusConflict, "user already exists"), nil
-		}
-		return errorResponse(http.StatusInternalServerError, "failed to register user"), nil
+		return serviceErrorResponse(err, "failed to register user"), nil
 	}
 
 	return jsonResponse(http.StatusCreated, user)
@@ -211,35 +587,96 @@ func (api *API) handleLogin(ctx context.Context, request events.APIGatewayProxyR
 		return errorResponse(http.StatusBadRequest, "invalid request"), nil
 	}
 
-	token, err := api.authService.LoginUser(ctx, req.Email, req.Password)
+	result, err := api.authService.LoginUser(ctx, req.Email, req.Password)
 	if err != nil {
-		if err == auth.ErrInvalidCredentials {
-			return errorResponse(http.StatusUnauthorized, "invalid credentials"), nil
-		}
-		return errorResponse(http.StatusInternalServerError, "failed to login"), nil
+		return serviceErrorResponse(err, "failed to login"), nil
+	}
+
+	return jsonResponse(http.StatusOK, result)
+}
+
+func (api *API) handleRefresh(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil || req.RefreshToken == "" {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	tokens, err := api.authService.RefreshToken(ctx, req.RefreshToken)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to refresh token"), nil
 	}
 
-	return jsonResponse(http.StatusOK, map[string]string{"token": token})
+	return jsonResponse(http.StatusOK, tokens)
 }
 
 func (api *API) handleGetCredits(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// Authenticate user
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	credits, err := api.billingService.GetUserCredits(ctx, user.ID)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to get credits"), nil
+	}
+
+	return jsonResponse(http.StatusOK, map[string]int{"credits": credits})
+}
+
+// authenticate resolves the bearer token to a user, or returns the 401 response
+// to send back
+func (api *API) authenticate(ctx context.Context, request events.APIGatewayProxyRequest) (*models.User, *events.APIGatewayProxyResponse) {
+	if p := principalFromContext(ctx); p != nil {
+		return p.user, nil
+	}
+
 	token, err := auth.ExtractTokenFromRequest(request)
 	if err != nil {
-		return errorResponse(http.StatusUnauthorized, "unauthorized"), nil
+		resp := errorResponse(http.StatusUnauthorized, "unauthorized")
+		return nil, &resp
 	}
 
 	user, err := api.authService.VerifyToken(ctx, token)
 	if err != nil {
-		return errorResponse(http.StatusUnauthorized, "invalid token"), nil
+		code := apierror.CodeInvalidToken
+		if errors.Is(err, auth.ErrExpiredToken) {
+			code = apierror.CodeTokenExpired
+		}
+		resp := apierror.New(http.StatusUnauthorized, code, "invalid token").Response()
+		return nil, &resp
 	}
 
-	credits, err := api.billingService.GetUserCredits(ctx, user.ID)
-	if err != nil {
-		return errorResponse(http.StatusInternalServerError, "failed to get credits"), nil
+	return user, nil
+}
+
+// newS3Client honours S3_ENDPOINT so the API can run against an S3-compatible
+// stand-in such as MinIO, which needs path-style addressing
+func newS3Client(cfg aws.Config) *s3.Client {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		return s3.NewFromConfig(cfg)
 	}
 
-	return jsonResponse(http.StatusOK, map[string]int{"credits": credits})
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = true
+	})
+}
+
+// newDynamoClient honors DYNAMODB_ENDPOINT so the API can run against
+// DynamoDB Local
+func newDynamoClient(cfg aws.Config) *dynamodb.Client {
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		return dynamodb.NewFromConfig(cfg)
+	}
+
+	return dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
 }
 
 func jsonResponse(statusCode int, body interface{}) (events.APIGatewayProxyResponse, error) {
@@ -257,16 +694,36 @@ func jsonResponse(statusCode int, body interface{}) (events.APIGatewayProxyRespo
 	}, nil
 }
 
-func errorResponse(statusCode int, message string) events.APIGatewayProxyResponse {
-	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
-		Body: fmt.Sprintf(`{"error":"%s"}`, message),
+func withHeaders(response events.APIGatewayProxyResponse, headers map[string]string) events.APIGatewayProxyResponse {
+	if response.Headers == nil {
+		response.Headers = make(map[string]string, len(headers))
+	}
+	for name, value := range headers {
+		response.Headers[name] = value
 	}
+	return response
 }
 
 func main() {
-	lambda.Start(handleRequest)
-}
\ No newline at end of file
+	// "api routes" prints the route table for the deployment
+	if len(os.Args) > 1 && os.Args[1] == "routes" {
+		if err := writeRoutes(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// "api serve" runs the same handlers over net/http for local development
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		if err := serve(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	api, err := newAPI(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	lambda.Start(api.handleRequest)
+}
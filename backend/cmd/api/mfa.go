@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/apierror"
+	"github.com/celebthumb-ai/internal/auth"
+)
+
+// enrollmentErrors overrides serviceErrors for enrollment, which is
+// authorized by the access token rather than a challenge session, and where a
+// wrong code is a bad request rather than a failed sign-in
+var enrollmentErrors = []apierror.Mapping{
+	{Err: auth.ErrInvalidSession, Status: http.StatusUnauthorized, Code: apierror.CodeInvalidToken, Message: "invalid token"},
+	{Err: auth.ErrInvalidMFACode, Status: http.StatusBadRequest, Code: apierror.CodeInvalidMFACode, Message: "invalid MFA code"},
+}
+
+func (api *API) handleLoginChallenge(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var req struct {
+		Email     string `json:"email"`
+		Challenge string `json:"challenge"`
+		Session   string `json:"session"`
+		Code      string `json:"code"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil || req.Session == "" || req.Code == "" {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	tokens, err := api.authService.RespondToChallenge(ctx, req.Email, auth.Challenge{
+		Name:    req.Challenge,
+		Session: req.Session,
+	}, req.Code)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to respond to challenge"), nil
+	}
+
+	return jsonResponse(http.StatusOK, tokens)
+}
+
+// handleAssociateSoftwareToken starts TOTP enrollment. It takes either an
+// access token, as handed out with an MFA_SETUP challenge raised by the MFA
+// policy, or the email and session of an MFA_SETUP challenge raised by the
+// identity provider. The session form returns a new session, which answers
+// the challenge at /auth/login/challenge together with the first code.
+func (api *API) handleAssociateSoftwareToken(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var req struct {
+		AccessToken string `json:"accessToken"`
+		Email       string `json:"email"`
+		Session     string `json:"session"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil || (req.AccessToken == "") == (req.Session == "") {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	var enrollment *auth.SoftwareTokenEnrollment
+	var err error
+	if req.Session != "" {
+		enrollment, err = api.authService.AssociateSoftwareTokenSession(ctx, req.Email, req.Session)
+	} else {
+		enrollment, err = api.authService.AssociateSoftwareToken(ctx, req.AccessToken)
+	}
+	if err != nil {
+		return serviceErrorResponse(err, "failed to associate software token"), nil
+	}
+
+	return jsonResponse(http.StatusOK, enrollment)
+}
+
+func (api *API) handleVerifySoftwareToken(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var req struct {
+		AccessToken string `json:"accessToken"`
+		Code        string `json:"code"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil || req.AccessToken == "" || req.Code == "" {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	if err := api.authService.VerifySoftwareToken(ctx, req.AccessToken, req.Code); err != nil {
+		return translateError(err, "failed to verify software token", enrollmentErrors, serviceErrors).Response(), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+	}, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/apierror"
+	"github.com/celebthumb-ai/internal/auth"
+)
+
+// oidcFlowCookie carries the state, nonce and PKCE verifier between the start
+// and callback requests, so no server-side session store is needed. The value
+// is signed so that a client can't swap in its own nonce or provider.
+const oidcFlowCookie = "oidc_flow"
+
+// federationErrors overrides serviceErrors for the provider callback, where
+// the user signed in somewhere else and a token or credential error means the
+// provider's response was rejected
+var federationErrors = []apierror.Mapping{
+	{Err: auth.ErrUserExists, Status: http.StatusConflict, Code: apierror.CodeUserExists, Message: "an account with this email already exists"},
+	{Err: auth.ErrInvalidToken, Status: http.StatusUnauthorized, Code: apierror.CodeInvalidCredentials, Message: "sign-in failed"},
+	{Err: auth.ErrInvalidCredentials, Status: http.StatusUnauthorized, Code: apierror.CodeInvalidCredentials, Message: "sign-in failed"},
+}
+
+// handleOIDCStart redirects the browser to the provider's authorization page
+func (api *API) handleOIDCStart(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return api.redirectToProvider(ctx, request.PathParameters["provider"])
+}
+
+// handleOIDCCallback finishes the flow and returns the same token set as login
+func (api *API) handleOIDCCallback(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	providerName := request.PathParameters["provider"]
+
+	if providerError := request.QueryStringParameters["error"]; providerError != "" {
+		return errorResponse(http.StatusUnauthorized, "sign-in was cancelled or denied"), nil
+	}
+
+	flow, err := api.readOIDCFlow(request)
+	if err != nil || flow.Provider != providerName {
+		return errorResponse(http.StatusBadRequest, "missing or invalid sign-in state"), nil
+	}
+
+	result, err := api.authService.CompleteFederatedLogin(ctx, flow, request.QueryStringParameters["state"], request.QueryStringParameters["code"])
+	if err != nil {
+		if errors.Is(err, auth.ErrAccountLinked) {
+			// The identity now belongs to the existing account; signing in
+			// again yields that account's tokens
+			return api.redirectToProvider(ctx, providerName)
+		}
+		return translateError(err, "failed to complete sign-in", federationErrors, serviceErrors).Response(), nil
+	}
+
+	response, err := jsonResponse(http.StatusOK, result)
+	response.MultiValueHeaders = map[string][]string{
+		"Set-Cookie": {expiredOIDCFlowCookie()},
+	}
+	return response, err
+}
+
+func (api *API) redirectToProvider(ctx context.Context, providerName string) (events.APIGatewayProxyResponse, error) {
+	authURL, flow, err := api.authService.StartFederatedLogin(ctx, providerName)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnknownOIDCProvider) {
+			return serviceErrorResponse(err, "failed to start sign-in"), nil
+		}
+		log.Printf("failed to start federated login: %v", err)
+		return errorResponse(http.StatusInternalServerError, "failed to start sign-in"), nil
+	}
+
+	cookie, err := api.oidcFlowCookieValue(flow)
+	if err != nil {
+		return errorResponse(http.StatusInternalServerError, "failed to start sign-in"), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusFound,
+		Headers: map[string]string{
+			"Location": authURL,
+		},
+		MultiValueHeaders: map[string][]string{
+			"Set-Cookie": {cookie},
+		},
+	}, nil
+}
+
+func (api *API) oidcFlowCookieValue(flow *auth.FederatedLoginState) (string, error) {
+	data, err := json.Marshal(flow)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	cookie := &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    payload + "." + api.signOIDCFlow(payload),
+		Path:     "/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   true,
+		// Lax so the cookie survives the top-level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	}
+	return cookie.String(), nil
+}
+
+func expiredOIDCFlowCookie() string {
+	cookie := &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	return cookie.String()
+}
+
+func (api *API) readOIDCFlow(request events.APIGatewayProxyRequest) (*auth.FederatedLoginState, error) {
+	// HTTP API payloads lower-case header names, so match them the way
+	// headerValue does
+	header := http.Header{}
+	if cookie := headerValue(request, "Cookie"); cookie != "" {
+		header.Add("Cookie", cookie)
+	}
+	for name, cookies := range request.MultiValueHeaders {
+		if strings.EqualFold(name, "Cookie") {
+			for _, cookie := range cookies {
+				header.Add("Cookie", cookie)
+			}
+		}
+	}
+
+	cookie, err := (&http.Request{Header: header}).Cookie(oidcFlowCookie)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(api.signOIDCFlow(payload))) {
+		return nil, errors.New("invalid sign-in state signature")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var flow auth.FederatedLoginState
+	if err := json.Unmarshal(data, &flow); err != nil {
+		return nil, err
+	}
+
+	return &flow, nil
+}
+
+func (api *API) signOIDCFlow(payload string) string {
+	mac := hmac.New(sha256.New, api.oidcStateKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
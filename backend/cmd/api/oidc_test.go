@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/auth"
+)
+
+func TestOIDCFlowCookie(t *testing.T) {
+	api := &API{oidcStateKey: []byte("test-key")}
+	flow := &auth.FederatedLoginState{
+		Provider:     "google",
+		State:        "state",
+		Nonce:        "nonce",
+		CodeVerifier: "verifier",
+	}
+
+	setCookie, err := api.oidcFlowCookieValue(flow)
+	if err != nil {
+		t.Fatalf("oidcFlowCookieValue: %v", err)
+	}
+	pair, _, _ := strings.Cut(setCookie, ";")
+	value := strings.TrimPrefix(pair, oidcFlowCookie+"=")
+	payload, signature, _ := strings.Cut(value, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"provider":"google","state":"state","nonce":"mine","codeVerifier":"verifier"}`))
+
+	tests := []struct {
+		name    string
+		request events.APIGatewayProxyRequest
+		wantErr bool
+	}{
+		{
+			name: "REST API header",
+			request: events.APIGatewayProxyRequest{
+				Headers: map[string]string{"Cookie": "theme=dark; " + pair},
+			},
+		},
+		{
+			name: "lower-cased header",
+			request: events.APIGatewayProxyRequest{
+				Headers: map[string]string{"cookie": pair},
+			},
+		},
+		{
+			name: "lower-cased multi-value header",
+			request: events.APIGatewayProxyRequest{
+				MultiValueHeaders: map[string][]string{"cookie": {"theme=dark", pair}},
+			},
+		},
+		{
+			name:    "missing cookie",
+			request: events.APIGatewayProxyRequest{},
+			wantErr: true,
+		},
+		{
+			name: "unsigned payload",
+			request: events.APIGatewayProxyRequest{
+				Headers: map[string]string{"Cookie": oidcFlowCookie + "=" + payload},
+			},
+			wantErr: true,
+		},
+		{
+			name: "forged payload",
+			request: events.APIGatewayProxyRequest{
+				Headers: map[string]string{"Cookie": oidcFlowCookie + "=" + forged + "." + signature},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := api.readOIDCFlow(tt.request)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("readOIDCFlow = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readOIDCFlow: %v", err)
+			}
+			if *got != *flow {
+				t.Errorf("readOIDCFlow = %+v, want %+v", got, flow)
+			}
+		})
+	}
+
+	other := &API{oidcStateKey: []byte("other-key")}
+	if _, err := other.readOIDCFlow(tests[0].request); err == nil {
+		t.Error("readOIDCFlow accepted a cookie signed with another key")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func (api *API) handleCreateOrganization(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil || req.Name == "" {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	org, err := api.orgService.CreateOrganization(ctx, user.ID, req.Name)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to create organization"), nil
+	}
+
+	return jsonResponse(http.StatusCreated, org)
+}
+
+// handleAddOrganizationMember invites a user; they join once they accept
+func (api *API) handleAddOrganizationMember(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		UserID string `json:"userId"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil || req.UserID == "" {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	if err := api.orgService.InviteMember(ctx, request.PathParameters["id"], user.ID, req.UserID); err != nil {
+		return serviceErrorResponse(err, "failed to invite member"), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusAccepted,
+	}, nil
+}
+
+// handleJoinOrganization accepts the signed-in user's invite
+func (api *API) handleJoinOrganization(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	if err := api.orgService.Join(ctx, request.PathParameters["id"], user.ID); err != nil {
+		return serviceErrorResponse(err, "failed to join organization"), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+	}, nil
+}
+
+// handleLeaveOrganization takes the signed-in user out of the organization
+func (api *API) handleLeaveOrganization(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	if err := api.orgService.Leave(ctx, request.PathParameters["id"], user.ID); err != nil {
+		return serviceErrorResponse(err, "failed to leave organization"), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+	}, nil
+}
+
+// handleSetOrganizationMFA lets an org admin require MFA for every member
+func (api *API) handleSetOrganizationMFA(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		Required bool `json:"required"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	org, err := api.orgService.SetRequireMFA(ctx, request.PathParameters["id"], user.ID, req.Required)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to update organization"), nil
+	}
+
+	return jsonResponse(http.StatusOK, org)
+}
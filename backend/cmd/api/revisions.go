@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/apierror"
+	"github.com/celebthumb-ai/internal/billing"
+	"github.com/celebthumb-ai/internal/storage"
+)
+
+func (api *API) handleListRevisions(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	revisions, err := api.storageService.ListRevisions(ctx, user.ID, request.PathParameters["id"])
+	if err != nil {
+		return serviceErrorResponse(err, "failed to list revisions"), nil
+	}
+
+	return jsonResponse(http.StatusOK, revisions)
+}
+
+func (api *API) handleGetRevision(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	number, err := strconv.Atoi(request.PathParameters["revision"])
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid revision"), nil
+	}
+
+	revision, err := api.storageService.GetRevision(ctx, user.ID, request.PathParameters["id"], number)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to get revision"), nil
+	}
+
+	return jsonResponse(http.StatusOK, revision)
+}
+
+// handleCreateRevision records an edit or a regeneration. An edit sends the
+// rendered image from the editor; without one the prompt is regenerated,
+// which costs a credit like any generation.
+func (api *API) handleCreateRevision(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		Prompt string          `json:"prompt"`
+		Layers json.RawMessage `json:"layers"`
+		// Image is the base64-encoded image of an edit
+		Image string `json:"image"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	var imageData []byte
+	// charged is set while a credit deducted for a generated image hasn't
+	// been spent on a saved revision yet
+	charged := false
+	defer func() {
+		if charged {
+			api.refundCredit(ctx, user.ID)
+		}
+	}()
+	switch {
+	case req.Image != "":
+		data, err := base64.StdEncoding.DecodeString(req.Image)
+		if err != nil {
+			return validationResponse(apierror.Field("image", "must be base64-encoded")), nil
+		}
+		imageData = data
+	case req.Prompt != "":
+		if err := api.storageService.CheckQuota(ctx, user.ID); errors.Is(err, storage.ErrQuotaExceeded) {
+			return quotaExceededResponse(), nil
+		} else if err != nil {
+			log.Printf("failed to check storage quota: %v", err)
+		}
+		if err := api.billingService.DeductCredits(ctx, user.ID, 1); err != nil {
+			return serviceErrorResponse(err, "failed to deduct credits"), nil
+		}
+		charged = true
+		data, err := api.aiService.GenerateImage(ctx, req.Prompt)
+		if err != nil {
+			return serviceErrorResponse(err, "failed to generate thumbnail"), nil
+		}
+		imageData = data
+	default:
+		return validationResponse(apierror.Field("image", "is required unless prompt is set"), apierror.Field("prompt", "is required unless image is set")), nil
+	}
+
+	thumbnail, err := api.storageService.ReviseThumbnail(ctx, user.ID, request.PathParameters["id"], imageData, storage.RevisionInput{
+		Prompt:        req.Prompt,
+		LayerDocument: req.Layers,
+		ChangedBy:     user.ID,
+	}, api.revisionRetention(ctx, user.ID))
+	if err != nil {
+		return serviceErrorResponse(err, "failed to save revision"), nil
+	}
+	charged = false
+
+	return jsonResponse(http.StatusCreated, thumbnail)
+}
+
+func (api *API) handleRestoreRevision(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	number, err := strconv.Atoi(request.PathParameters["revision"])
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid revision"), nil
+	}
+
+	thumbnail, err := api.storageService.RestoreRevision(ctx, user.ID, request.PathParameters["id"], number, user.ID, api.revisionRetention(ctx, user.ID))
+	if err != nil {
+		return serviceErrorResponse(err, "failed to restore revision"), nil
+	}
+
+	return jsonResponse(http.StatusOK, thumbnail)
+}
+
+// revisionRetention looks up the owner's plan retention, falling back to the
+// free plan's when billing is unavailable
+func (api *API) revisionRetention(ctx context.Context, userID string) int {
+	if p := principalFromContext(ctx); p != nil && p.user.ID == userID && p.plan != nil {
+		return p.plan.RevisionRetention
+	}
+
+	plan, err := api.billingService.GetUserPlan(ctx, userID)
+	if err != nil {
+		log.Printf("failed to get plan for %s: %v", userID, err)
+		plan = billing.Plans["free"]
+	}
+	return plan.RevisionRetention
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/router"
+)
+
+//go:generate sh -c "go run . routes > ../../infrastructure/routes.json"
+
+// routes is the API's route table and the only place routes are declared.
+// infrastructure/routes.json is generated from it and the gateway deploys
+// exactly those routes, so run go generate after changing it.
+func (api *API) routes() []router.Route {
+	return []router.Route{
+		{Method: "GET", Path: "/health", Public: true, Handler: api.handleHealth},
+
+		// Sign-in. Challenge and enrollment calls carry their own session or
+		// access token.
+		{Method: "POST", Path: "/auth/register", Public: true, Handler: api.handleRegister},
+		{Method: "POST", Path: "/auth/login", Public: true, Handler: api.handleLogin},
+		{Method: "POST", Path: "/auth/refresh", Public: true, Handler: api.handleRefresh},
+		{Method: "POST", Path: "/auth/login/challenge", Public: true, Handler: api.handleLoginChallenge},
+		{Method: "POST", Path: "/auth/mfa/totp/associate", Public: true, Handler: api.handleAssociateSoftwareToken},
+		{Method: "POST", Path: "/auth/mfa/totp/verify", Public: true, Handler: api.handleVerifySoftwareToken},
+		{Method: "GET", Path: "/auth/oidc/{provider}/start", Public: true, Handler: api.handleOIDCStart},
+		{Method: "GET", Path: "/auth/oidc/{provider}/callback", Public: true, Handler: api.handleOIDCCallback},
+
+		// Share links are opened by people without accounts
+		{Method: "GET", Path: "/share/{token}", Public: true, Handler: api.handleOpenShare},
+
+		{Method: "POST", Path: "/thumbnails/generate", Handler: api.handleGenerateThumbnail},
+		{Method: "GET", Path: "/thumbnails", Handler: api.handleListThumbnails},
+		{Method: "GET", Path: "/thumbnails/{id}", Handler: api.handleGetThumbnail},
+		{Method: "DELETE", Path: "/thumbnails/{id}", Handler: api.handleDeleteThumbnail},
+		{Method: "GET", Path: "/thumbnails/{id}/revisions", Handler: api.handleListRevisions},
+		{Method: "POST", Path: "/thumbnails/{id}/revisions", Handler: api.handleCreateRevision},
+		{Method: "GET", Path: "/thumbnails/{id}/revisions/{revision}", Handler: api.handleGetRevision},
+		{Method: "POST", Path: "/thumbnails/{id}/revisions/{revision}/restore", Handler: api.handleRestoreRevision},
+		{Method: "PUT", Path: "/thumbnails/{id}/tags", Handler: api.handleSetTags},
+		{Method: "GET", Path: "/thumbnails/{id}/similar", Handler: api.handleListSimilar},
+		{Method: "GET", Path: "/search", Handler: api.handleSearch},
+		{Method: "POST", Path: "/search/reindex", Handler: api.handleReindex},
+		{Method: "POST", Path: "/uploads", Handler: api.handleCreateUpload},
+		{Method: "POST", Path: "/assets", Handler: api.handleCreateAsset},
+		{Method: "GET", Path: "/assets", Handler: api.handleListAssets},
+		{Method: "DELETE", Path: "/assets/{id}", Handler: api.handleDeleteAsset},
+		{Method: "GET", Path: "/trash", Handler: api.handleListTrash},
+		{Method: "POST", Path: "/trash/{id}/restore", Handler: api.handleRestoreThumbnail},
+		{Method: "DELETE", Path: "/trash/{id}", Handler: api.handleDeletePermanently},
+
+		{Method: "POST", Path: "/collections", Handler: api.handleCreateCollection},
+		{Method: "GET", Path: "/collections", Handler: api.handleListCollections},
+		{Method: "PUT", Path: "/collections/order", Handler: api.handleReorderCollections},
+		{Method: "GET", Path: "/collections/{id}", Handler: api.handleGetCollection},
+		{Method: "PUT", Path: "/collections/{id}", Handler: api.handleUpdateCollection},
+		{Method: "DELETE", Path: "/collections/{id}", Handler: api.handleDeleteCollection},
+		{Method: "POST", Path: "/collections/{id}/thumbnails", Handler: api.handleCollectionThumbnails},
+		{Method: "DELETE", Path: "/collections/{id}/thumbnails", Handler: api.handleCollectionThumbnails},
+
+		{Method: "POST", Path: "/shares", Handler: api.handleCreateShare},
+		{Method: "GET", Path: "/shares", Handler: api.handleListShares},
+		{Method: "DELETE", Path: "/shares/{token}", Handler: api.handleRevokeShare},
+
+		{Method: "POST", Path: "/exports", Handler: api.handleCreateExport},
+		{Method: "GET", Path: "/exports", Handler: api.handleListExports},
+		{Method: "GET", Path: "/exports/{id}", Handler: api.handleGetExport},
+		{Method: "DELETE", Path: "/account", Handler: api.handleDeleteAccount},
+		{Method: "POST", Path: "/account/export", Handler: api.handleCreateAccountExport},
+		{Method: "GET", Path: "/usage", Handler: api.handleGetUsage},
+
+		{Method: "POST", Path: "/subscriptions", Handler: api.handleCreateSubscription},
+		{Method: "GET", Path: "/credits", Handler: api.handleGetCredits},
+
+		{Method: "POST", Path: "/organizations", Handler: api.handleCreateOrganization},
+		{Method: "POST", Path: "/organizations/{id}/members", Handler: api.handleAddOrganizationMember},
+		{Method: "POST", Path: "/organizations/{id}/join", Handler: api.handleJoinOrganization},
+		{Method: "POST", Path: "/organizations/{id}/leave", Handler: api.handleLeaveOrganization},
+		{Method: "PUT", Path: "/organizations/{id}/mfa", Handler: api.handleSetOrganizationMFA},
+
+		// Every admin handler checks its own permission and records an audit
+		// entry
+		{Method: "GET", Path: "/admin/users", Handler: api.handleAdminFindUser},
+		{Method: "GET", Path: "/admin/users/{id}", Handler: api.handleAdminGetUser},
+		{Method: "POST", Path: "/admin/users/{id}/credits", Handler: api.handleAdminAdjustCredits},
+		{Method: "PUT", Path: "/admin/users/{id}/plan", Handler: api.handleAdminChangePlan},
+		{Method: "POST", Path: "/admin/users/{id}/disable", Handler: api.handleAdminDisableUser},
+		{Method: "GET", Path: "/admin/users/{id}/thumbnails", Handler: api.handleAdminListThumbnails},
+	}
+}
+
+// newRouter dispatches api's routes behind the rate limiter. Every response
+// carries the request ID.
+func (api *API) newRouter() *router.Router {
+	return router.New(router.Config{
+		Routes:     api.routes(),
+		Middleware: []router.Middleware{withRequestID, api.rateLimit},
+		NotFound: func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			return errorResponse(http.StatusNotFound, "not found"), nil
+		},
+		MethodNotAllowed: func(allowed []string) events.APIGatewayProxyResponse {
+			return errorResponse(http.StatusMethodNotAllowed, "method not allowed")
+		},
+	})
+}
+
+// writeRoutes prints the route table as JSON for the deployment
+func writeRoutes(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode((&API{}).newRouter().Routes())
+}
+
+func (api *API) handleHealth(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return jsonResponse(http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// TestRoutesGenerated fails when routes.go changed without regenerating the
+// route table the gateway deploys
+func TestRoutesGenerated(t *testing.T) {
+	deployed, err := os.ReadFile("../../infrastructure/routes.json")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	var generated bytes.Buffer
+	if err := writeRoutes(&generated); err != nil {
+		t.Fatalf("writeRoutes: %v", err)
+	}
+
+	if !bytes.Equal(generated.Bytes(), deployed) {
+		t.Error("infrastructure/routes.json is out of date; run go generate in cmd/api")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/ai"
+	"github.com/celebthumb-ai/internal/apierror"
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/celebthumb-ai/internal/search"
+	"github.com/celebthumb-ai/internal/storage"
+)
+
+// searchResult is a matching thumbnail with its relevance score
+type searchResult struct {
+	*models.Thumbnail
+	Score float64 `json:"score"`
+}
+
+// handleSearch runs a ranked search over the caller's thumbnails: GET
+// /search?q=...&limit=
+func (api *API) handleSearch(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	limit := search.DefaultLimit
+	if value := request.QueryStringParameters["limit"]; value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > search.MaxLimit {
+			return validationResponse(apierror.Field("limit", fmt.Sprintf("must be between 1 and %d", search.MaxLimit))), nil
+		}
+		limit = n
+	}
+
+	hits, err := api.searchIndex.Search(ctx, user.ID, request.QueryStringParameters["q"], limit)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to search thumbnails"), nil
+	}
+
+	ids := make([]string, 0, len(hits))
+	scores := make(map[string]float64, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.ThumbnailID)
+		scores[hit.ThumbnailID] = hit.Score
+	}
+
+	thumbnails, err := api.storageService.GetThumbnails(ctx, user.ID, ids)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to search thumbnails"), nil
+	}
+
+	results := make([]searchResult, 0, len(thumbnails))
+	for _, thumbnail := range thumbnails {
+		results = append(results, searchResult{Thumbnail: thumbnail, Score: scores[thumbnail.ID]})
+	}
+
+	return jsonResponse(http.StatusOK, map[string]interface{}{
+		"items": results,
+	})
+}
+
+// handleReindex rebuilds the search index for the caller's thumbnails
+func (api *API) handleReindex(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	count, err := api.storageService.Reindex(ctx, user.ID)
+	if err != nil {
+		log.Printf("failed to reindex thumbnails of %s after %d: %v", user.ID, count, err)
+		return errorResponse(http.StatusInternalServerError, "failed to reindex thumbnails"), nil
+	}
+
+	return jsonResponse(http.StatusOK, map[string]int{
+		"indexed": count,
+	})
+}
+
+// handleSetTags replaces the user's tags on a thumbnail
+func (api *API) handleSetTags(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	thumbnail, err := api.storageService.SetTags(ctx, user.ID, request.PathParameters["id"], req.Tags)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to update tags"), nil
+	}
+
+	return jsonResponse(http.StatusOK, thumbnail)
+}
+
+// aiTags turns a content analysis into stored tags, keeping the first
+// storage.MaxTags valid ones
+func aiTags(analysis *ai.ContentAnalysis) []string {
+	if analysis == nil {
+		return nil
+	}
+
+	var tags []string
+	for _, tag := range analysis.Tags() {
+		if len(tags) == storage.MaxTags {
+			break
+		}
+		// Overlong tags are skipped rather than failing the rest
+		normalized, err := storage.NormalizeTags(append(tags, tag))
+		if err != nil {
+			continue
+		}
+		tags = normalized
+	}
+	return tags
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxRequestBody matches the Lambda synchronous invocation payload limit
+	maxRequestBody = 6 << 20
+	// shutdownTimeout is how long in-flight requests get to finish
+	shutdownTimeout = 15 * time.Second
+)
+
+// corsHeaders mirrors the gateway's CORS settings in infrastructure/stacks/api.ts
+var corsHeaders = map[string]string{
+	"Access-Control-Allow-Origin":  "*",
+	"Access-Control-Allow-Methods": "GET, POST, PUT, DELETE",
+	"Access-Control-Allow-Headers": "Content-Type, Authorization, X-Api-Key, X-Amz-Security-Token, If-None-Match, Range, X-Share-Password",
+}
+
+//go:embed profiles/*.env
+var profiles embed.FS
+
+// serve runs the API as a plain HTTP server for local development. Each
+// request is translated into the API Gateway event the Lambda would receive,
+// so it goes through exactly the same handlers.
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:8080", "address to listen on")
+	profile := flags.String("profile", "", `config profile to load, e.g. "local"`)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *profile != "" {
+		if err := loadProfile(*profile); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if os.Getenv("LOCAL_BOOTSTRAP") == "true" {
+		if err := bootstrapLocal(ctx); err != nil {
+			return err
+		}
+	}
+
+	api, err := newAPI(ctx)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", withCORS(http.HandlerFunc(api.serveHTTP)))
+
+	// Presigned URLs of the filesystem store point back at this server
+	if store, ok := api.blobStore.(*blob.FSStore); ok {
+		baseURL, err := url.Parse(os.Getenv("BLOB_BASE_URL"))
+		if err != nil {
+			return fmt.Errorf("invalid blob base URL: %w", err)
+		}
+		prefix := strings.TrimSuffix(baseURL.Path, "/") + "/"
+		mux.Handle(prefix, withCORS(store.Handler()))
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("serving API on http://%s", *addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down: %w", err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// serveHTTP answers a request through the same router the Lambda uses
+func (api *API) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	request, err := eventFromRequest(r)
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	response, err := api.handleRequest(r.Context(), request)
+	if err != nil {
+		// Lambda would answer a handler error with a bare 502
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	if err := writeResponse(w, response); err != nil {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	}
+}
+
+// eventFromRequest builds the proxy event API Gateway would send for r
+func eventFromRequest(r *http.Request) (events.APIGatewayProxyRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody+1))
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+	if len(body) > maxRequestBody {
+		return events.APIGatewayProxyRequest{}, errors.New("request body too large")
+	}
+
+	headers := make(map[string]string, len(r.Header))
+	for name, values := range r.Header {
+		headers[name] = values[len(values)-1]
+	}
+	query := r.URL.Query()
+	params := make(map[string]string, len(query))
+	for name, values := range query {
+		params[name] = values[len(values)-1]
+	}
+
+	sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceIP = r.RemoteAddr
+	}
+
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod:                      r.Method,
+		Path:                            r.URL.Path,
+		Headers:                         headers,
+		MultiValueHeaders:               r.Header,
+		QueryStringParameters:           params,
+		MultiValueQueryStringParameters: query,
+		RequestContext: events.APIGatewayProxyRequestContext{
+			RequestID:  uuid.New().String(),
+			Stage:      "local",
+			HTTPMethod: r.Method,
+			Path:       r.URL.Path,
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  sourceIP,
+				UserAgent: r.UserAgent(),
+			},
+		},
+	}
+
+	if isTextContent(r.Header.Get("Content-Type")) {
+		request.Body = string(body)
+	} else {
+		request.Body = base64.StdEncoding.EncodeToString(body)
+		request.IsBase64Encoded = true
+	}
+
+	return request, nil
+}
+
+// isTextContent reports whether the gateway would pass a body through as text
+// rather than base64-encode it
+func isTextContent(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		mediaType == "application/x-www-form-urlencoded" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+func writeResponse(w http.ResponseWriter, response events.APIGatewayProxyResponse) error {
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	for name, values := range response.MultiValueHeaders {
+		w.Header().Del(name)
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+
+	body := []byte(response.Body)
+	if response.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return fmt.Errorf("invalid base64 response body: %w", err)
+		}
+		body = decoded
+	}
+
+	w.WriteHeader(response.StatusCode)
+	_, err := w.Write(body)
+	return err
+}
+
+// withCORS answers preflight requests and adds CORS headers the way the
+// gateway does
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range corsHeaders {
+			w.Header().Set(name, value)
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loadProfile sets the variables in profiles/<name>.env. Variables that are
+// already set win, so single settings can be overridden from the shell.
+func loadProfile(name string) error {
+	file, err := profiles.Open("profiles/" + name + ".env")
+	if err != nil {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("invalid line in profile %q: %s", name, line)
+		}
+		if _, set := os.LookupEnv(key); !set {
+			os.Setenv(key, value)
+		}
+	}
+	return scanner.Err()
+}
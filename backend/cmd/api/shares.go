@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/share"
+)
+
+// handleCreateShare makes a link to a thumbnail or collection, optionally with
+// a password, an RFC 3339 expiry and a view limit
+func (api *API) handleCreateShare(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		Kind      string     `json:"kind"`
+		TargetID  string     `json:"targetId"`
+		Password  string     `json:"password"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+		MaxViews  int        `json:"maxViews"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	link, err := api.shareService.CreateShare(ctx, user.ID, share.Request{
+		Kind:      req.Kind,
+		TargetID:  req.TargetID,
+		Password:  req.Password,
+		ExpiresAt: req.ExpiresAt,
+		MaxViews:  req.MaxViews,
+	})
+	if err != nil {
+		return serviceErrorResponse(err, "failed to create share link"), nil
+	}
+
+	return jsonResponse(http.StatusCreated, link)
+}
+
+// handleListShares lists the caller's links with their view counts
+func (api *API) handleListShares(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	links, err := api.shareService.ListShares(ctx, user.ID)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to list share links"), nil
+	}
+
+	return jsonResponse(http.StatusOK, links)
+}
+
+func (api *API) handleRevokeShare(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	link, err := api.shareService.RevokeShare(ctx, user.ID, request.PathParameters["token"])
+	if err != nil {
+		return serviceErrorResponse(err, "failed to revoke share link"), nil
+	}
+
+	return jsonResponse(http.StatusOK, link)
+}
+
+// handleOpenShare is the unauthenticated read-only view behind a share link.
+// Password-protected links take the password in the X-Share-Password header.
+func (api *API) handleOpenShare(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	view, err := api.shareService.OpenShare(ctx, request.PathParameters["token"], headerValue(request, "X-Share-Password"))
+	if err != nil {
+		return serviceErrorResponse(err, "failed to open share link"), nil
+	}
+
+	// Preview URLs are presigned per view, so the page mustn't be cached
+	response, err := jsonResponse(http.StatusOK, view)
+	return withHeaders(response, map[string]string{"Cache-Control": "no-store"}), err
+}
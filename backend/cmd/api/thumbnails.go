@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/apierror"
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/celebthumb-ai/internal/storage"
+)
+
+// parseListOptions reads the GET /thumbnails query parameters: limit, cursor,
+// sort (asc or desc by creation date), style, templateId, collection, status,
+// and an RFC 3339 from/to creation date range
+func parseListOptions(params map[string]string) (storage.ListOptions, error) {
+	opts := storage.ListOptions{
+		Cursor:       params["cursor"],
+		Style:        params["style"],
+		TemplateID:   params["templateId"],
+		CollectionID: params["collection"],
+		Status:       params["status"],
+	}
+
+	if limit := params["limit"]; limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > storage.MaxPageSize {
+			return opts, fmt.Errorf("limit must be between 1 and %d", storage.MaxPageSize)
+		}
+		opts.Limit = n
+	}
+
+	switch params["sort"] {
+	case "", "desc":
+	case "asc":
+		opts.Ascending = true
+	default:
+		return opts, fmt.Errorf("sort must be asc or desc")
+	}
+
+	for name, dest := range map[string]*time.Time{"from": &opts.From, "to": &opts.To} {
+		value := params[name]
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return opts, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+		}
+		*dest = t
+	}
+
+	if !opts.From.IsZero() && !opts.To.IsZero() && opts.To.Before(opts.From) {
+		return opts, fmt.Errorf("to must not be before from")
+	}
+
+	return opts, nil
+}
+
+func (api *API) redirectToThumbnail(ctx context.Context, userID, thumbnailID string) (events.APIGatewayProxyResponse, error) {
+	thumbnail, err := api.storageService.GetThumbnailRecord(ctx, userID, thumbnailID)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to get thumbnail"), nil
+	}
+
+	if err := api.storageService.PresignThumbnail(ctx, thumbnail); err != nil {
+		return serviceErrorResponse(err, "failed to get thumbnail"), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusFound,
+		Headers: map[string]string{
+			"Location":      thumbnail.URL,
+			"Cache-Control": "no-store",
+		},
+	}, nil
+}
+
+// handleCreateUpload hands out a presigned PUT URL for a source image, so the
+// bytes go straight to S3 rather than through the Lambda payload
+func (api *API) handleCreateUpload(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	var req struct {
+		ContentType   string `json:"contentType"`
+		ContentLength int64  `json:"contentLength"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid request"), nil
+	}
+
+	upload, err := api.storageService.PresignUpload(ctx, user.ID, req.ContentType, req.ContentLength)
+	switch {
+	case errors.Is(err, storage.ErrUnsupportedContentType):
+		return validationResponse(apierror.Field("contentType", "must be image/jpeg or image/png")), nil
+	case errors.Is(err, storage.ErrUploadTooLarge):
+		return validationResponse(apierror.Field("contentLength", fmt.Sprintf("must be between 1 and %d bytes", storage.MaxUploadSize))), nil
+	case err != nil:
+		return serviceErrorResponse(err, "failed to create upload"), nil
+	}
+
+	return jsonResponse(http.StatusCreated, upload)
+}
+
+// thumbnailCacheControl lets browsers keep a thumbnail briefly and then
+// revalidate it with If-None-Match
+const thumbnailCacheControl = "private, max-age=300, must-revalidate"
+
+// maxInlineThumbnail is the largest body proxied through Lambda. Responses
+// are capped at 6 MB and base64 grows the body by a third, so larger reads
+// are redirected to a presigned URL instead.
+const maxInlineThumbnail = 4 << 20
+
+// thumbnailResponse builds the 200, 206 or 304 response for an open thumbnail
+// read, base64-encoding the body as API Gateway requires for binary payloads
+func thumbnailResponse(object *blob.Object) (events.APIGatewayProxyResponse, error) {
+	headers := map[string]string{
+		"ETag":          object.ETag,
+		"Cache-Control": thumbnailCacheControl,
+		"Accept-Ranges": "bytes",
+	}
+	if object.Checksum != "" {
+		headers["X-Checksum-Sha256"] = object.Checksum
+	}
+
+	if object.NotModified {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusNotModified,
+			Headers:    headers,
+		}, nil
+	}
+	defer object.Body.Close()
+
+	var body strings.Builder
+	encoder := base64.NewEncoder(base64.StdEncoding, &body)
+	if _, err := io.Copy(encoder, object.Body); err != nil {
+		return serviceErrorResponse(err, "failed to read thumbnail"), nil
+	}
+	encoder.Close()
+
+	statusCode := http.StatusOK
+	if object.Partial {
+		statusCode = http.StatusPartialContent
+		headers["Content-Range"] = object.ContentRange
+	}
+	headers["Content-Type"] = object.ContentType
+	if !object.LastModified.IsZero() {
+		headers["Last-Modified"] = object.LastModified.UTC().Format(http.TimeFormat)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode:      statusCode,
+		Headers:         headers,
+		Body:            body.String(),
+		IsBase64Encoded: true,
+	}, nil
+}
+
+// headerValue looks a header up case-insensitively, since HTTP/2 clients send
+// lowercase names
+func headerValue(request events.APIGatewayProxyRequest, name string) string {
+	if value, ok := request.Headers[name]; ok {
+		return value
+	}
+	for key, value := range request.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
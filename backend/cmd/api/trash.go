@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/celebthumb-ai/internal/apierror"
+	"github.com/celebthumb-ai/internal/storage"
+)
+
+func (api *API) handleListTrash(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	opts, err := parseListOptions(request.QueryStringParameters)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+
+	page, err := api.storageService.ListTrash(ctx, user.ID, opts)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to list trash"), nil
+	}
+
+	return jsonResponse(http.StatusOK, page)
+}
+
+func (api *API) handleRestoreThumbnail(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	thumbnail, err := api.storageService.RestoreThumbnail(ctx, user.ID, request.PathParameters["id"])
+	if errors.Is(err, storage.ErrThumbnailNotFound) {
+		return apierror.New(http.StatusNotFound, apierror.CodeThumbnailNotFound, "thumbnail not found in trash").Response(), nil
+	}
+	if err != nil {
+		return serviceErrorResponse(err, "failed to restore thumbnail"), nil
+	}
+
+	return jsonResponse(http.StatusOK, thumbnail)
+}
+
+func (api *API) handleDeletePermanently(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	err := api.storageService.DeletePermanently(ctx, user.ID, request.PathParameters["id"])
+	if errors.Is(err, storage.ErrThumbnailNotFound) {
+		return apierror.New(http.StatusNotFound, apierror.CodeThumbnailNotFound, "thumbnail not found in trash").Response(), nil
+	}
+	if err != nil {
+		return serviceErrorResponse(err, "failed to delete thumbnail"), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+	}, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// handleGetUsage reports how much the caller has stored and their plan's
+// storage quota
+func (api *API) handleGetUsage(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, errResp := api.authenticate(ctx, request)
+	if errResp != nil {
+		return *errResp, nil
+	}
+
+	usage, err := api.storageService.GetUsage(ctx, user.ID)
+	if err != nil {
+		return serviceErrorResponse(err, "failed to get usage"), nil
+	}
+
+	return jsonResponse(http.StatusOK, usage)
+}
+
+func quotaExceededResponse() events.APIGatewayProxyResponse {
+	return errorResponse(http.StatusInsufficientStorage, "storage quota exceeded; delete thumbnails or upgrade your plan")
+}
@@ -0,0 +1,82 @@
+// Command export builds ZIP archives for export jobs, including "download my
+// data" account exports. It consumes the exports table's stream and runs every
+// newly inserted export.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/celebthumb-ai/internal/account"
+	"github.com/celebthumb-ai/internal/billing"
+	"github.com/celebthumb-ai/internal/collection"
+	"github.com/celebthumb-ai/internal/export"
+	"github.com/celebthumb-ai/internal/storage"
+)
+
+func handleStream(ctx context.Context, event events.DynamoDBEvent) error {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	// Account exports only need the billing and collection data
+	accountData := account.NewAccountService(account.AccountConfig{
+		Billing: billing.NewBillingService(billing.BillingConfig{
+			DynamoClient: dynamodb.NewFromConfig(cfg),
+			TableName:    os.Getenv("USERS_TABLE"),
+			StripeKey:    os.Getenv("STRIPE_SECRET_KEY"),
+			LedgerTable:  os.Getenv("CREDIT_LEDGER_TABLE"),
+		}),
+		Collections: collection.NewCollectionService(collection.CollectionConfig{
+			DynamoClient:    dynamodb.NewFromConfig(cfg),
+			TableName:       os.Getenv("COLLECTIONS_TABLE"),
+			ThumbnailsTable: os.Getenv("THUMBNAILS_TABLE"),
+		}),
+	})
+
+	exportService := export.NewExportService(export.ExportConfig{
+		DynamoClient: dynamodb.NewFromConfig(cfg),
+		TableName:    os.Getenv("EXPORTS_TABLE"),
+		Storage: storage.NewStorageService(storage.StorageConfig{
+			S3Client:        s3.NewFromConfig(cfg),
+			Bucket:          os.Getenv("THUMBNAIL_BUCKET"),
+			DynamoClient:    dynamodb.NewFromConfig(cfg),
+			ThumbnailsTable: os.Getenv("THUMBNAILS_TABLE"),
+			RevisionsTable:  os.Getenv("THUMBNAIL_REVISIONS_TABLE"),
+		}),
+		Notifier:    newNotifier(),
+		AccountData: accountData,
+	})
+
+	// A returned error makes Lambda retry the batch; Run skips exports that
+	// have already been handled
+	for _, record := range event.Records {
+		if record.EventName != string(events.DynamoDBOperationTypeInsert) {
+			continue
+		}
+		if err := exportService.Run(ctx, record.Change.Keys["id"].String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newNotifier returns an email notifier when SMTP is configured
+func newNotifier() export.Notifier {
+	if notifier := export.NewSMTPNotifier(export.SMTPConfig{}); notifier != nil {
+		return notifier
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(handleStream)
+}
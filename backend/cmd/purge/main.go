@@ -0,0 +1,118 @@
+// Command purge is the scheduled job that permanently deletes thumbnails that
+// have been in the trash longer than TRASH_RETENTION. It also finishes account
+// deletions that failed partway.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/celebthumb-ai/internal/account"
+	"github.com/celebthumb-ai/internal/asset"
+	"github.com/celebthumb-ai/internal/auth"
+	"github.com/celebthumb-ai/internal/billing"
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/celebthumb-ai/internal/collection"
+	"github.com/celebthumb-ai/internal/export"
+	"github.com/celebthumb-ai/internal/organization"
+	"github.com/celebthumb-ai/internal/search"
+	"github.com/celebthumb-ai/internal/share"
+	"github.com/celebthumb-ai/internal/storage"
+)
+
+func handleSchedule(ctx context.Context) error {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	searchIndex := search.NewIndex(search.IndexConfig{
+		DynamoClient: dynamodb.NewFromConfig(cfg),
+		TableName:    os.Getenv("SEARCH_INDEX_TABLE"),
+	})
+
+	blobStore := blob.NewS3Store(blob.S3StoreConfig{
+		S3Client: s3.NewFromConfig(cfg),
+		Bucket:   os.Getenv("THUMBNAIL_BUCKET"),
+	})
+
+	assetService := asset.NewAssetService(asset.AssetConfig{
+		DynamoClient: dynamodb.NewFromConfig(cfg),
+		Store:        blobStore,
+		TableName:    os.Getenv("ASSETS_TABLE"),
+		RefsTable:    os.Getenv("ASSET_REFS_TABLE"),
+	})
+
+	storageService := storage.NewStorageService(storage.StorageConfig{
+		Store:           blobStore,
+		DynamoClient:    dynamodb.NewFromConfig(cfg),
+		ThumbnailsTable: os.Getenv("THUMBNAILS_TABLE"),
+		RevisionsTable:  os.Getenv("THUMBNAIL_REVISIONS_TABLE"),
+		Indexer:         searchIndex,
+		UsageTable:      os.Getenv("USAGE_TABLE"),
+		Assets:          assetService,
+	})
+
+	retention := storage.TrashRetentionFromEnv()
+	purged, purgeErr := storageService.PurgeTrash(ctx, retention)
+	log.Printf("purged %d thumbnails trashed more than %s ago", purged, retention)
+
+	collectionService := collection.NewCollectionService(collection.CollectionConfig{
+		DynamoClient:    dynamodb.NewFromConfig(cfg),
+		TableName:       os.Getenv("COLLECTIONS_TABLE"),
+		ThumbnailsTable: os.Getenv("THUMBNAILS_TABLE"),
+	})
+
+	accountService := account.NewAccountService(account.AccountConfig{
+		DynamoClient: dynamodb.NewFromConfig(cfg),
+		TableName:    os.Getenv("ACCOUNT_DELETIONS_TABLE"),
+		Auth: auth.NewAuthService(auth.AuthConfig{
+			CognitoClient: cognitoidentityprovider.NewFromConfig(cfg),
+			UserPoolID:    os.Getenv("USER_POOL_ID"),
+			ClientID:      os.Getenv("USER_POOL_CLIENT_ID"),
+		}),
+		Billing: billing.NewBillingService(billing.BillingConfig{
+			DynamoClient: dynamodb.NewFromConfig(cfg),
+			TableName:    os.Getenv("USERS_TABLE"),
+			StripeKey:    os.Getenv("STRIPE_SECRET_KEY"),
+			LedgerTable:  os.Getenv("CREDIT_LEDGER_TABLE"),
+		}),
+		Storage:     storageService,
+		Collections: collectionService,
+		Exports: export.NewExportService(export.ExportConfig{
+			DynamoClient: dynamodb.NewFromConfig(cfg),
+			TableName:    os.Getenv("EXPORTS_TABLE"),
+			Storage:      storageService,
+		}),
+		Search: searchIndex,
+		Organizations: organization.NewOrganizationService(organization.OrganizationConfig{
+			DynamoClient: dynamodb.NewFromConfig(cfg),
+			TableName:    os.Getenv("ORGANIZATIONS_TABLE"),
+			UsersTable:   os.Getenv("USERS_TABLE"),
+		}),
+		Assets: assetService,
+		Shares: share.NewShareService(share.ShareConfig{
+			DynamoClient: dynamodb.NewFromConfig(cfg),
+			TableName:    os.Getenv("SHARES_TABLE"),
+			Storage:      storageService,
+			Collections:  collectionService,
+		}),
+	})
+
+	resumed, resumeErr := accountService.ResumeDeletions(ctx)
+	log.Printf("finished %d pending account deletions", resumed)
+
+	return errors.Join(purgeErr, resumeErr)
+}
+
+func main() {
+	lambda.Start(handleSchedule)
+}
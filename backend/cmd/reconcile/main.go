@@ -0,0 +1,38 @@
+// Command reconcile is the scheduled job that recomputes every user's storage
+// usage from the bucket, correcting drift left by failed counter updates.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/celebthumb-ai/internal/storage"
+)
+
+func handleSchedule(ctx context.Context) error {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	storageService := storage.NewStorageService(storage.StorageConfig{
+		S3Client:     s3.NewFromConfig(cfg),
+		Bucket:       os.Getenv("THUMBNAIL_BUCKET"),
+		DynamoClient: dynamodb.NewFromConfig(cfg),
+		UsageTable:   os.Getenv("USAGE_TABLE"),
+	})
+
+	users, err := storageService.ReconcileUsage(ctx)
+	log.Printf("reconciled storage usage of %d users", users)
+	return err
+}
+
+func main() {
+	lambda.Start(handleSchedule)
+}
@@ -1,5 +1,6 @@
-import { StackContext, Api, Function, use } from "sst/constructs";
+import { StackContext, Api, Cron, Function, use } from "sst/constructs";
 import { AuthStack } from "./auth";
+import routeTable from "../routes.json";
 
 export function APIStack({ stack }: StackContext) {
   // Reference the Auth stack
@@ -16,9 +17,78 @@ export function APIStack({ stack }: StackContext) {
       USER_POOL_CLIENT_ID: auth.userPoolClientId,
       THUMBNAIL_BUCKET: stack.stage + "-thumbnails-bucket",
       USERS_TABLE: stack.stage + "-users-table",
+      THUMBNAILS_TABLE: stack.stage + "-thumbnails-table",
+      THUMBNAIL_REVISIONS_TABLE: stack.stage + "-thumbnail-revisions-table",
+      THUMBNAIL_URL_EXPIRY: "15m",
+      RATE_LIMIT_TABLE: stack.stage + "-rate-limits-table",
+      COLLECTIONS_TABLE: stack.stage + "-collections-table",
+      SEARCH_INDEX_TABLE: stack.stage + "-search-index-table",
+      EXPORTS_TABLE: stack.stage + "-exports-table",
+      EXPORT_URL_EXPIRY: "24h",
+      SHARES_TABLE: stack.stage + "-shares-table",
+      ORGANIZATIONS_TABLE: stack.stage + "-organizations-table",
+      AUDIT_LOG_TABLE: stack.stage + "-audit-log-table",
+      CREDIT_LEDGER_TABLE: stack.stage + "-credit-ledger-table",
+      USAGE_TABLE: stack.stage + "-usage-table",
+      ASSETS_TABLE: stack.stage + "-assets-table",
+      ASSET_REFS_TABLE: stack.stage + "-asset-refs-table",
+      ACCOUNT_DELETIONS_TABLE: stack.stage + "-account-deletions-table",
+      STRIPE_SECRET_KEY: process.env.STRIPE_SECRET_KEY ?? "",
+      OIDC_PROVIDERS: process.env.OIDC_PROVIDERS ?? "",
+      OIDC_STATE_SECRET: process.env.OIDC_STATE_SECRET ?? "",
     },
   });
 
+  // Purge thumbnails that have been in the trash longer than the retention
+  // period and finish account deletions that failed partway
+  const purgeJob = new Cron(stack, "PurgeTrash", {
+    schedule: "rate(1 day)",
+    job: {
+      function: {
+        handler: "cmd/purge/main.go",
+        runtime: "go1.x",
+        environment: {
+          THUMBNAIL_BUCKET: stack.stage + "-thumbnails-bucket",
+          THUMBNAILS_TABLE: stack.stage + "-thumbnails-table",
+          THUMBNAIL_REVISIONS_TABLE: stack.stage + "-thumbnail-revisions-table",
+          SEARCH_INDEX_TABLE: stack.stage + "-search-index-table",
+          TRASH_RETENTION: "720h",
+          USER_POOL_ID: auth.userPoolId,
+          USER_POOL_CLIENT_ID: auth.userPoolClientId,
+          USERS_TABLE: stack.stage + "-users-table",
+          COLLECTIONS_TABLE: stack.stage + "-collections-table",
+          EXPORTS_TABLE: stack.stage + "-exports-table",
+          SHARES_TABLE: stack.stage + "-shares-table",
+          ORGANIZATIONS_TABLE: stack.stage + "-organizations-table",
+          CREDIT_LEDGER_TABLE: stack.stage + "-credit-ledger-table",
+          ACCOUNT_DELETIONS_TABLE: stack.stage + "-account-deletions-table",
+          USAGE_TABLE: stack.stage + "-usage-table",
+          ASSETS_TABLE: stack.stage + "-assets-table",
+          ASSET_REFS_TABLE: stack.stage + "-asset-refs-table",
+          STRIPE_SECRET_KEY: process.env.STRIPE_SECRET_KEY ?? "",
+        },
+      },
+    },
+  });
+  purgeJob.attachPermissions(["dynamodb:*", "s3:*", "cognito-idp:*"]);
+
+  // Recompute storage usage from the bucket to correct drift in the counters
+  const reconcileJob = new Cron(stack, "ReconcileUsage", {
+    schedule: "rate(1 day)",
+    job: {
+      function: {
+        handler: "cmd/reconcile/main.go",
+        runtime: "go1.x",
+        timeout: "15 minutes",
+        environment: {
+          THUMBNAIL_BUCKET: stack.stage + "-thumbnails-bucket",
+          USAGE_TABLE: stack.stage + "-usage-table",
+        },
+      },
+    },
+  });
+  reconcileJob.attachPermissions(["dynamodb:*", "s3:*"]);
+
   // Create the API Gateway
   const api = new Api(stack, "API", {
     cors: {
@@ -29,34 +99,25 @@ export function APIStack({ stack }: StackContext) {
         "Authorization",
         "X-Api-Key",
         "X-Amz-Security-Token",
+        "If-None-Match",
+        "Range",
+        "X-Share-Password",
       ],
     },
     defaults: {
       authorizer: "iam",
       function: apiFunction,
     },
-    routes: {
-      "GET /health": {
-        authorizer: "none",
-        function: apiFunction,
-      },
-      "POST /auth/register": {
-        authorizer: "none",
-        function: apiFunction,
-      },
-      "POST /auth/login": {
-        authorizer: "none",
-        function: apiFunction,
-      },
-      "POST /thumbnails/generate": apiFunction,
-      "GET /thumbnails": apiFunction,
-      "GET /thumbnails/{id}": apiFunction,
-      "DELETE /thumbnails/{id}": apiFunction,
-      "GET /templates": apiFunction,
-      "POST /templates": apiFunction,
-      "POST /subscriptions": apiFunction,
-      "GET /credits": apiFunction,
-    },
+    // Generated from the Go route table in cmd/api/routes.go, so the
+    // gateway serves exactly the routes the function handles
+    routes: Object.fromEntries(
+      routeTable.map((route) => [
+        `${route.method} ${route.path}`,
+        route.public
+          ? { authorizer: "none" as const, function: apiFunction }
+          : apiFunction,
+      ])
+    ),
   });
 
   // Attach the auth to the API
@@ -66,6 +127,19 @@ export function APIStack({ stack }: StackContext) {
   bucket.grantReadWrite(apiFunction);
   usersTable.grantReadWriteData(apiFunction);
   thumbnailsTable.grantReadWriteData(apiFunction);
+  thumbnailRevisionsTable.grantReadWriteData(apiFunction);
+  rateLimitsTable.grantReadWriteData(apiFunction);
+  collectionsTable.grantReadWriteData(apiFunction);
+  searchIndexTable.grantReadWriteData(apiFunction);
+  exportsTable.grantReadWriteData(apiFunction);
+  sharesTable.grantReadWriteData(apiFunction);
+  organizationsTable.grantReadWriteData(apiFunction);
+  auditLogTable.grantReadWriteData(apiFunction);
+  creditLedgerTable.grantReadWriteData(apiFunction);
+  accountDeletionsTable.grantReadWriteData(apiFunction);
+  usageTable.grantReadWriteData(apiFunction);
+  assetsTable.grantReadWriteData(apiFunction);
+  assetRefsTable.grantReadWriteData(apiFunction);
 
   // Add additional permissions
   api.attachPermissions([
@@ -1,4 +1,5 @@
 import { StackContext, Cognito, use } from "sst/constructs";
+import { Mfa } from "aws-cdk-lib/aws-cognito";
 import { APIStack } from "./api";
 
 export function AuthStack({ stack }: StackContext) {
@@ -8,6 +9,12 @@ export function AuthStack({ stack }: StackContext) {
     cdk: {
       userPool: {
         selfSignUpEnabled: true,
+        // Users opt in to TOTP; organizations can require it at login
+        mfa: Mfa.OPTIONAL,
+        mfaSecondFactor: {
+          otp: true,
+          sms: false,
+        },
         autoVerify: {
           email: true,
         },
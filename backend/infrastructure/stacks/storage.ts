@@ -1,3 +1,4 @@
+import { Duration } from "aws-cdk-lib";
 import { StackContext, Bucket, Table } from "sst/constructs";
 
 export function StorageStack({ stack }: StackContext) {
@@ -10,6 +11,15 @@ export function StorageStack({ stack }: StackContext) {
         allowedHeaders: ["*"],
       },
     ],
+    cdk: {
+      bucket: {
+        lifecycleRules: [
+          // Export archives are only kept as long as their export records
+          { prefix: "exports/", expiration: Duration.days(7) },
+          { abortIncompleteMultipartUploadAfter: Duration.days(1) },
+        ],
+      },
+    },
   });
 
   // Create a DynamoDB table for users
@@ -35,18 +45,201 @@ export function StorageStack({ stack }: StackContext) {
       videoTitle: "string",
       description: "string",
       style: "string",
-      url: "string",
+      templateId: "string",
+      status: "string",
       createdAt: "string",
+      deletedAt: "string",
     },
     primaryIndex: { partitionKey: "id" },
     globalIndexes: {
-      byUser: { partitionKey: "userId" },
+      byUser: { partitionKey: "userId", sortKey: "createdAt" },
+      // Sparse: only trashed thumbnails have a deletedAt
+      trash: { partitionKey: "status", sortKey: "deletedAt" },
+    },
+  });
+
+  // Create a DynamoDB table for thumbnail revision history
+  const thumbnailRevisionsTable = new Table(stack, "ThumbnailRevisionsTable", {
+    fields: {
+      thumbnailId: "string",
+      revision: "number",
+    },
+    primaryIndex: { partitionKey: "thumbnailId", sortKey: "revision" },
+  });
+
+  // Create a DynamoDB table for thumbnail collections
+  const collectionsTable = new Table(stack, "CollectionsTable", {
+    fields: {
+      id: "string",
+      userId: "string",
+      position: "number",
+    },
+    primaryIndex: { partitionKey: "id" },
+    globalIndexes: {
+      byUser: { partitionKey: "userId", sortKey: "position" },
+    },
+  });
+
+  // Create a DynamoDB table for the thumbnail search index. Each entry is a
+  // "token#thumbnailId" under its owner, so prefix search is one query.
+  const searchIndexTable = new Table(stack, "SearchIndexTable", {
+    fields: {
+      userId: "string",
+      entry: "string",
+      thumbnailId: "string",
+    },
+    primaryIndex: { partitionKey: "userId", sortKey: "entry" },
+    globalIndexes: {
+      byThumbnail: { partitionKey: "thumbnailId" },
+    },
+  });
+
+  // Create a DynamoDB table for library exports. Every new export is picked up
+  // from the table's stream by the function that builds its archive.
+  const exportsTable = new Table(stack, "ExportsTable", {
+    fields: {
+      id: "string",
+      userId: "string",
+      createdAt: "string",
+    },
+    primaryIndex: { partitionKey: "id" },
+    globalIndexes: {
+      byUser: { partitionKey: "userId", sortKey: "createdAt" },
+    },
+    timeToLiveAttribute: "expiresAt",
+    stream: "new_image",
+    consumers: {
+      export: {
+        function: {
+          handler: "cmd/export/main.go",
+          runtime: "go1.x",
+          timeout: "15 minutes",
+          memorySize: "512 MB",
+          environment: {
+            THUMBNAIL_BUCKET: stack.stage + "-thumbnails-bucket",
+            THUMBNAILS_TABLE: stack.stage + "-thumbnails-table",
+            THUMBNAIL_REVISIONS_TABLE: stack.stage + "-thumbnail-revisions-table",
+            EXPORTS_TABLE: stack.stage + "-exports-table",
+            EXPORT_URL_EXPIRY: "24h",
+            // Account exports include profile and billing history
+            USERS_TABLE: stack.stage + "-users-table",
+            COLLECTIONS_TABLE: stack.stage + "-collections-table",
+            CREDIT_LEDGER_TABLE: stack.stage + "-credit-ledger-table",
+            STRIPE_SECRET_KEY: process.env.STRIPE_SECRET_KEY ?? "",
+          },
+          permissions: ["dynamodb", "s3"],
+        },
+        filters: [{ eventName: ["INSERT"] }],
+      },
+    },
+  });
+
+  // Create a DynamoDB table for share links, keyed by their random token
+  const sharesTable = new Table(stack, "SharesTable", {
+    fields: {
+      token: "string",
+      userId: "string",
+      createdAt: "string",
+    },
+    primaryIndex: { partitionKey: "token" },
+    globalIndexes: {
+      byUser: { partitionKey: "userId", sortKey: "createdAt" },
+    },
+  });
+
+  // Create a DynamoDB table for organizations
+  const organizationsTable = new Table(stack, "OrganizationsTable", {
+    fields: {
+      id: "string",
+    },
+    primaryIndex: { partitionKey: "id" },
+  });
+
+  // Create a DynamoDB table for rate limit token buckets
+  const rateLimitsTable = new Table(stack, "RateLimitsTable", {
+    fields: {
+      id: "string",
+    },
+    primaryIndex: { partitionKey: "id" },
+    timeToLiveAttribute: "expiresAt",
+  });
+
+  // Create a DynamoDB table for the admin audit log
+  const auditLogTable = new Table(stack, "AuditLogTable", {
+    fields: {
+      id: "string",
+      targetUserId: "string",
+      createdAt: "string",
+    },
+    primaryIndex: { partitionKey: "id" },
+    globalIndexes: {
+      byTarget: { partitionKey: "targetUserId", sortKey: "createdAt" },
+    },
+  });
+
+  // Create a DynamoDB table for manual credit adjustments
+  const creditLedgerTable = new Table(stack, "CreditLedgerTable", {
+    fields: {
+      userId: "string",
+      id: "string",
+    },
+    primaryIndex: { partitionKey: "userId", sortKey: "id" },
+  });
+
+  // Create a DynamoDB table tracking account deletions, so one that fails
+  // partway can be resumed
+  const accountDeletionsTable = new Table(stack, "AccountDeletionsTable", {
+    fields: {
+      userId: "string",
+      status: "string",
+    },
+    primaryIndex: { partitionKey: "userId" },
+    globalIndexes: {
+      byStatus: { partitionKey: "status" },
+    },
+  });
+
+  // Create a DynamoDB table for per-user storage usage counters
+  const usageTable = new Table(stack, "UsageTable", {
+    fields: {
+      userId: "string",
+    },
+    primaryIndex: { partitionKey: "userId" },
+  });
+
+  // Create DynamoDB tables for content-addressed source assets and the
+  // references that keep them alive
+  const assetsTable = new Table(stack, "AssetsTable", {
+    fields: {
+      hash: "string",
+    },
+    primaryIndex: { partitionKey: "hash" },
+  });
+
+  const assetRefsTable = new Table(stack, "AssetRefsTable", {
+    fields: {
+      ref: "string",
+      hash: "string",
     },
+    primaryIndex: { partitionKey: "ref", sortKey: "hash" },
   });
 
   return {
     bucket,
     usersTable,
     thumbnailsTable,
+    thumbnailRevisionsTable,
+    collectionsTable,
+    searchIndexTable,
+    exportsTable,
+    sharesTable,
+    rateLimitsTable,
+    organizationsTable,
+    auditLogTable,
+    creditLedgerTable,
+    accountDeletionsTable,
+    usageTable,
+    assetsTable,
+    assetRefsTable,
   };
 }
\ No newline at end of file
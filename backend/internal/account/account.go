@@ -0,0 +1,363 @@
+// Package account deletes a user's account and everything it owns, and
+// gathers the personal data included in an account export.
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/asset"
+	"github.com/celebthumb-ai/internal/auth"
+	"github.com/celebthumb-ai/internal/billing"
+	"github.com/celebthumb-ai/internal/collection"
+	"github.com/celebthumb-ai/internal/export"
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/celebthumb-ai/internal/organization"
+	"github.com/celebthumb-ai/internal/search"
+	"github.com/celebthumb-ai/internal/share"
+	"github.com/celebthumb-ai/internal/storage"
+)
+
+var ErrDeletionNotFound = errors.New("account deletion not found")
+
+// Deletion steps, in the order they run. The user is locked out first so
+// nothing new is created while their data is removed, and the identity goes
+// last so a failed deletion can still be retried with the user's id.
+const (
+	StepDisable      = "disable"
+	StepBilling      = "billing"
+	StepOrganization = "organization"
+	StepShares       = "shares"
+	StepExports      = "exports"
+	StepThumbnails   = "thumbnails"
+	StepAssets       = "assets"
+	StepCollections  = "collections"
+	StepSearch       = "search"
+	StepLedger       = "ledger"
+	StepProfile      = "profile"
+	StepIdentity     = "identity"
+)
+
+type AccountConfig struct {
+	DynamoClient  *dynamodb.Client
+	TableName     string
+	Auth          *auth.AuthService
+	Billing       *billing.BillingService
+	Storage       *storage.StorageService
+	Collections   *collection.CollectionService
+	Exports       *export.ExportService
+	Search        *search.Index
+	Organizations *organization.OrganizationService
+	Assets        *asset.AssetService
+	Shares        *share.ShareService
+}
+
+type AccountService struct {
+	dynamoClient  *dynamodb.Client
+	tableName     string
+	auth          *auth.AuthService
+	billing       *billing.BillingService
+	storage       *storage.StorageService
+	collections   *collection.CollectionService
+	exports       *export.ExportService
+	search        *search.Index
+	organizations *organization.OrganizationService
+	assets        *asset.AssetService
+	shares        *share.ShareService
+}
+
+// Data is the personal data included in an account export. Thumbnails are
+// described by the archive's own manifest.
+type Data struct {
+	Profile     *models.User         `json:"profile"`
+	Billing     BillingData          `json:"billing"`
+	Collections []*models.Collection `json:"collections"`
+	GeneratedAt time.Time            `json:"generatedAt"`
+}
+
+type BillingData struct {
+	Invoices []billing.Invoice      `json:"invoices"`
+	Ledger   []*billing.LedgerEntry `json:"ledger"`
+}
+
+type step struct {
+	name string
+	run  func(ctx context.Context, userID string) error
+}
+
+func NewAccountService(config AccountConfig) *AccountService {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("ACCOUNT_DELETIONS_TABLE")
+	}
+
+	return &AccountService{
+		dynamoClient:  config.DynamoClient,
+		tableName:     tableName,
+		auth:          config.Auth,
+		billing:       config.Billing,
+		storage:       config.Storage,
+		collections:   config.Collections,
+		exports:       config.Exports,
+		search:        config.Search,
+		organizations: config.Organizations,
+		assets:        config.Assets,
+		shares:        config.Shares,
+	}
+}
+
+func (s *AccountService) steps() []step {
+	return []step{
+		{StepDisable, s.disable},
+		{StepBilling, s.billing.CancelBilling},
+		{StepOrganization, s.leaveOrganization},
+		{StepShares, s.shares.DeleteUserShares},
+		{StepExports, s.exports.DeleteUserExports},
+		{StepThumbnails, s.storage.DeleteUserData},
+		{StepAssets, s.releaseAssets},
+		{StepCollections, s.collections.DeleteUserCollections},
+		{StepSearch, s.search.RemoveUser},
+		{StepLedger, s.billing.ScrubLedger},
+		{StepProfile, s.billing.DeleteUserRecord},
+		{StepIdentity, s.deleteIdentity},
+	}
+}
+
+// DeleteAccount records a deletion request, or picks up an earlier one, and
+// runs it. When a step fails the deletion stays pending with the error
+// recorded, and the returned record shows how far it got.
+func (s *AccountService) DeleteAccount(ctx context.Context, userID string) (*models.AccountDeletion, error) {
+	deletion, err := s.request(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return deletion, s.run(ctx, deletion)
+}
+
+// GetDeletion returns the state of a user's account deletion
+func (s *AccountService) GetDeletion(ctx context.Context, userID string) (*models.AccountDeletion, error) {
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account deletion: %w", err)
+	}
+	if resp.Item == nil {
+		return nil, ErrDeletionNotFound
+	}
+
+	var deletion models.AccountDeletion
+	if err := attributevalue.UnmarshalMap(resp.Item, &deletion); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal account deletion: %w", err)
+	}
+
+	return &deletion, nil
+}
+
+// ResumeDeletions retries every deletion that hasn't completed and returns
+// how many finished. A deletion that fails again is logged and left pending.
+func (s *AccountService) ResumeDeletions(ctx context.Context) (int, error) {
+	var pending []*models.AccountDeletion
+
+	paginator := dynamodb.NewQueryPaginator(s.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String("byStatus"),
+		KeyConditionExpression: aws.String("#status = :pending"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: models.AccountDeletionPending},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to query account deletions: %w", err)
+		}
+
+		var items []*models.AccountDeletion
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return 0, fmt.Errorf("failed to unmarshal account deletions: %w", err)
+		}
+		pending = append(pending, items...)
+	}
+
+	completed := 0
+	for _, deletion := range pending {
+		if err := s.run(ctx, deletion); err != nil {
+			log.Printf("failed to resume deletion of account %s: %v", deletion.UserID, err)
+			continue
+		}
+		completed++
+	}
+
+	return completed, nil
+}
+
+// AccountData collects the user's profile, billing history and collections.
+// It implements export.AccountDataSource and only needs the billing and
+// collection services.
+func (s *AccountService) AccountData(ctx context.Context, userID string) (interface{}, error) {
+	data := &Data{GeneratedAt: time.Now().UTC()}
+
+	profile, err := s.billing.GetUser(ctx, userID)
+	if err != nil && !errors.Is(err, billing.ErrUserNotFound) {
+		return nil, err
+	}
+	data.Profile = profile
+
+	if data.Billing.Invoices, err = s.billing.BillingHistory(ctx, userID); err != nil {
+		return nil, err
+	}
+	if data.Billing.Ledger, err = s.billing.ListLedger(ctx, userID); err != nil {
+		return nil, err
+	}
+	if data.Collections, err = s.collections.ListCollections(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// request creates the deletion record unless one already exists, in which
+// case the existing one is returned
+func (s *AccountService) request(ctx context.Context, userID string) (*models.AccountDeletion, error) {
+	now := time.Now().UTC()
+	deletion := &models.AccountDeletion{
+		UserID:         userID,
+		Status:         models.AccountDeletionPending,
+		CompletedSteps: []string{},
+		RequestedAt:    now,
+		UpdatedAt:      now,
+	}
+
+	item, err := attributevalue.MarshalMap(deletion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal account deletion: %w", err)
+	}
+
+	_, err = s.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(userId)"),
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return s.GetDeletion(ctx, userID)
+		}
+		return nil, fmt.Errorf("failed to create account deletion: %w", err)
+	}
+
+	return deletion, nil
+}
+
+// run carries out the steps the deletion hasn't completed yet. Every step is
+// safe to repeat, so a step that finished but wasn't recorded just runs again.
+func (s *AccountService) run(ctx context.Context, deletion *models.AccountDeletion) error {
+	if deletion.Status == models.AccountDeletionCompleted {
+		return nil
+	}
+
+	for _, step := range s.steps() {
+		if deletion.StepCompleted(step.name) {
+			continue
+		}
+
+		if err := step.run(ctx, deletion.UserID); err != nil {
+			deletion.Error = fmt.Sprintf("%s: %v", step.name, err)
+			if saveErr := s.save(ctx, deletion); saveErr != nil {
+				log.Printf("failed to record deletion error for %s: %v", deletion.UserID, saveErr)
+			}
+			return fmt.Errorf("failed to delete account at step %s: %w", step.name, err)
+		}
+
+		deletion.CompletedSteps = append(deletion.CompletedSteps, step.name)
+		deletion.Error = ""
+		if err := s.save(ctx, deletion); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	deletion.Status = models.AccountDeletionCompleted
+	deletion.CompletedAt = &now
+	return s.save(ctx, deletion)
+}
+
+func (s *AccountService) save(ctx context.Context, deletion *models.AccountDeletion) error {
+	deletion.UpdatedAt = time.Now().UTC()
+
+	item, err := attributevalue.MarshalMap(deletion)
+	if err != nil {
+		return fmt.Errorf("failed to marshal account deletion: %w", err)
+	}
+
+	_, err = s.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save account deletion: %w", err)
+	}
+
+	return nil
+}
+
+// disable locks the user out. A user already gone from the identity provider
+// has nothing to lock.
+func (s *AccountService) disable(ctx context.Context, userID string) error {
+	err := s.auth.DisableUser(ctx, userID)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		return nil
+	}
+	return err
+}
+
+// leaveOrganization drops the user's admin rights in their organization.
+// Membership itself lives on the user record, which is deleted later.
+func (s *AccountService) leaveOrganization(ctx context.Context, userID string) error {
+	user, err := s.billing.GetUser(ctx, userID)
+	if errors.Is(err, billing.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.OrgID == "" {
+		return nil
+	}
+
+	return s.organizations.RemoveAdmin(ctx, user.OrgID, userID)
+}
+
+// releaseAssets drops the user's asset library. Assets still used by another
+// user's thumbnails are kept.
+func (s *AccountService) releaseAssets(ctx context.Context, userID string) error {
+	if s.assets == nil {
+		return nil
+	}
+	return s.assets.RemoveRefs(ctx, asset.UserRef(userID))
+}
+
+func (s *AccountService) deleteIdentity(ctx context.Context, userID string) error {
+	err := s.auth.DeleteUser(ctx, userID)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		return nil
+	}
+	return err
+}
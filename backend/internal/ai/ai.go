@@ -5,21 +5,24 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/rekognition"
-	"github.com/aws/aws-sdk-go-v2/service/sagemaker"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
 	"github.com/celebthumb-ai/internal/models"
 	"github.com/google/uuid"
 )
 
+// RekognitionAPI is the part of the Rekognition client AIService uses
+type RekognitionAPI interface {
+	RecognizeCelebrities(ctx context.Context, params *rekognition.RecognizeCelebritiesInput, optFns ...func(*rekognition.Options)) (*rekognition.RecognizeCelebritiesOutput, error)
+}
+
 type AIConfig struct {
-	RekognitionClient *rekognition.Client
-	SagemakerClient   *sagemaker.Client
+	// RekognitionClient is optional; without it no celebrities are detected
+	RekognitionClient RekognitionAPI
 }
 
 type AIService struct {
-	rekognitionClient *rekognition.Client
-	sagemakerClient   *sagemaker.Client
+	rekognitionClient RekognitionAPI
 }
 
 type GenerationParams struct {
@@ -31,7 +34,6 @@ type GenerationParams struct {
 func NewAIService(config AIConfig) *AIService {
 	return &AIService{
 		rekognitionClient: config.RekognitionClient,
-		sagemakerClient:   config.SagemakerClient,
 	}
 }
 
@@ -54,7 +56,7 @@ func (s *AIService) GenerateThumbnail(ctx context.Context, params GenerationPara
 func (s *AIService) DetectCelebrities(ctx context.Context, imageBytes []byte) ([]string, error) {
 	// Use AWS Rekognition to detect celebrities in the image
 	resp, err := s.rekognitionClient.RecognizeCelebrities(ctx, &rekognition.RecognizeCelebritiesInput{
-		Image: &rekognition.Image{
+		Image: &types.Image{
 			Bytes: imageBytes,
 		},
 	})
@@ -0,0 +1,140 @@
+package ai
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxKeywords caps the keywords taken from a title and description
+const maxKeywords = 8
+
+// ContentAnalysis is what the AI found in a thumbnail and its video's text.
+// It feeds the thumbnail's automatic tags.
+type ContentAnalysis struct {
+	Keywords    []string `json:"keywords"`
+	Celebrities []string `json:"celebrities"`
+}
+
+// Tags flattens the analysis into tags, celebrities first
+func (a *ContentAnalysis) Tags() []string {
+	tags := make([]string, 0, len(a.Celebrities)+len(a.Keywords))
+	tags = append(tags, a.Celebrities...)
+	tags = append(tags, a.Keywords...)
+	return tags
+}
+
+// AnalyzeContent extracts keywords from the video text and detects the
+// celebrities in image. Celebrity detection is best effort: if it fails the
+// analysis is still returned, together with the error.
+func (s *AIService) AnalyzeContent(ctx context.Context, params GenerationParams, image []byte) (*ContentAnalysis, error) {
+	analysis := &ContentAnalysis{
+		Keywords:    extractKeywords(params.VideoTitle + " " + params.Description),
+		Celebrities: []string{},
+	}
+
+	if s.rekognitionClient == nil || len(image) == 0 {
+		return analysis, nil
+	}
+
+	celebrities, err := s.DetectCelebrities(ctx, image)
+	if err != nil {
+		return analysis, err
+	}
+	analysis.Celebrities = celebrities
+
+	return analysis, nil
+}
+
+// DetectSourceCelebrities finds the celebrities in the user's source images,
+// each named once in the order first seen. Images that fail detection are
+// skipped and the first error is returned with whatever was found.
+func (s *AIService) DetectSourceCelebrities(ctx context.Context, images [][]byte) ([]string, error) {
+	celebrities := []string{}
+	if s.rekognitionClient == nil {
+		return celebrities, nil
+	}
+
+	var firstErr error
+	for _, image := range images {
+		names, err := s.DetectCelebrities(ctx, image)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		celebrities = appendNew(celebrities, names...)
+	}
+
+	return celebrities, firstErr
+}
+
+// AddCelebrities merges celebrities found elsewhere, such as in the source
+// images, into the analysis
+func (a *ContentAnalysis) AddCelebrities(names ...string) {
+	a.Celebrities = appendNew(a.Celebrities, names...)
+}
+
+// appendNew appends the names not already in list
+func appendNew(list []string, names ...string) []string {
+	for _, name := range names {
+		found := false
+		for _, existing := range list {
+			if strings.EqualFold(existing, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+var commonWords = map[string]bool{
+	"about": true, "after": true, "and": true, "are": true, "but": true,
+	"can": true, "for": true, "from": true, "have": true, "how": true,
+	"into": true, "just": true, "more": true, "not": true, "our": true,
+	"out": true, "the": true, "their": true, "this": true, "video": true,
+	"was": true, "what": true, "when": true, "why": true, "will": true,
+	"with": true, "you": true, "your": true,
+}
+
+// extractKeywords picks the most frequent meaningful words, ties broken by
+// first appearance
+func extractKeywords(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	counts := map[string]int{}
+	first := map[string]int{}
+	for i, word := range words {
+		if len([]rune(word)) < 3 || commonWords[word] {
+			continue
+		}
+		if _, ok := counts[word]; !ok {
+			first[word] = i
+		}
+		counts[word]++
+	}
+
+	keywords := make([]string, 0, len(counts))
+	for word := range counts {
+		keywords = append(keywords, word)
+	}
+	sort.Slice(keywords, func(a, b int) bool {
+		if counts[keywords[a]] != counts[keywords[b]] {
+			return counts[keywords[a]] > counts[keywords[b]]
+		}
+		return first[keywords[a]] < first[keywords[b]]
+	})
+	if len(keywords) > maxKeywords {
+		keywords = keywords[:maxKeywords]
+	}
+
+	return keywords
+}
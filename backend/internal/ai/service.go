@@ -1,97 +0,0 @@
-package ai
-
-import (
-	"context"
-	"encoding/json"
-	"fmt"
-
-	"github.com/aws/aws-sdk-go-v2/service/rekognition"
-	"github.com/aws/aws-sdk-go-v2/service/sagemaker"
-	"github.com/celebthumb-ai/internal/models"
-)
-
-type AIService struct {
-	rekognitionClient *rekognition.Client
-	sagemakerClient  *sagemaker.Client
-}
-
-type AIConfig struct {
-	RekognitionClient *rekognition.Client
-	SagemakerClient  *sagemaker.Client
-}
-
-type GenerationParams struct {
-	VideoTitle  string
-	Description string
-	Style       string
-}
-
-func (s *AIService) GenerateThumbnail(ctx context.Context, params GenerationParams) (*models.Thumbnail, error) {
-	// 1. Analyze text content for context
-	textAnalysis, err := s.analyzeText(ctx, params)
-	if err != nil {
-		return nil, fmt.Errorf("failed to analyze text: %w", err)
-	}
-
-	// 2. Generate image based on analysis
-	imageURL, err := s.generateImage(ctx, textAnalysis)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate image: %w", err)
-	}
-
-	// 3. Apply style and branding
-	finalURL, err := s.applyStyle(ctx, imageURL, params.Style)
-	if err != nil {
-		return nil, fmt.Errorf("failed to apply style: %w", err)
-	}
-
-	return &models.Thumbnail{
-		URL:         finalURL,
-		VideoTitle:  params.VideoTitle,
-		Description: params.Description,
-		Style:       params.Style,
-	}, nil
-}
-
-type textAnalysisResult struct {
-	Keywords    []string          `json:"keywords"`
-	Sentiment   string           `json:"sentiment"`
-	MainThemes  []string          `json:"mainThemes"`
-	StyleGuide map[string]string `json:"styleGuide"`
-}
-
-func (s *AIService) analyzeText(ctx context.Context, params GenerationParams) (*textAnalysisResult, error) {
-	// TODO: Implement text analysis using SageMaker endpoint
-	// This will analyze the video title and description to extract:
-	// - Key themes and topics
-	// - Emotional tone
-	// - Visual style suggestions
-	return &textAnalysisResult{
-		Keywords:   []string{"youtube", "thumbnail", "ai"},
-		Sentiment: "positive",
-		MainThemes: []string{"technology", "ai", "content creation"},
-		StyleGuide: map[string]string{
-			"colorScheme": "vibrant",
-			"composition": "dynamic",
-			"mood":        "energetic",
-		},
-	}, nil
-}
-
-func (s *AIService) generateImage(ctx context.Context, analysis *textAnalysisResult) (string, error) {
-	// TODO: Implement image generation using Stable Diffusion or similar
-	// This will:
-	// - Create a prompt based on the text analysis
-	// - Generate base image
-	// - Ensure celebrity likeness rights
-	return "https://example.com/generated-image.jpg", nil
-}
-
-func (s *AIService) applyStyle(ctx context.Context, imageURL string, style string) (string, error) {
-	// TODO: Implement style application
-	// This will:
-	// - Apply brand colors
-	// - Add text overlays
-	// - Apply visual effects based on style
-	return "https://example.com/final-thumbnail.jpg", nil
-}
\ No newline at end of file
@@ -0,0 +1,257 @@
+// Package apierror is the API's error model. Every error response carries a
+// human-readable message, a stable machine-readable code, the request ID and,
+// for validation failures, the offending fields:
+//
+//	{"error":"invalid request","code":"validation_failed","requestId":"...",
+//	 "fields":[{"field":"reason","message":"is required"}]}
+//
+// The message stays under "error" for clients written against the original
+// responses. Clients should branch on the code; messages may change.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Code identifies a kind of failure. Codes are part of the API contract and
+// must not be renamed.
+type Code string
+
+// Generic codes, one per status the API returns
+const (
+	CodeInvalidRequest       Code = "invalid_request"
+	CodeValidationFailed     Code = "validation_failed"
+	CodeUnauthorized         Code = "unauthorized"
+	CodeForbidden            Code = "forbidden"
+	CodeNotFound             Code = "not_found"
+	CodeMethodNotAllowed     Code = "method_not_allowed"
+	CodeConflict             Code = "conflict"
+	CodeGone                 Code = "gone"
+	CodePayloadTooLarge      Code = "payload_too_large"
+	CodeUnsupportedMediaType Code = "unsupported_media_type"
+	CodeRangeNotSatisfiable  Code = "range_not_satisfiable"
+	CodeRateLimited          Code = "rate_limited"
+	CodeInternal             Code = "internal_error"
+)
+
+// Specific codes for failures clients are expected to handle
+const (
+	CodeInvalidToken           Code = "invalid_token"
+	CodeTokenExpired           Code = "token_expired"
+	CodeInvalidCredentials     Code = "invalid_credentials"
+	CodeUserExists             Code = "user_exists"
+	CodeUserNotFound           Code = "user_not_found"
+	CodeWeakPassword           Code = "weak_password"
+	CodeInvalidMFACode         Code = "invalid_mfa_code"
+	CodeInvalidSession         Code = "invalid_session"
+	CodeMFASetupRequired       Code = "mfa_setup_required"
+	CodeUnsupportedChallenge   Code = "unsupported_challenge"
+	CodeInvalidSignInState     Code = "invalid_sign_in_state"
+	CodeUnknownProvider        Code = "unknown_provider"
+	CodePasswordSignInRequired Code = "password_sign_in_required"
+
+	CodeInsufficientCredits Code = "insufficient_credits"
+	CodeInvalidPlan         Code = "invalid_plan"
+	CodeQuotaExceeded       Code = "quota_exceeded"
+
+	CodeThumbnailNotFound Code = "thumbnail_not_found"
+	CodeRevisionNotFound  Code = "revision_not_found"
+	CodeRevisionConflict  Code = "revision_conflict"
+	CodeInvalidCursor     Code = "invalid_cursor"
+	CodeInvalidTags       Code = "invalid_tags"
+	CodeEmptyQuery        Code = "empty_query"
+
+	CodeAssetNotFound       Code = "asset_not_found"
+	CodeUploadNotFound      Code = "upload_not_found"
+	CodeInvalidImage        Code = "invalid_image"
+	CodeImageTooLarge       Code = "image_too_large"
+	CodeContentTypeMismatch Code = "content_type_mismatch"
+
+	CodeCollectionNotFound Code = "collection_not_found"
+	CodeTooManyThumbnails  Code = "too_many_thumbnails"
+	CodeInvalidOrder       Code = "invalid_order"
+
+	CodeExportNotFound   Code = "export_not_found"
+	CodeExportInProgress Code = "export_in_progress"
+
+	CodeOrganizationNotFound  Code = "organization_not_found"
+	CodeNotOrganizationAdmin  Code = "not_organization_admin"
+	CodeAlreadyInOrganization Code = "already_in_organization"
+	CodeInviteNotFound        Code = "invite_not_found"
+	CodeNotOrganizationMember Code = "not_organization_member"
+	CodeLastOrganizationAdmin Code = "last_organization_admin"
+
+	CodeShareNotFound        Code = "share_not_found"
+	CodeSharedItemNotFound   Code = "shared_item_not_found"
+	CodeShareExpired         Code = "share_expired"
+	CodeViewLimitReached     Code = "view_limit_reached"
+	CodePasswordRequired     Code = "password_required"
+	CodeInvalidSharePassword Code = "invalid_share_password"
+	CodeInvalidShare         Code = "invalid_share"
+)
+
+// statusCodes gives the code used when only a status is known. 402 has no
+// generic code: a payment failure is always a specific one, so it must be
+// built with New.
+var statusCodes = map[int]Code{
+	http.StatusBadRequest:                   CodeInvalidRequest,
+	http.StatusUnauthorized:                 CodeUnauthorized,
+	http.StatusForbidden:                    CodeForbidden,
+	http.StatusNotFound:                     CodeNotFound,
+	http.StatusMethodNotAllowed:             CodeMethodNotAllowed,
+	http.StatusConflict:                     CodeConflict,
+	http.StatusGone:                         CodeGone,
+	http.StatusRequestEntityTooLarge:        CodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:         CodeUnsupportedMediaType,
+	http.StatusRequestedRangeNotSatisfiable: CodeRangeNotSatisfiable,
+	http.StatusTooManyRequests:              CodeRateLimited,
+	http.StatusInsufficientStorage:          CodeQuotaExceeded,
+}
+
+// FieldError points at one invalid request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an API error. Err, when set, is the service error it was
+// translated from and is never sent to the client.
+type Error struct {
+	Status    int          `json:"-"`
+	Message   string       `json:"error"`
+	Code      Code         `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	Err       error        `json:"-"`
+}
+
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// FromStatus builds an error with the generic code for status
+func FromStatus(status int, message string) *Error {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInternal
+		if status < http.StatusInternalServerError {
+			code = CodeInvalidRequest
+		}
+	}
+	return New(status, code, message)
+}
+
+// Validation reports invalid request fields
+func Validation(fields ...FieldError) *Error {
+	return &Error{
+		Status:  http.StatusBadRequest,
+		Code:    CodeValidationFailed,
+		Message: "invalid request",
+		Fields:  fields,
+	}
+}
+
+// Field is shorthand for a FieldError
+func Field(field, message string) FieldError {
+	return FieldError{Field: field, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Response renders the error as an API Gateway response
+func (e *Error) Response() events.APIGatewayProxyResponse {
+	body, err := json.Marshal(e)
+	if err != nil {
+		body = []byte(`{"error":"internal error","code":"internal_error"}`)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: e.Status,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(body),
+	}
+}
+
+// Mapping translates a service's sentinel error
+type Mapping struct {
+	Err     error
+	Status  int
+	Code    Code
+	Message string
+}
+
+// Translate returns the API error for err. An *Error anywhere in the chain is
+// returned as it is; otherwise the first mapping that matches with errors.Is
+// wins, searching the tables in order. Anything else is an internal error
+// with the given message, so service details never reach the client.
+func Translate(err error, message string, tables ...[]Mapping) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	for _, table := range tables {
+		for _, mapping := range table {
+			if errors.Is(err, mapping.Err) {
+				return &Error{Status: mapping.Status, Code: mapping.Code, Message: mapping.Message, Err: err}
+			}
+		}
+	}
+
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: message, Err: err}
+}
+
+// WithRequestID sets the X-Request-Id header and, on error responses built by
+// this package, fills in the body's request ID
+func WithRequestID(response events.APIGatewayProxyResponse, requestID string) events.APIGatewayProxyResponse {
+	if requestID == "" {
+		return response
+	}
+
+	headers := make(map[string]string, len(response.Headers)+1)
+	for name, value := range response.Headers {
+		headers[name] = value
+	}
+	headers["X-Request-Id"] = requestID
+	response.Headers = headers
+
+	if response.StatusCode < http.StatusBadRequest || response.IsBase64Encoded || !isJSON(headers) {
+		return response
+	}
+
+	var apiErr Error
+	if err := json.Unmarshal([]byte(response.Body), &apiErr); err != nil || apiErr.Code == "" {
+		return response
+	}
+	apiErr.RequestID = requestID
+	if body, err := json.Marshal(&apiErr); err == nil {
+		response.Body = string(body)
+	}
+	return response
+}
+
+func isJSON(headers map[string]string) bool {
+	for name, value := range headers {
+		if strings.EqualFold(name, "Content-Type") {
+			return strings.HasPrefix(value, "application/json")
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var (
+	errMissing = errors.New("missing")
+	errTaken   = errors.New("taken")
+)
+
+var serviceTable = []Mapping{
+	{Err: errMissing, Status: http.StatusNotFound, Code: CodeNotFound, Message: "not found"},
+	{Err: errTaken, Status: http.StatusConflict, Code: CodeConflict, Message: "taken"},
+}
+
+var overrideTable = []Mapping{
+	{Err: errTaken, Status: http.StatusBadRequest, Code: CodeInvalidRequest, Message: "pick another"},
+}
+
+func TestTranslate(t *testing.T) {
+	passthrough := New(http.StatusForbidden, CodeForbidden, "forbidden")
+
+	tests := []struct {
+		name        string
+		err         error
+		tables      [][]Mapping
+		wantStatus  int
+		wantCode    Code
+		wantMessage string
+	}{
+		{
+			name:        "sentinel",
+			err:         errMissing,
+			tables:      [][]Mapping{serviceTable},
+			wantStatus:  http.StatusNotFound,
+			wantCode:    CodeNotFound,
+			wantMessage: "not found",
+		},
+		{
+			name:        "wrapped sentinel",
+			err:         fmt.Errorf("lookup failed: %w", errMissing),
+			tables:      [][]Mapping{serviceTable},
+			wantStatus:  http.StatusNotFound,
+			wantCode:    CodeNotFound,
+			wantMessage: "not found",
+		},
+		{
+			name:        "earlier table wins",
+			err:         errTaken,
+			tables:      [][]Mapping{overrideTable, serviceTable},
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    CodeInvalidRequest,
+			wantMessage: "pick another",
+		},
+		{
+			name:        "later table still applies",
+			err:         errMissing,
+			tables:      [][]Mapping{overrideTable, serviceTable},
+			wantStatus:  http.StatusNotFound,
+			wantCode:    CodeNotFound,
+			wantMessage: "not found",
+		},
+		{
+			name:        "unknown error is internal",
+			err:         errors.New("connection reset"),
+			tables:      [][]Mapping{serviceTable},
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    CodeInternal,
+			wantMessage: "failed",
+		},
+		{
+			name:        "API error passes through",
+			err:         fmt.Errorf("check: %w", passthrough),
+			tables:      [][]Mapping{serviceTable},
+			wantStatus:  http.StatusForbidden,
+			wantCode:    CodeForbidden,
+			wantMessage: "forbidden",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Translate(tt.err, "failed", tt.tables...)
+
+			if got.Status != tt.wantStatus || got.Code != tt.wantCode || got.Message != tt.wantMessage {
+				t.Errorf("Translate = %d %s %q, want %d %s %q", got.Status, got.Code, got.Message, tt.wantStatus, tt.wantCode, tt.wantMessage)
+			}
+			if !errors.Is(got, tt.err) && !errors.Is(tt.err, got) {
+				t.Errorf("Translate result doesn't match %v with errors.Is", tt.err)
+			}
+		})
+	}
+}
+
+func TestTranslateKeepsCause(t *testing.T) {
+	cause := fmt.Errorf("lookup failed: %w", errMissing)
+	got := Translate(cause, "failed", serviceTable)
+
+	if !errors.Is(got, errMissing) {
+		t.Error("errors.Is(Translate(...), errMissing) = false, want true")
+	}
+	if errors.Is(got, errTaken) {
+		t.Error("errors.Is(Translate(...), errTaken) = true, want false")
+	}
+	if got.Err != cause {
+		t.Errorf("Err = %v, want %v", got.Err, cause)
+	}
+}
+
+func TestFromStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   Code
+	}{
+		{http.StatusBadRequest, CodeInvalidRequest},
+		{http.StatusNotFound, CodeNotFound},
+		{http.StatusTooManyRequests, CodeRateLimited},
+		// Payment failures need an explicit code
+		{http.StatusPaymentRequired, CodeInvalidRequest},
+		{http.StatusTeapot, CodeInvalidRequest},
+		{http.StatusBadGateway, CodeInternal},
+	}
+
+	for _, tt := range tests {
+		if got := FromStatus(tt.status, "message").Code; got != tt.want {
+			t.Errorf("FromStatus(%d) code = %s, want %s", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  events.APIGatewayProxyResponse
+		requestID string
+		want      string
+	}{
+		{
+			name:     "error",
+			response: Translate(fmt.Errorf("secret detail: %w", errMissing), "failed", serviceTable).Response(),
+			want:     `{"error":"not found","code":"not_found"}`,
+		},
+		{
+			name:     "internal error hides the cause",
+			response: Translate(errors.New("secret detail"), "failed").Response(),
+			want:     `{"error":"failed","code":"internal_error"}`,
+		},
+		{
+			name:      "validation with request ID",
+			response:  Validation(Field("name", "is required")).Response(),
+			requestID: "req-1",
+			want:      `{"error":"invalid request","code":"validation_failed","requestId":"req-1","fields":[{"field":"name","message":"is required"}]}`,
+		},
+		{
+			name: "success body is left alone",
+			response: events.APIGatewayProxyResponse{
+				StatusCode: http.StatusOK,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"code":"ok"}`,
+			},
+			requestID: "req-1",
+			want:      `{"code":"ok"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := WithRequestID(tt.response, tt.requestID)
+
+			if response.Body != tt.want {
+				t.Errorf("body = %s, want %s", response.Body, tt.want)
+			}
+			if tt.requestID != "" && response.Headers["X-Request-Id"] != tt.requestID {
+				t.Errorf("X-Request-Id = %q, want %q", response.Headers["X-Request-Id"], tt.requestID)
+			}
+			if tt.response.StatusCode >= http.StatusBadRequest && response.Headers["Content-Type"] != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", response.Headers["Content-Type"])
+			}
+		})
+	}
+}
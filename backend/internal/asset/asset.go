@@ -0,0 +1,518 @@
+// Package asset stores source images such as headshots and logos once per
+// distinct content. Each asset lives under its SHA-256 hash and is reference
+// counted; the object is deleted when the last reference goes.
+package asset
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/celebthumb-ai/internal/rendition"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrAssetNotFound = errors.New("asset not found")
+	ErrInvalidUpload = errors.New("invalid upload")
+	ErrInvalidImage  = errors.New("invalid image")
+)
+
+// Kinds of things that reference assets
+const (
+	// KindUser is a user's asset library
+	KindUser      = "user"
+	KindThumbnail = "thumbnail"
+	KindTemplate  = "template"
+	KindBrandKit  = "brandKit"
+)
+
+// putAttempts bounds retries when an asset is collected while being re-added
+const putAttempts = 3
+
+// Ref identifies something that uses assets
+type Ref struct {
+	Kind string
+	ID   string
+}
+
+func (r Ref) String() string {
+	return r.Kind + "#" + r.ID
+}
+
+func UserRef(userID string) Ref           { return Ref{Kind: KindUser, ID: userID} }
+func ThumbnailRef(thumbnailID string) Ref { return Ref{Kind: KindThumbnail, ID: thumbnailID} }
+
+type AssetConfig struct {
+	DynamoClient *dynamodb.Client
+	Store        blob.Store
+	TableName    string
+	RefsTable    string
+	// URLExpiry is how long presigned asset URLs stay valid
+	URLExpiry time.Duration
+}
+
+type AssetService struct {
+	dynamoClient *dynamodb.Client
+	store        blob.Store
+	tableName    string
+	refsTable    string
+	urlExpiry    time.Duration
+}
+
+func NewAssetService(config AssetConfig) *AssetService {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("ASSETS_TABLE")
+	}
+
+	refsTable := config.RefsTable
+	if refsTable == "" {
+		refsTable = os.Getenv("ASSET_REFS_TABLE")
+	}
+
+	urlExpiry := config.URLExpiry
+	if urlExpiry <= 0 {
+		urlExpiry = 15 * time.Minute
+	}
+
+	return &AssetService{
+		dynamoClient: config.DynamoClient,
+		store:        config.Store,
+		tableName:    tableName,
+		refsTable:    refsTable,
+		urlExpiry:    urlExpiry,
+	}
+}
+
+// Hash returns the hex SHA-256 assets are keyed by
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Put stores a sanitized image, or finds the identical asset already stored,
+// and records that ref uses it. Adding the same reference twice counts once.
+func (s *AssetService) Put(ctx context.Context, ref Ref, source *rendition.Source) (*models.Asset, error) {
+	hash := Hash(source.Data)
+
+	for attempt := 1; ; attempt++ {
+		asset, err := s.ensure(ctx, hash, source)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.AddRef(ctx, ref, hash)
+		if err == nil {
+			return asset, nil
+		}
+		// The asset was collected between ensure and AddRef; store it again
+		if !errors.Is(err, ErrAssetNotFound) || attempt == putAttempts {
+			return nil, err
+		}
+	}
+}
+
+// Import validates and sanitizes an uploaded image and moves it into the asset
+// store for ref. The upload is deleted once it has been stored, whether or not
+// it was a duplicate, and also when it is rejected. Rejections wrap the
+// rendition error saying why.
+func (s *AssetService) Import(ctx context.Context, ref Ref, key string) (*models.Asset, error) {
+	object, err := s.store.Get(ctx, key, blob.GetOptions{})
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, ErrInvalidUpload
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	data, err := io.ReadAll(object.Body)
+	object.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	source, err := rendition.Sanitize(data, object.ContentType)
+	if err != nil {
+		s.deleteObject(ctx, key)
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImage, err)
+	}
+
+	asset, err := s.Put(ctx, ref, source)
+	if err != nil {
+		return nil, err
+	}
+
+	s.deleteObject(ctx, key)
+	return asset, nil
+}
+
+// Get returns an asset with a presigned download URL
+func (s *AssetService) Get(ctx context.Context, hash string) (*models.Asset, error) {
+	asset, err := s.get(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.presign(ctx, asset); err != nil {
+		return nil, err
+	}
+	return asset, nil
+}
+
+// Open reads an asset's content
+func (s *AssetService) Open(ctx context.Context, hash string) (*blob.Object, error) {
+	asset, err := s.get(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := s.store.Get(ctx, asset.ObjectKey, blob.GetOptions{})
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, ErrAssetNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read asset: %w", err)
+	}
+	return object, nil
+}
+
+// HasRef reports whether ref uses the asset
+func (s *AssetService) HasRef(ctx context.Context, ref Ref, hash string) (bool, error) {
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.refsTable),
+		Key:       refKey(ref, hash),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get asset reference: %w", err)
+	}
+	return resp.Item != nil, nil
+}
+
+// List returns the assets ref uses, each with a presigned URL
+func (s *AssetService) List(ctx context.Context, ref Ref) ([]*models.Asset, error) {
+	refs, err := s.refs(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	assets := make([]*models.Asset, 0, len(refs))
+	for _, r := range refs {
+		asset, err := s.Get(ctx, r.Hash)
+		if errors.Is(err, ErrAssetNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+
+	return assets, nil
+}
+
+// AddRef records that ref uses the asset
+func (s *AssetService) AddRef(ctx context.Context, ref Ref, hash string) error {
+	item, err := attributevalue.MarshalMap(models.AssetRef{
+		Ref:       ref.String(),
+		Hash:      hash,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal asset reference: %w", err)
+	}
+
+	_, err = s.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName:           aws.String(s.refsTable),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(#ref)"),
+					ExpressionAttributeNames: map[string]string{
+						"#ref": "ref",
+					},
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName:           aws.String(s.tableName),
+					Key:                 assetKey(hash),
+					UpdateExpression:    aws.String("ADD refCount :one"),
+					ConditionExpression: aws.String("attribute_exists(#hash)"),
+					ExpressionAttributeNames: map[string]string{
+						"#hash": "hash",
+					},
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":one": &types.AttributeValueMemberN{Value: "1"},
+					},
+				},
+			},
+		},
+	})
+	switch failed := canceledBy(err); {
+	case err == nil:
+		return nil
+	case failed[0]:
+		// Already referenced
+		return nil
+	case failed[1]:
+		return ErrAssetNotFound
+	}
+	return fmt.Errorf("failed to add asset reference: %w", err)
+}
+
+// RemoveRef records that ref no longer uses the asset, deleting the asset if
+// nothing else does. Removing a missing reference is not an error.
+func (s *AssetService) RemoveRef(ctx context.Context, ref Ref, hash string) error {
+	_, err := s.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName:           aws.String(s.refsTable),
+					Key:                 refKey(ref, hash),
+					ConditionExpression: aws.String("attribute_exists(#ref)"),
+					ExpressionAttributeNames: map[string]string{
+						"#ref": "ref",
+					},
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName:        aws.String(s.tableName),
+					Key:              assetKey(hash),
+					UpdateExpression: aws.String("ADD refCount :minusOne"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":minusOne": &types.AttributeValueMemberN{Value: "-1"},
+					},
+				},
+			},
+		},
+	})
+	if err != nil && !canceledBy(err)[0] {
+		return fmt.Errorf("failed to remove asset reference: %w", err)
+	}
+
+	return s.collect(ctx, hash)
+}
+
+// RemoveRefs drops every reference ref holds, e.g. when a thumbnail is purged
+func (s *AssetService) RemoveRefs(ctx context.Context, ref Ref) error {
+	refs, err := s.refs(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range refs {
+		if err := s.RemoveRef(ctx, ref, r.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseThumbnail drops a purged thumbnail's references. It implements
+// storage.AssetReleaser.
+func (s *AssetService) ReleaseThumbnail(ctx context.Context, thumbnailID string) error {
+	return s.RemoveRefs(ctx, ThumbnailRef(thumbnailID))
+}
+
+// ensure returns the stored asset for hash, storing data first if there is
+// none. Every stored copy gets its own object key, so an asset collected
+// while it is being re-added can never delete the new copy's object.
+func (s *AssetService) ensure(ctx context.Context, hash string, source *rendition.Source) (*models.Asset, error) {
+	asset, err := s.get(ctx, hash)
+	if err == nil {
+		return asset, nil
+	}
+	if !errors.Is(err, ErrAssetNotFound) {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("assets/sha256/%s/%s", hash, uuid.New().String())
+	info, err := s.store.Put(ctx, key, bytes.NewReader(source.Data), blob.PutOptions{ContentType: source.ContentType})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store asset: %w", err)
+	}
+
+	asset = &models.Asset{
+		Hash:        hash,
+		ObjectKey:   key,
+		ContentType: source.ContentType,
+		Size:        info.Size,
+		Width:       source.Width,
+		Height:      source.Height,
+		CreatedAt:   time.Now().UTC(),
+	}
+	item, err := attributevalue.MarshalMap(asset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal asset: %w", err)
+	}
+
+	_, err = s.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#hash)"),
+		ExpressionAttributeNames: map[string]string{
+			"#hash": "hash",
+		},
+	})
+	if err != nil {
+		s.deleteObject(ctx, key)
+
+		// Someone else stored the same content first
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return s.get(ctx, hash)
+		}
+		return nil, fmt.Errorf("failed to create asset: %w", err)
+	}
+
+	return asset, nil
+}
+
+// collect deletes the asset if nothing references it any more
+func (s *AssetService) collect(ctx context.Context, hash string) error {
+	resp, err := s.dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 assetKey(hash),
+		ConditionExpression: aws.String("refCount <= :zero"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete asset: %w", err)
+	}
+
+	var asset models.Asset
+	if err := attributevalue.UnmarshalMap(resp.Attributes, &asset); err != nil {
+		return fmt.Errorf("failed to unmarshal asset: %w", err)
+	}
+	if asset.ObjectKey != "" {
+		s.deleteObject(ctx, asset.ObjectKey)
+	}
+
+	return nil
+}
+
+// deleteObject removes an object no record points at. A failure only leaves
+// an orphan behind, so it is logged rather than returned.
+func (s *AssetService) deleteObject(ctx context.Context, key string) {
+	if err := s.store.Delete(ctx, key); err != nil {
+		log.Printf("failed to delete asset object %s: %v", key, err)
+	}
+}
+
+func (s *AssetService) get(ctx context.Context, hash string) (*models.Asset, error) {
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            assetKey(hash),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get asset: %w", err)
+	}
+	if resp.Item == nil {
+		return nil, ErrAssetNotFound
+	}
+
+	var asset models.Asset
+	if err := attributevalue.UnmarshalMap(resp.Item, &asset); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal asset: %w", err)
+	}
+	return &asset, nil
+}
+
+func (s *AssetService) refs(ctx context.Context, ref Ref) ([]*models.AssetRef, error) {
+	refs := []*models.AssetRef{}
+
+	paginator := dynamodb.NewQueryPaginator(s.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(s.refsTable),
+		KeyConditionExpression: aws.String("#ref = :ref"),
+		ExpressionAttributeNames: map[string]string{
+			"#ref": "ref",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":ref": &types.AttributeValueMemberS{Value: ref.String()},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query asset references: %w", err)
+		}
+
+		var items []*models.AssetRef
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal asset references: %w", err)
+		}
+		refs = append(refs, items...)
+	}
+
+	return refs, nil
+}
+
+func (s *AssetService) presign(ctx context.Context, asset *models.Asset) error {
+	req, err := s.store.PresignGet(ctx, asset.ObjectKey, s.urlExpiry)
+	if err != nil {
+		return fmt.Errorf("failed to presign asset: %w", err)
+	}
+	asset.URL = req.URL
+	return nil
+}
+
+// IsHash reports whether s looks like an asset id
+func IsHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	return strings.Trim(s, "0123456789abcdef") == ""
+}
+
+func assetKey(hash string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"hash": &types.AttributeValueMemberS{Value: hash},
+	}
+}
+
+func refKey(ref Ref, hash string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"ref":  &types.AttributeValueMemberS{Value: ref.String()},
+		"hash": &types.AttributeValueMemberS{Value: hash},
+	}
+}
+
+// canceledBy reports which items of a two-item transaction failed their
+// condition check
+func canceledBy(err error) [2]bool {
+	var failed [2]bool
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return failed
+	}
+	for i, reason := range canceled.CancellationReasons {
+		if i < len(failed) && aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+			failed[i] = true
+		}
+	}
+	return failed
+}
@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/google/uuid"
+)
+
+// Outcomes recorded on entries
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeFailed  = "failed"
+)
+
+// Entry describes one privileged action
+type Entry struct {
+	ID           string            `json:"id" dynamodbav:"id"`
+	ActorID      string            `json:"actorId" dynamodbav:"actorId"`
+	ActorEmail   string            `json:"actorEmail,omitempty" dynamodbav:"actorEmail,omitempty"`
+	Action       string            `json:"action" dynamodbav:"action"`
+	TargetUserID string            `json:"targetUserId,omitempty" dynamodbav:"targetUserId,omitempty"`
+	Reason       string            `json:"reason,omitempty" dynamodbav:"reason,omitempty"`
+	Outcome      string            `json:"outcome" dynamodbav:"outcome"`
+	Details      map[string]string `json:"details,omitempty" dynamodbav:"details,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+type AuditConfig struct {
+	DynamoClient *dynamodb.Client
+	TableName    string
+}
+
+// AuditLogger writes entries to the function log and, when a table is
+// configured, to DynamoDB so they can be queried per user
+type AuditLogger struct {
+	dynamoClient *dynamodb.Client
+	tableName    string
+}
+
+func NewAuditLogger(config AuditConfig) *AuditLogger {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("AUDIT_LOG_TABLE")
+	}
+
+	return &AuditLogger{
+		dynamoClient: config.DynamoClient,
+		tableName:    tableName,
+	}
+}
+
+// Record stores entry, filling in its ID and timestamp
+func (l *AuditLogger) Record(ctx context.Context, entry *Entry) error {
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	// The log line is written first so there is a trail even if DynamoDB fails
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	log.Printf("audit: %s", line)
+
+	if l.tableName == "" {
+		return nil
+	}
+
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	_, err = l.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(l.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store audit entry: %w", err)
+	}
+
+	return nil
+}
@@ -2,202 +2,441 @@ package auth
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
-	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
 	"github.com/celebthumb-ai/internal/models"
-	"github.com/golang-jwt/jwt/v4"
-	"github.com/lestrrat-go/jwx/jwk"
 )
 
 var (
-	ErrInvalidToken      = errors.New("invalid token")
-	ErrExpiredToken      = errors.New("token expired")
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrUserNotFound      = errors.New("user not found")
-	ErrUserExists        = errors.New("user already exists")
+	ErrInvalidToken         = errors.New("invalid token")
+	ErrExpiredToken         = errors.New("token expired")
+	ErrInvalidCredentials   = errors.New("invalid credentials")
+	ErrInvalidPassword      = errors.New("password does not meet requirements")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrUserExists           = errors.New("user already exists")
+	ErrInvalidMFACode       = errors.New("invalid MFA code")
+	ErrInvalidSession       = errors.New("invalid or expired challenge session")
+	ErrUnsupportedChallenge = errors.New("unsupported challenge")
+	// ErrMFASetupRequired refuses a refresh for a user whose organization
+	// requires MFA they haven't enrolled; logging in again starts enrollment
+	ErrMFASetupRequired = errors.New("MFA setup required")
 )
 
+// Challenge names returned by LoginUser. They match Cognito's so the Cognito
+// provider can pass them straight through.
+const (
+	// ChallengeSoftwareTokenMFA asks for a code from the user's authenticator app
+	ChallengeSoftwareTokenMFA = "SOFTWARE_TOKEN_MFA"
+	// ChallengeMFASetup means the user has to enroll a software token before
+	// signing in. When the user's organization requires it, the challenge
+	// carries an access token to enroll with, after which the user logs in
+	// again. When the provider itself requires it, as a Cognito pool with MFA
+	// turned on does, the challenge carries a session instead: enrollment
+	// starts from the session and answering the challenge with a first code
+	// finishes the login.
+	ChallengeMFASetup = "MFA_SETUP"
+)
+
+// Challenge is an additional step the client has to complete before tokens
+// are issued
+type Challenge struct {
+	Name    string `json:"name"`
+	Session string `json:"session"`
+	// AccessToken is set instead of Session on an MFA_SETUP challenge raised
+	// by the MFA policy
+	AccessToken string `json:"accessToken,omitempty"`
+	// Email is the account the challenge is for. It is only set after a
+	// federated sign-in, where the client never saw the email.
+	Email string `json:"email,omitempty"`
+}
+
+// LoginResult holds either the issued tokens or the next challenge
+type LoginResult struct {
+	*Tokens
+	Challenge *Challenge `json:"challenge,omitempty"`
+}
+
+// MFAPolicy reports whether a user must use MFA, e.g. because their
+// organization requires it
+type MFAPolicy interface {
+	RequiresMFA(ctx context.Context, userID string) (bool, error)
+}
+
+// Tokens is the token set issued on login and refresh
+type Tokens struct {
+	// IDToken is serialized as "token" for clients written against the
+	// original login response
+	IDToken      string `json:"token"`
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+// IdentityProvider is the backend AuthService delegates to. Implementations
+// must return the package's sentinel errors so callers can tell failures apart.
+type IdentityProvider interface {
+	Register(ctx context.Context, email, username, password string) (*models.User, error)
+	Login(ctx context.Context, email, password string) (*LoginResult, error)
+	Refresh(ctx context.Context, refreshToken string) (*Tokens, error)
+	Verify(ctx context.Context, token string) (*models.User, error)
+	// DisableUser blocks further logins and refreshes for the user. Whether
+	// ID tokens already issued stop verifying is up to the provider; with
+	// Cognito they stay valid until they expire.
+	DisableUser(ctx context.Context, userID string) error
+	// DeleteUser removes the user for good
+	DeleteUser(ctx context.Context, userID string) error
+	// GetUser and FindUserByEmail look an account up outside a sign-in
+	GetUser(ctx context.Context, userID string) (*models.User, error)
+	FindUserByEmail(ctx context.Context, email string) (*models.User, error)
+
+	// RespondToChallenge completes a SOFTWARE_TOKEN_MFA challenge
+	RespondToChallenge(ctx context.Context, email string, challenge Challenge, code string) (*Tokens, error)
+	// AssociateSoftwareToken starts TOTP enrollment for the access token's user
+	AssociateSoftwareToken(ctx context.Context, accessToken string) (*SoftwareTokenEnrollment, error)
+	// VerifySoftwareToken confirms enrollment with a first code and turns MFA on
+	VerifySoftwareToken(ctx context.Context, accessToken, code string) error
+	// MFAEnabled reports whether the access token's user has enrolled a
+	// software token
+	MFAEnabled(ctx context.Context, accessToken string) (bool, error)
+}
+
+// MFASetupProvider is implemented by providers whose own login can answer
+// with an MFA_SETUP challenge. RespondToChallenge answers that challenge with
+// the first code from the enrolled token.
+type MFASetupProvider interface {
+	// AssociateSoftwareTokenSession starts TOTP enrollment on the challenge
+	// session and returns the session for the next step
+	AssociateSoftwareTokenSession(ctx context.Context, email, session string) (*SoftwareTokenEnrollment, error)
+}
+
 type AuthConfig struct {
-	CognitoClient *cognitoidentityprovider.Client
+	// Provider overrides the Cognito provider built from the fields below
+	Provider      IdentityProvider
+	CognitoClient CognitoAPI
 	UserPoolID    string
 	ClientID      string
+	// MFAPolicy is optional; without it MFA is only enforced for users who
+	// enrolled themselves
+	MFAPolicy MFAPolicy
+	// OIDCClients are the providers available for social login
+	OIDCClients []*OIDCClient
+	// RoleStore is optional; roles from it are added to the provider's groups
+	RoleStore RoleStore
 }
 
 type AuthService struct {
-	cognitoClient *cognitoidentityprovider.Client
-	userPoolID    string
-	clientID      string
-	jwkSet        jwk.Set
+	provider    IdentityProvider
+	mfaPolicy   MFAPolicy
+	oidcClients map[string]*OIDCClient
+	roleStore   RoleStore
 }
 
 func NewAuthService(config AuthConfig) *AuthService {
-	userPoolID := config.UserPoolID
-	if userPoolID == "" {
-		userPoolID = os.Getenv("USER_POOL_ID")
+	provider := config.Provider
+	if provider == nil {
+		provider = NewCognitoProvider(CognitoProviderConfig{
+			CognitoClient: config.CognitoClient,
+			UserPoolID:    config.UserPoolID,
+			ClientID:      config.ClientID,
+			Region:        os.Getenv("AWS_REGION"),
+		})
 	}
 
-	clientID := config.ClientID
-	if clientID == "" {
-		clientID = os.Getenv("USER_POOL_CLIENT_ID")
+	oidcClients := make(map[string]*OIDCClient, len(config.OIDCClients))
+	for _, client := range config.OIDCClients {
+		oidcClients[client.Name()] = client
 	}
 
-	// Fetch JWK Set for token validation
-	jwksURL := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s/.well-known/jwks.json", 
-		os.Getenv("AWS_REGION"), userPoolID)
-	jwkSet, err := jwk.Fetch(context.Background(), jwksURL)
+	return &AuthService{
+		provider:    provider,
+		mfaPolicy:   config.MFAPolicy,
+		oidcClients: oidcClients,
+		roleStore:   config.RoleStore,
+	}
+}
+
+func (s *AuthService) RegisterUser(ctx context.Context, email, username, password string) (*models.User, error) {
+	user, err := s.provider.Register(ctx, email, username, password)
 	if err != nil {
-		// Log error but continue - we'll validate tokens without JWK if needed
-		fmt.Printf("Error fetching JWK set: %v\n", err)
+		return nil, err
 	}
 
-	return &AuthService{
-		cognitoClient: config.CognitoClient,
-		userPoolID:    userPoolID,
-		clientID:      clientID,
-		jwkSet:        jwkSet,
+	// Give new users their free plan allowance
+	user.Plan = "free"
+	user.Credits = 10
+
+	return user, nil
+}
+
+// LoginUser authenticates the user. The result carries tokens, or a challenge
+// that has to be answered through RespondToChallenge or MFA enrollment.
+func (s *AuthService) LoginUser(ctx context.Context, email, password string) (*LoginResult, error) {
+	result, err := s.provider.Login(ctx, email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens without a challenge mean the user has no MFA enrolled
+	if result.Tokens == nil {
+		return result, nil
+	}
+
+	required, err := s.requiresMFA(ctx, result.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	if !required {
+		return result, nil
 	}
+
+	return mfaSetupChallenge(result.Tokens), nil
 }
 
-func (s *AuthService) RegisterUser(ctx context.Context, email, username, password string) (*models.User, error) {
-	// Check if user already exists
-	_, err := s.cognitoClient.AdminGetUser(ctx, &cognitoidentityprovider.AdminGetUserInput{
-		UserPoolId: aws.String(s.userPoolID),
-		Username:   aws.String(email),
-	})
-	if err == nil {
-		return nil, ErrUserExists
-	}
-
-	// Create user in Cognito
-	_, err = s.cognitoClient.SignUp(ctx, &cognitoidentityprovider.SignUpInput{
-		ClientId: aws.String(s.clientID),
-		Username: aws.String(email),
-		Password: aws.String(password),
-		UserAttributes: []types.AttributeType{
-			{
-				Name:  aws.String("email"),
-				Value: aws.String(email),
-			},
-			{
-				Name:  aws.String("preferred_username"),
-				Value: aws.String(username),
-			},
-		},
-	})
+// requiresMFA reports whether the MFA policy applies to the tokens' user
+func (s *AuthService) requiresMFA(ctx context.Context, tokens *Tokens) (bool, error) {
+	if s.mfaPolicy == nil {
+		return false, nil
+	}
+
+	user, err := s.provider.Verify(ctx, tokens.IDToken)
 	if err != nil {
-		return nil, fmt.Errorf("failed to register user: %w", err)
+		return false, fmt.Errorf("failed to verify issued token: %w", err)
 	}
 
-	// Auto-confirm user for development purposes
-	// In production, you would use email verification
-	_, err = s.cognitoClient.AdminConfirmSignUp(ctx, &cognitoidentityprovider.AdminConfirmSignUpInput{
-		UserPoolId: aws.String(s.userPoolID),
-		Username:   aws.String(email),
-	})
+	required, err := s.mfaPolicy.RequiresMFA(ctx, user.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to confirm user: %w", err)
+		return false, fmt.Errorf("failed to check MFA policy: %w", err)
 	}
 
-	// Create and return user model
-	user := &models.User{
-		ID:        email,
-		Email:     email,
-		Plan:      "free",
-		Credits:   10, // Give new users some free credits
-		CreatedAt: time.Now(),
+	return required, nil
+}
+
+// requiresMFASetup reports whether the MFA policy applies to the tokens' user
+// and they haven't enrolled. Logins that never reach the provider's MFA
+// challenge, like federated logins and refreshes, check this.
+func (s *AuthService) requiresMFASetup(ctx context.Context, tokens *Tokens) (bool, error) {
+	required, err := s.requiresMFA(ctx, tokens)
+	if err != nil || !required {
+		return false, err
 	}
 
-	return user, nil
+	enrolled, err := s.provider.MFAEnabled(ctx, tokens.AccessToken)
+	if err != nil {
+		return false, fmt.Errorf("failed to check MFA enrollment: %w", err)
+	}
+
+	return !enrolled, nil
 }
 
-func (s *AuthService) LoginUser(ctx context.Context, email, password string) (string, error) {
-	// Authenticate user with Cognito
-	resp, err := s.cognitoClient.InitiateAuth(ctx, &cognitoidentityprovider.InitiateAuthInput{
-		AuthFlow: types.AuthFlowTypeUserPasswordAuth,
-		ClientId: aws.String(s.clientID),
-		AuthParameters: map[string]string{
-			"USERNAME": email,
-			"PASSWORD": password,
+// mfaSetupChallenge withholds tokens until the user enrolls. The access token
+// becomes the challenge session.
+func mfaSetupChallenge(tokens *Tokens) *LoginResult {
+	return &LoginResult{
+		Challenge: &Challenge{
+			Name:        ChallengeMFASetup,
+			AccessToken: tokens.AccessToken,
 		},
-	})
+	}
+}
+
+// RespondToChallenge answers a login challenge with an MFA code
+func (s *AuthService) RespondToChallenge(ctx context.Context, email string, challenge Challenge, code string) (*Tokens, error) {
+	switch challenge.Name {
+	case ChallengeSoftwareTokenMFA:
+	case ChallengeMFASetup:
+		// Only the provider's own setup challenge has a session to answer
+		if _, ok := s.provider.(MFASetupProvider); !ok {
+			return nil, ErrUnsupportedChallenge
+		}
+	default:
+		return nil, ErrUnsupportedChallenge
+	}
+
+	return s.provider.RespondToChallenge(ctx, email, challenge, code)
+}
+
+// AssociateSoftwareToken starts TOTP enrollment and returns the shared secret
+func (s *AuthService) AssociateSoftwareToken(ctx context.Context, accessToken string) (*SoftwareTokenEnrollment, error) {
+	return s.provider.AssociateSoftwareToken(ctx, accessToken)
+}
+
+// AssociateSoftwareTokenSession starts TOTP enrollment from the provider's
+// own MFA_SETUP challenge. The returned enrollment carries the session to
+// answer the challenge with.
+func (s *AuthService) AssociateSoftwareTokenSession(ctx context.Context, email, session string) (*SoftwareTokenEnrollment, error) {
+	setup, ok := s.provider.(MFASetupProvider)
+	if !ok {
+		return nil, ErrUnsupportedChallenge
+	}
+	return setup.AssociateSoftwareTokenSession(ctx, email, session)
+}
+
+// VerifySoftwareToken finishes TOTP enrollment; later logins get a
+// SOFTWARE_TOKEN_MFA challenge
+func (s *AuthService) VerifySoftwareToken(ctx context.Context, accessToken, code string) error {
+	return s.provider.VerifySoftwareToken(ctx, accessToken, code)
+}
+
+// RefreshToken exchanges a refresh token for a new token set. Users who have
+// to enroll MFA under the policy get ErrMFASetupRequired instead.
+func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error) {
+	tokens, err := s.provider.Refresh(ctx, refreshToken)
 	if err != nil {
-		return "", ErrInvalidCredentials
+		return nil, err
 	}
 
-	// Return the ID token
-	return *resp.AuthenticationResult.IdToken, nil
+	setup, err := s.requiresMFASetup(ctx, tokens)
+	if err != nil {
+		return nil, err
+	}
+	if setup {
+		return nil, ErrMFASetupRequired
+	}
+
+	return tokens, nil
 }
 
+// VerifyToken resolves a token to its user, including the user's roles
 func (s *AuthService) VerifyToken(ctx context.Context, token string) (*models.User, error) {
-	// Parse and validate the JWT token
-	parser := jwt.Parser{
-		ValidMethods: []string{"RS256"},
+	user, err := s.provider.Verify(ctx, token)
+	if err != nil {
+		return nil, err
 	}
 
-	parsedToken, err := parser.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		// Verify the token signing method
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
+	return s.withRoles(ctx, user)
+}
 
-		// Get the key ID from the token header
-		kid, ok := token.Header["kid"].(string)
-		if !ok {
-			return nil, errors.New("kid header not found")
-		}
+// GetUser looks the account up in the identity provider, which knows every
+// user, including those without a billing record
+func (s *AuthService) GetUser(ctx context.Context, userID string) (*models.User, error) {
+	user, err := s.provider.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.withRoles(ctx, user)
+}
+
+// FindUserByEmail is GetUser by email address
+func (s *AuthService) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := s.provider.FindUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
 
-		// Find the key in the JWK set
-		if key, found := s.jwkSet.LookupKeyID(kid); found {
-			var rawKey interface{}
-			if err := key.Raw(&rawKey); err != nil {
-				return nil, err
-			}
-			return rawKey, nil
+	return s.withRoles(ctx, user)
+}
+
+// withRoles adds the roles granted through the role store to those the
+// provider reported
+func (s *AuthService) withRoles(ctx context.Context, user *models.User) (*models.User, error) {
+	if s.roleStore != nil {
+		roles, err := s.roleStore.Roles(ctx, user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve roles: %w", err)
 		}
+		user.Roles = mergeRoles(user.Roles, roles)
+	}
+
+	return user, nil
+}
+
+// DisableUser blocks the account from signing in again. Refresh tokens are
+// revoked, but with Cognito ID tokens already handed out keep working until
+// they expire, an hour by default.
+func (s *AuthService) DisableUser(ctx context.Context, userID string) error {
+	return s.provider.DisableUser(ctx, userID)
+}
 
-		return nil, errors.New("key not found")
-	})
+// DeleteUser removes the account from the identity provider
+func (s *AuthService) DeleteUser(ctx context.Context, userID string) error {
+	return s.provider.DeleteUser(ctx, userID)
+}
 
+// StartFederatedLogin begins an authorization code flow with PKCE. The
+// returned state must be kept by the client and passed to
+// CompleteFederatedLogin along with the callback parameters.
+func (s *AuthService) StartFederatedLogin(ctx context.Context, providerName string) (string, *FederatedLoginState, error) {
+	client, ok := s.oidcClients[providerName]
+	if !ok {
+		return "", nil, ErrUnknownOIDCProvider
+	}
+
+	flow, err := client.newFederatedLoginState()
 	if err != nil {
-		return nil, ErrInvalidToken
+		return "", nil, err
 	}
 
-	if !parsedToken.Valid {
-		return nil, ErrInvalidToken
+	authURL, err := client.AuthCodeURL(ctx, flow)
+	if err != nil {
+		return "", nil, err
 	}
 
-	// Extract claims
-	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	return authURL, flow, nil
+}
+
+// CompleteFederatedLogin handles the provider callback and returns the same
+// result as LoginUser. Users the MFA policy applies to get the MFA_SETUP
+// challenge until they enroll; enrolled users aren't challenged again, as the
+// identity provider never asks for a code on federated logins.
+func (s *AuthService) CompleteFederatedLogin(ctx context.Context, flow *FederatedLoginState, state, code string) (*LoginResult, error) {
+	if flow == nil || flow.State == "" || subtle.ConstantTimeCompare([]byte(flow.State), []byte(state)) != 1 {
+		return nil, ErrInvalidOIDCState
+	}
+
+	client, ok := s.oidcClients[flow.Provider]
 	if !ok {
-		return nil, ErrInvalidToken
+		return nil, ErrUnknownOIDCProvider
+	}
+
+	federation, ok := s.provider.(FederationProvider)
+	if !ok {
+		return nil, ErrFederationNotSupported
+	}
+
+	exchanged, err := client.exchange(ctx, code, flow.CodeVerifier)
+	if err != nil {
+		return nil, err
 	}
 
-	// Verify token expiration
-	if exp, ok := claims["exp"].(float64); ok {
-		if time.Unix(int64(exp), 0).Before(time.Now()) {
-			return nil, ErrExpiredToken
+	identity, err := client.verifyIDToken(ctx, exchanged.IDToken, flow.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.config.CognitoIdentityProvider != "" {
+		identity.Tokens = &Tokens{
+			IDToken:      exchanged.IDToken,
+			AccessToken:  exchanged.AccessToken,
+			RefreshToken: exchanged.RefreshToken,
+			ExpiresIn:    exchanged.ExpiresIn,
 		}
 	}
 
-	// Extract user information
-	email, _ := claims["email"].(string)
-	sub, _ := claims["sub"].(string)
+	result, err := federation.FederatedLogin(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	// A challenge means the account has MFA enrolled
+	if result.Tokens == nil {
+		return result, nil
+	}
 
-	// Create and return user model
-	user := &models.User{
-		ID:    sub,
-		Email: email,
+	setup, err := s.requiresMFASetup(ctx, result.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	if setup {
+		return mfaSetupChallenge(result.Tokens), nil
 	}
 
-	return user, nil
+	return result, nil
 }
 
 // ExtractTokenFromRequest extracts the JWT token from the Authorization header
@@ -219,4 +458,28 @@ func ExtractTokenFromRequest(request events.APIGatewayProxyRequest) (string, err
 	}
 
 	return token, nil
-}
\ No newline at end of file
+}
+
+// validatePassword applies the same policy as the Cognito user pool
+func validatePassword(password string) error {
+	if len(password) < 8 {
+		return ErrInvalidPassword
+	}
+
+	var lower, upper, digit bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		}
+	}
+	if !lower || !upper || !digit {
+		return fmt.Errorf("%w: needs lowercase, uppercase and digit characters", ErrInvalidPassword)
+	}
+
+	return nil
+}
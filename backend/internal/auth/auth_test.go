@@ -0,0 +1,199 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/celebthumb-ai/internal/auth"
+	"github.com/celebthumb-ai/internal/auth/authtest"
+	"github.com/celebthumb-ai/internal/auth/oidctest"
+)
+
+// requireMFA is an MFA policy that applies to every user
+type requireMFA struct{}
+
+func (requireMFA) RequiresMFA(ctx context.Context, userID string) (bool, error) {
+	return true, nil
+}
+
+func newPolicyService(t *testing.T, oidcClients ...*auth.OIDCClient) *auth.AuthService {
+	t.Helper()
+
+	provider, err := auth.NewLocalProvider(auth.LocalProviderConfig{
+		Path: filepath.Join(t.TempDir(), "auth.json"),
+	})
+	if err != nil {
+		t.Fatalf("NewLocalProvider: %v", err)
+	}
+
+	return auth.NewAuthService(auth.AuthConfig{
+		Provider:    provider,
+		MFAPolicy:   requireMFA{},
+		OIDCClients: oidcClients,
+	})
+}
+
+func TestMFAPolicyLogin(t *testing.T) {
+	ctx := context.Background()
+	service := newPolicyService(t)
+
+	if _, err := service.RegisterUser(ctx, "policy@example.com", "policy", authtest.ValidPassword); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+
+	result, err := service.LoginUser(ctx, "policy@example.com", authtest.ValidPassword)
+	if err != nil {
+		t.Fatalf("LoginUser: %v", err)
+	}
+	if result.Tokens != nil || result.Challenge == nil || result.Challenge.Name != auth.ChallengeMFASetup {
+		t.Fatalf("LoginUser = %+v, want %s challenge", result, auth.ChallengeMFASetup)
+	}
+
+	enroll(t, service, result.Challenge.AccessToken)
+
+	result, err = service.LoginUser(ctx, "policy@example.com", authtest.ValidPassword)
+	if err != nil {
+		t.Fatalf("LoginUser after enrollment: %v", err)
+	}
+	if result.Challenge == nil || result.Challenge.Name != auth.ChallengeSoftwareTokenMFA {
+		t.Fatalf("LoginUser after enrollment = %+v, want %s challenge", result, auth.ChallengeSoftwareTokenMFA)
+	}
+}
+
+func TestMFAPolicyRefresh(t *testing.T) {
+	ctx := context.Background()
+
+	// Sign in before the policy applies, then refresh under it
+	provider, err := auth.NewLocalProvider(auth.LocalProviderConfig{
+		Path: filepath.Join(t.TempDir(), "auth.json"),
+	})
+	if err != nil {
+		t.Fatalf("NewLocalProvider: %v", err)
+	}
+	if _, err := provider.Register(ctx, "refresh@example.com", "refresh", authtest.ValidPassword); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	result, err := provider.Login(ctx, "refresh@example.com", authtest.ValidPassword)
+	if err != nil || result.Tokens == nil {
+		t.Fatalf("Login: %+v, %v", result, err)
+	}
+
+	service := auth.NewAuthService(auth.AuthConfig{
+		Provider:  provider,
+		MFAPolicy: requireMFA{},
+	})
+
+	if _, err := service.RefreshToken(ctx, result.RefreshToken); !errors.Is(err, auth.ErrMFASetupRequired) {
+		t.Fatalf("RefreshToken before enrollment error = %v, want %v", err, auth.ErrMFASetupRequired)
+	}
+
+	enroll(t, service, result.AccessToken)
+
+	if _, err := service.RefreshToken(ctx, result.RefreshToken); err != nil {
+		t.Errorf("RefreshToken after enrollment: %v", err)
+	}
+}
+
+func TestMFAPolicyFederatedLogin(t *testing.T) {
+	ctx := context.Background()
+
+	server := oidctest.NewServer("client")
+	defer server.Close()
+	server.SetUser(oidctest.User{
+		Subject:       "google-user",
+		Email:         "federated@example.com",
+		EmailVerified: true,
+	})
+
+	service := newPolicyService(t, auth.NewOIDCClient(auth.OIDCConfig{
+		Name:        "google",
+		Issuer:      server.URL,
+		ClientID:    "client",
+		RedirectURL: "https://app.example.com/callback",
+	}))
+
+	result := federatedLogin(t, service)
+	if result.Tokens != nil || result.Challenge == nil || result.Challenge.Name != auth.ChallengeMFASetup {
+		t.Fatalf("CompleteFederatedLogin = %+v, want %s challenge", result, auth.ChallengeMFASetup)
+	}
+
+	secret := enroll(t, service, result.Challenge.AccessToken)
+
+	// Once enrolled, the federated sign-in is challenged like a password one
+	result = federatedLogin(t, service)
+	if result.Tokens != nil || result.Challenge == nil || result.Challenge.Name != auth.ChallengeSoftwareTokenMFA {
+		t.Fatalf("CompleteFederatedLogin after enrollment = %+v, want %s challenge", result, auth.ChallengeSoftwareTokenMFA)
+	}
+	if result.Challenge.Email != "federated@example.com" {
+		t.Errorf("challenge email = %q, want %q", result.Challenge.Email, "federated@example.com")
+	}
+
+	// The enrollment code's time step is spent, so answer with the next one
+	code, err := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	tokens, err := service.RespondToChallenge(ctx, result.Challenge.Email, *result.Challenge, code)
+	if err != nil {
+		t.Fatalf("RespondToChallenge: %v", err)
+	}
+	if _, err := service.VerifyToken(ctx, tokens.IDToken); err != nil {
+		t.Errorf("VerifyToken: %v", err)
+	}
+}
+
+// federatedLogin runs the authorization code flow against the test server
+func federatedLogin(t *testing.T, service *auth.AuthService) *auth.LoginResult {
+	t.Helper()
+	ctx := context.Background()
+
+	authURL, flow, err := service.StartFederatedLogin(ctx, "google")
+	if err != nil {
+		t.Fatalf("StartFederatedLogin: %v", err)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize redirect: %v", err)
+	}
+
+	result, err := service.CompleteFederatedLogin(ctx, flow, location.Query().Get("state"), location.Query().Get("code"))
+	if err != nil {
+		t.Fatalf("CompleteFederatedLogin: %v", err)
+	}
+	return result
+}
+
+// enroll completes software token enrollment with the access token and
+// returns the TOTP secret
+func enroll(t *testing.T, service *auth.AuthService, accessToken string) string {
+	t.Helper()
+	ctx := context.Background()
+
+	enrollment, err := service.AssociateSoftwareToken(ctx, accessToken)
+	if err != nil {
+		t.Fatalf("AssociateSoftwareToken: %v", err)
+	}
+	code, err := auth.TOTPCode(enrollment.SecretCode, time.Now())
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	if err := service.VerifySoftwareToken(ctx, accessToken, code); err != nil {
+		t.Fatalf("VerifySoftwareToken: %v", err)
+	}
+	return enrollment.SecretCode
+}
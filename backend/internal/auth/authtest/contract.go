@@ -0,0 +1,317 @@
+// Package authtest provides a contract test suite that every
+// auth.IdentityProvider implementation must pass.
+package authtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/celebthumb-ai/internal/auth"
+	"github.com/google/uuid"
+)
+
+// ValidPassword satisfies the user pool password policy
+const ValidPassword = "Thumbnail123"
+
+// Options records where a provider is allowed to differ from the contract's
+// defaults
+type Options struct {
+	// SelfContainedTokens is set for providers whose Verify checks only the
+	// token's signature and claims, like Cognito. Their ID tokens keep
+	// verifying after the user is disabled or deleted, until they expire.
+	SelfContainedTokens bool
+}
+
+// TestIdentityProvider runs the contract suite against provider. Every run
+// registers fresh users, so it is safe to point at a shared user pool.
+func TestIdentityProvider(t *testing.T, provider auth.IdentityProvider, opts Options) {
+	ctx := context.Background()
+
+	email := fmt.Sprintf("contract-%s@example.com", uuid.New().String())
+
+	registered, err := provider.Register(ctx, email, "contract", ValidPassword)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if registered.ID == "" {
+		t.Fatal("Register returned a user without an ID")
+	}
+	if registered.Email != email {
+		t.Errorf("Register email = %q, want %q", registered.Email, email)
+	}
+
+	t.Run("RegisterDuplicate", func(t *testing.T) {
+		_, err := provider.Register(ctx, email, "contract", ValidPassword)
+		if !errors.Is(err, auth.ErrUserExists) {
+			t.Errorf("Register duplicate error = %v, want %v", err, auth.ErrUserExists)
+		}
+	})
+
+	t.Run("RegisterWeakPassword", func(t *testing.T) {
+		weakEmail := fmt.Sprintf("contract-%s@example.com", uuid.New().String())
+		_, err := provider.Register(ctx, weakEmail, "contract", "short")
+		if !errors.Is(err, auth.ErrInvalidPassword) {
+			t.Errorf("Register weak password error = %v, want %v", err, auth.ErrInvalidPassword)
+		}
+	})
+
+	t.Run("LoginWrongPassword", func(t *testing.T) {
+		_, err := provider.Login(ctx, email, ValidPassword+"x")
+		if !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Errorf("Login wrong password error = %v, want %v", err, auth.ErrInvalidCredentials)
+		}
+	})
+
+	t.Run("LoginUnknownUser", func(t *testing.T) {
+		_, err := provider.Login(ctx, "missing-"+email, ValidPassword)
+		if !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Errorf("Login unknown user error = %v, want %v", err, auth.ErrInvalidCredentials)
+		}
+	})
+
+	result, err := provider.Login(ctx, email, ValidPassword)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if result.Challenge != nil {
+		t.Fatalf("Login without MFA returned challenge %q", result.Challenge.Name)
+	}
+	tokens := result.Tokens
+	if tokens == nil || tokens.IDToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("Login returned incomplete tokens: %+v", tokens)
+	}
+
+	t.Run("Verify", func(t *testing.T) {
+		user, err := provider.Verify(ctx, tokens.IDToken)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if user.ID != registered.ID {
+			t.Errorf("Verify ID = %q, want %q", user.ID, registered.ID)
+		}
+		if user.Email != email {
+			t.Errorf("Verify email = %q, want %q", user.Email, email)
+		}
+	})
+
+	t.Run("VerifyRejectsGarbage", func(t *testing.T) {
+		if _, err := provider.Verify(ctx, "not-a-token"); !errors.Is(err, auth.ErrInvalidToken) {
+			t.Errorf("Verify garbage error = %v, want %v", err, auth.ErrInvalidToken)
+		}
+	})
+
+	t.Run("VerifyRejectsRefreshToken", func(t *testing.T) {
+		if _, err := provider.Verify(ctx, tokens.RefreshToken); err == nil {
+			t.Error("Verify accepted a refresh token")
+		}
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		refreshed, err := provider.Refresh(ctx, tokens.RefreshToken)
+		if err != nil {
+			t.Fatalf("Refresh: %v", err)
+		}
+
+		user, err := provider.Verify(ctx, refreshed.IDToken)
+		if err != nil {
+			t.Fatalf("Verify refreshed token: %v", err)
+		}
+		if user.ID != registered.ID {
+			t.Errorf("Verify refreshed ID = %q, want %q", user.ID, registered.ID)
+		}
+	})
+
+	t.Run("GetUser", func(t *testing.T) {
+		user, err := provider.GetUser(ctx, registered.ID)
+		if err != nil {
+			t.Fatalf("GetUser: %v", err)
+		}
+		if user.ID != registered.ID || user.Email != email {
+			t.Errorf("GetUser = %q %q, want %q %q", user.ID, user.Email, registered.ID, email)
+		}
+
+		if _, err := provider.GetUser(ctx, uuid.New().String()); !errors.Is(err, auth.ErrUserNotFound) {
+			t.Errorf("GetUser unknown error = %v, want %v", err, auth.ErrUserNotFound)
+		}
+	})
+
+	t.Run("FindUserByEmail", func(t *testing.T) {
+		user, err := provider.FindUserByEmail(ctx, email)
+		if err != nil {
+			t.Fatalf("FindUserByEmail: %v", err)
+		}
+		if user.ID != registered.ID {
+			t.Errorf("FindUserByEmail ID = %q, want %q", user.ID, registered.ID)
+		}
+
+		unknown := fmt.Sprintf("contract-%s@example.com", uuid.New().String())
+		if _, err := provider.FindUserByEmail(ctx, unknown); !errors.Is(err, auth.ErrUserNotFound) {
+			t.Errorf("FindUserByEmail unknown error = %v, want %v", err, auth.ErrUserNotFound)
+		}
+	})
+
+	t.Run("SoftwareTokenMFA", func(t *testing.T) {
+		testSoftwareTokenMFA(t, provider)
+	})
+
+	t.Run("DisableUser", func(t *testing.T) {
+		testDisableUser(t, provider, opts)
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		testDeleteUser(t, provider, opts)
+	})
+
+	t.Run("RefreshRejectsGarbage", func(t *testing.T) {
+		if _, err := provider.Refresh(ctx, "not-a-token"); !errors.Is(err, auth.ErrInvalidToken) {
+			t.Errorf("Refresh garbage error = %v, want %v", err, auth.ErrInvalidToken)
+		}
+	})
+}
+
+func testSoftwareTokenMFA(t *testing.T, provider auth.IdentityProvider) {
+	ctx := context.Background()
+
+	email := fmt.Sprintf("contract-mfa-%s@example.com", uuid.New().String())
+	if _, err := provider.Register(ctx, email, "contract", ValidPassword); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	result, err := provider.Login(ctx, email, ValidPassword)
+	if err != nil || result.Tokens == nil {
+		t.Fatalf("Login before enrollment: %+v, %v", result, err)
+	}
+
+	if enabled, err := provider.MFAEnabled(ctx, result.AccessToken); err != nil || enabled {
+		t.Errorf("MFAEnabled before enrollment = %v, %v, want false", enabled, err)
+	}
+
+	enrollment, err := provider.AssociateSoftwareToken(ctx, result.AccessToken)
+	if err != nil {
+		t.Fatalf("AssociateSoftwareToken: %v", err)
+	}
+	if enrollment.SecretCode == "" {
+		t.Fatal("AssociateSoftwareToken returned an empty secret")
+	}
+
+	if err := provider.VerifySoftwareToken(ctx, result.AccessToken, "000000"); !errors.Is(err, auth.ErrInvalidMFACode) {
+		// 000000 is a valid code about once in a million runs
+		t.Errorf("VerifySoftwareToken wrong code error = %v, want %v", err, auth.ErrInvalidMFACode)
+	}
+
+	code, err := auth.TOTPCode(enrollment.SecretCode, time.Now())
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	if err := provider.VerifySoftwareToken(ctx, result.AccessToken, code); err != nil {
+		t.Fatalf("VerifySoftwareToken: %v", err)
+	}
+	if enabled, err := provider.MFAEnabled(ctx, result.AccessToken); err != nil || !enabled {
+		t.Errorf("MFAEnabled after enrollment = %v, %v, want true", enabled, err)
+	}
+
+	result, err = provider.Login(ctx, email, ValidPassword)
+	if err != nil {
+		t.Fatalf("Login after enrollment: %v", err)
+	}
+	if result.Tokens != nil || result.Challenge == nil || result.Challenge.Name != auth.ChallengeSoftwareTokenMFA {
+		t.Fatalf("Login after enrollment = %+v, want %s challenge", result, auth.ChallengeSoftwareTokenMFA)
+	}
+
+	// The enrollment code's time step is spent, so answer with the next one
+	code, err = auth.TOTPCode(enrollment.SecretCode, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	tokens, err := provider.RespondToChallenge(ctx, email, *result.Challenge, code)
+	if err != nil {
+		t.Fatalf("RespondToChallenge: %v", err)
+	}
+
+	if _, err := provider.Verify(ctx, tokens.IDToken); err != nil {
+		t.Errorf("Verify token issued after MFA: %v", err)
+	}
+}
+
+func testDisableUser(t *testing.T, provider auth.IdentityProvider, opts Options) {
+	ctx := context.Background()
+
+	email := fmt.Sprintf("contract-disable-%s@example.com", uuid.New().String())
+	user, err := provider.Register(ctx, email, "contract", ValidPassword)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	result, err := provider.Login(ctx, email, ValidPassword)
+	if err != nil || result.Tokens == nil {
+		t.Fatalf("Login before disabling: %+v, %v", result, err)
+	}
+
+	if err := provider.DisableUser(ctx, user.ID); err != nil {
+		t.Fatalf("DisableUser: %v", err)
+	}
+
+	if _, err := provider.Login(ctx, email, ValidPassword); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("Login disabled user error = %v, want %v", err, auth.ErrInvalidCredentials)
+	}
+	if _, err := provider.Refresh(ctx, result.RefreshToken); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("Refresh disabled user error = %v, want %v", err, auth.ErrInvalidToken)
+	}
+	testVerifyRevoked(t, provider, opts, result.IDToken)
+	if err := provider.DisableUser(ctx, "missing-"+user.ID); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("DisableUser unknown user error = %v, want %v", err, auth.ErrUserNotFound)
+	}
+}
+
+func testDeleteUser(t *testing.T, provider auth.IdentityProvider, opts Options) {
+	ctx := context.Background()
+
+	email := fmt.Sprintf("contract-delete-%s@example.com", uuid.New().String())
+	user, err := provider.Register(ctx, email, "contract", ValidPassword)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	result, err := provider.Login(ctx, email, ValidPassword)
+	if err != nil || result.Tokens == nil {
+		t.Fatalf("Login before deleting: %+v, %v", result, err)
+	}
+
+	if err := provider.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	if _, err := provider.Login(ctx, email, ValidPassword); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("Login deleted user error = %v, want %v", err, auth.ErrInvalidCredentials)
+	}
+	if _, err := provider.Refresh(ctx, result.RefreshToken); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("Refresh deleted user error = %v, want %v", err, auth.ErrInvalidToken)
+	}
+	testVerifyRevoked(t, provider, opts, result.IDToken)
+	if _, err := provider.GetUser(ctx, user.ID); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("GetUser deleted user error = %v, want %v", err, auth.ErrUserNotFound)
+	}
+	if err := provider.DeleteUser(ctx, user.ID); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("DeleteUser twice error = %v, want %v", err, auth.ErrUserNotFound)
+	}
+}
+
+// testVerifyRevoked checks Verify on an ID token issued before its user was
+// disabled or deleted
+func testVerifyRevoked(t *testing.T, provider auth.IdentityProvider, opts Options, idToken string) {
+	t.Helper()
+
+	_, err := provider.Verify(context.Background(), idToken)
+	if opts.SelfContainedTokens {
+		if err != nil {
+			t.Errorf("Verify self-contained token after revocation: %v, want it to verify until expiry", err)
+		}
+		return
+	}
+	if !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("Verify revoked user error = %v, want %v", err, auth.ErrInvalidToken)
+	}
+}
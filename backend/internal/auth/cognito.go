@@ -0,0 +1,658 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// CognitoAPI is the part of the Cognito client CognitoProvider uses
+type CognitoAPI interface {
+	AdminConfirmSignUp(ctx context.Context, params *cognitoidentityprovider.AdminConfirmSignUpInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminConfirmSignUpOutput, error)
+	AdminDeleteUser(ctx context.Context, params *cognitoidentityprovider.AdminDeleteUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDeleteUserOutput, error)
+	AdminDisableUser(ctx context.Context, params *cognitoidentityprovider.AdminDisableUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDisableUserOutput, error)
+	AdminGetUser(ctx context.Context, params *cognitoidentityprovider.AdminGetUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminGetUserOutput, error)
+	AdminLinkProviderForUser(ctx context.Context, params *cognitoidentityprovider.AdminLinkProviderForUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminLinkProviderForUserOutput, error)
+	AdminListGroupsForUser(ctx context.Context, params *cognitoidentityprovider.AdminListGroupsForUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminListGroupsForUserOutput, error)
+	AdminUserGlobalSignOut(ctx context.Context, params *cognitoidentityprovider.AdminUserGlobalSignOutInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminUserGlobalSignOutOutput, error)
+	AssociateSoftwareToken(ctx context.Context, params *cognitoidentityprovider.AssociateSoftwareTokenInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AssociateSoftwareTokenOutput, error)
+	GetUser(ctx context.Context, params *cognitoidentityprovider.GetUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.GetUserOutput, error)
+	InitiateAuth(ctx context.Context, params *cognitoidentityprovider.InitiateAuthInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.InitiateAuthOutput, error)
+	ListUsers(ctx context.Context, params *cognitoidentityprovider.ListUsersInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.ListUsersOutput, error)
+	RespondToAuthChallenge(ctx context.Context, params *cognitoidentityprovider.RespondToAuthChallengeInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.RespondToAuthChallengeOutput, error)
+	SetUserMFAPreference(ctx context.Context, params *cognitoidentityprovider.SetUserMFAPreferenceInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.SetUserMFAPreferenceOutput, error)
+	SignUp(ctx context.Context, params *cognitoidentityprovider.SignUpInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.SignUpOutput, error)
+	VerifySoftwareToken(ctx context.Context, params *cognitoidentityprovider.VerifySoftwareTokenInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.VerifySoftwareTokenOutput, error)
+}
+
+type CognitoProviderConfig struct {
+	CognitoClient CognitoAPI
+	UserPoolID    string
+	ClientID      string
+	Region        string
+}
+
+// CognitoProvider authenticates users against a Cognito user pool
+type CognitoProvider struct {
+	cognitoClient CognitoAPI
+	userPoolID    string
+	clientID      string
+	issuer        string
+	keySet        *remoteKeySet
+}
+
+func NewCognitoProvider(config CognitoProviderConfig) *CognitoProvider {
+	userPoolID := config.UserPoolID
+	if userPoolID == "" {
+		userPoolID = os.Getenv("USER_POOL_ID")
+	}
+
+	clientID := config.ClientID
+	if clientID == "" {
+		clientID = os.Getenv("USER_POOL_CLIENT_ID")
+	}
+
+	region := config.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	issuer := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", region, userPoolID)
+
+	return &CognitoProvider{
+		cognitoClient: config.CognitoClient,
+		userPoolID:    userPoolID,
+		clientID:      clientID,
+		issuer:        issuer,
+		// The JWK set is fetched on first use so that construction never
+		// needs network access
+		keySet: newRemoteKeySet(issuer + "/.well-known/jwks.json"),
+	}
+}
+
+func (p *CognitoProvider) Register(ctx context.Context, email, username, password string) (*models.User, error) {
+	// Check if user already exists
+	_, err := p.cognitoClient.AdminGetUser(ctx, &cognitoidentityprovider.AdminGetUserInput{
+		UserPoolId: aws.String(p.userPoolID),
+		Username:   aws.String(email),
+	})
+	if err == nil {
+		return nil, ErrUserExists
+	}
+
+	// Create user in Cognito
+	resp, err := p.cognitoClient.SignUp(ctx, &cognitoidentityprovider.SignUpInput{
+		ClientId: aws.String(p.clientID),
+		Username: aws.String(email),
+		Password: aws.String(password),
+		UserAttributes: []types.AttributeType{
+			{
+				Name:  aws.String("email"),
+				Value: aws.String(email),
+			},
+			{
+				Name:  aws.String("preferred_username"),
+				Value: aws.String(username),
+			},
+		},
+	})
+	if err != nil {
+		var usernameExists *types.UsernameExistsException
+		if errors.As(err, &usernameExists) {
+			return nil, ErrUserExists
+		}
+		var invalidPassword *types.InvalidPasswordException
+		if errors.As(err, &invalidPassword) {
+			return nil, ErrInvalidPassword
+		}
+		return nil, fmt.Errorf("failed to register user: %w", err)
+	}
+
+	// Auto-confirm user for development purposes
+	// In production, you would use email verification
+	_, err = p.cognitoClient.AdminConfirmSignUp(ctx, &cognitoidentityprovider.AdminConfirmSignUpInput{
+		UserPoolId: aws.String(p.userPoolID),
+		Username:   aws.String(email),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm user: %w", err)
+	}
+
+	return &models.User{
+		ID:        aws.ToString(resp.UserSub),
+		Email:     email,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func (p *CognitoProvider) Login(ctx context.Context, email, password string) (*LoginResult, error) {
+	// Authenticate user with Cognito
+	resp, err := p.cognitoClient.InitiateAuth(ctx, &cognitoidentityprovider.InitiateAuthInput{
+		AuthFlow: types.AuthFlowTypeUserPasswordAuth,
+		ClientId: aws.String(p.clientID),
+		AuthParameters: map[string]string{
+			"USERNAME": email,
+			"PASSWORD": password,
+		},
+	})
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if resp.AuthenticationResult != nil {
+		return &LoginResult{Tokens: tokensFromResult(resp.AuthenticationResult)}, nil
+	}
+
+	// Cognito wants another step before issuing tokens
+	switch resp.ChallengeName {
+	case types.ChallengeNameTypeSoftwareTokenMfa, types.ChallengeNameTypeMfaSetup:
+		return &LoginResult{
+			Challenge: &Challenge{
+				Name:    string(resp.ChallengeName),
+				Session: aws.ToString(resp.Session),
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedChallenge, resp.ChallengeName)
+	}
+}
+
+func (p *CognitoProvider) RespondToChallenge(ctx context.Context, email string, challenge Challenge, code string) (*Tokens, error) {
+	if challenge.Name == ChallengeMFASetup {
+		return p.completeMFASetup(ctx, email, challenge.Session, code)
+	}
+
+	resp, err := p.cognitoClient.RespondToAuthChallenge(ctx, &cognitoidentityprovider.RespondToAuthChallengeInput{
+		ClientId:      aws.String(p.clientID),
+		ChallengeName: types.ChallengeNameType(challenge.Name),
+		Session:       aws.String(challenge.Session),
+		ChallengeResponses: map[string]string{
+			"USERNAME":                email,
+			"SOFTWARE_TOKEN_MFA_CODE": code,
+		},
+	})
+	if err != nil {
+		return nil, challengeError(err)
+	}
+
+	if resp.AuthenticationResult == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedChallenge, resp.ChallengeName)
+	}
+
+	return tokensFromResult(resp.AuthenticationResult), nil
+}
+
+// AssociateSoftwareTokenSession starts enrollment from the MFA_SETUP
+// challenge Cognito raises when the pool requires MFA
+func (p *CognitoProvider) AssociateSoftwareTokenSession(ctx context.Context, email, session string) (*SoftwareTokenEnrollment, error) {
+	resp, err := p.cognitoClient.AssociateSoftwareToken(ctx, &cognitoidentityprovider.AssociateSoftwareTokenInput{
+		Session: aws.String(session),
+	})
+	if err != nil {
+		return nil, challengeError(err)
+	}
+
+	enrollment := newSoftwareTokenEnrollment(aws.ToString(resp.SecretCode), email)
+	enrollment.Session = aws.ToString(resp.Session)
+	return enrollment, nil
+}
+
+// completeMFASetup verifies the first code on the session from
+// AssociateSoftwareTokenSession and answers the MFA_SETUP challenge with it
+func (p *CognitoProvider) completeMFASetup(ctx context.Context, email, session, code string) (*Tokens, error) {
+	verified, err := p.cognitoClient.VerifySoftwareToken(ctx, &cognitoidentityprovider.VerifySoftwareTokenInput{
+		Session:  aws.String(session),
+		UserCode: aws.String(code),
+	})
+	if err != nil {
+		return nil, challengeError(err)
+	}
+	if verified.Status != types.VerifySoftwareTokenResponseTypeSuccess {
+		return nil, ErrInvalidMFACode
+	}
+
+	resp, err := p.cognitoClient.RespondToAuthChallenge(ctx, &cognitoidentityprovider.RespondToAuthChallengeInput{
+		ClientId:      aws.String(p.clientID),
+		ChallengeName: types.ChallengeNameTypeMfaSetup,
+		Session:       verified.Session,
+		ChallengeResponses: map[string]string{
+			"USERNAME": email,
+		},
+	})
+	if err != nil {
+		return nil, challengeError(err)
+	}
+	if resp.AuthenticationResult == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedChallenge, resp.ChallengeName)
+	}
+
+	tokens := tokensFromResult(resp.AuthenticationResult)
+	if err := p.preferSoftwareToken(ctx, tokens.AccessToken); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (p *CognitoProvider) AssociateSoftwareToken(ctx context.Context, accessToken string) (*SoftwareTokenEnrollment, error) {
+	user, err := p.cognitoClient.GetUser(ctx, &cognitoidentityprovider.GetUserInput{
+		AccessToken: aws.String(accessToken),
+	})
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	resp, err := p.cognitoClient.AssociateSoftwareToken(ctx, &cognitoidentityprovider.AssociateSoftwareTokenInput{
+		AccessToken: aws.String(accessToken),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to associate software token: %w", err)
+	}
+
+	email := aws.ToString(user.Username)
+	for _, attr := range user.UserAttributes {
+		if aws.ToString(attr.Name) == "email" {
+			email = aws.ToString(attr.Value)
+		}
+	}
+
+	return newSoftwareTokenEnrollment(aws.ToString(resp.SecretCode), email), nil
+}
+
+func (p *CognitoProvider) VerifySoftwareToken(ctx context.Context, accessToken, code string) error {
+	resp, err := p.cognitoClient.VerifySoftwareToken(ctx, &cognitoidentityprovider.VerifySoftwareTokenInput{
+		AccessToken: aws.String(accessToken),
+		UserCode:    aws.String(code),
+	})
+	if err != nil {
+		return challengeError(err)
+	}
+	if resp.Status != types.VerifySoftwareTokenResponseTypeSuccess {
+		return ErrInvalidMFACode
+	}
+
+	return p.preferSoftwareToken(ctx, accessToken)
+}
+
+// preferSoftwareToken makes TOTP the user's MFA method so logins start
+// getting challenged for it
+func (p *CognitoProvider) preferSoftwareToken(ctx context.Context, accessToken string) error {
+	_, err := p.cognitoClient.SetUserMFAPreference(ctx, &cognitoidentityprovider.SetUserMFAPreferenceInput{
+		AccessToken: aws.String(accessToken),
+		SoftwareTokenMfaSettings: &types.SoftwareTokenMfaSettingsType{
+			Enabled:      true,
+			PreferredMfa: true,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable software token MFA: %w", err)
+	}
+
+	return nil
+}
+
+func (p *CognitoProvider) MFAEnabled(ctx context.Context, accessToken string) (bool, error) {
+	user, err := p.cognitoClient.GetUser(ctx, &cognitoidentityprovider.GetUserInput{
+		AccessToken: aws.String(accessToken),
+	})
+	if err != nil {
+		return false, ErrInvalidToken
+	}
+
+	return hasSoftwareTokenMFA(user.UserMFASettingList), nil
+}
+
+func hasSoftwareTokenMFA(settings []string) bool {
+	for _, setting := range settings {
+		if setting == string(types.ChallengeNameTypeSoftwareTokenMfa) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CognitoProvider) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	resp, err := p.cognitoClient.InitiateAuth(ctx, &cognitoidentityprovider.InitiateAuthInput{
+		AuthFlow: types.AuthFlowTypeRefreshTokenAuth,
+		ClientId: aws.String(p.clientID),
+		AuthParameters: map[string]string{
+			"REFRESH_TOKEN": refreshToken,
+		},
+	})
+	if err != nil || resp.AuthenticationResult == nil {
+		return nil, ErrInvalidToken
+	}
+
+	tokens := tokensFromResult(resp.AuthenticationResult)
+	// Cognito doesn't rotate refresh tokens, so hand back the one we were given
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = refreshToken
+	}
+
+	return tokens, nil
+}
+
+func (p *CognitoProvider) Verify(ctx context.Context, token string) (*models.User, error) {
+	// Parse and validate the JWT token
+	parser := jwt.Parser{
+		ValidMethods: []string{"RS256"},
+	}
+
+	parsedToken, err := parser.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		// Get the key ID from the token header
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("kid header not found")
+		}
+
+		return p.keySet.lookup(ctx, kid)
+	})
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, ErrExpiredToken
+		}
+		return nil, ErrInvalidToken
+	}
+
+	if !parsedToken.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	// Extract claims
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	// Only ID tokens carry the email claim we rely on
+	if tokenUse, _ := claims["token_use"].(string); tokenUse != "id" {
+		return nil, ErrInvalidToken
+	}
+
+	// Tokens from another pool or app client are signed by the same keys
+	// when they share a region, so the audience and issuer must match ours
+	if !claims.VerifyAudience(p.clientID, true) || !claims.VerifyIssuer(p.issuer, true) {
+		return nil, ErrInvalidToken
+	}
+
+	// Extract user information
+	email, _ := claims["email"].(string)
+	sub, _ := claims["sub"].(string)
+
+	return &models.User{
+		ID:    sub,
+		Email: email,
+		Roles: groupsClaim(claims["cognito:groups"]),
+	}, nil
+}
+
+func (p *CognitoProvider) DisableUser(ctx context.Context, userID string) error {
+	username, err := p.username(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.cognitoClient.AdminDisableUser(ctx, &cognitoidentityprovider.AdminDisableUserInput{
+		UserPoolId: aws.String(p.userPoolID),
+		Username:   username,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to disable user: %w", err)
+	}
+
+	// Revoke refresh tokens so existing sessions can't be extended
+	_, err = p.cognitoClient.AdminUserGlobalSignOut(ctx, &cognitoidentityprovider.AdminUserGlobalSignOutInput{
+		UserPoolId: aws.String(p.userPoolID),
+		Username:   username,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to sign out user: %w", err)
+	}
+
+	return nil
+}
+
+func (p *CognitoProvider) DeleteUser(ctx context.Context, userID string) error {
+	username, err := p.username(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.cognitoClient.AdminDeleteUser(ctx, &cognitoidentityprovider.AdminDeleteUserInput{
+		UserPoolId: aws.String(p.userPoolID),
+		Username:   username,
+	})
+	if err != nil {
+		var notFound *types.UserNotFoundException
+		if errors.As(err, &notFound) {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	return nil
+}
+
+// GetUser looks the user up by their sub
+func (p *CognitoProvider) GetUser(ctx context.Context, userID string) (*models.User, error) {
+	return p.findUser(ctx, fmt.Sprintf("sub = %q", userID))
+}
+
+func (p *CognitoProvider) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return p.findUser(ctx, fmt.Sprintf("email = %q", email))
+}
+
+// findUser returns the first user matching a ListUsers filter, with their
+// groups as roles the way Verify reads them from cognito:groups
+func (p *CognitoProvider) findUser(ctx context.Context, filter string) (*models.User, error) {
+	resp, err := p.cognitoClient.ListUsers(ctx, &cognitoidentityprovider.ListUsersInput{
+		UserPoolId: aws.String(p.userPoolID),
+		Filter:     aws.String(filter),
+		Limit:      aws.Int32(1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if len(resp.Users) == 0 {
+		return nil, ErrUserNotFound
+	}
+	found := resp.Users[0]
+
+	user := &models.User{
+		CreatedAt: aws.ToTime(found.UserCreateDate),
+	}
+	for _, attr := range found.Attributes {
+		switch aws.ToString(attr.Name) {
+		case "sub":
+			user.ID = aws.ToString(attr.Value)
+		case "email":
+			user.Email = aws.ToString(attr.Value)
+		}
+	}
+
+	groups, err := p.cognitoClient.AdminListGroupsForUser(ctx, &cognitoidentityprovider.AdminListGroupsForUserInput{
+		UserPoolId: aws.String(p.userPoolID),
+		Username:   found.Username,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user groups: %w", err)
+	}
+	for _, group := range groups.Groups {
+		user.Roles = append(user.Roles, aws.ToString(group.GroupName))
+	}
+
+	return user, nil
+}
+
+// username looks up the user's Cognito username. Admin APIs take the
+// username, which differs from the sub for federated users.
+func (p *CognitoProvider) username(ctx context.Context, userID string) (*string, error) {
+	resp, err := p.cognitoClient.ListUsers(ctx, &cognitoidentityprovider.ListUsersInput{
+		UserPoolId: aws.String(p.userPoolID),
+		Filter:     aws.String(fmt.Sprintf("sub = %q", userID)),
+		Limit:      aws.Int32(1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if len(resp.Users) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	return resp.Users[0].Username, nil
+}
+
+// FederatedLogin accepts tokens issued by the user pool's hosted UI for a
+// federated identity provider. When the federated user's verified email
+// belongs to an existing password user, the federated user is folded into
+// that account and ErrAccountLinked asks the caller to sign in again. The
+// hosted UI never asks federated users for a TOTP code, so accounts with MFA
+// enrolled are neither signed in nor linked this way.
+func (p *CognitoProvider) FederatedLogin(ctx context.Context, identity *FederatedIdentity) (*LoginResult, error) {
+	if identity.Tokens == nil {
+		// Cognito can't mint tokens for identities it didn't authenticate
+		return nil, ErrFederationNotSupported
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(identity.Tokens.IDToken, claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	enrolled, err := p.MFAEnabled(ctx, identity.Tokens.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	if enrolled {
+		return nil, ErrPasswordSignInRequired
+	}
+
+	federatedUsername, _ := claims["cognito:username"].(string)
+	providerName, providerUserID := federatedSource(claims)
+	if providerName == "" || !identity.EmailVerified || identity.Email == "" {
+		return &LoginResult{Tokens: identity.Tokens}, nil
+	}
+
+	nativeUsername, err := p.findNativeUser(ctx, identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	if nativeUsername == "" || nativeUsername == federatedUsername {
+		return &LoginResult{Tokens: identity.Tokens}, nil
+	}
+
+	native, err := p.cognitoClient.AdminGetUser(ctx, &cognitoidentityprovider.AdminGetUserInput{
+		UserPoolId: aws.String(p.userPoolID),
+		Username:   aws.String(nativeUsername),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up existing user: %w", err)
+	}
+	if hasSoftwareTokenMFA(native.UserMFASettingList) {
+		// Once linked, the hosted UI would sign in as this account
+		// without its second factor
+		return nil, ErrPasswordSignInRequired
+	}
+
+	// Cognito only links a source identity that doesn't exist as a user yet,
+	// so the freshly created federated user has to go first
+	_, err = p.cognitoClient.AdminDeleteUser(ctx, &cognitoidentityprovider.AdminDeleteUserInput{
+		UserPoolId: aws.String(p.userPoolID),
+		Username:   aws.String(federatedUsername),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove federated user: %w", err)
+	}
+
+	_, err = p.cognitoClient.AdminLinkProviderForUser(ctx, &cognitoidentityprovider.AdminLinkProviderForUserInput{
+		UserPoolId: aws.String(p.userPoolID),
+		DestinationUser: &types.ProviderUserIdentifierType{
+			ProviderName:           aws.String("Cognito"),
+			ProviderAttributeValue: aws.String(nativeUsername),
+		},
+		SourceUser: &types.ProviderUserIdentifierType{
+			ProviderName:           aws.String(providerName),
+			ProviderAttributeName:  aws.String("Cognito_Subject"),
+			ProviderAttributeValue: aws.String(providerUserID),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to link federated identity: %w", err)
+	}
+
+	return nil, ErrAccountLinked
+}
+
+// findNativeUser returns the username of the password user with email, if any
+func (p *CognitoProvider) findNativeUser(ctx context.Context, email string) (string, error) {
+	resp, err := p.cognitoClient.ListUsers(ctx, &cognitoidentityprovider.ListUsersInput{
+		UserPoolId: aws.String(p.userPoolID),
+		Filter:     aws.String(fmt.Sprintf("email = %q", email)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up users by email: %w", err)
+	}
+
+	for _, user := range resp.Users {
+		// The SDK enum predates federated users, so compare the raw status
+		if user.UserStatus != types.UserStatusType("EXTERNAL_PROVIDER") {
+			return aws.ToString(user.Username), nil
+		}
+	}
+
+	return "", nil
+}
+
+// federatedSource reads the upstream provider from the identities claim Cognito
+// adds to tokens of federated users
+func federatedSource(claims jwt.MapClaims) (string, string) {
+	identities, _ := claims["identities"].([]interface{})
+	if len(identities) == 0 {
+		return "", ""
+	}
+
+	first, _ := identities[0].(map[string]interface{})
+	providerName, _ := first["providerName"].(string)
+	userID, _ := first["userId"].(string)
+
+	return providerName, userID
+}
+
+// challengeError maps Cognito's MFA failures onto the package errors
+func challengeError(err error) error {
+	var codeMismatch *types.CodeMismatchException
+	if errors.As(err, &codeMismatch) {
+		return ErrInvalidMFACode
+	}
+	var enableFailed *types.EnableSoftwareTokenMFAException
+	if errors.As(err, &enableFailed) {
+		return ErrInvalidMFACode
+	}
+	var notAuthorized *types.NotAuthorizedException
+	if errors.As(err, &notAuthorized) {
+		return ErrInvalidSession
+	}
+	var expired *types.ExpiredCodeException
+	if errors.As(err, &expired) {
+		return ErrInvalidSession
+	}
+	return fmt.Errorf("failed to verify MFA code: %w", err)
+}
+
+func tokensFromResult(result *types.AuthenticationResultType) *Tokens {
+	return &Tokens{
+		IDToken:      aws.ToString(result.IdToken),
+		AccessToken:  aws.ToString(result.AccessToken),
+		RefreshToken: aws.ToString(result.RefreshToken),
+		ExpiresIn:    int(result.ExpiresIn),
+	}
+}
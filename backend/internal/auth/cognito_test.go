@@ -0,0 +1,39 @@
+package auth_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/celebthumb-ai/internal/auth"
+	"github.com/celebthumb-ai/internal/auth/authtest"
+)
+
+// TestCognitoProvider runs the contract suite against a real user pool. It
+// needs AWS credentials and a pool whose app client allows USER_PASSWORD_AUTH
+// and optional TOTP MFA, so it only runs when the pool is named explicitly.
+func TestCognitoProvider(t *testing.T) {
+	userPoolID := os.Getenv("COGNITO_TEST_USER_POOL_ID")
+	clientID := os.Getenv("COGNITO_TEST_CLIENT_ID")
+	if userPoolID == "" || clientID == "" {
+		t.Skip("COGNITO_TEST_USER_POOL_ID and COGNITO_TEST_CLIENT_ID are not set")
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		t.Fatalf("LoadDefaultConfig: %v", err)
+	}
+
+	provider := auth.NewCognitoProvider(auth.CognitoProviderConfig{
+		CognitoClient: cognitoidentityprovider.NewFromConfig(cfg),
+		UserPoolID:    userPoolID,
+		ClientID:      clientID,
+		Region:        cfg.Region,
+	})
+
+	// Cognito ID tokens are verified against the pool's keys alone, so a
+	// disabled or deleted user's token stays valid until it expires
+	authtest.TestIdentityProvider(t, provider, authtest.Options{SelfContainedTokens: true})
+}
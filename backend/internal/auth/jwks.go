@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// jwksRefreshInterval limits how often an unknown key ID triggers a refetch
+const jwksRefreshInterval = 5 * time.Minute
+
+// remoteKeySet caches a JWK set fetched from url. The set is fetched on first
+// use so that construction never needs network access.
+type remoteKeySet struct {
+	url string
+
+	mu      sync.Mutex
+	set     jwk.Set
+	fetched time.Time
+}
+
+func newRemoteKeySet(url string) *remoteKeySet {
+	return &remoteKeySet{url: url}
+}
+
+// lookup finds the signing key for kid, refetching the JWK set when the key
+// is unknown so that key rotation is picked up
+func (k *remoteKeySet) lookup(ctx context.Context, kid string) (interface{}, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.set != nil {
+		if key, found := k.set.LookupKeyID(kid); found {
+			return rawKey(key)
+		}
+	}
+
+	if k.set != nil && time.Since(k.fetched) < jwksRefreshInterval {
+		return nil, errors.New("key not found")
+	}
+
+	set, err := jwk.Fetch(ctx, k.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWK set: %w", err)
+	}
+	k.set = set
+	k.fetched = time.Now()
+
+	if key, found := k.set.LookupKeyID(kid); found {
+		return rawKey(key)
+	}
+
+	return nil, errors.New("key not found")
+}
+
+func rawKey(key jwk.Key) (interface{}, error) {
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
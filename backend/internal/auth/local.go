@@ -0,0 +1,719 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	localIssuer          = "celebthumb-local"
+	localIDTokenTTL      = time.Hour
+	localRefreshTokenTTL = 30 * 24 * time.Hour
+	localChallengeTTL    = 3 * time.Minute
+	// localChallengeAttempts caps wrong codes per challenge session and per
+	// pending enrollment
+	localChallengeAttempts = 5
+)
+
+type LocalProviderConfig struct {
+	// Path is the JSON file users and the signing key are kept in. When empty
+	// everything lives in memory and is lost on restart.
+	Path string
+}
+
+// LocalProvider is a self-contained identity provider for offline development.
+// Passwords are bcrypt-hashed and tokens are RS256 JWTs signed with a key
+// generated on first use.
+type LocalProvider struct {
+	mu    sync.Mutex
+	path  string
+	key   *rsa.PrivateKey
+	kid   string
+	users map[string]*localUser
+	// sessions maps pending MFA challenge sessions to the user's email
+	sessions map[string]localSession
+}
+
+type localUser struct {
+	ID           string    `json:"id"`
+	Email        string    `json:"email"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash"`
+	CreatedAt    time.Time `json:"createdAt"`
+
+	TOTPSecret        string `json:"totpSecret,omitempty"`
+	PendingTOTPSecret string `json:"pendingTotpSecret,omitempty"`
+	// PendingTOTPAttempts counts wrong codes against the pending secret
+	PendingTOTPAttempts int `json:"pendingTotpAttempts,omitempty"`
+	// LastTOTPStep is the last accepted time step, used to stop code replay
+	LastTOTPStep int64 `json:"lastTotpStep,omitempty"`
+
+	// Identities are the federated logins linked to this user
+	Identities []localIdentity `json:"identities,omitempty"`
+
+	// Roles are issued as the cognito:groups claim; edit the file to grant them
+	Roles    []string `json:"roles,omitempty"`
+	Disabled bool     `json:"disabled,omitempty"`
+}
+
+type localIdentity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+type localSession struct {
+	email     string
+	expiresAt time.Time
+	attempts  int
+}
+
+// localState is the on-disk layout of the provider file
+type localState struct {
+	SigningKey string                `json:"signingKey"`
+	Users      map[string]*localUser `json:"users"`
+}
+
+func NewLocalProvider(config LocalProviderConfig) (*LocalProvider, error) {
+	path := config.Path
+	if path == "" {
+		path = os.Getenv("LOCAL_AUTH_FILE")
+	}
+
+	p := &LocalProvider{
+		path:     path,
+		users:    make(map[string]*localUser),
+		sessions: make(map[string]localSession),
+	}
+
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+
+	if p.key == nil {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+		p.setKey(key)
+
+		if err := p.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func (p *LocalProvider) Register(ctx context.Context, email, username, password string) (*models.User, error) {
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	email = normalizeEmail(email)
+	if _, exists := p.users[email]; exists {
+		return nil, ErrUserExists
+	}
+
+	user := &localUser{
+		ID:           uuid.New().String(),
+		Email:        email,
+		Username:     username,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now(),
+	}
+	p.users[email] = user
+
+	if err := p.save(); err != nil {
+		delete(p.users, email)
+		return nil, err
+	}
+
+	return &models.User{
+		ID:        user.ID,
+		Email:     user.Email,
+		CreatedAt: user.CreatedAt,
+	}, nil
+}
+
+func (p *LocalProvider) Login(ctx context.Context, email, password string) (*LoginResult, error) {
+	p.mu.Lock()
+	user, ok := p.users[normalizeEmail(email)]
+	p.mu.Unlock()
+
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	p.mu.Lock()
+	mfaEnabled := user.TOTPSecret != ""
+	disabled := user.Disabled
+	p.mu.Unlock()
+
+	if disabled {
+		return nil, ErrInvalidCredentials
+	}
+
+	if mfaEnabled {
+		session, err := p.startSession(user.Email)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{
+			Challenge: &Challenge{
+				Name:    ChallengeSoftwareTokenMFA,
+				Session: session,
+			},
+		}, nil
+	}
+
+	tokens, err := p.issueTokens(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{Tokens: tokens}, nil
+}
+
+func (p *LocalProvider) RespondToChallenge(ctx context.Context, email string, challenge Challenge, code string) (*Tokens, error) {
+	if challenge.Name != ChallengeSoftwareTokenMFA {
+		return nil, ErrUnsupportedChallenge
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	email = normalizeEmail(email)
+	session, ok := p.sessions[challenge.Session]
+	if !ok || session.email != email || time.Now().After(session.expiresAt) {
+		return nil, ErrInvalidSession
+	}
+
+	user, ok := p.users[email]
+	if !ok || user.TOTPSecret == "" || user.Disabled {
+		return nil, ErrInvalidSession
+	}
+
+	step, ok := validateTOTP(user.TOTPSecret, code, time.Now(), user.LastTOTPStep)
+	if !ok {
+		session.attempts++
+		if session.attempts >= localChallengeAttempts {
+			delete(p.sessions, challenge.Session)
+		} else {
+			p.sessions[challenge.Session] = session
+		}
+		return nil, ErrInvalidMFACode
+	}
+
+	user.LastTOTPStep = step
+	delete(p.sessions, challenge.Session)
+	if err := p.save(); err != nil {
+		return nil, err
+	}
+
+	return p.issueTokens(user)
+}
+
+func (p *LocalProvider) AssociateSoftwareToken(ctx context.Context, accessToken string) (*SoftwareTokenEnrollment, error) {
+	claims, err := p.parse(accessToken, "access")
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, ok := p.users[normalizeEmail(claims.Email)]
+	if !ok || user.ID != claims.Subject {
+		return nil, ErrInvalidToken
+	}
+
+	user.PendingTOTPSecret = secret
+	user.PendingTOTPAttempts = 0
+	if err := p.save(); err != nil {
+		return nil, err
+	}
+
+	return newSoftwareTokenEnrollment(secret, user.Email), nil
+}
+
+func (p *LocalProvider) VerifySoftwareToken(ctx context.Context, accessToken, code string) error {
+	claims, err := p.parse(accessToken, "access")
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, ok := p.users[normalizeEmail(claims.Email)]
+	if !ok || user.ID != claims.Subject {
+		return ErrInvalidToken
+	}
+	if user.PendingTOTPSecret == "" {
+		return ErrInvalidMFACode
+	}
+
+	step, ok := validateTOTP(user.PendingTOTPSecret, code, time.Now(), 0)
+	if !ok {
+		// Like a challenge session, the pending secret only takes so many
+		// guesses; after that enrollment starts over with a new secret
+		user.PendingTOTPAttempts++
+		if user.PendingTOTPAttempts >= localChallengeAttempts {
+			user.PendingTOTPSecret = ""
+			user.PendingTOTPAttempts = 0
+		}
+		if err := p.save(); err != nil {
+			return err
+		}
+		return ErrInvalidMFACode
+	}
+
+	user.TOTPSecret = user.PendingTOTPSecret
+	user.PendingTOTPSecret = ""
+	user.PendingTOTPAttempts = 0
+	user.LastTOTPStep = step
+
+	return p.save()
+}
+
+func (p *LocalProvider) MFAEnabled(ctx context.Context, accessToken string) (bool, error) {
+	claims, err := p.parse(accessToken, "access")
+	if err != nil {
+		return false, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, ok := p.users[normalizeEmail(claims.Email)]
+	if !ok || user.ID != claims.Subject {
+		return false, ErrInvalidToken
+	}
+
+	return user.TOTPSecret != "", nil
+}
+
+// FederatedLogin signs in a user authenticated by an OIDC provider. A known
+// identity maps to its user; otherwise a verified email is linked to the
+// existing account, or a new passwordless account is created.
+func (p *LocalProvider) FederatedLogin(ctx context.Context, identity *FederatedIdentity) (*LoginResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	link := localIdentity{Provider: identity.Provider, Subject: identity.Subject}
+	for _, user := range p.users {
+		for _, linked := range user.Identities {
+			if linked == link {
+				if user.Disabled {
+					return nil, ErrInvalidCredentials
+				}
+				return p.federatedResult(user)
+			}
+		}
+	}
+
+	email := normalizeEmail(identity.Email)
+	if email == "" {
+		return nil, ErrInvalidToken
+	}
+
+	user, exists := p.users[email]
+	if exists && user.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	if exists && !identity.EmailVerified {
+		// Linking on an unverified email would let anyone take over the account
+		return nil, ErrUserExists
+	}
+
+	if !exists {
+		user = &localUser{
+			ID:        uuid.New().String(),
+			Email:     email,
+			Username:  identity.Name,
+			CreatedAt: time.Now(),
+		}
+		p.users[email] = user
+	}
+
+	user.Identities = append(user.Identities, link)
+	if err := p.save(); err != nil {
+		user.Identities = user.Identities[:len(user.Identities)-1]
+		if !exists {
+			delete(p.users, email)
+		}
+		return nil, err
+	}
+
+	return p.federatedResult(user)
+}
+
+// federatedResult finishes a federated sign-in the way Login would, with a
+// TOTP challenge when the user has MFA enrolled. Callers hold p.mu.
+func (p *LocalProvider) federatedResult(user *localUser) (*LoginResult, error) {
+	if user.TOTPSecret == "" {
+		tokens, err := p.issueTokens(user)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{Tokens: tokens}, nil
+	}
+
+	session, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	p.addSession(session, user.Email)
+
+	return &LoginResult{
+		Challenge: &Challenge{
+			Name:    ChallengeSoftwareTokenMFA,
+			Session: session,
+			Email:   user.Email,
+		},
+	}, nil
+}
+
+// startSession records a pending MFA challenge for email
+func (p *LocalProvider) startSession(email string) (string, error) {
+	session, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.addSession(session, email)
+	return session, nil
+}
+
+func newSessionID() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate session: %w", err)
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// addSession records a challenge session for email. Callers hold p.mu.
+func (p *LocalProvider) addSession(session, email string) {
+	// Drop expired sessions while we hold the lock
+	now := time.Now()
+	for id, s := range p.sessions {
+		if now.After(s.expiresAt) {
+			delete(p.sessions, id)
+		}
+	}
+
+	p.sessions[session] = localSession{
+		email:     email,
+		expiresAt: now.Add(localChallengeTTL),
+	}
+}
+
+func (p *LocalProvider) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	claims, err := p.parse(refreshToken, "refresh")
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	user, ok := p.users[normalizeEmail(claims.Email)]
+	p.mu.Unlock()
+
+	// Tokens for deleted, recreated or disabled users are no longer valid
+	if !ok || user.ID != claims.Subject || user.Disabled {
+		return nil, ErrInvalidToken
+	}
+
+	return p.issueTokens(user)
+}
+
+func (p *LocalProvider) Verify(ctx context.Context, token string) (*models.User, error) {
+	claims, err := p.parse(token, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	user, ok := p.users[normalizeEmail(claims.Email)]
+	p.mu.Unlock()
+
+	// Like refresh tokens, ID tokens die with the account or when it's disabled
+	if !ok || user.ID != claims.Subject || user.Disabled {
+		return nil, ErrInvalidToken
+	}
+
+	return &models.User{
+		ID:    claims.Subject,
+		Email: claims.Email,
+		Roles: claims.Groups,
+	}, nil
+}
+
+func (p *LocalProvider) DisableUser(ctx context.Context, userID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, user := range p.users {
+		if user.ID == userID {
+			user.Disabled = true
+			return p.save()
+		}
+	}
+
+	return ErrUserNotFound
+}
+
+func (p *LocalProvider) DeleteUser(ctx context.Context, userID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for email, user := range p.users {
+		if user.ID == userID {
+			delete(p.users, email)
+			return p.save()
+		}
+	}
+
+	return ErrUserNotFound
+}
+
+func (p *LocalProvider) GetUser(ctx context.Context, userID string) (*models.User, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, user := range p.users {
+		if user.ID == userID {
+			return user.model(), nil
+		}
+	}
+
+	return nil, ErrUserNotFound
+}
+
+func (p *LocalProvider) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, ok := p.users[normalizeEmail(email)]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	return user.model(), nil
+}
+
+func (u *localUser) model() *models.User {
+	return &models.User{
+		ID:        u.ID,
+		Email:     u.Email,
+		Roles:     append([]string(nil), u.Roles...),
+		CreatedAt: u.CreatedAt,
+	}
+}
+
+// localClaims mirrors the subset of Cognito ID token claims the API relies on
+type localClaims struct {
+	Email    string   `json:"email,omitempty"`
+	TokenUse string   `json:"token_use"`
+	Groups   []string `json:"cognito:groups,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (p *LocalProvider) issueTokens(user *localUser) (*Tokens, error) {
+	now := time.Now()
+
+	idToken, err := p.sign(user, "id", now, localIDTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := p.sign(user, "access", now, localIDTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := p.sign(user, "refresh", now, localRefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		IDToken:      idToken,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(localIDTokenTTL.Seconds()),
+	}, nil
+}
+
+func (p *LocalProvider) sign(user *localUser, tokenUse string, now time.Time, ttl time.Duration) (string, error) {
+	claims := localClaims{
+		Email:    user.Email,
+		TokenUse: tokenUse,
+		Groups:   user.Roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    localIssuer,
+			Subject:   user.ID,
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.kid
+
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return signed, nil
+}
+
+func (p *LocalProvider) parse(token, tokenUse string) (*localClaims, error) {
+	parser := jwt.Parser{
+		ValidMethods: []string{"RS256"},
+	}
+
+	claims := &localClaims{}
+	_, err := parser.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if kid, _ := token.Header["kid"].(string); kid != p.kid {
+			return nil, errors.New("key not found")
+		}
+		return &p.key.PublicKey, nil
+	})
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, ErrExpiredToken
+		}
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Issuer != localIssuer || claims.TokenUse != tokenUse {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+func (p *LocalProvider) setKey(key *rsa.PrivateKey) {
+	p.key = key
+
+	// Derive a stable key ID from the public key
+	der := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+	sum := sha256.Sum256(der)
+	p.kid = hex.EncodeToString(sum[:8])
+}
+
+func (p *LocalProvider) load() error {
+	if p.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read local auth file: %w", err)
+	}
+
+	var state localState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse local auth file: %w", err)
+	}
+
+	if state.Users != nil {
+		p.users = state.Users
+	}
+
+	if state.SigningKey != "" {
+		block, _ := pem.Decode([]byte(state.SigningKey))
+		if block == nil {
+			return errors.New("failed to decode signing key")
+		}
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse signing key: %w", err)
+		}
+		p.setKey(key)
+	}
+
+	return nil
+}
+
+// save writes the provider state atomically; callers must hold p.mu or be
+// the constructor
+func (p *LocalProvider) save() error {
+	if p.path == "" {
+		return nil
+	}
+
+	state := localState{
+		SigningKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(p.key),
+		})),
+		Users: p.users,
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal local auth state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create local auth directory: %w", err)
+	}
+
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write local auth file: %w", err)
+	}
+	if err := os.Rename(tmp, p.path); err != nil {
+		return fmt.Errorf("failed to write local auth file: %w", err)
+	}
+
+	return nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
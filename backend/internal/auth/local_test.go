@@ -0,0 +1,91 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/celebthumb-ai/internal/auth"
+	"github.com/celebthumb-ai/internal/auth/authtest"
+)
+
+func TestLocalProvider(t *testing.T) {
+	provider, err := auth.NewLocalProvider(auth.LocalProviderConfig{
+		Path: filepath.Join(t.TempDir(), "auth.json"),
+	})
+	if err != nil {
+		t.Fatalf("NewLocalProvider: %v", err)
+	}
+
+	authtest.TestIdentityProvider(t, provider, authtest.Options{})
+}
+
+func TestLocalProviderAttemptCaps(t *testing.T) {
+	ctx := context.Background()
+	provider, err := auth.NewLocalProvider(auth.LocalProviderConfig{})
+	if err != nil {
+		t.Fatalf("NewLocalProvider: %v", err)
+	}
+
+	const email = "attempts@example.com"
+	if _, err := provider.Register(ctx, email, "attempts", authtest.ValidPassword); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	result, err := provider.Login(ctx, email, authtest.ValidPassword)
+	if err != nil || result.Tokens == nil {
+		t.Fatalf("Login: %+v, %v", result, err)
+	}
+	accessToken := result.AccessToken
+
+	// A code that can never match, so the test can't pass by luck
+	const wrong = "wrong!"
+
+	enrollment, err := provider.AssociateSoftwareToken(ctx, accessToken)
+	if err != nil {
+		t.Fatalf("AssociateSoftwareToken: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := provider.VerifySoftwareToken(ctx, accessToken, wrong); !errors.Is(err, auth.ErrInvalidMFACode) {
+			t.Fatalf("VerifySoftwareToken attempt %d error = %v, want %v", i+1, err, auth.ErrInvalidMFACode)
+		}
+	}
+	code, err := auth.TOTPCode(enrollment.SecretCode, time.Now())
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	if err := provider.VerifySoftwareToken(ctx, accessToken, code); !errors.Is(err, auth.ErrInvalidMFACode) {
+		t.Fatalf("VerifySoftwareToken after the cap error = %v, want %v", err, auth.ErrInvalidMFACode)
+	}
+
+	// Enrollment starts over with a new secret
+	enrollment, err = provider.AssociateSoftwareToken(ctx, accessToken)
+	if err != nil {
+		t.Fatalf("AssociateSoftwareToken again: %v", err)
+	}
+	code, err = auth.TOTPCode(enrollment.SecretCode, time.Now())
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	if err := provider.VerifySoftwareToken(ctx, accessToken, code); err != nil {
+		t.Fatalf("VerifySoftwareToken: %v", err)
+	}
+
+	result, err = provider.Login(ctx, email, authtest.ValidPassword)
+	if err != nil || result.Challenge == nil {
+		t.Fatalf("Login after enrollment: %+v, %v", result, err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := provider.RespondToChallenge(ctx, email, *result.Challenge, wrong); !errors.Is(err, auth.ErrInvalidMFACode) {
+			t.Fatalf("RespondToChallenge attempt %d error = %v, want %v", i+1, err, auth.ErrInvalidMFACode)
+		}
+	}
+	code, err = auth.TOTPCode(enrollment.SecretCode, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	if _, err := provider.RespondToChallenge(ctx, email, *result.Challenge, code); !errors.Is(err, auth.ErrInvalidSession) {
+		t.Errorf("RespondToChallenge after the cap error = %v, want %v", err, auth.ErrInvalidSession)
+	}
+}
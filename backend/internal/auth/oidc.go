@@ -0,0 +1,349 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrUnknownOIDCProvider    = errors.New("unknown OIDC provider")
+	ErrInvalidOIDCState       = errors.New("invalid OIDC state")
+	ErrFederationNotSupported = errors.New("identity provider does not support federated login")
+	// ErrAccountLinked is returned when a federated identity was just linked
+	// to an existing password account and the flow has to be started again to
+	// sign in as that account
+	ErrAccountLinked = errors.New("federated identity linked to existing account")
+	// ErrPasswordSignInRequired is returned by providers that can't challenge
+	// a federated sign-in for the account's TOTP code
+	ErrPasswordSignInRequired = errors.New("account has MFA enabled and must sign in with its password")
+)
+
+var defaultOIDCScopes = []string{"openid", "email", "profile"}
+
+type OIDCConfig struct {
+	// Name identifies the provider in routes, e.g. "google"
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// CognitoIdentityProvider is set when Issuer is our Cognito user pool and
+	// its hosted UI federates to this provider (e.g. "Google"). The code
+	// exchange then yields Cognito tokens, which are handed back as-is.
+	CognitoIdentityProvider string
+	HTTPClient              *http.Client
+}
+
+// OIDCClient runs the authorization code flow with PKCE against one provider
+type OIDCClient struct {
+	config     OIDCConfig
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keySet    *remoteKeySet
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IDToken      string `json:"id_token"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// FederatedLoginState is created when a flow starts and has to be presented
+// again on callback, typically through a short-lived cookie
+type FederatedLoginState struct {
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"codeVerifier"`
+}
+
+// FederatedIdentity is a user authenticated by an external OIDC provider
+type FederatedIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	// Tokens is set when the provider already issued our own token set, as
+	// Cognito does for its federated identity providers
+	Tokens *Tokens
+}
+
+// FederationProvider is implemented by identity providers that can sign in
+// users authenticated elsewhere, linking them to existing accounts by email.
+// The other provider's sign-in says nothing about the account's second
+// factor, so accounts with MFA enrolled get the same challenge as a password
+// login, or ErrPasswordSignInRequired where the provider can't issue one.
+type FederationProvider interface {
+	FederatedLogin(ctx context.Context, identity *FederatedIdentity) (*LoginResult, error)
+}
+
+func NewOIDCClient(config OIDCConfig) *OIDCClient {
+	if len(config.Scopes) == 0 {
+		config.Scopes = defaultOIDCScopes
+	}
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &OIDCClient{
+		config:     config,
+		httpClient: httpClient,
+	}
+}
+
+// OIDCConfigsFromEnv reads providers listed in OIDC_PROVIDERS. Each name
+// (e.g. "google") is configured through OIDC_GOOGLE_ISSUER,
+// OIDC_GOOGLE_CLIENT_ID, OIDC_GOOGLE_CLIENT_SECRET, OIDC_GOOGLE_REDIRECT_URL
+// and optionally OIDC_GOOGLE_COGNITO_IDP.
+func OIDCConfigsFromEnv() []OIDCConfig {
+	var configs []OIDCConfig
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		configs = append(configs, OIDCConfig{
+			Name:                    name,
+			Issuer:                  os.Getenv(prefix + "ISSUER"),
+			ClientID:                os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret:            os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:             os.Getenv(prefix + "REDIRECT_URL"),
+			CognitoIdentityProvider: os.Getenv(prefix + "COGNITO_IDP"),
+		})
+	}
+	return configs
+}
+
+func (c *OIDCClient) Name() string {
+	return c.config.Name
+}
+
+// newFederatedLoginState generates the state, nonce and PKCE verifier for a flow
+func (c *OIDCClient) newFederatedLoginState() (*FederatedLoginState, error) {
+	state, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return &FederatedLoginState{
+		Provider:     c.config.Name,
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	}, nil
+}
+
+// AuthCodeURL returns the provider URL the browser is sent to
+func (c *OIDCClient) AuthCodeURL(ctx context.Context, flow *FederatedLoginState) (string, error) {
+	discovery, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(flow.CodeVerifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.config.ClientID},
+		"redirect_uri":          {c.config.RedirectURL},
+		"scope":                 {strings.Join(c.config.Scopes, " ")},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if c.config.CognitoIdentityProvider != "" {
+		// Skip the hosted UI and go straight to the federated provider
+		query.Set("identity_provider", c.config.CognitoIdentityProvider)
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// exchange trades the authorization code for tokens
+func (c *OIDCClient) exchange(ctx context.Context, code, verifier string) (*oidcTokenResponse, error) {
+	discovery, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.config.RedirectURL},
+		"client_id":     {c.config.ClientID},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// The provider rejected the code or verifier
+		return nil, fmt.Errorf("%w: token endpoint returned %d", ErrInvalidCredentials, resp.StatusCode)
+	}
+
+	var tokens oidcTokenResponse
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return &tokens, nil
+}
+
+// verifyIDToken checks the ID token's signature, issuer, audience and nonce
+func (c *OIDCClient) verifyIDToken(ctx context.Context, rawToken, nonce string) (*FederatedIdentity, error) {
+	discovery, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := jwt.Parser{
+		ValidMethods: []string{"RS256"},
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = parser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("kid header not found")
+		}
+		return c.keySet.lookup(ctx, kid)
+	})
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !claims.VerifyIssuer(discovery.Issuer, true) || !claims.VerifyAudience(c.config.ClientID, true) {
+		return nil, ErrInvalidToken
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, ErrInvalidToken
+	}
+
+	identity := &FederatedIdentity{
+		Provider: c.config.Name,
+	}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+
+	// Some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+
+	if identity.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+
+	return identity, nil
+}
+
+func (c *OIDCClient) discover(ctx context.Context) (*oidcDiscovery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.discovery != nil {
+		return c.discovery, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(c.config.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build discovery request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: status %d", resp.StatusCode)
+	}
+
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("failed to parse OIDC discovery document: %w", err)
+	}
+
+	c.discovery = &discovery
+	c.keySet = newRemoteKeySet(discovery.JWKSURI)
+
+	return c.discovery, nil
+}
+
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
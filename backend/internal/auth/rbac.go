@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/models"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// Roles map to Cognito group names
+const (
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+)
+
+type Permission string
+
+const (
+	PermissionReadUsers      Permission = "users:read"
+	PermissionDisableUsers   Permission = "users:disable"
+	PermissionAdjustCredits  Permission = "credits:adjust"
+	PermissionChangePlan     Permission = "plans:change"
+	PermissionReadThumbnails Permission = "thumbnails:read"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermissionReadUsers,
+		PermissionDisableUsers,
+		PermissionAdjustCredits,
+		PermissionChangePlan,
+		PermissionReadThumbnails,
+	},
+	RoleSupport: {
+		PermissionReadUsers,
+		PermissionAdjustCredits,
+		PermissionReadThumbnails,
+	},
+}
+
+// HasRole reports whether user has been granted role
+func HasRole(user *models.User, role string) bool {
+	for _, r := range user.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize returns ErrForbidden unless one of the user's roles grants permission
+func Authorize(user *models.User, permission Permission) error {
+	if user == nil {
+		return ErrForbidden
+	}
+
+	for _, role := range user.Roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return nil
+			}
+		}
+	}
+
+	return ErrForbidden
+}
+
+// RoleStore supplies roles kept outside the identity provider
+type RoleStore interface {
+	Roles(ctx context.Context, userID string) ([]string, error)
+}
+
+// DynamoAPI is the part of the DynamoDB client DynamoRoleStore uses
+type DynamoAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+type DynamoRoleStoreConfig struct {
+	DynamoClient DynamoAPI
+	// TableName defaults to the users table, whose items carry a roles string set
+	TableName string
+}
+
+// DynamoRoleStore reads the roles attribute of a user's item
+type DynamoRoleStore struct {
+	dynamoClient DynamoAPI
+	tableName    string
+}
+
+func NewDynamoRoleStore(config DynamoRoleStoreConfig) *DynamoRoleStore {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("USERS_TABLE")
+	}
+
+	return &DynamoRoleStore{
+		dynamoClient: config.DynamoClient,
+		tableName:    tableName,
+	}
+}
+
+func (s *DynamoRoleStore) Roles(ctx context.Context, userID string) ([]string, error) {
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: userID},
+		},
+		ProjectionExpression: aws.String("#roles"),
+		ExpressionAttributeNames: map[string]string{
+			"#roles": "roles",
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get roles: %w", err)
+	}
+
+	var user models.User
+	if err := attributevalue.UnmarshalMap(resp.Item, &user); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal roles: %w", err)
+	}
+
+	return user.Roles, nil
+}
+
+// mergeRoles appends roles not already present
+func mergeRoles(existing, extra []string) []string {
+	for _, role := range extra {
+		found := false
+		for _, r := range existing {
+			if r == role {
+				found = true
+				break
+			}
+		}
+		if !found {
+			existing = append(existing, role)
+		}
+	}
+	return existing
+}
+
+// groupsClaim reads Cognito's group membership claim
+func groupsClaim(value interface{}) []string {
+	list, _ := value.([]interface{})
+	groups := make([]string, 0, len(list))
+	for _, item := range list {
+		if group, ok := item.(string); ok {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
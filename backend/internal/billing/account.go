@@ -0,0 +1,200 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/customer"
+	"github.com/stripe/stripe-go/v76/invoice"
+	"github.com/stripe/stripe-go/v76/subscription"
+)
+
+// Invoice is the part of a Stripe invoice included in a user's data export
+type Invoice struct {
+	ID          string    `json:"id"`
+	Number      string    `json:"number"`
+	Status      string    `json:"status"`
+	Total       int64     `json:"total"`
+	AmountPaid  int64     `json:"amountPaid"`
+	Currency    string    `json:"currency"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+	CreatedAt   time.Time `json:"createdAt"`
+	URL         string    `json:"url,omitempty"`
+}
+
+// stripeCustomers finds the Stripe customers created for a user. Customers are
+// tagged with the user id in their metadata when a subscription starts.
+func (s *BillingService) stripeCustomers(ctx context.Context, userID string) ([]*stripe.Customer, error) {
+	if s.stripeKey == "" {
+		return nil, nil
+	}
+
+	params := &stripe.CustomerSearchParams{}
+	params.Context = ctx
+	params.Query = fmt.Sprintf("metadata['userId']:'%s'", strings.ReplaceAll(userID, "'", ""))
+
+	var customers []*stripe.Customer
+	iter := customer.Search(params)
+	for iter.Next() {
+		customers = append(customers, iter.Customer())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find stripe customers: %w", err)
+	}
+
+	return customers, nil
+}
+
+// CancelBilling cancels the user's subscriptions straight away and deletes
+// their Stripe customers. Running it again once it has succeeded is a no-op.
+func (s *BillingService) CancelBilling(ctx context.Context, userID string) error {
+	customers, err := s.stripeCustomers(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range customers {
+		listParams := &stripe.SubscriptionListParams{Customer: stripe.String(c.ID)}
+		listParams.Context = ctx
+
+		iter := subscription.List(listParams)
+		for iter.Next() {
+			cancelParams := &stripe.SubscriptionCancelParams{}
+			cancelParams.Context = ctx
+			if _, err := subscription.Cancel(iter.Subscription().ID, cancelParams); err != nil {
+				return fmt.Errorf("failed to cancel subscription: %w", err)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return fmt.Errorf("failed to list subscriptions: %w", err)
+		}
+
+		delParams := &stripe.CustomerParams{}
+		delParams.Context = ctx
+		if _, err := customer.Del(c.ID, delParams); err != nil {
+			return fmt.Errorf("failed to delete stripe customer: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// BillingHistory lists the user's Stripe invoices, newest first
+func (s *BillingService) BillingHistory(ctx context.Context, userID string) ([]Invoice, error) {
+	customers, err := s.stripeCustomers(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	invoices := []Invoice{}
+	for _, c := range customers {
+		params := &stripe.InvoiceListParams{Customer: stripe.String(c.ID)}
+		params.Context = ctx
+
+		iter := invoice.List(params)
+		for iter.Next() {
+			inv := iter.Invoice()
+			invoices = append(invoices, Invoice{
+				ID:          inv.ID,
+				Number:      inv.Number,
+				Status:      string(inv.Status),
+				Total:       inv.Total,
+				AmountPaid:  inv.AmountPaid,
+				Currency:    string(inv.Currency),
+				PeriodStart: time.Unix(inv.PeriodStart, 0).UTC(),
+				PeriodEnd:   time.Unix(inv.PeriodEnd, 0).UTC(),
+				CreatedAt:   time.Unix(inv.Created, 0).UTC(),
+				URL:         inv.HostedInvoiceURL,
+			})
+		}
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("failed to list invoices: %w", err)
+		}
+	}
+
+	return invoices, nil
+}
+
+// ListLedger returns the manual credit adjustments made to a user's balance,
+// oldest first
+func (s *BillingService) ListLedger(ctx context.Context, userID string) ([]*LedgerEntry, error) {
+	entries := []*LedgerEntry{}
+	if s.ledgerTable == "" {
+		return entries, nil
+	}
+
+	paginator := dynamodb.NewQueryPaginator(s.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(s.ledgerTable),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query ledger: %w", err)
+		}
+
+		var items []*LedgerEntry
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal ledger entries: %w", err)
+		}
+		entries = append(entries, items...)
+	}
+
+	return entries, nil
+}
+
+// ScrubLedger removes the free-text reasons from a user's ledger entries. The
+// amounts are kept for accounting; the reasons may name the user.
+func (s *BillingService) ScrubLedger(ctx context.Context, userID string) error {
+	entries, err := s.ListLedger(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Reason == "" {
+			continue
+		}
+
+		_, err := s.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(s.ledgerTable),
+			Key: map[string]types.AttributeValue{
+				"userId": &types.AttributeValueMemberS{Value: entry.UserID},
+				"id":     &types.AttributeValueMemberS{Value: entry.ID},
+			},
+			UpdateExpression: aws.String("REMOVE reason"),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scrub ledger entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteUserRecord removes the user's billing record. Deleting a missing
+// record is not an error.
+func (s *BillingService) DeleteUserRecord(ctx context.Context, userID string) error {
+	_, err := s.dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	return nil
+}
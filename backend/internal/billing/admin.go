@@ -0,0 +1,178 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/google/uuid"
+)
+
+// LedgerEntry is a manual credit adjustment made by support staff
+type LedgerEntry struct {
+	UserID    string    `json:"userId" dynamodbav:"userId"`
+	ID        string    `json:"id" dynamodbav:"id"`
+	Delta     int       `json:"delta" dynamodbav:"delta"`
+	Reason    string    `json:"reason" dynamodbav:"reason"`
+	ActorID   string    `json:"actorId" dynamodbav:"actorId"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+// GetUser returns the billing record for a user
+func (s *BillingService) GetUser(ctx context.Context, userID string) (*models.User, error) {
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if resp.Item == nil {
+		return nil, ErrUserNotFound
+	}
+
+	var user models.User
+	if err := attributevalue.UnmarshalMap(resp.Item, &user); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// FindUserByEmail looks a user up through the byEmail index
+func (s *BillingService) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	resp, err := s.dynamoClient.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String("byEmail"),
+		KeyConditionExpression: aws.String("email = :email"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":email": &types.AttributeValueMemberS{Value: email},
+		},
+		Limit: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+
+	if len(resp.Items) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	var user models.User
+	if err := attributevalue.UnmarshalMap(resp.Items[0], &user); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// AdjustCredits adds delta (which may be negative) to the user's balance and
+// records the change in the credit ledger. It returns the new balance.
+func (s *BillingService) AdjustCredits(ctx context.Context, userID string, delta int, reason, actorID string) (int, error) {
+	update := &types.Update{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: userID},
+		},
+		UpdateExpression:    aws.String("SET credits = if_not_exists(credits, :zero) + :delta"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":delta": &types.AttributeValueMemberN{Value: strconv.Itoa(delta)},
+			":zero":  &types.AttributeValueMemberN{Value: "0"},
+		},
+	}
+	if delta < 0 {
+		// Never take a balance below zero
+		update.ConditionExpression = aws.String("attribute_exists(id) AND credits >= :needed")
+		update.ExpressionAttributeValues[":needed"] = &types.AttributeValueMemberN{Value: strconv.Itoa(-delta)}
+	}
+
+	items := []types.TransactWriteItem{{Update: update}}
+
+	if s.ledgerTable != "" {
+		entry, err := attributevalue.MarshalMap(LedgerEntry{
+			UserID:    userID,
+			ID:        fmt.Sprintf("%s#%s", time.Now().UTC().Format(time.RFC3339Nano), uuid.New().String()),
+			Delta:     delta,
+			Reason:    reason,
+			ActorID:   actorID,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal ledger entry: %w", err)
+		}
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName: aws.String(s.ledgerTable),
+				Item:      entry,
+			},
+		})
+	}
+
+	_, err := s.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+			aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+			if _, getErr := s.GetUser(ctx, userID); errors.Is(getErr, ErrUserNotFound) {
+				return 0, ErrUserNotFound
+			}
+			return 0, ErrInsufficientCredits
+		}
+		return 0, fmt.Errorf("failed to adjust credits: %w", err)
+	}
+
+	return s.GetUserCredits(ctx, userID)
+}
+
+// ChangePlan moves the user to planID and resets their credits to the plan's
+// allowance without touching Stripe, e.g. for comped accounts
+func (s *BillingService) ChangePlan(ctx context.Context, userID, planID string) (*models.User, error) {
+	plan, ok := Plans[planID]
+	if !ok {
+		return nil, ErrInvalidPlan
+	}
+
+	resp, err := s.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: userID},
+		},
+		UpdateExpression:    aws.String("SET #plan = :plan, credits = :credits"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeNames: map[string]string{
+			"#plan": "plan",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":plan":    &types.AttributeValueMemberS{Value: planID},
+			":credits": &types.AttributeValueMemberN{Value: strconv.Itoa(plan.Credits)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to change plan: %w", err)
+	}
+
+	var user models.User
+	if err := attributevalue.UnmarshalMap(resp.Attributes, &user); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+
+	return &user, nil
+}
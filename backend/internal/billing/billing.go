@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -19,23 +21,37 @@ import (
 var (
 	ErrInsufficientCredits = errors.New("insufficient credits")
 	ErrInvalidPlan        = errors.New("invalid subscription plan")
+	ErrUserNotFound       = errors.New("user not found")
 )
 
 type Plan struct {
-	ID            string
-	Name          string
-	PriceID       string
-	Credits       int
-	PricePerMonth float64
-	Features      []string
+	ID                string
+	Name              string
+	PriceID           string
+	Credits           int
+	PricePerMonth     float64
+	RequestsPerMinute int
+	BurstRequests     int
+	// RevisionRetention is how many revisions of each thumbnail are kept
+	RevisionRetention int
+	// StorageBytes and StorageObjects cap what the user may store, counting
+	// every rendition and kept revision
+	StorageBytes   int64
+	StorageObjects int
+	Features       []string
 }
 
 var Plans = map[string]Plan{
 	"free": {
-		ID:            "free",
-		Name:          "Free Tier",
-		Credits:       10,
-		PricePerMonth: 0,
+		ID:                "free",
+		Name:              "Free Tier",
+		Credits:           10,
+		PricePerMonth:     0,
+		RequestsPerMinute: 30,
+		BurstRequests:     10,
+		RevisionRetention: 3,
+		StorageBytes:      100 << 20,
+		StorageObjects:    1000,
 		Features: []string{
 			"10 thumbnails per month",
 			"Basic styles",
@@ -43,11 +59,16 @@ var Plans = map[string]Plan{
 		},
 	},
 	"pro": {
-		ID:            "pro",
-		Name:          "Pro",
-		PriceID:       "price_pro",
-		Credits:       100,
-		PricePerMonth: 29.99,
+		ID:                "pro",
+		Name:              "Pro",
+		PriceID:           "price_pro",
+		Credits:           100,
+		PricePerMonth:     29.99,
+		RequestsPerMinute: 120,
+		BurstRequests:     30,
+		RevisionRetention: 20,
+		StorageBytes:      10 << 30,
+		StorageObjects:    50000,
 		Features: []string{
 			"100 thumbnails per month",
 			"Advanced styles",
@@ -56,11 +77,16 @@ var Plans = map[string]Plan{
 		},
 	},
 	"enterprise": {
-		ID:            "enterprise",
-		Name:          "Enterprise",
-		PriceID:       "price_enterprise",
-		Credits:       1000,
-		PricePerMonth: 199.99,
+		ID:                "enterprise",
+		Name:              "Enterprise",
+		PriceID:           "price_enterprise",
+		Credits:           1000,
+		PricePerMonth:     199.99,
+		RequestsPerMinute: 600,
+		BurstRequests:     100,
+		RevisionRetention: 100,
+		StorageBytes:      200 << 30,
+		StorageObjects:    1000000,
 		Features: []string{
 			"1000 thumbnails per month",
 			"Custom styles",
@@ -71,26 +97,44 @@ var Plans = map[string]Plan{
 	},
 }
 
+// DynamoAPI is the part of the DynamoDB client BillingService uses
+type DynamoAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
 type BillingConfig struct {
-	DynamoClient *dynamodb.Client
+	DynamoClient DynamoAPI
 	TableName    string
 	StripeKey    string
+	// LedgerTable records every manual credit adjustment
+	LedgerTable string
 }
 
 type BillingService struct {
-	dynamoClient *dynamodb.Client
+	dynamoClient DynamoAPI
 	tableName    string
 	stripeKey    string
+	ledgerTable  string
 }
 
 func NewBillingService(config BillingConfig) *BillingService {
 	// Initialize Stripe
 	stripe.Key = config.StripeKey
 	
+	ledgerTable := config.LedgerTable
+	if ledgerTable == "" {
+		ledgerTable = os.Getenv("CREDIT_LEDGER_TABLE")
+	}
+
 	return &BillingService{
 		dynamoClient: config.DynamoClient,
 		tableName:    config.TableName,
 		stripeKey:    config.StripeKey,
+		ledgerTable:  ledgerTable,
 	}
 }
 
@@ -120,6 +164,46 @@ func (s *BillingService) GetUserCredits(ctx context.Context, userID string) (int
 	return user.Credits, nil
 }
 
+// GetUserPlan returns the plan the user is subscribed to, defaulting to free
+func (s *BillingService) GetUserPlan(ctx context.Context, userID string) (Plan, error) {
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: userID},
+		},
+		ProjectionExpression: aws.String("#plan"),
+		ExpressionAttributeNames: map[string]string{
+			"#plan": "plan",
+		},
+	})
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	var user models.User
+	if err := attributevalue.UnmarshalMap(resp.Item, &user); err != nil {
+		return Plan{}, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+
+	plan, ok := Plans[user.Plan]
+	if !ok {
+		return Plans["free"], nil
+	}
+
+	return plan, nil
+}
+
+// StorageQuota returns the storage limits of the user's plan. It implements
+// storage.QuotaPolicy.
+func (s *BillingService) StorageQuota(ctx context.Context, userID string) (models.Quota, error) {
+	plan, err := s.GetUserPlan(ctx, userID)
+	if err != nil {
+		return models.Quota{}, err
+	}
+
+	return models.Quota{Bytes: plan.StorageBytes, Objects: plan.StorageObjects}, nil
+}
+
 func (s *BillingService) DeductCredits(ctx context.Context, userID string, amount int) error {
 	// Get current credits
 	credits, err := s.GetUserCredits(ctx, userID)
@@ -129,7 +213,7 @@ func (s *BillingService) DeductCredits(ctx context.Context, userID string, amoun
 
 	// Check if user has enough credits
 	if credits < amount {
-		return fmt.Errorf("insufficient credits")
+		return ErrInsufficientCredits
 	}
 
 	// Update credits in DynamoDB
@@ -145,6 +229,11 @@ func (s *BillingService) DeductCredits(ctx context.Context, userID string, amoun
 		ConditionExpression: aws.String("credits >= :amount"),
 	})
 	if err != nil {
+		// Another request spent the credits since they were read
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return ErrInsufficientCredits
+		}
 		return fmt.Errorf("failed to deduct credits: %w", err)
 	}
 
@@ -208,21 +297,23 @@ func (s *BillingService) CreateSubscription(ctx context.Context, user *models.Us
 		}
 	}
 
-	// Update user in DynamoDB
-	item, err := attributevalue.MarshalMap(models.User{
-		ID:        user.ID,
-		Email:     user.Email,
-		Plan:      planID,
-		Credits:   plan.Credits,
-		CreatedAt: time.Now(),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to marshal user: %w", err)
-	}
-
-	_, err = s.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+	// Only touch the billing attributes; the item also carries the user's
+	// roles and organization membership. The first subscription creates it.
+	_, err = s.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		TableName: aws.String(s.tableName),
-		Item:      item,
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: user.ID},
+		},
+		UpdateExpression: aws.String("SET #plan = :plan, credits = :credits, email = :email, createdAt = if_not_exists(createdAt, :now)"),
+		ExpressionAttributeNames: map[string]string{
+			"#plan": "plan",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":plan":    &types.AttributeValueMemberS{Value: planID},
+			":credits": &types.AttributeValueMemberN{Value: strconv.Itoa(plan.Credits)},
+			":email":   &types.AttributeValueMemberS{Value: user.Email},
+			":now":     &types.AttributeValueMemberS{Value: time.Now().Format(time.RFC3339Nano)},
+		},
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create subscription: %w", err)
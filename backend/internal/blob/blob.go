@@ -0,0 +1,222 @@
+// Package blob abstracts the object storage behind thumbnails and uploads so
+// the API can run on S3 (or MinIO) in the cloud and on the local filesystem in
+// development.
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("object not found")
+	ErrInvalidKey   = errors.New("invalid object key")
+	ErrInvalidRange = errors.New("range not satisfiable")
+)
+
+// Store is a flat key/value object store. Keys are slash-separated paths
+// without a leading slash.
+type Store interface {
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (*ObjectInfo, error)
+	// NewWriter streams an object too large to buffer. Nothing is visible
+	// under key until the writer is committed.
+	NewWriter(ctx context.Context, key string, opts PutOptions) (Writer, error)
+	// Get opens an object for streaming. See GetOptions for conditional and
+	// ranged reads.
+	Get(ctx context.Context, key string, opts GetOptions) (*Object, error)
+	Head(ctx context.Context, key string) (*ObjectInfo, error)
+	// List returns objects under prefix in lexicographic key order
+	List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error)
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (*PresignedRequest, error)
+	PresignPut(ctx context.Context, key string, opts PutOptions, expiry time.Duration) (*PresignedRequest, error)
+}
+
+// Writer is a streamed write. Exactly one of Commit or Abort must be called.
+type Writer interface {
+	io.Writer
+	// Commit finishes the object and makes it visible
+	Commit() (*ObjectInfo, error)
+	// Abort discards everything written
+	Abort() error
+}
+
+type PutOptions struct {
+	ContentType string
+	// ContentLength is required by presigned PUTs, which sign it
+	ContentLength int64
+	Metadata      map[string]string
+}
+
+type GetOptions struct {
+	// IfNoneMatch makes the read return NotModified when it matches the ETag
+	IfNoneMatch string
+	// Range is an HTTP Range header. Only a single bytes range is honoured;
+	// anything else reads the whole object.
+	Range string
+}
+
+type ListOptions struct {
+	// MaxKeys defaults to and is capped at 1000
+	MaxKeys int
+	// Token continues a previous listing
+	Token string
+}
+
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ETag        string
+	// Checksum is the base64 SHA-256 of the content, when known
+	Checksum     string
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// Object is an open read. Body is nil when NotModified is set; otherwise the
+// caller must close it.
+type Object struct {
+	ObjectInfo
+	Body io.ReadCloser
+	// ContentLength is the length of Body, which is less than Size for a
+	// ranged read
+	ContentLength int64
+	ContentRange  string
+	Partial       bool
+	NotModified   bool
+}
+
+type ListResult struct {
+	Objects []ObjectInfo
+	// NextToken is empty on the last page
+	NextToken string
+}
+
+// PresignedRequest is a time-limited request the client performs itself.
+// Headers must be sent exactly as given.
+type PresignedRequest struct {
+	URL       string
+	Method    string
+	Headers   map[string]string
+	ExpiresAt time.Time
+}
+
+const maxListKeys = 1000
+
+func listLimit(maxKeys int) int {
+	if maxKeys <= 0 || maxKeys > maxListKeys {
+		return maxListKeys
+	}
+	return maxKeys
+}
+
+// IsSingleByteRange reports whether header is a single "bytes=" range
+func IsSingleByteRange(header string) bool {
+	return strings.HasPrefix(header, "bytes=") && !strings.Contains(header, ",")
+}
+
+// validKey rejects keys that could escape a filesystem root or that S3 and the
+// filesystem would treat differently
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func checksumOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func bytesReader(data []byte) io.ReadSeeker {
+	return bytes.NewReader(data)
+}
+
+// sizeFromContentRange reads the complete length from "bytes a-b/size"
+func sizeFromContentRange(contentRange string) int64 {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return 0
+	}
+	size, _ := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	return size
+}
+
+// parseRange resolves a single "bytes=" range against an object of size bytes
+// into an inclusive start and end. ok is false when the header should be
+// ignored; ErrInvalidRange means it can't be satisfied.
+func parseRange(header string, size int64) (start, end int64, ok bool, err error) {
+	if !IsSingleByteRange(header) {
+		return 0, 0, false, nil
+	}
+
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	switch {
+	case first == "":
+		// Suffix range: the last n bytes
+		n, parseErr := strconv.ParseInt(last, 10, 64)
+		if parseErr != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, ErrInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, nil
+	default:
+		start, parseErr := strconv.ParseInt(first, 10, 64)
+		if parseErr != nil || start < 0 {
+			return 0, 0, false, nil
+		}
+		end := size - 1
+		if last != "" {
+			end, parseErr = strconv.ParseInt(last, 10, 64)
+			if parseErr != nil || end < start {
+				return 0, 0, false, nil
+			}
+		}
+		if start >= size {
+			return 0, 0, false, ErrInvalidRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+		return start, end, true, nil
+	}
+}
+
+// etagMatches implements the weak comparison If-None-Match uses
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,371 @@
+// Package blobtest provides a conformance test suite that every blob.Store
+// implementation must pass.
+package blobtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/google/uuid"
+)
+
+// TestStore runs the conformance suite against store. Every run works under a
+// fresh prefix, so it is safe to point at a shared bucket. Presigned URLs are
+// fetched with http.DefaultClient, so the store's URLs must be reachable.
+func TestStore(t *testing.T, store blob.Store) {
+	ctx := context.Background()
+	prefix := fmt.Sprintf("conformance/%s/", uuid.New().String())
+	t.Cleanup(func() {
+		cleanup(ctx, store, prefix)
+	})
+
+	content := []byte("0123456789abcdefghij")
+	key := prefix + "object.jpg"
+
+	info, err := store.Put(ctx, key, bytes.NewReader(content), blob.PutOptions{
+		ContentType: "image/jpeg",
+		Metadata:    map[string]string{"owner": "conformance"},
+	})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if info.Size != int64(len(content)) {
+		t.Errorf("Put size = %d, want %d", info.Size, len(content))
+	}
+	if info.ETag == "" {
+		t.Error("Put returned no ETag")
+	}
+	if want := checksum(content); info.Checksum != want {
+		t.Errorf("Put checksum = %q, want %q", info.Checksum, want)
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		object, err := store.Get(ctx, key, blob.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		data := readAll(t, object)
+		if !bytes.Equal(data, content) {
+			t.Errorf("Get body = %q, want %q", data, content)
+		}
+		if object.ContentType != "image/jpeg" {
+			t.Errorf("Get content type = %q, want image/jpeg", object.ContentType)
+		}
+		if object.ETag != info.ETag {
+			t.Errorf("Get ETag = %q, want %q", object.ETag, info.ETag)
+		}
+		if object.Size != int64(len(content)) || object.ContentLength != int64(len(content)) {
+			t.Errorf("Get size = %d/%d, want %d", object.Size, object.ContentLength, len(content))
+		}
+		if object.Checksum != "" && object.Checksum != checksum(content) {
+			t.Errorf("Get checksum = %q, want %q", object.Checksum, checksum(content))
+		}
+		if object.Metadata["owner"] != "conformance" {
+			t.Errorf("Get metadata = %v, want owner=conformance", object.Metadata)
+		}
+	})
+
+	t.Run("GetMissing", func(t *testing.T) {
+		_, err := store.Get(ctx, prefix+"missing.jpg", blob.GetOptions{})
+		if !errors.Is(err, blob.ErrNotFound) {
+			t.Errorf("Get missing error = %v, want %v", err, blob.ErrNotFound)
+		}
+	})
+
+	t.Run("Head", func(t *testing.T) {
+		head, err := store.Head(ctx, key)
+		if err != nil {
+			t.Fatalf("Head: %v", err)
+		}
+		if head.Size != int64(len(content)) || head.ETag != info.ETag || head.ContentType != "image/jpeg" {
+			t.Errorf("Head = %+v, want size %d, ETag %s, image/jpeg", head, len(content), info.ETag)
+		}
+
+		_, err = store.Head(ctx, prefix+"missing.jpg")
+		if !errors.Is(err, blob.ErrNotFound) {
+			t.Errorf("Head missing error = %v, want %v", err, blob.ErrNotFound)
+		}
+	})
+
+	t.Run("IfNoneMatch", func(t *testing.T) {
+		object, err := store.Get(ctx, key, blob.GetOptions{IfNoneMatch: info.ETag})
+		if err != nil {
+			t.Fatalf("Get If-None-Match: %v", err)
+		}
+		if !object.NotModified {
+			t.Error("Get with matching If-None-Match was not NotModified")
+		}
+
+		object, err = store.Get(ctx, key, blob.GetOptions{IfNoneMatch: `"stale"`})
+		if err != nil {
+			t.Fatalf("Get stale If-None-Match: %v", err)
+		}
+		if object.NotModified {
+			t.Error("Get with stale If-None-Match was NotModified")
+		}
+		readAll(t, object)
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		for header, want := range map[string]string{
+			"bytes=2-5":   "2345",
+			"bytes=15-":   "fghij",
+			"bytes=-3":    "hij",
+			"bytes=18-99": "ij",
+		} {
+			object, err := store.Get(ctx, key, blob.GetOptions{Range: header})
+			if err != nil {
+				t.Fatalf("Get %s: %v", header, err)
+			}
+			if !object.Partial || object.ContentRange == "" {
+				t.Errorf("Get %s was not partial", header)
+			}
+			if object.Size != int64(len(content)) {
+				t.Errorf("Get %s size = %d, want %d", header, object.Size, len(content))
+			}
+			if data := readAll(t, object); string(data) != want {
+				t.Errorf("Get %s body = %q, want %q", header, data, want)
+			}
+		}
+
+		_, err := store.Get(ctx, key, blob.GetOptions{Range: "bytes=100-"})
+		if !errors.Is(err, blob.ErrInvalidRange) {
+			t.Errorf("Get unsatisfiable range error = %v, want %v", err, blob.ErrInvalidRange)
+		}
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		for _, bad := range []string{"", "/absolute", "../escape", prefix + "a/../b", prefix + "dir/"} {
+			_, err := store.Put(ctx, bad, bytes.NewReader(content), blob.PutOptions{})
+			if !errors.Is(err, blob.ErrInvalidKey) {
+				t.Errorf("Put %q error = %v, want %v", bad, err, blob.ErrInvalidKey)
+			}
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		listPrefix := prefix + "list/"
+		var want []string
+		for i := 0; i < 5; i++ {
+			k := fmt.Sprintf("%sitem-%d", listPrefix, i)
+			if _, err := store.Put(ctx, k, bytes.NewReader([]byte{byte(i)}), blob.PutOptions{}); err != nil {
+				t.Fatalf("Put %s: %v", k, err)
+			}
+			want = append(want, k)
+		}
+
+		var got []string
+		opts := blob.ListOptions{MaxKeys: 2}
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatal("List didn't terminate")
+			}
+			result, err := store.List(ctx, listPrefix, opts)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if len(result.Objects) > 2 {
+				t.Errorf("List returned %d objects, want at most 2", len(result.Objects))
+			}
+			for _, obj := range result.Objects {
+				got = append(got, obj.Key)
+			}
+			if result.NextToken == "" {
+				break
+			}
+			opts.Token = result.NextToken
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("List keys = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		deleteKey := prefix + "delete.jpg"
+		if _, err := store.Put(ctx, deleteKey, bytes.NewReader(content), blob.PutOptions{}); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if err := store.Delete(ctx, deleteKey); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := store.Head(ctx, deleteKey); !errors.Is(err, blob.ErrNotFound) {
+			t.Errorf("Head after Delete error = %v, want %v", err, blob.ErrNotFound)
+		}
+		if err := store.Delete(ctx, deleteKey); err != nil {
+			t.Errorf("Delete missing object: %v", err)
+		}
+	})
+
+	t.Run("Writer", func(t *testing.T) {
+		writerKey := prefix + "streamed.zip"
+		w, err := store.NewWriter(ctx, writerKey, blob.PutOptions{ContentType: "application/zip"})
+		if err != nil {
+			t.Fatalf("NewWriter: %v", err)
+		}
+		for _, chunk := range [][]byte{content, content, content} {
+			if _, err := w.Write(chunk); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+		}
+		if _, err := store.Head(ctx, writerKey); !errors.Is(err, blob.ErrNotFound) {
+			t.Errorf("Head before Commit error = %v, want %v", err, blob.ErrNotFound)
+		}
+
+		info, err := w.Commit()
+		if err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+		want := bytes.Repeat(content, 3)
+		if info.Size != int64(len(want)) || info.Checksum != checksum(want) {
+			t.Errorf("Commit info = %d/%q, want %d/%q", info.Size, info.Checksum, len(want), checksum(want))
+		}
+
+		object, err := store.Get(ctx, writerKey, blob.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if data := readAll(t, object); !bytes.Equal(data, want) {
+			t.Errorf("Get body = %q, want %q", data, want)
+		}
+		if object.ContentType != "application/zip" {
+			t.Errorf("Get content type = %q, want application/zip", object.ContentType)
+		}
+	})
+
+	t.Run("WriterAbort", func(t *testing.T) {
+		abortKey := prefix + "aborted.zip"
+		w, err := store.NewWriter(ctx, abortKey, blob.PutOptions{})
+		if err != nil {
+			t.Fatalf("NewWriter: %v", err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := w.Abort(); err != nil {
+			t.Fatalf("Abort: %v", err)
+		}
+		if _, err := store.Head(ctx, abortKey); !errors.Is(err, blob.ErrNotFound) {
+			t.Errorf("Head after Abort error = %v, want %v", err, blob.ErrNotFound)
+		}
+	})
+
+	t.Run("PresignGet", func(t *testing.T) {
+		req, err := store.PresignGet(ctx, key, time.Minute)
+		if err != nil {
+			t.Fatalf("PresignGet: %v", err)
+		}
+		if req.ExpiresAt.Before(time.Now()) {
+			t.Errorf("PresignGet expiry %v is in the past", req.ExpiresAt)
+		}
+
+		resp := do(t, req, nil)
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || !bytes.Equal(data, content) {
+			t.Errorf("presigned GET = %d %q, want 200 %q", resp.StatusCode, data, content)
+		}
+	})
+
+	t.Run("PresignPut", func(t *testing.T) {
+		uploadKey := prefix + "upload.png"
+		upload := []byte("uploaded image")
+		req, err := store.PresignPut(ctx, uploadKey, blob.PutOptions{
+			ContentType:   "image/png",
+			ContentLength: int64(len(upload)),
+		}, time.Minute)
+		if err != nil {
+			t.Fatalf("PresignPut: %v", err)
+		}
+
+		// A different content type than was signed must be rejected
+		tampered := *req
+		tampered.Headers = map[string]string{"Content-Type": "text/html"}
+		resp := do(t, &tampered, upload)
+		resp.Body.Close()
+		if resp.StatusCode < 400 {
+			t.Errorf("presigned PUT with wrong content type = %d, want an error", resp.StatusCode)
+		}
+
+		// So must a body longer than was signed
+		resp = do(t, req, append(upload, " and more"...))
+		resp.Body.Close()
+		if resp.StatusCode < 400 {
+			t.Errorf("presigned PUT with a longer body = %d, want an error", resp.StatusCode)
+		}
+
+		resp = do(t, req, upload)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("presigned PUT = %d, want 200", resp.StatusCode)
+		}
+
+		object, err := store.Get(ctx, uploadKey, blob.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get uploaded object: %v", err)
+		}
+		if data := readAll(t, object); !bytes.Equal(data, upload) || object.ContentType != "image/png" {
+			t.Errorf("uploaded object = %q (%s), want %q (image/png)", data, object.ContentType, upload)
+		}
+	})
+}
+
+func do(t *testing.T, req *blob.PresignedRequest, body []byte) *http.Response {
+	t.Helper()
+
+	httpReq, err := http.NewRequest(req.Method, req.URL, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("building presigned request: %v", err)
+	}
+	for name, value := range req.Headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatalf("presigned %s: %v", req.Method, err)
+	}
+	return resp
+}
+
+func readAll(t *testing.T, object *blob.Object) []byte {
+	t.Helper()
+	defer object.Body.Close()
+
+	data, err := io.ReadAll(object.Body)
+	if err != nil {
+		t.Fatalf("reading object: %v", err)
+	}
+	return data
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func cleanup(ctx context.Context, store blob.Store, prefix string) {
+	opts := blob.ListOptions{}
+	for {
+		result, err := store.List(ctx, prefix, opts)
+		if err != nil {
+			return
+		}
+		for _, obj := range result.Objects {
+			store.Delete(ctx, obj.Key)
+		}
+		if result.NextToken == "" {
+			return
+		}
+		opts.Token = result.NextToken
+	}
+}
@@ -0,0 +1,588 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSignature = errors.New("invalid or expired signature")
+
+type FSStoreConfig struct {
+	// Root is the directory objects are stored under
+	Root string
+	// BaseURL is where Handler is served, e.g. http://localhost:8080/blob
+	BaseURL string
+	// SigningKey signs presigned URLs. A random key is generated when empty,
+	// which invalidates outstanding URLs on restart.
+	SigningKey []byte
+	Now        func() time.Time
+}
+
+// FSStore keeps objects as files for local development. Presigned URLs are
+// HMAC-signed and served by Handler.
+type FSStore struct {
+	root       string
+	baseURL    *url.URL
+	signingKey []byte
+	now        func() time.Time
+}
+
+// fsMetadata is stored next to each object since files have no content type
+type fsMetadata struct {
+	ContentType string            `json:"contentType"`
+	Checksum    string            `json:"checksum"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func NewFSStore(config FSStoreConfig) (*FSStore, error) {
+	root := config.Root
+	if root == "" {
+		root = os.Getenv("BLOB_ROOT")
+	}
+	if root == "" {
+		return nil, errors.New("blob root directory is required")
+	}
+
+	rawURL := config.BaseURL
+	if rawURL == "" {
+		rawURL = os.Getenv("BLOB_BASE_URL")
+	}
+	baseURL, err := url.Parse(strings.TrimSuffix(rawURL, "/"))
+	if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid blob base URL %q", rawURL)
+	}
+
+	signingKey := config.SigningKey
+	if len(signingKey) == 0 {
+		signingKey = []byte(os.Getenv("BLOB_SIGNING_KEY"))
+	}
+	if len(signingKey) == 0 {
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+	}
+
+	now := config.Now
+	if now == nil {
+		now = time.Now
+	}
+
+	for _, dir := range []string{"objects", "meta", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create blob directory: %w", err)
+		}
+	}
+
+	return &FSStore{
+		root:       root,
+		baseURL:    baseURL,
+		signingKey: signingKey,
+		now:        now,
+	}, nil
+}
+
+func (s *FSStore) objectPath(key string) string {
+	return filepath.Join(s.root, "objects", filepath.FromSlash(key))
+}
+
+func (s *FSStore) metaPath(key string) string {
+	return filepath.Join(s.root, "meta", filepath.FromSlash(key)+".json")
+}
+
+func (s *FSStore) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (*ObjectInfo, error) {
+	w, err := s.NewWriter(ctx, key, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(w, body); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("failed to write object: %w", err)
+	}
+
+	return w.Commit()
+}
+
+// NewWriter writes to a temporary file that is renamed into place on commit,
+// so readers never see a partial object
+func (s *FSStore) NewWriter(ctx context.Context, key string, opts PutOptions) (Writer, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.root, "tmp"), "put-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object: %w", err)
+	}
+
+	return &fsWriter{
+		store: s,
+		key:   key,
+		opts:  opts,
+		file:  tmp,
+		hash:  sha256.New(),
+	}, nil
+}
+
+type fsWriter struct {
+	store *FSStore
+	key   string
+	opts  PutOptions
+	file  *os.File
+	hash  hash.Hash
+	size  int64
+}
+
+func (w *fsWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *fsWriter) Abort() error {
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
+
+func (w *fsWriter) Commit() (*ObjectInfo, error) {
+	s, key := w.store, w.key
+	defer os.Remove(w.file.Name())
+
+	if err := w.file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write object: %w", err)
+	}
+
+	meta := fsMetadata{
+		ContentType: w.opts.ContentType,
+		Checksum:    base64.StdEncoding.EncodeToString(w.hash.Sum(nil)),
+		Metadata:    w.opts.Metadata,
+	}
+	if meta.ContentType == "" {
+		meta.ContentType = "application/octet-stream"
+	}
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal object metadata: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.objectPath(key)), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create object directory: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.metaPath(key)), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create object directory: %w", err)
+	}
+	if err := writeFileAtomic(s.metaPath(key), metaData); err != nil {
+		return nil, fmt.Errorf("failed to write object metadata: %w", err)
+	}
+	if err := os.Rename(w.file.Name(), s.objectPath(key)); err != nil {
+		return nil, fmt.Errorf("failed to write object: %w", err)
+	}
+
+	info, err := os.Stat(s.objectPath(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	return s.objectInfo(key, w.size, info.ModTime(), meta), nil
+}
+
+func (s *FSStore) Get(ctx context.Context, key string, opts GetOptions) (*Object, error) {
+	info, err := s.Head(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if etagMatches(opts.IfNoneMatch, info.ETag) {
+		return &Object{ObjectInfo: *info, NotModified: true}, nil
+	}
+
+	start, end, partial, err := parseRange(opts.Range, info.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(s.objectPath(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to open object: %w", err)
+	}
+
+	object := &Object{
+		ObjectInfo:    *info,
+		Body:          file,
+		ContentLength: info.Size,
+	}
+	if partial {
+		if _, err := file.Seek(start, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to seek object: %w", err)
+		}
+		object.Body = readCloser{io.LimitReader(file, end-start+1), file}
+		object.ContentLength = end - start + 1
+		object.ContentRange = fmt.Sprintf("bytes %d-%d/%d", start, end, info.Size)
+		object.Partial = true
+	}
+
+	return object, nil
+}
+
+func (s *FSStore) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	info, err := os.Stat(s.objectPath(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+	if info.IsDir() {
+		return nil, ErrNotFound
+	}
+
+	var meta fsMetadata
+	data, err := os.ReadFile(s.metaPath(key))
+	if err == nil {
+		err = json.Unmarshal(data, &meta)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object metadata: %w", err)
+	}
+
+	return s.objectInfo(key, info.Size(), info.ModTime(), meta), nil
+}
+
+func (s *FSStore) objectInfo(key string, size int64, modTime time.Time, meta fsMetadata) *ObjectInfo {
+	// The checksum is the content hash, which makes a good strong ETag
+	sum, _ := base64.StdEncoding.DecodeString(meta.Checksum)
+	return &ObjectInfo{
+		Key:          key,
+		Size:         size,
+		ContentType:  meta.ContentType,
+		ETag:         `"` + hex.EncodeToString(sum) + `"`,
+		Checksum:     meta.Checksum,
+		LastModified: modTime.UTC(),
+		Metadata:     meta.Metadata,
+	}
+}
+
+// List walks the whole tree, which is fine for the object counts of a
+// development machine. The token is the last key of the previous page.
+func (s *FSStore) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	after := ""
+	if opts.Token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(opts.Token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid list token: %w", err)
+		}
+		after = string(decoded)
+	}
+
+	objectsDir := filepath.Join(s.root, "objects")
+	var keys []string
+	err := filepath.WalkDir(objectsDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(objectsDir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	sort.Strings(keys)
+
+	limit := listLimit(opts.MaxKeys)
+	result := &ListResult{Objects: []ObjectInfo{}}
+	if len(keys) > limit {
+		keys = keys[:limit]
+		result.NextToken = base64.RawURLEncoding.EncodeToString([]byte(keys[limit-1]))
+	}
+
+	for _, key := range keys {
+		info, err := s.Head(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted while listing
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Objects = append(result.Objects, *info)
+	}
+
+	return result, nil
+}
+
+func (s *FSStore) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	for _, path := range []string{s.objectPath(key), s.metaPath(key)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete object: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *FSStore) PresignGet(ctx context.Context, key string, expiry time.Duration) (*PresignedRequest, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	expiresAt := s.now().Add(expiry).UTC()
+	return &PresignedRequest{
+		URL:       s.signedURL(http.MethodGet, key, expiresAt, PutOptions{}),
+		Method:    http.MethodGet,
+		Headers:   map[string]string{},
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// PresignPut signs the content type and length like S3 does, so Handler
+// rejects uploads that don't match them
+func (s *FSStore) PresignPut(ctx context.Context, key string, opts PutOptions, expiry time.Duration) (*PresignedRequest, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	expiresAt := s.now().Add(expiry).UTC()
+	headers := map[string]string{}
+	if opts.ContentType != "" {
+		headers["Content-Type"] = opts.ContentType
+	}
+
+	return &PresignedRequest{
+		URL:       s.signedURL(http.MethodPut, key, expiresAt, opts),
+		Method:    http.MethodPut,
+		Headers:   headers,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func (s *FSStore) signedURL(method, key string, expiresAt time.Time, opts PutOptions) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	length := ""
+	if opts.ContentLength > 0 {
+		length = strconv.FormatInt(opts.ContentLength, 10)
+	}
+
+	query := url.Values{}
+	query.Set("expires", expires)
+	if length != "" {
+		query.Set("length", length)
+	}
+	query.Set("signature", s.sign(method, key, expires, opts.ContentType, length))
+
+	u := *s.baseURL
+	u.Path = s.baseURL.Path + "/" + key
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (s *FSStore) sign(method, key, expires, contentType, length string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(strings.Join([]string{method, key, expires, contentType, length}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks a request against its signed URL. HEAD is allowed wherever
+// GET is, as with S3.
+func (s *FSStore) verify(r *http.Request, key string) error {
+	query := r.URL.Query()
+	expires := query.Get("expires")
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || s.now().Unix() > expiresAt {
+		return ErrInvalidSignature
+	}
+
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	contentType := ""
+	if method == http.MethodPut {
+		contentType = r.Header.Get("Content-Type")
+	}
+
+	expected := s.sign(method, key, expires, contentType, query.Get("length"))
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Handler serves presigned URLs. Mount it at the path of BaseURL.
+func (s *FSStore) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, s.baseURL.Path+"/")
+		if !validKey(key) {
+			http.Error(w, "invalid key", http.StatusBadRequest)
+			return
+		}
+		if err := s.verify(r, key); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			s.serveGet(w, r, key)
+		case http.MethodPut:
+			s.servePut(w, r, key)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (s *FSStore) serveGet(w http.ResponseWriter, r *http.Request, key string) {
+	object, err := s.Get(r.Context(), key, GetOptions{
+		IfNoneMatch: r.Header.Get("If-None-Match"),
+		Range:       r.Header.Get("Range"),
+	})
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	case errors.Is(err, ErrInvalidRange):
+		w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(sizeOrZero(s, r, key), 10))
+		http.Error(w, "range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	case err != nil:
+		http.Error(w, "failed to read object", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", object.ETag)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if object.NotModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	defer object.Body.Close()
+
+	w.Header().Set("Content-Type", object.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(object.ContentLength, 10))
+	w.Header().Set("Last-Modified", object.LastModified.Format(http.TimeFormat))
+	if object.Checksum != "" {
+		w.Header().Set("X-Checksum-Sha256", object.Checksum)
+	}
+
+	statusCode := http.StatusOK
+	if object.Partial {
+		w.Header().Set("Content-Range", object.ContentRange)
+		statusCode = http.StatusPartialContent
+	}
+	w.WriteHeader(statusCode)
+
+	if r.Method != http.MethodHead {
+		io.Copy(w, object.Body)
+	}
+}
+
+func (s *FSStore) servePut(w http.ResponseWriter, r *http.Request, key string) {
+	want := int64(-1)
+	if length := r.URL.Query().Get("length"); length != "" {
+		want, _ = strconv.ParseInt(length, 10, 64)
+		if r.ContentLength != want {
+			http.Error(w, "content length doesn't match signature", http.StatusForbidden)
+			return
+		}
+	}
+
+	writer, err := s.NewWriter(r.Context(), key, PutOptions{
+		ContentType: r.Header.Get("Content-Type"),
+	})
+	if err != nil {
+		http.Error(w, "failed to store object", http.StatusInternalServerError)
+		return
+	}
+
+	// Read one byte past the signed length, so a body that runs on is
+	// rejected rather than silently cut short
+	body := io.Reader(r.Body)
+	if want >= 0 {
+		body = io.LimitReader(r.Body, want+1)
+	}
+	n, err := io.Copy(writer, body)
+	if err != nil {
+		writer.Abort()
+		http.Error(w, "failed to store object", http.StatusInternalServerError)
+		return
+	}
+	if want >= 0 && n != want {
+		writer.Abort()
+		http.Error(w, "content length doesn't match signature", http.StatusForbidden)
+		return
+	}
+
+	info, err := writer.Commit()
+	if err != nil {
+		http.Error(w, "failed to store object", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", info.ETag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func sizeOrZero(s *FSStore, r *http.Request, key string) int64 {
+	info, err := s.Head(r.Context(), key)
+	if err != nil {
+		return 0
+	}
+	return info.Size
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,95 @@
+package blob_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/celebthumb-ai/internal/blob/blobtest"
+)
+
+func TestFSStore(t *testing.T) {
+	// The store needs the server's URL for presigning before it can serve
+	server := httptest.NewUnstartedServer(nil)
+	defer server.Close()
+
+	store, err := blob.NewFSStore(blob.FSStoreConfig{
+		Root:    t.TempDir(),
+		BaseURL: "http://" + server.Listener.Addr().String() + "/blob",
+	})
+	if err != nil {
+		t.Fatalf("NewFSStore: %v", err)
+	}
+
+	server.Config.Handler = store.Handler()
+	server.Start()
+
+	blobtest.TestStore(t, store)
+}
+
+func TestFSStorePutLength(t *testing.T) {
+	store, err := blob.NewFSStore(blob.FSStoreConfig{
+		Root:    t.TempDir(),
+		BaseURL: "http://blob.test/blob",
+	})
+	if err != nil {
+		t.Fatalf("NewFSStore: %v", err)
+	}
+
+	req, err := store.PresignPut(context.Background(), "uploads/photo.png", blob.PutOptions{
+		ContentType:   "image/png",
+		ContentLength: 5,
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("PresignPut: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		// The declared length matches the signature but the body runs on,
+		// as it can behind a proxy that doesn't enforce Content-Length
+		{name: "longer body", body: "12345678", wantStatus: http.StatusForbidden},
+		{name: "shorter body", body: "123", wantStatus: http.StatusForbidden},
+		{name: "signed length", body: "12345", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(req.Method, req.URL, strings.NewReader(tt.body))
+			r.ContentLength = 5
+			for name, value := range req.Headers {
+				r.Header.Set(name, value)
+			}
+
+			w := httptest.NewRecorder()
+			store.Handler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("PUT = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			object, err := store.Get(context.Background(), "uploads/photo.png", blob.GetOptions{})
+			if tt.wantStatus != http.StatusOK {
+				if !errors.Is(err, blob.ErrNotFound) {
+					t.Errorf("Get after rejected PUT error = %v, want %v", err, blob.ErrNotFound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			defer object.Body.Close()
+			if data, _ := io.ReadAll(object.Body); string(data) != tt.body {
+				t.Errorf("stored %q, want %q", data, tt.body)
+			}
+		})
+	}
+}
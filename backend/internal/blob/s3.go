@@ -0,0 +1,295 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type S3StoreConfig struct {
+	S3Client *s3.Client
+	Bucket   string
+}
+
+// S3Store keeps objects in an S3 bucket or an S3-compatible service such as
+// MinIO
+type S3Store struct {
+	s3Client      *s3.Client
+	presignClient *s3.PresignClient
+	bucket        string
+}
+
+func NewS3Store(config S3StoreConfig) *S3Store {
+	return &S3Store{
+		s3Client:      config.S3Client,
+		presignClient: s3.NewPresignClient(config.S3Client),
+		bucket:        config.Bucket,
+	}
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (*ObjectInfo, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	// Buffer up to a part so the SHA-256 checksum can be sent with the upload
+	// and verified by S3. Larger bodies are streamed as a multipart upload,
+	// which keeps memory use at a part however large the body is.
+	data, err := io.ReadAll(io.LimitReader(body, PartSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object body: %w", err)
+	}
+	if len(data) > PartSize {
+		return s.putMultipart(ctx, key, io.MultiReader(bytesReader(data), body), opts)
+	}
+	checksum := checksumOf(data)
+
+	resp, err := s.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:         aws.String(s.bucket),
+		Key:            aws.String(key),
+		Body:           bytesReader(data),
+		ContentType:    optionalString(opts.ContentType),
+		ChecksumSHA256: aws.String(checksum),
+		Metadata:       opts.Metadata,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to put object: %w", err)
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         int64(len(data)),
+		ContentType:  opts.ContentType,
+		ETag:         aws.ToString(resp.ETag),
+		Checksum:     checksum,
+		LastModified: time.Now().UTC(),
+		Metadata:     opts.Metadata,
+	}, nil
+}
+
+// putMultipart streams body through NewWriter
+func (s *S3Store) putMultipart(ctx context.Context, key string, body io.Reader, opts PutOptions) (*ObjectInfo, error) {
+	w, err := s.NewWriter(ctx, key, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(w, body); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("failed to stream object body: %w", err)
+	}
+
+	return w.Commit()
+}
+
+func (s *S3Store) Get(ctx context.Context, key string, opts GetOptions) (*Object, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	}
+	if opts.IfNoneMatch != "" {
+		input.IfNoneMatch = aws.String(opts.IfNoneMatch)
+	}
+	if IsSingleByteRange(opts.Range) {
+		input.Range = aws.String(opts.Range)
+	}
+
+	resp, err := s.s3Client.GetObject(ctx, input)
+	if err != nil {
+		switch statusCode(err) {
+		case http.StatusNotModified:
+			return &Object{
+				ObjectInfo:  ObjectInfo{Key: key, ETag: opts.IfNoneMatch},
+				NotModified: true,
+			}, nil
+		case http.StatusNotFound:
+			return nil, ErrNotFound
+		case http.StatusRequestedRangeNotSatisfiable:
+			return nil, ErrInvalidRange
+		}
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+
+	object := &Object{
+		ObjectInfo: ObjectInfo{
+			Key:          key,
+			Size:         aws.ToInt64(resp.ContentLength),
+			ContentType:  aws.ToString(resp.ContentType),
+			ETag:         aws.ToString(resp.ETag),
+			Checksum:     aws.ToString(resp.ChecksumSHA256),
+			LastModified: aws.ToTime(resp.LastModified),
+			Metadata:     resp.Metadata,
+		},
+		Body:          resp.Body,
+		ContentLength: aws.ToInt64(resp.ContentLength),
+		ContentRange:  aws.ToString(resp.ContentRange),
+	}
+	if object.ContentRange != "" {
+		object.Partial = true
+		object.Size = sizeFromContentRange(object.ContentRange)
+		// S3 only returns the whole-object checksum on full reads
+		object.Checksum = ""
+	}
+
+	return object, nil
+}
+
+func (s *S3Store) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	resp, err := s.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		if statusCode(err) == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to head object: %w", err)
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(resp.ContentLength),
+		ContentType:  aws.ToString(resp.ContentType),
+		ETag:         aws.ToString(resp.ETag),
+		Checksum:     aws.ToString(resp.ChecksumSHA256),
+		LastModified: aws.ToTime(resp.LastModified),
+		Metadata:     resp.Metadata,
+	}, nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(int32(listLimit(opts.MaxKeys))),
+	}
+	if opts.Token != "" {
+		input.ContinuationToken = aws.String(opts.Token)
+	}
+
+	resp, err := s.s3Client.ListObjectsV2(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	result := &ListResult{Objects: make([]ObjectInfo, 0, len(resp.Contents))}
+	for _, obj := range resp.Contents {
+		result.Objects = append(result.Objects, ObjectInfo{
+			Key:          aws.ToString(obj.Key),
+			Size:         aws.ToInt64(obj.Size),
+			ETag:         aws.ToString(obj.ETag),
+			LastModified: aws.ToTime(obj.LastModified),
+		})
+	}
+	if aws.ToBool(resp.IsTruncated) {
+		result.NextToken = aws.ToString(resp.NextContinuationToken)
+	}
+
+	return result, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	_, err := s.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+
+	return nil
+}
+
+func (s *S3Store) PresignGet(ctx context.Context, key string, expiry time.Duration) (*PresignedRequest, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	req, err := s.presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign get: %w", err)
+	}
+
+	return &PresignedRequest{
+		URL:       req.URL,
+		Method:    req.Method,
+		Headers:   map[string]string{},
+		ExpiresAt: time.Now().Add(expiry).UTC(),
+	}, nil
+}
+
+// PresignPut signs the content type and length, so S3 rejects uploads that
+// don't match them
+func (s *S3Store) PresignPut(ctx context.Context, key string, opts PutOptions, expiry time.Duration) (*PresignedRequest, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	req, err := s.presignClient.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		ContentType:   optionalString(opts.ContentType),
+		ContentLength: aws.Int64(opts.ContentLength),
+		Metadata:      opts.Metadata,
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign put: %w", err)
+	}
+
+	headers := make(map[string]string, len(req.SignedHeader))
+	for name := range req.SignedHeader {
+		// Host is set by the HTTP client from the URL
+		if name == "Host" {
+			continue
+		}
+		headers[name] = req.SignedHeader.Get(name)
+	}
+
+	return &PresignedRequest{
+		URL:       req.URL,
+		Method:    req.Method,
+		Headers:   headers,
+		ExpiresAt: time.Now().Add(expiry).UTC(),
+	}, nil
+}
+
+// statusCode extracts the HTTP status of a failed S3 call, or 0
+func statusCode(err error) int {
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.HTTPStatusCode()
+	}
+	return 0
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
@@ -0,0 +1,40 @@
+package blob_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/celebthumb-ai/internal/blob/blobtest"
+)
+
+// TestS3Store runs the conformance suite against a real bucket. Point
+// BLOB_TEST_S3_ENDPOINT at MinIO or localstack to run it without AWS; the
+// bucket has to exist already.
+func TestS3Store(t *testing.T) {
+	bucket := os.Getenv("BLOB_TEST_S3_BUCKET")
+	if bucket == "" {
+		t.Skip("BLOB_TEST_S3_BUCKET is not set")
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		t.Fatalf("LoadDefaultConfig: %v", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint := os.Getenv("BLOB_TEST_S3_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	blobtest.TestStore(t, blob.NewS3Store(blob.S3StoreConfig{
+		S3Client: client,
+		Bucket:   bucket,
+	}))
+}
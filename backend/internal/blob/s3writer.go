@@ -0,0 +1,146 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// PartSize is the multipart chunk a streamed S3 write buffers. S3 needs every
+// part but the last to be at least 5 MiB.
+const PartSize = 8 << 20
+
+// NewWriter uploads in PartSize parts, so memory use stays flat however large
+// the object grows. An object smaller than one part is sent with a single
+// PutObject on commit.
+func (s *S3Store) NewWriter(ctx context.Context, key string, opts PutOptions) (Writer, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	return &s3Writer{
+		ctx:   ctx,
+		store: s,
+		key:   key,
+		opts:  opts,
+		hash:  sha256.New(),
+	}, nil
+}
+
+type s3Writer struct {
+	ctx      context.Context
+	store    *S3Store
+	key      string
+	opts     PutOptions
+	hash     hash.Hash
+	size     int64
+	buf      bytes.Buffer
+	uploadID string
+	parts    []types.CompletedPart
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	n, _ := w.buf.Write(p)
+	w.hash.Write(p)
+	w.size += int64(n)
+
+	for w.buf.Len() >= PartSize {
+		if err := w.uploadPart(w.buf.Next(PartSize)); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (w *s3Writer) uploadPart(data []byte) error {
+	if w.uploadID == "" {
+		resp, err := w.store.s3Client.CreateMultipartUpload(w.ctx, &s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(w.store.bucket),
+			Key:         aws.String(w.key),
+			ContentType: optionalString(w.opts.ContentType),
+			Metadata:    w.opts.Metadata,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to start multipart upload: %w", err)
+		}
+		w.uploadID = aws.ToString(resp.UploadId)
+	}
+
+	number := int32(len(w.parts) + 1)
+	resp, err := w.store.s3Client.UploadPart(w.ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(w.store.bucket),
+		Key:        aws.String(w.key),
+		UploadId:   aws.String(w.uploadID),
+		PartNumber: aws.Int32(number),
+		Body:       bytesReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d: %w", number, err)
+	}
+
+	w.parts = append(w.parts, types.CompletedPart{
+		ETag:       resp.ETag,
+		PartNumber: aws.Int32(number),
+	})
+	return nil
+}
+
+func (w *s3Writer) Commit() (*ObjectInfo, error) {
+	if w.uploadID == "" {
+		return w.store.Put(w.ctx, w.key, &w.buf, w.opts)
+	}
+
+	if w.buf.Len() > 0 {
+		if err := w.uploadPart(w.buf.Bytes()); err != nil {
+			w.Abort()
+			return nil, err
+		}
+	}
+
+	resp, err := w.store.s3Client.CompleteMultipartUpload(w.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(w.store.bucket),
+		Key:             aws.String(w.key),
+		UploadId:        aws.String(w.uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: w.parts},
+	})
+	if err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	return &ObjectInfo{
+		Key:          w.key,
+		Size:         w.size,
+		ContentType:  w.opts.ContentType,
+		ETag:         aws.ToString(resp.ETag),
+		Checksum:     base64.StdEncoding.EncodeToString(w.hash.Sum(nil)),
+		LastModified: time.Now().UTC(),
+		Metadata:     w.opts.Metadata,
+	}, nil
+}
+
+func (w *s3Writer) Abort() error {
+	w.buf.Reset()
+	if w.uploadID == "" {
+		return nil
+	}
+
+	_, err := w.store.s3Client.AbortMultipartUpload(w.ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(w.store.bucket),
+		Key:      aws.String(w.key),
+		UploadId: aws.String(w.uploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,465 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrCollectionNotFound = errors.New("collection not found")
+	// ErrThumbnailNotFound means a thumbnail in a bulk change doesn't exist or
+	// belongs to someone else; none of the change is applied
+	ErrThumbnailNotFound = errors.New("thumbnail not found")
+	ErrTooManyThumbnails = errors.New("too many thumbnails in one request")
+	ErrInvalidOrder      = errors.New("order must list each collection exactly once")
+)
+
+// MaxBulkThumbnails keeps a bulk change inside one DynamoDB transaction
+// alongside the collection check
+const MaxBulkThumbnails = 99
+
+type CollectionConfig struct {
+	DynamoClient *dynamodb.Client
+	TableName    string
+	// ThumbnailsTable holds each thumbnail's collectionIds membership set
+	ThumbnailsTable string
+}
+
+type CollectionService struct {
+	dynamoClient    *dynamodb.Client
+	tableName       string
+	thumbnailsTable string
+}
+
+// Update holds the collection fields to change; nil fields are left alone
+type Update struct {
+	Name             *string
+	Description      *string
+	CoverThumbnailID *string
+}
+
+func NewCollectionService(config CollectionConfig) *CollectionService {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("COLLECTIONS_TABLE")
+	}
+
+	thumbnailsTable := config.ThumbnailsTable
+	if thumbnailsTable == "" {
+		thumbnailsTable = os.Getenv("THUMBNAILS_TABLE")
+	}
+
+	return &CollectionService{
+		dynamoClient:    config.DynamoClient,
+		tableName:       tableName,
+		thumbnailsTable: thumbnailsTable,
+	}
+}
+
+// CreateCollection adds a collection after the user's existing ones
+func (s *CollectionService) CreateCollection(ctx context.Context, userID, name, description string) (*models.Collection, error) {
+	collections, err := s.ListCollections(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	position := 0
+	if len(collections) > 0 {
+		position = collections[len(collections)-1].Position + 1
+	}
+
+	now := time.Now().UTC()
+	collection := &models.Collection{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Name:        name,
+		Description: description,
+		Position:    position,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if err := s.put(ctx, collection); err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+// GetCollection returns a collection owned by userID
+func (s *CollectionService) GetCollection(ctx context.Context, userID, collectionID string) (*models.Collection, error) {
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: collectionID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection: %w", err)
+	}
+	if resp.Item == nil {
+		return nil, ErrCollectionNotFound
+	}
+
+	var collection models.Collection
+	if err := attributevalue.UnmarshalMap(resp.Item, &collection); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal collection: %w", err)
+	}
+	if collection.UserID != userID {
+		return nil, ErrCollectionNotFound
+	}
+
+	return &collection, nil
+}
+
+// ListCollections returns a user's collections in position order
+func (s *CollectionService) ListCollections(ctx context.Context, userID string) ([]*models.Collection, error) {
+	collections := []*models.Collection{}
+
+	paginator := dynamodb.NewQueryPaginator(s.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String("byUser"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query collections: %w", err)
+		}
+
+		var items []*models.Collection
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal collections: %w", err)
+		}
+		collections = append(collections, items...)
+	}
+
+	return collections, nil
+}
+
+// UpdateCollection changes a collection's details. A cover must be one of the
+// collection's own thumbnails; an empty cover clears it.
+func (s *CollectionService) UpdateCollection(ctx context.Context, userID, collectionID string, update Update) (*models.Collection, error) {
+	collection, err := s.GetCollection(ctx, userID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Name != nil {
+		collection.Name = *update.Name
+	}
+	if update.Description != nil {
+		collection.Description = *update.Description
+	}
+	if update.CoverThumbnailID != nil {
+		if *update.CoverThumbnailID != "" {
+			member, err := s.isMember(ctx, userID, collectionID, *update.CoverThumbnailID)
+			if err != nil {
+				return nil, err
+			}
+			if !member {
+				return nil, ErrThumbnailNotFound
+			}
+		}
+		collection.CoverThumbnailID = *update.CoverThumbnailID
+	}
+	collection.UpdatedAt = time.Now().UTC()
+
+	if err := s.put(ctx, collection); err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+// ReorderCollections sets the position of each of the user's collections from
+// its index in collectionIDs, which must list all of them
+func (s *CollectionService) ReorderCollections(ctx context.Context, userID string, collectionIDs []string) ([]*models.Collection, error) {
+	collections, err := s.ListCollections(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*models.Collection, len(collections))
+	for _, c := range collections {
+		byID[c.ID] = c
+	}
+	if len(collectionIDs) != len(collections) {
+		return nil, ErrInvalidOrder
+	}
+
+	ordered := make([]*models.Collection, 0, len(collectionIDs))
+	for position, id := range collectionIDs {
+		c, ok := byID[id]
+		if !ok {
+			return nil, ErrInvalidOrder
+		}
+		delete(byID, id)
+
+		if c.Position != position {
+			_, err := s.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName: aws.String(s.tableName),
+				Key: map[string]types.AttributeValue{
+					"id": &types.AttributeValueMemberS{Value: id},
+				},
+				UpdateExpression: aws.String("SET #position = :position"),
+				ExpressionAttributeNames: map[string]string{
+					"#position": "position",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":position": &types.AttributeValueMemberN{Value: strconv.Itoa(position)},
+				},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to reorder collections: %w", err)
+			}
+			c.Position = position
+		}
+		ordered = append(ordered, c)
+	}
+
+	return ordered, nil
+}
+
+// DeleteCollection removes the collection and its membership entries. The
+// thumbnails themselves are kept.
+func (s *CollectionService) DeleteCollection(ctx context.Context, userID, collectionID string) error {
+	if _, err := s.GetCollection(ctx, userID, collectionID); err != nil {
+		return err
+	}
+
+	memberIDs, err := s.memberIDs(ctx, userID, collectionID)
+	if err != nil {
+		return err
+	}
+	for _, thumbnailID := range memberIDs {
+		_, err := s.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(s.thumbnailsTable),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: thumbnailID},
+			},
+			UpdateExpression:    aws.String("DELETE collectionIds :collection"),
+			ConditionExpression: aws.String("attribute_exists(id)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":collection": &types.AttributeValueMemberSS{Value: []string{collectionID}},
+			},
+		})
+		var conditionFailed *types.ConditionalCheckFailedException
+		if err != nil && !errors.As(err, &conditionFailed) {
+			return fmt.Errorf("failed to remove thumbnail from collection: %w", err)
+		}
+	}
+
+	_, err = s.dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: collectionID},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete collection: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUserCollections removes all of a user's collections. Membership is
+// left on the thumbnails, which are expected to be deleted with the user.
+func (s *CollectionService) DeleteUserCollections(ctx context.Context, userID string) error {
+	collections, err := s.ListCollections(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		_, err := s.dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.tableName),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: collection.ID},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete collection: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// AddThumbnails puts thumbnails into a collection. Either all of them are
+// added or, if any isn't the user's, none are.
+func (s *CollectionService) AddThumbnails(ctx context.Context, userID, collectionID string, thumbnailIDs []string) error {
+	return s.changeMembership(ctx, userID, collectionID, thumbnailIDs, "ADD")
+}
+
+// RemoveThumbnails takes thumbnails out of a collection, clearing the cover if
+// it was one of them
+func (s *CollectionService) RemoveThumbnails(ctx context.Context, userID, collectionID string, thumbnailIDs []string) error {
+	if err := s.changeMembership(ctx, userID, collectionID, thumbnailIDs, "DELETE"); err != nil {
+		return err
+	}
+
+	collection, err := s.GetCollection(ctx, userID, collectionID)
+	if err != nil {
+		return err
+	}
+	for _, id := range thumbnailIDs {
+		if id == collection.CoverThumbnailID {
+			clear := ""
+			_, err := s.UpdateCollection(ctx, userID, collectionID, Update{CoverThumbnailID: &clear})
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *CollectionService) changeMembership(ctx context.Context, userID, collectionID string, thumbnailIDs []string, action string) error {
+	if len(thumbnailIDs) == 0 {
+		return nil
+	}
+	if len(thumbnailIDs) > MaxBulkThumbnails {
+		return ErrTooManyThumbnails
+	}
+
+	items := []types.TransactWriteItem{{
+		ConditionCheck: &types.ConditionCheck{
+			TableName: aws.String(s.tableName),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: collectionID},
+			},
+			ConditionExpression: aws.String("userId = :userId"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":userId": &types.AttributeValueMemberS{Value: userID},
+			},
+		},
+	}}
+
+	seen := map[string]bool{}
+	for _, thumbnailID := range thumbnailIDs {
+		if seen[thumbnailID] {
+			continue
+		}
+		seen[thumbnailID] = true
+
+		items = append(items, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(s.thumbnailsTable),
+				Key: map[string]types.AttributeValue{
+					"id": &types.AttributeValueMemberS{Value: thumbnailID},
+				},
+				UpdateExpression:    aws.String(action + " collectionIds :collection"),
+				ConditionExpression: aws.String("userId = :userId"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":collection": &types.AttributeValueMemberSS{Value: []string{collectionID}},
+					":userId":     &types.AttributeValueMemberS{Value: userID},
+				},
+			},
+		})
+	}
+
+	_, err := s.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 {
+			if aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+				return ErrCollectionNotFound
+			}
+			return ErrThumbnailNotFound
+		}
+		return fmt.Errorf("failed to update collection membership: %w", err)
+	}
+
+	return nil
+}
+
+func (s *CollectionService) isMember(ctx context.Context, userID, collectionID, thumbnailID string) (bool, error) {
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.thumbnailsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: thumbnailID},
+		},
+		ProjectionExpression: aws.String("userId, collectionIds"),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get thumbnail: %w", err)
+	}
+
+	var thumbnail models.Thumbnail
+	if err := attributevalue.UnmarshalMap(resp.Item, &thumbnail); err != nil {
+		return false, fmt.Errorf("failed to unmarshal thumbnail: %w", err)
+	}
+	if thumbnail.UserID != userID {
+		return false, nil
+	}
+	for _, id := range thumbnail.CollectionIDs {
+		if id == collectionID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// memberIDs finds the user's thumbnails in a collection, trashed ones included
+func (s *CollectionService) memberIDs(ctx context.Context, userID, collectionID string) ([]string, error) {
+	ids := []string{}
+
+	paginator := dynamodb.NewQueryPaginator(s.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(s.thumbnailsTable),
+		IndexName:              aws.String("byUser"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		FilterExpression:       aws.String("contains(collectionIds, :collection)"),
+		ProjectionExpression:   aws.String("id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId":     &types.AttributeValueMemberS{Value: userID},
+			":collection": &types.AttributeValueMemberS{Value: collectionID},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query collection members: %w", err)
+		}
+		for _, item := range page.Items {
+			if id, ok := item["id"].(*types.AttributeValueMemberS); ok {
+				ids = append(ids, id.Value)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+func (s *CollectionService) put(ctx context.Context, collection *models.Collection) error {
+	item, err := attributevalue.MarshalMap(collection)
+	if err != nil {
+		return fmt.Errorf("failed to marshal collection: %w", err)
+	}
+
+	_, err = s.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save collection: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,410 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/celebthumb-ai/internal/storage"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrExportNotFound   = errors.New("export not found")
+	ErrExportInProgress = errors.New("an export is already in progress")
+)
+
+const (
+	// DefaultURLExpiry is how long an export's download link stays valid
+	DefaultURLExpiry = 24 * time.Hour
+	// Retention is how long an export and its archive are kept
+	Retention = 7 * 24 * time.Hour
+	// RunTimeout matches the export function's timeout. A job still running
+	// after it was cut off and may be picked up again.
+	RunTimeout = 15 * time.Minute
+)
+
+// AccountDataSource collects the personal data included in an account export
+type AccountDataSource interface {
+	AccountData(ctx context.Context, userID string) (interface{}, error)
+}
+
+// Notifier tells a user their export is ready
+type Notifier interface {
+	ExportReady(ctx context.Context, export *models.Export) error
+}
+
+type ExportConfig struct {
+	DynamoClient *dynamodb.Client
+	TableName    string
+	Storage      *storage.StorageService
+	// Notifier is optional; without one no emails are sent
+	Notifier  Notifier
+	URLExpiry time.Duration
+	// AccountData is required for account exports
+	AccountData AccountDataSource
+}
+
+type ExportService struct {
+	dynamoClient *dynamodb.Client
+	tableName    string
+	storage      *storage.StorageService
+	notifier     Notifier
+	urlExpiry    time.Duration
+	accountData  AccountDataSource
+}
+
+// Request selects what to export. Zero values mean everything.
+type Request struct {
+	// Kind defaults to a library export
+	Kind         string
+	CollectionID string
+	From         *time.Time
+	To           *time.Time
+	Notify       bool
+}
+
+func NewExportService(config ExportConfig) *ExportService {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("EXPORTS_TABLE")
+	}
+
+	urlExpiry := config.URLExpiry
+	if urlExpiry <= 0 {
+		urlExpiry = DefaultURLExpiry
+		if d, err := time.ParseDuration(os.Getenv("EXPORT_URL_EXPIRY")); err == nil && d > 0 {
+			urlExpiry = d
+		}
+	}
+
+	return &ExportService{
+		dynamoClient: config.DynamoClient,
+		tableName:    tableName,
+		storage:      config.Storage,
+		notifier:     config.Notifier,
+		urlExpiry:    urlExpiry,
+		accountData:  config.AccountData,
+	}
+}
+
+// CreateExport records a pending export. The archive is built asynchronously
+// by Run, which the table's stream triggers for every new export.
+func (s *ExportService) CreateExport(ctx context.Context, user *models.User, req Request) (*models.Export, error) {
+	exports, err := s.ListExports(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range exports {
+		if e.Status == models.ExportStatusPending || e.Status == models.ExportStatusRunning {
+			return nil, ErrExportInProgress
+		}
+	}
+
+	kind := req.Kind
+	if kind == "" {
+		kind = models.ExportKindLibrary
+	}
+
+	now := time.Now().UTC()
+	e := &models.Export{
+		ID:           uuid.New().String(),
+		UserID:       user.ID,
+		Status:       models.ExportStatusPending,
+		Kind:         kind,
+		CollectionID: req.CollectionID,
+		From:         req.From,
+		To:           req.To,
+		Notify:       req.Notify,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(Retention),
+	}
+	if req.Notify {
+		e.Email = user.Email
+	}
+
+	item, err := attributevalue.MarshalMap(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal export: %w", err)
+	}
+
+	_, err = s.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export: %w", err)
+	}
+
+	return e, nil
+}
+
+// GetExport returns one of the user's exports, with a download link once it
+// has completed
+func (s *ExportService) GetExport(ctx context.Context, userID, exportID string) (*models.Export, error) {
+	e, err := s.get(ctx, exportID)
+	if err != nil {
+		return nil, err
+	}
+	if e.UserID != userID {
+		return nil, ErrExportNotFound
+	}
+
+	if err := s.presign(ctx, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ListExports returns the user's exports, newest first
+func (s *ExportService) ListExports(ctx context.Context, userID string) ([]*models.Export, error) {
+	exports, err := s.listAll(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The TTL sweep can lag, so expired exports are hidden here
+	now := time.Now()
+	live := exports[:0]
+	for _, e := range exports {
+		if e.ExpiresAt.After(now) {
+			live = append(live, e)
+		}
+	}
+
+	return live, nil
+}
+
+// DeleteUserExports removes the records of all the user's exports. Their
+// archives live under the user's exports/ prefix and are deleted with it.
+func (s *ExportService) DeleteUserExports(ctx context.Context, userID string) error {
+	exports, err := s.listAll(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range exports {
+		_, err := s.dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.tableName),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: e.ID},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete export: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *ExportService) listAll(ctx context.Context, userID string) ([]*models.Export, error) {
+	exports := []*models.Export{}
+
+	paginator := dynamodb.NewQueryPaginator(s.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String("byUser"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userID},
+		},
+		ScanIndexForward: aws.Bool(false),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query exports: %w", err)
+		}
+
+		var items []*models.Export
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal exports: %w", err)
+		}
+		exports = append(exports, items...)
+	}
+
+	return exports, nil
+}
+
+// Run builds the archive for a pending export. It is safe to call more than
+// once: a job another invocation is already running is left alone. A failed
+// build is recorded on the export rather than returned, so it isn't retried.
+func (s *ExportService) Run(ctx context.Context, exportID string) error {
+	e, err := s.claim(ctx, exportID)
+	if errors.Is(err, ErrExportNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	opts := storage.ListOptions{CollectionID: e.CollectionID}
+	if e.From != nil {
+		opts.From = *e.From
+	}
+	if e.To != nil {
+		opts.To = *e.To
+	}
+
+	var extra []storage.ArchiveFile
+	if e.Kind == models.ExportKindAccount {
+		file, err := s.accountFile(ctx, e.UserID)
+		if err != nil {
+			log.Printf("export %s failed: %v", e.ID, err)
+			return s.finish(ctx, e, models.ExportStatusFailed, "failed to collect account data")
+		}
+		extra = append(extra, *file)
+	}
+
+	key := fmt.Sprintf("exports/%s/%s.zip", e.UserID, e.ID)
+	archive, err := s.storage.WriteArchive(ctx, e.UserID, opts, key, extra...)
+	if err != nil {
+		log.Printf("export %s failed: %v", e.ID, err)
+		return s.finish(ctx, e, models.ExportStatusFailed, "failed to build archive")
+	}
+
+	e.ObjectKey = archive.Key
+	e.Size = archive.Size
+	e.Thumbnails = archive.Thumbnails
+	if err := s.finish(ctx, e, models.ExportStatusCompleted, ""); err != nil {
+		return err
+	}
+
+	if e.Notify && e.Email != "" && s.notifier != nil {
+		if err := s.presign(ctx, e); err != nil {
+			log.Printf("failed to presign export %s: %v", e.ID, err)
+		} else if err := s.notifier.ExportReady(ctx, e); err != nil {
+			log.Printf("failed to notify user about export %s: %v", e.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// accountFile renders the user's account data as account.json
+func (s *ExportService) accountFile(ctx context.Context, userID string) (*storage.ArchiveFile, error) {
+	if s.accountData == nil {
+		return nil, errors.New("no account data source configured")
+	}
+
+	data, err := s.accountData.AccountData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal account data: %w", err)
+	}
+
+	return &storage.ArchiveFile{Name: "account.json", Data: encoded}, nil
+}
+
+// claim moves an export from pending to running. A job running for longer
+// than RunTimeout was cut off and can be claimed again.
+func (s *ExportService) claim(ctx context.Context, exportID string) (*models.Export, error) {
+	now := time.Now().UTC()
+	resp, err := s.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: exportID},
+		},
+		UpdateExpression:    aws.String("SET #status = :running, startedAt = :now"),
+		ConditionExpression: aws.String("#status = :pending OR (#status = :running AND startedAt < :stale)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: models.ExportStatusPending},
+			":running": &types.AttributeValueMemberS{Value: models.ExportStatusRunning},
+			":now":     &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+			":stale":   &types.AttributeValueMemberS{Value: now.Add(-RunTimeout).Format(time.RFC3339Nano)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil, ErrExportNotFound
+		}
+		return nil, fmt.Errorf("failed to claim export: %w", err)
+	}
+
+	var e models.Export
+	if err := attributevalue.UnmarshalMap(resp.Attributes, &e); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal export: %w", err)
+	}
+	return &e, nil
+}
+
+func (s *ExportService) finish(ctx context.Context, e *models.Export, status, message string) error {
+	now := time.Now().UTC()
+	e.Status = status
+	e.Error = message
+	e.CompletedAt = &now
+
+	item, err := attributevalue.MarshalMap(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal export: %w", err)
+	}
+
+	_, err = s.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update export: %w", err)
+	}
+
+	return nil
+}
+
+func (s *ExportService) get(ctx context.Context, exportID string) (*models.Export, error) {
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: exportID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get export: %w", err)
+	}
+	if resp.Item == nil {
+		return nil, ErrExportNotFound
+	}
+
+	var e models.Export
+	if err := attributevalue.UnmarshalMap(resp.Item, &e); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal export: %w", err)
+	}
+	if !e.ExpiresAt.After(time.Now()) {
+		return nil, ErrExportNotFound
+	}
+
+	return &e, nil
+}
+
+func (s *ExportService) presign(ctx context.Context, e *models.Export) error {
+	if e.Status != models.ExportStatusCompleted || e.ObjectKey == "" {
+		return nil
+	}
+
+	req, err := s.storage.PresignDownload(ctx, e.ObjectKey, s.urlExpiry)
+	if err != nil {
+		return err
+	}
+	e.URL = req.URL
+	e.URLExpiresAt = &req.ExpiresAt
+
+	return nil
+}
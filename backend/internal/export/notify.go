@@ -0,0 +1,87 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+
+	"github.com/celebthumb-ai/internal/models"
+)
+
+type SMTPConfig struct {
+	// Host and Port of the mail server, e.g. the SES SMTP endpoint
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPNotifier emails the download link of a finished export
+type SMTPNotifier struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPNotifier falls back to SMTP_HOST, SMTP_PORT (587), SMTP_USERNAME,
+// SMTP_PASSWORD and EMAIL_FROM. It returns nil when no host is configured.
+func NewSMTPNotifier(config SMTPConfig) *SMTPNotifier {
+	if config.Host == "" {
+		config.Host = os.Getenv("SMTP_HOST")
+	}
+	if config.Host == "" {
+		return nil
+	}
+	if config.Port == "" {
+		config.Port = os.Getenv("SMTP_PORT")
+	}
+	if config.Port == "" {
+		config.Port = "587"
+	}
+	if config.Username == "" {
+		config.Username = os.Getenv("SMTP_USERNAME")
+	}
+	if config.Password == "" {
+		config.Password = os.Getenv("SMTP_PASSWORD")
+	}
+	if config.From == "" {
+		config.From = os.Getenv("EMAIL_FROM")
+	}
+
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+
+	return &SMTPNotifier{
+		addr: net.JoinHostPort(config.Host, config.Port),
+		auth: auth,
+		from: config.From,
+	}
+}
+
+func (n *SMTPNotifier) ExportReady(ctx context.Context, export *models.Export) error {
+	if strings.ContainsAny(export.Email, "\r\n") {
+		return fmt.Errorf("invalid recipient address")
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", n.from)
+	fmt.Fprintf(&body, "To: %s\r\n", export.Email)
+	body.WriteString("Subject: Your thumbnail export is ready\r\n")
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&body, "Your export of %d thumbnails is ready to download:\r\n\r\n%s\r\n\r\n", export.Thumbnails, export.URL)
+	if export.URLExpiresAt != nil {
+		fmt.Fprintf(&body, "The link expires at %s.\r\n", export.URLExpiresAt.UTC().Format("2006-01-02 15:04 MST"))
+	}
+
+	if err := smtp.SendMail(n.addr, n.auth, n.from, []string{export.Email}, []byte(body.String())); err != nil {
+		return fmt.Errorf("failed to send export email: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+const (
+	AccountDeletionPending   = "pending"
+	AccountDeletionCompleted = "completed"
+)
+
+// AccountDeletion tracks the removal of a user's account. Each step is
+// recorded as it finishes so an interrupted deletion can pick up where it
+// stopped.
+type AccountDeletion struct {
+	UserID         string     `json:"userId" dynamodbav:"userId"`
+	Status         string     `json:"status" dynamodbav:"status"`
+	CompletedSteps []string   `json:"completedSteps" dynamodbav:"completedSteps"`
+	Error          string     `json:"error,omitempty" dynamodbav:"error,omitempty"`
+	RequestedAt    time.Time  `json:"requestedAt" dynamodbav:"requestedAt"`
+	UpdatedAt      time.Time  `json:"updatedAt" dynamodbav:"updatedAt"`
+	CompletedAt    *time.Time `json:"completedAt,omitempty" dynamodbav:"completedAt,omitempty"`
+}
+
+func (d *AccountDeletion) StepCompleted(step string) bool {
+	for _, s := range d.CompletedSteps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
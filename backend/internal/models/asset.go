@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Asset is a source image stored once under its SHA-256 hash however many
+// times it is uploaded. It is kept while anything references it.
+type Asset struct {
+	Hash        string `json:"id" dynamodbav:"hash"`
+	ObjectKey   string `json:"-" dynamodbav:"objectKey"`
+	ContentType string `json:"contentType" dynamodbav:"contentType"`
+	Size        int64  `json:"size" dynamodbav:"size"`
+	Width       int    `json:"width" dynamodbav:"width"`
+	Height      int    `json:"height" dynamodbav:"height"`
+	// RefCount is the number of AssetRefs pointing at the asset
+	RefCount  int       `json:"-" dynamodbav:"refCount"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	// URL is presigned per response
+	URL string `json:"url,omitempty" dynamodbav:"-"`
+}
+
+// AssetRef records that something, such as a user's asset library or a
+// thumbnail, uses an asset. Ref is "kind#id".
+type AssetRef struct {
+	Ref       string    `json:"ref" dynamodbav:"ref"`
+	Hash      string    `json:"hash" dynamodbav:"hash"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+}
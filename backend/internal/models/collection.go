@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Collection groups a user's thumbnails, e.g. one per video series. Membership
+// is stored on each thumbnail's collectionIds, so a thumbnail may belong to
+// several collections.
+type Collection struct {
+	ID               string `json:"id" dynamodbav:"id"`
+	UserID           string `json:"userId" dynamodbav:"userId"`
+	Name             string `json:"name" dynamodbav:"name"`
+	Description      string `json:"description,omitempty" dynamodbav:"description,omitempty"`
+	CoverThumbnailID string `json:"coverThumbnailId,omitempty" dynamodbav:"coverThumbnailId,omitempty"`
+	// Position orders a user's collections, lowest first
+	Position  int       `json:"position" dynamodbav:"position"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" dynamodbav:"updatedAt"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusCompleted = "completed"
+	ExportStatusFailed    = "failed"
+
+	// ExportKindLibrary exports thumbnails only
+	ExportKindLibrary = "library"
+	// ExportKindAccount also includes the user's profile and billing history
+	ExportKindAccount = "account"
+)
+
+// Export is a job that bundles a user's thumbnails into a ZIP archive
+type Export struct {
+	ID     string `json:"id" dynamodbav:"id"`
+	UserID string `json:"userId" dynamodbav:"userId"`
+	Status string `json:"status" dynamodbav:"status"`
+	Kind   string `json:"kind" dynamodbav:"kind"`
+	// CollectionID, From and To narrow down which thumbnails are exported
+	CollectionID string     `json:"collectionId,omitempty" dynamodbav:"collectionId,omitempty"`
+	From         *time.Time `json:"from,omitempty" dynamodbav:"from,omitempty"`
+	To           *time.Time `json:"to,omitempty" dynamodbav:"to,omitempty"`
+	// Notify sends the download link to Email once the archive is ready
+	Notify      bool       `json:"notify" dynamodbav:"notify"`
+	Email       string     `json:"-" dynamodbav:"email,omitempty"`
+	ObjectKey   string     `json:"-" dynamodbav:"objectKey,omitempty"`
+	Size        int64      `json:"size,omitempty" dynamodbav:"size,omitempty"`
+	Thumbnails  int        `json:"thumbnails,omitempty" dynamodbav:"thumbnails,omitempty"`
+	Error       string     `json:"error,omitempty" dynamodbav:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt" dynamodbav:"createdAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty" dynamodbav:"startedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty" dynamodbav:"completedAt,omitempty"`
+	// ExpiresAt is when the record and its archive are cleaned up
+	ExpiresAt time.Time `json:"expiresAt" dynamodbav:"expiresAt,unixtime"`
+	// URL is presigned per response once the export has completed
+	URL          string     `json:"url,omitempty" dynamodbav:"-"`
+	URLExpiresAt *time.Time `json:"urlExpiresAt,omitempty" dynamodbav:"-"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Organization groups users, e.g. the staff working on one celebrity channel
+type Organization struct {
+	ID         string    `json:"id" dynamodbav:"id"`
+	Name       string    `json:"name" dynamodbav:"name"`
+	AdminIDs   []string  `json:"adminIds" dynamodbav:"adminIds"`
+	RequireMFA bool      `json:"requireMfa" dynamodbav:"requireMfa"`
+	CreatedAt  time.Time `json:"createdAt" dynamodbav:"createdAt"`
+
+	// InvitedIDs are users an admin has invited who haven't joined yet
+	InvitedIDs []string `json:"invitedIds,omitempty" dynamodbav:"invitedIds,omitempty,stringset"`
+}
+
+func (o *Organization) IsAdmin(userID string) bool {
+	for _, id := range o.AdminIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
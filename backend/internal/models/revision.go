@@ -0,0 +1,44 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Revision is a snapshot of a thumbnail's image and the inputs that produced
+// it. Revisions are numbered from 1 in the order they were made.
+type Revision struct {
+	ThumbnailID string `json:"thumbnailId" dynamodbav:"thumbnailId"`
+	Number      int    `json:"revision" dynamodbav:"revision"`
+	Prompt      string `json:"prompt,omitempty" dynamodbav:"prompt,omitempty"`
+	// LayerDocument is the editor's layer JSON, stored verbatim
+	LayerDocument json.RawMessage `json:"layers,omitempty" dynamodbav:"layers,omitempty"`
+	ChangedBy     string          `json:"changedBy" dynamodbav:"changedBy"`
+	// RestoredFrom is set when the revision was made by restoring an older one
+	RestoredFrom int         `json:"restoredFrom,omitempty" dynamodbav:"restoredFrom,omitempty"`
+	ObjectKey    string      `json:"-" dynamodbav:"objectKey"`
+	Size         int64       `json:"size" dynamodbav:"size"`
+	Checksum     string      `json:"checksum" dynamodbav:"checksum"`
+	Renditions   []Rendition `json:"renditions,omitempty" dynamodbav:"renditions,omitempty"`
+	// PerceptualHash is carried over when the revision is restored
+	PerceptualHash string    `json:"-" dynamodbav:"perceptualHash,omitempty"`
+	CreatedAt      time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	URL            string    `json:"url,omitempty" dynamodbav:"-"`
+}
+
+// NewRevision snapshots the current state of thumbnail
+func NewRevision(thumbnail *Thumbnail, changedBy string) *Revision {
+	return &Revision{
+		ThumbnailID:    thumbnail.ID,
+		Number:         thumbnail.Revision,
+		Prompt:         thumbnail.Prompt,
+		LayerDocument:  thumbnail.LayerDocument,
+		ChangedBy:      changedBy,
+		ObjectKey:      thumbnail.ObjectKey,
+		Size:           thumbnail.Size,
+		Checksum:       thumbnail.Checksum,
+		Renditions:     thumbnail.Renditions,
+		PerceptualHash: thumbnail.PerceptualHash,
+		CreatedAt:      time.Now().UTC(),
+	}
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+const (
+	ShareKindThumbnail  = "thumbnail"
+	ShareKindCollection = "collection"
+)
+
+// ShareLink gives anyone holding Token a read-only, watermarked view of one
+// thumbnail or collection without an account
+type ShareLink struct {
+	Token    string `json:"token" dynamodbav:"token"`
+	UserID   string `json:"userId" dynamodbav:"userId"`
+	Kind     string `json:"kind" dynamodbav:"kind"`
+	TargetID string `json:"targetId" dynamodbav:"targetId"`
+	// PasswordHash is a bcrypt hash, empty when the link has no password
+	PasswordHash      string     `json:"-" dynamodbav:"passwordHash,omitempty"`
+	PasswordProtected bool       `json:"passwordProtected" dynamodbav:"-"`
+	ExpiresAt         *time.Time `json:"expiresAt,omitempty" dynamodbav:"expiresAt,omitempty"`
+	// MaxViews of 0 means unlimited
+	MaxViews     int        `json:"maxViews,omitempty" dynamodbav:"maxViews"`
+	Views        int        `json:"views" dynamodbav:"views"`
+	LastViewedAt *time.Time `json:"lastViewedAt,omitempty" dynamodbav:"lastViewedAt,omitempty"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty" dynamodbav:"revokedAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+// SharedView is what a share link shows. Thumbnails carry watermarked
+// preview URLs only.
+type SharedView struct {
+	Kind       string             `json:"kind"`
+	Title      string             `json:"title"`
+	Thumbnails []*SharedThumbnail `json:"thumbnails"`
+	ExpiresAt  *time.Time         `json:"expiresAt,omitempty"`
+}
+
+type SharedThumbnail struct {
+	ID         string    `json:"id"`
+	VideoTitle string    `json:"videoTitle"`
+	Style      string    `json:"style"`
+	CreatedAt  time.Time `json:"createdAt"`
+	PreviewURL string    `json:"previewUrl"`
+}
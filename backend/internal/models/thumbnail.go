@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,17 +12,64 @@ type ThumbnailRequest struct {
 	VideoTitle  string    `json:"videoTitle"`
 	Description string    `json:"description"`
 	Style       string    `json:"style"`
+	TemplateID  string    `json:"templateId,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	AssetIDs    []string  `json:"assetIds,omitempty"`
 	CreatedAt   time.Time `json:"createdAt"`
 }
 
+const (
+	ThumbnailStatusReady = "ready"
+	// ThumbnailStatusDeleted marks a thumbnail in the trash
+	ThumbnailStatusDeleted = "deleted"
+)
+
 type Thumbnail struct {
-	ID          string    `json:"id"`
-	UserID      string    `json:"userId"`
-	URL         string    `json:"url"`
-	VideoTitle  string    `json:"videoTitle"`
-	Description string    `json:"description"`
-	Style       string    `json:"style"`
-	CreatedAt   time.Time `json:"createdAt"`
+	ID          string    `json:"id" dynamodbav:"id"`
+	UserID      string    `json:"userId" dynamodbav:"userId"`
+	URL         string    `json:"url" dynamodbav:"-"`
+	VideoTitle  string    `json:"videoTitle" dynamodbav:"videoTitle"`
+	Description string    `json:"description" dynamodbav:"description"`
+	Style       string    `json:"style" dynamodbav:"style"`
+	TemplateID  string    `json:"templateId,omitempty" dynamodbav:"templateId,omitempty"`
+	Status      string    `json:"status" dynamodbav:"status"`
+	Size        int64     `json:"size" dynamodbav:"size"`
+	Checksum    string    `json:"checksum" dynamodbav:"checksum"`
+	ObjectKey   string    `json:"-" dynamodbav:"objectKey"`
+	CreatedAt   time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	// DeletedAt is set while the thumbnail is in the trash
+	DeletedAt     *time.Time  `json:"deletedAt,omitempty" dynamodbav:"deletedAt,omitempty"`
+	Renditions    []Rendition `json:"renditions,omitempty" dynamodbav:"renditions,omitempty"`
+	CollectionIDs []string    `json:"collectionIds,omitempty" dynamodbav:"collectionIds,omitempty,stringset"`
+	// Tags are set by the user; AITags come from analysing the image and text
+	Tags   []string `json:"tags,omitempty" dynamodbav:"tags,omitempty"`
+	AITags []string `json:"aiTags,omitempty" dynamodbav:"aiTags,omitempty"`
+	// AssetIDs are the source assets the thumbnail was generated from
+	AssetIDs []string `json:"assetIds,omitempty" dynamodbav:"assetIds,omitempty"`
+	// Revision is the number of the current revision
+	Revision      int             `json:"revision" dynamodbav:"revision"`
+	Prompt        string          `json:"prompt,omitempty" dynamodbav:"prompt,omitempty"`
+	LayerDocument json.RawMessage `json:"layers,omitempty" dynamodbav:"layers,omitempty"`
+	// PerceptualHash is the hex difference hash of the current image, used to
+	// spot near-duplicates
+	PerceptualHash string `json:"perceptualHash,omitempty" dynamodbav:"perceptualHash,omitempty"`
+	// NearDuplicates lists similar thumbnails already in the library when one
+	// is generated; it is not stored
+	NearDuplicates []string `json:"nearDuplicates,omitempty" dynamodbav:"-"`
+	// URL is presigned per response, so neither it nor its expiry is stored
+	URLExpiresAt *time.Time `json:"urlExpiresAt,omitempty" dynamodbav:"-"`
+}
+
+// Rendition is one stored size and format of a thumbnail
+type Rendition struct {
+	Name        string `json:"name" dynamodbav:"name"`
+	Format      string `json:"format" dynamodbav:"format"`
+	ContentType string `json:"contentType" dynamodbav:"contentType"`
+	Key         string `json:"-" dynamodbav:"key"`
+	Width       int    `json:"width" dynamodbav:"width"`
+	Height      int    `json:"height" dynamodbav:"height"`
+	Size        int64  `json:"size" dynamodbav:"size"`
+	URL         string `json:"url,omitempty" dynamodbav:"-"`
 }
 
 func NewThumbnail(req ThumbnailRequest) *Thumbnail {
@@ -31,14 +79,18 @@ func NewThumbnail(req ThumbnailRequest) *Thumbnail {
 		VideoTitle:  req.VideoTitle,
 		Description: req.Description,
 		Style:       req.Style,
+		TemplateID:  req.TemplateID,
+		Status:      ThumbnailStatusReady,
 		CreatedAt:   time.Now(),
 	}
 }
 
 type User struct {
-	ID        string    `json:"id"`
-	Email     string    `json:"email"`
-	Plan      string    `json:"plan"`
-	Credits   int       `json:"credits"`
-	CreatedAt time.Time `json:"createdAt"`
-}
\ No newline at end of file
+	ID        string    `json:"id" dynamodbav:"id"`
+	Email     string    `json:"email" dynamodbav:"email"`
+	Plan      string    `json:"plan" dynamodbav:"plan"`
+	Credits   int       `json:"credits" dynamodbav:"credits"`
+	OrgID     string    `json:"orgId,omitempty" dynamodbav:"orgId,omitempty"`
+	Roles     []string  `json:"roles,omitempty" dynamodbav:"roles,omitempty,stringset"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Quota caps what a user may store. Zero means no limit.
+type Quota struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int   `json:"objects"`
+}
+
+// Usage is how much a user has stored, counting every object under their
+// thumbnails/ prefix including old revisions and trashed thumbnails
+type Usage struct {
+	UserID  string `json:"userId" dynamodbav:"userId"`
+	Bytes   int64  `json:"bytes" dynamodbav:"bytes"`
+	Objects int    `json:"objects" dynamodbav:"objects"`
+	// ReconciledAt is when the counters were last recomputed from the bucket
+	ReconciledAt *time.Time `json:"reconciledAt,omitempty" dynamodbav:"reconciledAt,omitempty"`
+	// Quota is filled in per response from the user's plan
+	Quota *Quota `json:"quota,omitempty" dynamodbav:"-"`
+}
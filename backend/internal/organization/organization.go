@@ -0,0 +1,430 @@
+package organization
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrNotOrganizationAdmin = errors.New("not an organization admin")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrAlreadyMember        = errors.New("user already belongs to an organization")
+	ErrInviteNotFound       = errors.New("no invite to this organization")
+	ErrNotMember            = errors.New("user doesn't belong to this organization")
+	// ErrLastAdmin is returned when the only admin tries to leave, which would
+	// leave nobody able to manage the organization
+	ErrLastAdmin = errors.New("the last admin can't leave the organization")
+)
+
+type OrganizationConfig struct {
+	DynamoClient *dynamodb.Client
+	TableName    string
+	// UsersTable holds each user's orgId membership attribute
+	UsersTable string
+}
+
+type OrganizationService struct {
+	dynamoClient *dynamodb.Client
+	tableName    string
+	usersTable   string
+}
+
+func NewOrganizationService(config OrganizationConfig) *OrganizationService {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("ORGANIZATIONS_TABLE")
+	}
+
+	usersTable := config.UsersTable
+	if usersTable == "" {
+		usersTable = os.Getenv("USERS_TABLE")
+	}
+
+	return &OrganizationService{
+		dynamoClient: config.DynamoClient,
+		tableName:    tableName,
+		usersTable:   usersTable,
+	}
+}
+
+// CreateOrganization creates an organization administered and joined by
+// owner. Users can only belong to one organization, so owners who already
+// belong to one get ErrAlreadyMember.
+func (s *OrganizationService) CreateOrganization(ctx context.Context, ownerID, name string) (*models.Organization, error) {
+	org := &models.Organization{
+		ID:        uuid.New().String(),
+		Name:      name,
+		AdminIDs:  []string{ownerID},
+		CreatedAt: time.Now(),
+	}
+
+	item, err := attributevalue.MarshalMap(org)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal organization: %w", err)
+	}
+
+	_, err = s.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Update: s.joinUpdate(ownerID, org.ID),
+			},
+			{
+				Put: &types.Put{
+					TableName: aws.String(s.tableName),
+					Item:      item,
+				},
+			},
+		},
+	})
+	if err != nil {
+		if failed := canceledBy(err); failed[0] {
+			return nil, ErrAlreadyMember
+		}
+		return nil, fmt.Errorf("failed to create organization: %w", err)
+	}
+
+	return org, nil
+}
+
+func (s *OrganizationService) GetOrganization(ctx context.Context, orgID string) (*models.Organization, error) {
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: orgID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	if resp.Item == nil {
+		return nil, ErrOrganizationNotFound
+	}
+
+	var org models.Organization
+	if err := attributevalue.UnmarshalMap(resp.Item, &org); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal organization: %w", err)
+	}
+
+	return &org, nil
+}
+
+// InviteMember invites userID to the organization; only admins may do this.
+// Nothing changes for the user until they accept with Join.
+func (s *OrganizationService) InviteMember(ctx context.Context, orgID, adminID, userID string) error {
+	if _, err := s.getAdministered(ctx, orgID, adminID); err != nil {
+		return err
+	}
+
+	_, err := s.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				ConditionCheck: &types.ConditionCheck{
+					TableName: aws.String(s.usersTable),
+					Key: map[string]types.AttributeValue{
+						"id": &types.AttributeValueMemberS{Value: userID},
+					},
+					ConditionExpression: aws.String("attribute_exists(id)"),
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName: aws.String(s.tableName),
+					Key: map[string]types.AttributeValue{
+						"id": &types.AttributeValueMemberS{Value: orgID},
+					},
+					UpdateExpression:    aws.String("ADD invitedIds :userIds"),
+					ConditionExpression: aws.String("attribute_exists(id)"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":userIds": &types.AttributeValueMemberSS{Value: []string{userID}},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		failed := canceledBy(err)
+		if failed[0] {
+			return ErrUserNotFound
+		}
+		if failed[1] {
+			return ErrOrganizationNotFound
+		}
+		return fmt.Errorf("failed to invite member: %w", err)
+	}
+
+	return nil
+}
+
+// Join accepts userID's invite to the organization. Users who already belong
+// to an organization have to Leave it first and get ErrAlreadyMember, so
+// nobody can be moved out of an organization that requires MFA.
+func (s *OrganizationService) Join(ctx context.Context, orgID, userID string) error {
+	_, err := s.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Update: s.joinUpdate(userID, orgID),
+			},
+			{
+				Update: &types.Update{
+					TableName: aws.String(s.tableName),
+					Key: map[string]types.AttributeValue{
+						"id": &types.AttributeValueMemberS{Value: orgID},
+					},
+					UpdateExpression:    aws.String("DELETE invitedIds :userIds"),
+					ConditionExpression: aws.String("contains(invitedIds, :userId)"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":userIds": &types.AttributeValueMemberSS{Value: []string{userID}},
+						":userId":  &types.AttributeValueMemberS{Value: userID},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		failed := canceledBy(err)
+		if failed[1] {
+			return ErrInviteNotFound
+		}
+		if failed[0] {
+			return ErrAlreadyMember
+		}
+		return fmt.Errorf("failed to join organization: %w", err)
+	}
+
+	return nil
+}
+
+// Leave takes userID out of the organization, and off its admins if they are
+// one. The organization's MFA requirement stops applying to them.
+func (s *OrganizationService) Leave(ctx context.Context, orgID, userID string) error {
+	org, err := s.GetOrganization(ctx, orgID)
+	if err != nil {
+		return err
+	}
+
+	items := []types.TransactWriteItem{
+		{
+			Update: &types.Update{
+				TableName: aws.String(s.usersTable),
+				Key: map[string]types.AttributeValue{
+					"id": &types.AttributeValueMemberS{Value: userID},
+				},
+				UpdateExpression:    aws.String("REMOVE orgId"),
+				ConditionExpression: aws.String("orgId = :orgId"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":orgId": &types.AttributeValueMemberS{Value: orgID},
+				},
+			},
+		},
+	}
+
+	if org.IsAdmin(userID) {
+		if len(org.AdminIDs) == 1 {
+			return ErrLastAdmin
+		}
+
+		adminIDs := make([]string, 0, len(org.AdminIDs)-1)
+		for _, id := range org.AdminIDs {
+			if id != userID {
+				adminIDs = append(adminIDs, id)
+			}
+		}
+		ids, err := attributevalue.Marshal(adminIDs)
+		if err != nil {
+			return fmt.Errorf("failed to marshal admins: %w", err)
+		}
+
+		// The size check stops two admins who leave at once from each
+		// writing back the other
+		items = append(items, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(s.tableName),
+				Key: map[string]types.AttributeValue{
+					"id": &types.AttributeValueMemberS{Value: orgID},
+				},
+				UpdateExpression:    aws.String("SET adminIds = :adminIds"),
+				ConditionExpression: aws.String("size(adminIds) = :count"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":adminIds": ids,
+					":count":    &types.AttributeValueMemberN{Value: strconv.Itoa(len(org.AdminIDs))},
+				},
+			},
+		})
+	}
+
+	_, err = s.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		failed := canceledBy(err)
+		if failed[0] {
+			return ErrNotMember
+		}
+		if failed[1] {
+			return errors.New("failed to leave organization: admins changed concurrently")
+		}
+		return fmt.Errorf("failed to leave organization: %w", err)
+	}
+
+	return nil
+}
+
+// SetRequireMFA turns the MFA requirement for all members on or off
+func (s *OrganizationService) SetRequireMFA(ctx context.Context, orgID, adminID string, required bool) (*models.Organization, error) {
+	org, err := s.getAdministered(ctx, orgID, adminID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: orgID},
+		},
+		UpdateExpression: aws.String("SET requireMfa = :required"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":required": &types.AttributeValueMemberBOOL{Value: required},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update organization: %w", err)
+	}
+
+	org.RequireMFA = required
+	return org, nil
+}
+
+// RemoveAdmin takes userID off the organization's admins, e.g. when their
+// account is deleted. It is not an error if they aren't one.
+func (s *OrganizationService) RemoveAdmin(ctx context.Context, orgID, userID string) error {
+	org, err := s.GetOrganization(ctx, orgID)
+	if errors.Is(err, ErrOrganizationNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !org.IsAdmin(userID) {
+		return nil
+	}
+
+	adminIDs := make([]string, 0, len(org.AdminIDs))
+	for _, id := range org.AdminIDs {
+		if id != userID {
+			adminIDs = append(adminIDs, id)
+		}
+	}
+
+	ids, err := attributevalue.Marshal(adminIDs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal admins: %w", err)
+	}
+
+	_, err = s.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: orgID},
+		},
+		UpdateExpression: aws.String("SET adminIds = :adminIds"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":adminIds": ids,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update organization: %w", err)
+	}
+
+	return nil
+}
+
+// RequiresMFA reports whether the user's organization requires MFA. It
+// implements auth.MFAPolicy.
+func (s *OrganizationService) RequiresMFA(ctx context.Context, userID string) (bool, error) {
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.usersTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: userID},
+		},
+		ProjectionExpression: aws.String("orgId"),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	var user models.User
+	if err := attributevalue.UnmarshalMap(resp.Item, &user); err != nil {
+		return false, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+
+	if user.OrgID == "" {
+		return false, nil
+	}
+
+	org, err := s.GetOrganization(ctx, user.OrgID)
+	if errors.Is(err, ErrOrganizationNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return org.RequireMFA, nil
+}
+
+func (s *OrganizationService) getAdministered(ctx context.Context, orgID, adminID string) (*models.Organization, error) {
+	org, err := s.GetOrganization(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !org.IsAdmin(adminID) {
+		return nil, ErrNotOrganizationAdmin
+	}
+
+	return org, nil
+}
+
+// joinUpdate sets the user's membership unless they already belong to an
+// organization
+func (s *OrganizationService) joinUpdate(userID, orgID string) *types.Update {
+	return &types.Update{
+		TableName: aws.String(s.usersTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: userID},
+		},
+		UpdateExpression:    aws.String("SET orgId = :orgId"),
+		ConditionExpression: aws.String("attribute_not_exists(orgId)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":orgId": &types.AttributeValueMemberS{Value: orgID},
+		},
+	}
+}
+
+// canceledBy reports which items of a canceled transaction failed their
+// condition check
+func canceledBy(err error) [2]bool {
+	var failed [2]bool
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return failed
+	}
+	for i, reason := range canceled.CancellationReasons {
+		if i < len(failed) && aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+			failed[i] = true
+		}
+	}
+	return failed
+}
@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxConflictRetries bounds the optimistic update loop when several instances
+// hit the same bucket at once
+const maxConflictRetries = 5
+
+type DynamoStoreConfig struct {
+	DynamoClient *dynamodb.Client
+	TableName    string
+}
+
+// DynamoStore keeps buckets in DynamoDB so that limits are shared across Lambda
+// instances. Items carry an expiresAt attribute for the table's TTL.
+type DynamoStore struct {
+	dynamoClient *dynamodb.Client
+	tableName    string
+}
+
+func NewDynamoStore(config DynamoStoreConfig) *DynamoStore {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("RATE_LIMIT_TABLE")
+	}
+
+	return &DynamoStore{
+		dynamoClient: config.DynamoClient,
+		tableName:    tableName,
+	}
+}
+
+func (s *DynamoStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (*Result, error) {
+	for attempt := 0; attempt < maxConflictRetries; attempt++ {
+		current, err := s.get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		next, result := take(current, limit, now)
+
+		// A full bucket is indistinguishable from a missing one, so let the
+		// item expire once it would have refilled completely
+		expiresAt := now.Add(result.Reset).Add(time.Minute)
+
+		err = s.put(ctx, key, current, next, expiresAt)
+		if err == nil {
+			return result, nil
+		}
+
+		var conflict *types.ConditionalCheckFailedException
+		if !errors.As(err, &conflict) {
+			return nil, fmt.Errorf("failed to update rate limit bucket: %w", err)
+		}
+	}
+
+	return nil, fmt.Errorf("failed to update rate limit bucket: too many concurrent updates")
+}
+
+func (s *DynamoStore) get(ctx context.Context, key string) (*bucket, error) {
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: key},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rate limit bucket: %w", err)
+	}
+
+	if resp.Item == nil {
+		return nil, nil
+	}
+
+	tokens, err := numberAttribute(resp.Item, "tokens")
+	if err != nil {
+		return nil, err
+	}
+	updatedAt, err := numberAttribute(resp.Item, "updatedAt")
+	if err != nil {
+		return nil, err
+	}
+
+	return &bucket{
+		Tokens:    tokens,
+		UpdatedAt: time.UnixMilli(int64(updatedAt)),
+	}, nil
+}
+
+// put writes next only if the stored bucket still matches previous
+func (s *DynamoStore) put(ctx context.Context, key string, previous, next *bucket, expiresAt time.Time) error {
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item: map[string]types.AttributeValue{
+			"id":        &types.AttributeValueMemberS{Value: key},
+			"tokens":    &types.AttributeValueMemberN{Value: strconv.FormatFloat(next.Tokens, 'f', -1, 64)},
+			"updatedAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(next.UpdatedAt.UnixMilli(), 10)},
+			"expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)},
+		},
+	}
+
+	if previous == nil {
+		input.ConditionExpression = aws.String("attribute_not_exists(id)")
+	} else {
+		input.ConditionExpression = aws.String("updatedAt = :updatedAt AND tokens = :tokens")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":updatedAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(previous.UpdatedAt.UnixMilli(), 10)},
+			":tokens":    &types.AttributeValueMemberN{Value: strconv.FormatFloat(previous.Tokens, 'f', -1, 64)},
+		}
+	}
+
+	_, err := s.dynamoClient.PutItem(ctx, input)
+	return err
+}
+
+func numberAttribute(item map[string]types.AttributeValue, name string) (float64, error) {
+	attr, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, fmt.Errorf("rate limit bucket is missing %s", name)
+	}
+
+	value, err := strconv.ParseFloat(attr.Value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+
+	return value, nil
+}
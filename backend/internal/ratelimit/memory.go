@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is how often MemoryStore drops buckets that have
+// refilled
+const memorySweepInterval = time.Minute
+
+// MemoryStore keeps buckets in process memory. It is meant for local runs and
+// tests; limits are not shared between Lambda instances.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	// nextSweep is when buckets that have refilled are dropped next
+	nextSweep time.Time
+}
+
+type memoryBucket struct {
+	*bucket
+	// fullAt is when the bucket will have refilled to capacity, after which
+	// it is no different from having no bucket at all
+	fullAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !now.Before(s.nextSweep) {
+		s.sweep(now)
+	}
+
+	var current *bucket
+	if b, ok := s.buckets[key]; ok {
+		current = b.bucket
+	}
+
+	next, result := take(current, limit, now)
+	s.buckets[key] = &memoryBucket{bucket: next, fullAt: now.Add(result.Reset)}
+
+	return result, nil
+}
+
+// sweep drops the buckets that are full again so that keys seen once, such as
+// client IPs, don't accumulate for the life of the process
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if !now.Before(b.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+	s.nextSweep = now.Add(memorySweepInterval)
+}
@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidLimit = errors.New("invalid rate limit")
+)
+
+// Limit describes a token bucket: RequestsPerMinute tokens are added every
+// minute and at most Burst tokens can be saved up.
+type Limit struct {
+	RequestsPerMinute int
+	Burst             int
+}
+
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.RequestsPerMinute)
+}
+
+func (l Limit) ratePerSecond() float64 {
+	return float64(l.RequestsPerMinute) / 60
+}
+
+// Result is the outcome of a single Allow call
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Headers returns the RateLimit-* and Retry-After response headers for the result
+func (r *Result) Headers() map[string]string {
+	headers := map[string]string{
+		"RateLimit-Limit":     strconv.Itoa(r.Limit),
+		"RateLimit-Remaining": strconv.Itoa(r.Remaining),
+		"RateLimit-Reset":     strconv.Itoa(ceilSeconds(r.Reset)),
+	}
+	if !r.Allowed {
+		headers["Retry-After"] = strconv.Itoa(ceilSeconds(r.RetryAfter))
+	}
+	return headers
+}
+
+// bucket is the persisted state of a token bucket
+type bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Store persists token buckets so that limits hold across processes
+type Store interface {
+	// Take refills the bucket for key, removes one token if available and
+	// returns the outcome.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (*Result, error)
+}
+
+type LimiterConfig struct {
+	Store Store
+	Now   func() time.Time
+}
+
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewLimiter(config LimiterConfig) *Limiter {
+	store := config.Store
+	if store == nil {
+		store = NewMemoryStore()
+	}
+
+	now := config.Now
+	if now == nil {
+		now = time.Now
+	}
+
+	return &Limiter{
+		store: store,
+		now:   now,
+	}
+}
+
+// Allow consumes one token from the bucket identified by key
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.RequestsPerMinute <= 0 {
+		return nil, ErrInvalidLimit
+	}
+
+	result, err := l.store.Take(ctx, key, limit, l.now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to take token: %w", err)
+	}
+
+	return result, nil
+}
+
+// take applies the token bucket algorithm to b and returns the new state
+func take(b *bucket, limit Limit, now time.Time) (*bucket, *Result) {
+	capacity := limit.capacity()
+	rate := limit.ratePerSecond()
+
+	// Refill tokens for the time elapsed since the last request
+	tokens := capacity
+	if b != nil {
+		elapsed := now.Sub(b.UpdatedAt).Seconds()
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		tokens = math.Min(capacity, b.Tokens+elapsed*rate)
+	}
+
+	result := &Result{
+		Limit: int(capacity),
+	}
+
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - tokens) / rate)
+	}
+
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = secondsToDuration((capacity - tokens) / rate)
+
+	return &bucket{Tokens: tokens, UpdatedAt: now}, result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,250 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestTake(t *testing.T) {
+	tests := []struct {
+		name          string
+		bucket        *bucket
+		limit         Limit
+		now           time.Time
+		wantAllowed   bool
+		wantRemaining int
+		wantTokens    float64
+		wantRetry     time.Duration
+	}{
+		{
+			name:          "new bucket starts full",
+			limit:         Limit{RequestsPerMinute: 60},
+			now:           start,
+			wantAllowed:   true,
+			wantRemaining: 59,
+			wantTokens:    59,
+		},
+		{
+			name:          "new bucket starts at burst",
+			limit:         Limit{RequestsPerMinute: 60, Burst: 10},
+			now:           start,
+			wantAllowed:   true,
+			wantRemaining: 9,
+			wantTokens:    9,
+		},
+		{
+			name:        "empty bucket is refused",
+			bucket:      &bucket{Tokens: 0, UpdatedAt: start},
+			limit:       Limit{RequestsPerMinute: 60},
+			now:         start,
+			wantAllowed: false,
+			wantTokens:  0,
+			wantRetry:   time.Second,
+		},
+		{
+			name:          "tokens refill with elapsed time",
+			bucket:        &bucket{Tokens: 0, UpdatedAt: start},
+			limit:         Limit{RequestsPerMinute: 60},
+			now:           start.Add(5 * time.Second),
+			wantAllowed:   true,
+			wantRemaining: 4,
+			wantTokens:    4,
+		},
+		{
+			name:        "partial refill is not enough",
+			bucket:      &bucket{Tokens: 0, UpdatedAt: start},
+			limit:       Limit{RequestsPerMinute: 60},
+			now:         start.Add(250 * time.Millisecond),
+			wantAllowed: false,
+			wantTokens:  0.25,
+			wantRetry:   750 * time.Millisecond,
+		},
+		{
+			name:          "refill stops at burst",
+			bucket:        &bucket{Tokens: 0, UpdatedAt: start},
+			limit:         Limit{RequestsPerMinute: 60, Burst: 10},
+			now:           start.Add(time.Hour),
+			wantAllowed:   true,
+			wantRemaining: 9,
+			wantTokens:    9,
+		},
+		{
+			name:          "clock going backwards adds nothing",
+			bucket:        &bucket{Tokens: 2, UpdatedAt: start},
+			limit:         Limit{RequestsPerMinute: 60},
+			now:           start.Add(-time.Minute),
+			wantAllowed:   true,
+			wantRemaining: 1,
+			wantTokens:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, result := take(tt.bucket, tt.limit, tt.now)
+
+			if result.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", result.Allowed, tt.wantAllowed)
+			}
+			if result.Remaining != tt.wantRemaining {
+				t.Errorf("Remaining = %d, want %d", result.Remaining, tt.wantRemaining)
+			}
+			if next.Tokens != tt.wantTokens {
+				t.Errorf("Tokens = %v, want %v", next.Tokens, tt.wantTokens)
+			}
+			if !next.UpdatedAt.Equal(tt.now) {
+				t.Errorf("UpdatedAt = %v, want %v", next.UpdatedAt, tt.now)
+			}
+			if result.RetryAfter != tt.wantRetry {
+				t.Errorf("RetryAfter = %v, want %v", result.RetryAfter, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestTakeBurst(t *testing.T) {
+	limit := Limit{RequestsPerMinute: 6, Burst: 3}
+
+	var b *bucket
+	var result *Result
+	for i := 0; i < 3; i++ {
+		b, result = take(b, limit, start)
+		if !result.Allowed {
+			t.Fatalf("request %d refused within burst", i+1)
+		}
+	}
+
+	b, result = take(b, limit, start)
+	if result.Allowed {
+		t.Fatal("request beyond burst allowed")
+	}
+	if want := 10 * time.Second; result.RetryAfter != want {
+		t.Errorf("RetryAfter = %v, want %v", result.RetryAfter, want)
+	}
+	if want := 30 * time.Second; result.Reset != want {
+		t.Errorf("Reset = %v, want %v", result.Reset, want)
+	}
+
+	// One token comes back every ten seconds
+	_, result = take(b, limit, start.Add(10*time.Second))
+	if !result.Allowed {
+		t.Error("request refused after refill")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []string
+		limit    Limit
+		advance  time.Duration
+		wantLast bool
+	}{
+		{
+			name:     "same key shares a bucket",
+			keys:     []string{"a", "a", "a"},
+			limit:    Limit{RequestsPerMinute: 60, Burst: 2},
+			wantLast: false,
+		},
+		{
+			name:     "keys have separate buckets",
+			keys:     []string{"a", "a", "b"},
+			limit:    Limit{RequestsPerMinute: 60, Burst: 2},
+			wantLast: true,
+		},
+		{
+			name:     "bucket refills between requests",
+			keys:     []string{"a", "a", "a"},
+			limit:    Limit{RequestsPerMinute: 60, Burst: 2},
+			advance:  time.Second,
+			wantLast: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			now := start
+
+			var result *Result
+			for _, key := range tt.keys {
+				var err error
+				result, err = store.Take(context.Background(), key, tt.limit, now)
+				if err != nil {
+					t.Fatalf("Take: %v", err)
+				}
+				now = now.Add(tt.advance)
+			}
+
+			if result.Allowed != tt.wantLast {
+				t.Errorf("last Allowed = %v, want %v", result.Allowed, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	now := start
+	limiter := NewLimiter(LimiterConfig{
+		Now: func() time.Time { return now },
+	})
+
+	if _, err := limiter.Allow(context.Background(), "a", Limit{}); err != ErrInvalidLimit {
+		t.Errorf("Allow with zero limit: err = %v, want %v", err, ErrInvalidLimit)
+	}
+
+	limit := Limit{RequestsPerMinute: 1}
+	result, err := limiter.Allow(context.Background(), "a", limit)
+	if err != nil || !result.Allowed {
+		t.Fatalf("first Allow = %+v, %v", result, err)
+	}
+	result, err = limiter.Allow(context.Background(), "a", limit)
+	if err != nil || result.Allowed {
+		t.Fatalf("second Allow = %+v, %v", result, err)
+	}
+
+	headers := result.Headers()
+	if headers["Retry-After"] != "60" {
+		t.Errorf("Retry-After = %q, want %q", headers["Retry-After"], "60")
+	}
+
+	now = now.Add(time.Minute)
+	result, err = limiter.Allow(context.Background(), "a", limit)
+	if err != nil || !result.Allowed {
+		t.Fatalf("Allow after a minute = %+v, %v", result, err)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{RequestsPerMinute: 60, Burst: 10}
+
+	// A single request refills in a second, a drained bucket in ten
+	for i := 0; i < 10; i++ {
+		if _, err := store.Take(context.Background(), "busy", limit, start); err != nil {
+			t.Fatalf("Take: %v", err)
+		}
+	}
+	if _, err := store.Take(context.Background(), "once", limit, start); err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+
+	// Sweeps happen at most once per interval
+	if _, err := store.Take(context.Background(), "other", limit, start.Add(5*time.Second)); err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if len(store.buckets) != 3 {
+		t.Fatalf("buckets before the sweep interval = %d, want 3", len(store.buckets))
+	}
+
+	// By the next sweep every bucket has refilled except the one just used
+	if _, err := store.Take(context.Background(), "other", limit, start.Add(memorySweepInterval)); err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if _, ok := store.buckets["other"]; len(store.buckets) != 1 || !ok {
+		t.Errorf("buckets after the sweep = %v, want only %q", len(store.buckets), "other")
+	}
+}
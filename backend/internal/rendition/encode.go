@@ -0,0 +1,122 @@
+package rendition
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sync"
+)
+
+const (
+	minQuality = 30
+	maxQuality = 92
+)
+
+// Encoder writes img at the given quality (1-100)
+type Encoder func(w io.Writer, img image.Image, quality int) error
+
+var (
+	webpMu      sync.RWMutex
+	webpEncoder Encoder
+)
+
+// RegisterWebPEncoder enables WebP renditions. The standard library has no
+// WebP encoder, so one has to be supplied by a build that links one.
+func RegisterWebPEncoder(encoder Encoder) {
+	webpMu.Lock()
+	defer webpMu.Unlock()
+	webpEncoder = encoder
+}
+
+type encodeFunc func(img image.Image) (*Rendition, error)
+
+func encoders() []encodeFunc {
+	funcs := []encodeFunc{encodeJPEG, encodePNG}
+
+	webpMu.RLock()
+	encoder := webpEncoder
+	webpMu.RUnlock()
+	if encoder != nil {
+		funcs = append(funcs, func(img image.Image) (*Rendition, error) {
+			return searchQuality(img, FormatWebP, "image/webp", encoder)
+		})
+	}
+
+	return funcs
+}
+
+func encodeJPEG(img image.Image) (*Rendition, error) {
+	return searchQuality(img, FormatJPEG, "image/jpeg", func(w io.Writer, img image.Image, quality int) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	})
+}
+
+// searchQuality binary-searches for the highest quality that fits MaxBytes
+func searchQuality(img image.Image, format, contentType string, encoder Encoder) (*Rendition, error) {
+	var best []byte
+	bestQuality := 0
+
+	low, high := minQuality, maxQuality
+	for low <= high {
+		quality := (low + high) / 2
+
+		var buf bytes.Buffer
+		if err := encoder(&buf, img, quality); err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", format, err)
+		}
+
+		if buf.Len() <= MaxBytes {
+			best, bestQuality = buf.Bytes(), quality
+			low = quality + 1
+		} else {
+			high = quality - 1
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("%w: %s at quality %d", ErrOverSizeLimit, format, minQuality)
+	}
+
+	return &Rendition{
+		Format:      format,
+		ContentType: contentType,
+		Data:        best,
+		Quality:     bestQuality,
+	}, nil
+}
+
+// encodePNG has no quality knob, so a PNG over MaxBytes is retried as a
+// dithered 256-colour image
+func encodePNG(img image.Image) (*Rendition, error) {
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode png: %w", err)
+	}
+
+	if buf.Len() > MaxBytes {
+		bounds := img.Bounds()
+		paletted := image.NewPaletted(bounds, palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, bounds, img, bounds.Min)
+
+		buf.Reset()
+		if err := encoder.Encode(&buf, paletted); err != nil {
+			return nil, fmt.Errorf("failed to encode png: %w", err)
+		}
+		if buf.Len() > MaxBytes {
+			return nil, fmt.Errorf("%w: png", ErrOverSizeLimit)
+		}
+	}
+
+	return &Rendition{
+		Format:      FormatPNG,
+		ContentType: "image/png",
+		Data:        buf.Bytes(),
+	}, nil
+}
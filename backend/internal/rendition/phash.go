@@ -0,0 +1,67 @@
+package rendition
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+)
+
+// DifferenceHash is a 64-bit perceptual hash of img: the image is shrunk to a
+// 9x8 greyscale grid and each bit records whether a cell is brighter than its
+// right-hand neighbour. Re-encoding, resizing and small edits barely change
+// it, so a small HashDistance means the images look alike.
+func DifferenceHash(img image.Image) uint64 {
+	const w, h = 9, 8
+
+	bounds := img.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+
+	var grid [h][w]uint32
+	for gy := 0; gy < h; gy++ {
+		y0 := bounds.Min.Y + gy*sh/h
+		y1 := max(bounds.Min.Y+(gy+1)*sh/h, y0+1)
+		for gx := 0; gx < w; gx++ {
+			x0 := bounds.Min.X + gx*sw/w
+			x1 := max(bounds.Min.X+(gx+1)*sw/w, x0+1)
+
+			// Sample a few points per cell rather than every pixel
+			var sum, n uint32
+			for y := y0; y < y1; y += max((y1-y0)/4, 1) {
+				for x := x0; x < x1; x += max((x1-x0)/4, 1) {
+					r, g, b, _ := img.At(x, y).RGBA()
+					sum += (299*r + 587*g + 114*b) / 1000
+					n++
+				}
+			}
+			grid[gy][gx] = sum / n
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if grid[y][x] > grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// HashDistance is the number of differing bits between two hashes, from 0 for
+// identical-looking images to 64
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash and ParseHash convert a hash to and from 16 hex digits
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func ParseHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
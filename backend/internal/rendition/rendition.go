@@ -0,0 +1,129 @@
+// Package rendition turns a generated or uploaded image into the fixed set of
+// sizes and formats stored for every thumbnail.
+package rendition
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrImageTooLarge     = errors.New("image dimensions too large")
+	ErrOverSizeLimit     = errors.New("rendition exceeds size limit")
+)
+
+const (
+	// MaxBytes is YouTube's custom thumbnail size limit
+	MaxBytes = 2 << 20
+	// MaxInputPixels guards against decompression bombs
+	MaxInputPixels = 50_000_000
+
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+)
+
+// Size is one output size. Inputs with another aspect ratio are scaled to
+// cover it and centre-cropped.
+type Size struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+var (
+	// Master is the canonical 16:9 thumbnail uploaded to YouTube
+	Master = Size{Name: "master", Width: 1280, Height: 720}
+	Medium = Size{Name: "medium", Width: 640, Height: 360}
+	Small  = Size{Name: "small", Width: 320, Height: 180}
+
+	Sizes = []Size{Master, Medium, Small}
+)
+
+// Rendition is one encoded output
+type Rendition struct {
+	Size
+	Format      string
+	ContentType string
+	Data        []byte
+	// Quality is the encoder quality chosen by the size search, 0 for
+	// lossless formats
+	Quality int
+}
+
+// Extension returns the file extension for the rendition's format
+func (r *Rendition) Extension() string {
+	switch r.Format {
+	case FormatJPEG:
+		return ".jpg"
+	default:
+		return "." + r.Format
+	}
+}
+
+// Sniff reports the content type of data from its leading bytes
+func Sniff(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+// Decode decodes data with whichever image decoders are registered, refusing
+// anything larger than MaxInputPixels before allocating it
+func Decode(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, Sniff(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image header: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, fmt.Errorf("failed to decode image: empty image")
+	}
+	if config.Width*config.Height > MaxInputPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	return img, nil
+}
+
+// Render decodes data and produces every size in JPEG and PNG, plus WebP when
+// an encoder is registered. Each rendition stays within MaxBytes.
+func Render(data []byte) ([]*Rendition, error) {
+	src, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return RenderImage(src)
+}
+
+// RenderImage is Render for an image that has already been decoded
+func RenderImage(src image.Image) ([]*Rendition, error) {
+	renditions := make([]*Rendition, 0, len(Sizes)*3)
+	for _, size := range Sizes {
+		img := Cover(src, size.Width, size.Height)
+
+		for _, encode := range encoders() {
+			rendition, err := encode(img)
+			if err != nil {
+				return nil, err
+			}
+			rendition.Size = size
+			renditions = append(renditions, rendition)
+		}
+	}
+
+	return renditions, nil
+}
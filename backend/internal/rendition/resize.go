@@ -0,0 +1,66 @@
+package rendition
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Cover scales src to fill width x height, cropping the overflow evenly from
+// both sides. Downscaling averages every source pixel under the destination
+// pixel; upscaling samples the nearest one.
+func Cover(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+
+	// Pick the source window with the target aspect ratio
+	cw, ch := sw, sh
+	if sw*height > sh*width {
+		cw = sh * width / height
+	} else {
+		ch = sw * height / width
+	}
+	if cw < 1 {
+		cw = 1
+	}
+	if ch < 1 {
+		ch = 1
+	}
+	x0 := bounds.Min.X + (sw-cw)/2
+	y0 := bounds.Min.Y + (sh-ch)/2
+
+	// Work on RGBA so pixels can be read without interface calls
+	rgba := image.NewRGBA(image.Rect(0, 0, cw, ch))
+	draw.Draw(rgba, rgba.Bounds(), src, image.Pt(x0, y0), draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for dy := 0; dy < height; dy++ {
+		sy0 := dy * ch / height
+		sy1 := max((dy+1)*ch/height, sy0+1)
+
+		for dx := 0; dx < width; dx++ {
+			sx0 := dx * cw / width
+			sx1 := max((dx+1)*cw/width, sx0+1)
+
+			var r, g, b, a, n int
+			for sy := sy0; sy < sy1; sy++ {
+				i := rgba.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					r += int(rgba.Pix[i])
+					g += int(rgba.Pix[i+1])
+					b += int(rgba.Pix[i+2])
+					a += int(rgba.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+
+			o := dst.PixOffset(dx, dy)
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(b / n)
+			dst.Pix[o+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,198 @@
+package rendition
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+)
+
+var ErrContentTypeMismatch = errors.New("image content does not match its declared type")
+
+const (
+	// MaxSourceDimension caps either side of an uploaded source image
+	MaxSourceDimension = 8192
+	// MaxSourcePixels caps the total size, so that the decoded image and the
+	// copies made while orienting it fit in the function's memory. A tiny
+	// file can still declare a huge image.
+	MaxSourcePixels = 25_000_000
+	// sourceQuality is high enough that re-encoding a photo is not visible
+	sourceQuality = 92
+)
+
+// sourceContentTypes are the formats accepted as source images. WebP has no
+// decoder in the standard library, so it can't be sanitized.
+var sourceContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+// Source is a sanitized source image
+type Source struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Sanitize checks an uploaded source image and re-encodes it. The content is
+// sniffed rather than trusted, and its dimensions and pixel count are checked
+// before the pixels are allocated. JPEGs are turned upright according to their EXIF
+// orientation. Re-encoding drops every metadata segment and chunk, including
+// EXIF and GPS data.
+func Sanitize(data []byte, contentType string) (*Source, error) {
+	sniffed := Sniff(data)
+	if !sourceContentTypes[sniffed] {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, sniffed)
+	}
+	if contentType != "" && contentType != sniffed {
+		return nil, fmt.Errorf("%w: declared %s, found %s", ErrContentTypeMismatch, contentType, sniffed)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image header: %w", err)
+	}
+	if config.Width > MaxSourceDimension || config.Height > MaxSourceDimension ||
+		config.Width*config.Height > MaxSourcePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	src, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if sniffed == "image/jpeg" {
+		img := Orient(src, jpegOrientation(data))
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: sourceQuality})
+		src = img
+	} else {
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode source image: %w", err)
+	}
+
+	bounds := src.Bounds()
+	return &Source{
+		Data:        buf.Bytes(),
+		ContentType: sniffed,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+	}, nil
+}
+
+// Orient applies an EXIF orientation (1-8) so the image displays upright.
+// Unknown orientations leave it as it is.
+func Orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	// Orientations 5-8 swap the axes
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := 0; dy < dh; dy++ {
+		for dx := 0; dx < dw; dx++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-dx, dy
+			case 3: // rotated 180
+				sx, sy = w-1-dx, h-1-dy
+			case 4: // mirrored vertically
+				sx, sy = dx, h-1-dy
+			case 5: // transposed
+				sx, sy = dy, dx
+			case 6: // rotated 90 clockwise
+				sx, sy = dy, h-1-dx
+			case 7: // transversed
+				sx, sy = w-1-dy, h-1-dx
+			case 8: // rotated 90 anticlockwise
+				sx, sy = w-1-dy, dx
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], rgba.Pix[rgba.PixOffset(sx, sy):][:4])
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation reads the orientation tag from a JPEG's EXIF segment,
+// returning 1 (upright) when there is none or it can't be parsed
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return 1
+		}
+		marker := data[i+1]
+		// Metadata only comes before the image data
+		if marker == 0xda || marker == 0xd9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+
+	return 1
+}
+
+// exifOrientation finds tag 0x0112 in the first IFD of a TIFF structure
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 1
+}
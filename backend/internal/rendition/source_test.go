@@ -0,0 +1,53 @@
+package rendition
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// pngHeader returns a PNG whose header declares width x height. Only the
+// header is valid, which is all Sanitize may read of an oversized image.
+func pngHeader(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	data := buf.Bytes()
+
+	// IHDR follows the 8 byte signature: length, type, width, height, ...
+	ihdr := data[8+8 : 8+8+13]
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(height))
+	binary.BigEndian.PutUint32(data[8+8+13:], crc32.ChecksumIEEE(data[8+4:8+8+13]))
+
+	return data
+}
+
+func TestSanitizeSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		wantTooLarge  bool
+	}{
+		{name: "side too long", width: MaxSourceDimension + 1, height: 1, wantTooLarge: true},
+		{name: "too many pixels", width: MaxSourceDimension, height: MaxSourceDimension, wantTooLarge: true},
+		{name: "just too many pixels", width: 5000, height: MaxSourcePixels/5000 + 1, wantTooLarge: true},
+		{name: "within limits", width: 1, height: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Sanitize(pngHeader(t, tt.width, tt.height), "image/png")
+			if got := errors.Is(err, ErrImageTooLarge); got != tt.wantTooLarge {
+				t.Errorf("Sanitize error = %v, want ErrImageTooLarge: %v", err, tt.wantTooLarge)
+			}
+		})
+	}
+}
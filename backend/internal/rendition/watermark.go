@@ -0,0 +1,104 @@
+package rendition
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+// previewQuality is fixed rather than searched for, since previews are far
+// below MaxBytes
+const previewQuality = 80
+
+// watermarkText is tiled across previews
+const watermarkText = "PREVIEW"
+
+// glyphs are 5x7 bitmaps for the letters of watermarkText, one row per entry
+// with the leftmost pixel in the highest bit
+var glyphs = map[rune][7]uint8{
+	'P': {0x1e, 0x11, 0x11, 0x1e, 0x10, 0x10, 0x10},
+	'R': {0x1e, 0x11, 0x11, 0x1e, 0x14, 0x12, 0x11},
+	'E': {0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x1f},
+	'V': {0x11, 0x11, 0x11, 0x11, 0x11, 0x0a, 0x04},
+	'I': {0x0e, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'W': {0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0a},
+}
+
+// Preview renders src at the Medium size with a watermark tiled across it,
+// for showing to people who shouldn't get a usable copy
+func Preview(src image.Image) (*Rendition, error) {
+	img := Cover(src, Medium.Width, Medium.Height)
+	Watermark(img)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: previewQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode preview: %w", err)
+	}
+
+	return &Rendition{
+		Size:        Medium,
+		Format:      FormatJPEG,
+		ContentType: "image/jpeg",
+		Data:        buf.Bytes(),
+		Quality:     previewQuality,
+	}, nil
+}
+
+// Watermark tiles watermarkText over img in staggered rows. Each letter is
+// blended half-transparent white over a darker shadow so it shows on both
+// light and dark images.
+func Watermark(img *image.RGBA) {
+	bounds := img.Bounds()
+	// Scale the letters with the image so the mark covers the same share of
+	// any size
+	scale := bounds.Dx() / 160
+	if scale < 1 {
+		scale = 1
+	}
+	width := (len(watermarkText)*6 - 1) * scale
+	height := 7 * scale
+	stepX := width + 12*scale
+	stepY := height + 16*scale
+
+	for row, y := 0, bounds.Min.Y+4*scale; y < bounds.Max.Y; row, y = row+1, y+stepY {
+		// Odd rows are shifted half a step so the text forms a lattice
+		offset := (row % 2) * stepX / 2
+		for x := bounds.Min.X - offset; x < bounds.Max.X; x += stepX {
+			drawText(img, x+scale, y+scale, scale, color.RGBA{0, 0, 0, 64})
+			drawText(img, x, y, scale, color.RGBA{255, 255, 255, 96})
+		}
+	}
+}
+
+// drawText blends watermarkText onto img with its top-left corner at x, y
+func drawText(img *image.RGBA, x, y, scale int, c color.RGBA) {
+	for i, r := range watermarkText {
+		glyph := glyphs[r]
+		gx := x + i*6*scale
+		for gy, bits := range glyph {
+			for col := 0; col < 5; col++ {
+				if bits&(0x10>>col) == 0 {
+					continue
+				}
+				fillBlend(img, image.Rect(gx+col*scale, y+gy*scale, gx+(col+1)*scale, y+(gy+1)*scale), c)
+			}
+		}
+	}
+}
+
+// fillBlend draws c over rect with straight alpha blending, clipped to img
+func fillBlend(img *image.RGBA, rect image.Rectangle, c color.RGBA) {
+	rect = rect.Intersect(img.Bounds())
+	a := uint32(c.A)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			p := img.Pix[i : i+3 : i+3]
+			p[0] = uint8((uint32(c.R)*a + uint32(p[0])*(255-a)) / 255)
+			p[1] = uint8((uint32(c.G)*a + uint32(p[1])*(255-a)) / 255)
+			p[2] = uint8((uint32(c.B)*a + uint32(p[2])*(255-a)) / 255)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Package router dispatches API Gateway proxy requests to handlers by method
+// and path template. The same route table is exported for the deployment, so
+// the handlers and the gateway routes can't disagree.
+package router
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type Handler func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// Middleware wraps a handler, e.g. to enforce rate limits. Middleware runs for
+// every request, including ones that match no route.
+type Middleware func(next Handler) Handler
+
+// Route maps a method and a path template such as "/thumbnails/{id}" to a
+// handler
+type Route struct {
+	Method string
+	Path   string
+	// Public routes are deployed without the gateway authorizer; their
+	// handlers authenticate the caller themselves, if at all
+	Public  bool
+	Handler Handler
+}
+
+// Spec is the deployment view of a route
+type Spec struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Public bool   `json:"public,omitempty"`
+}
+
+type Config struct {
+	Routes     []Route
+	Middleware []Middleware
+	// NotFound answers requests whose path matches no route
+	NotFound Handler
+	// MethodNotAllowed builds the response for a path that exists under other
+	// methods; the router adds the Allow header
+	MethodNotAllowed func(allowed []string) events.APIGatewayProxyResponse
+}
+
+type Router struct {
+	routes           []*compiledRoute
+	middleware       []Middleware
+	notFound         Handler
+	methodNotAllowed func(allowed []string) events.APIGatewayProxyResponse
+}
+
+type compiledRoute struct {
+	Route
+	segments []string
+}
+
+type routeKey struct{}
+
+func New(config Config) *Router {
+	r := &Router{
+		middleware:       config.Middleware,
+		notFound:         config.NotFound,
+		methodNotAllowed: config.MethodNotAllowed,
+	}
+	if r.notFound == nil {
+		r.notFound = func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			return textResponse(http.StatusNotFound), nil
+		}
+	}
+	if r.methodNotAllowed == nil {
+		r.methodNotAllowed = func(allowed []string) events.APIGatewayProxyResponse {
+			return textResponse(http.StatusMethodNotAllowed)
+		}
+	}
+
+	for _, route := range config.Routes {
+		r.routes = append(r.routes, &compiledRoute{
+			Route:    route,
+			segments: split(route.Path),
+		})
+	}
+
+	return r
+}
+
+// Routes lists every route for deployment, in table order
+func (r *Router) Routes() []Spec {
+	specs := make([]Spec, 0, len(r.routes))
+	for _, route := range r.routes {
+		specs = append(specs, Spec{Method: route.Method, Path: route.Path, Public: route.Public})
+	}
+	return specs
+}
+
+// RouteFromContext returns the route a request matched, or nil when it
+// matched none
+func RouteFromContext(ctx context.Context) *Route {
+	route, _ := ctx.Value(routeKey{}).(*Route)
+	return route
+}
+
+// Handle matches the request's path, runs the middleware chain and dispatches
+// to the route's handler. Path parameters are set on the request and its
+// Resource is set to the matched template.
+func (r *Router) Handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	candidates, params := r.match(request.Path)
+
+	var matched *compiledRoute
+	allowed := make([]string, 0, len(candidates))
+	for _, route := range candidates {
+		if route.Method == request.HTTPMethod {
+			matched = route
+			break
+		}
+		if !contains(allowed, route.Method) {
+			allowed = append(allowed, route.Method)
+		}
+	}
+
+	var handler Handler
+	switch {
+	case matched != nil:
+		ctx = context.WithValue(ctx, routeKey{}, &matched.Route)
+		request.Resource = matched.Path
+		request.PathParameters = params[matched]
+		handler = matched.Handler
+	case len(candidates) > 0:
+		sort.Strings(allowed)
+		handler = func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			response := r.methodNotAllowed(allowed)
+			if response.Headers == nil {
+				response.Headers = map[string]string{}
+			}
+			response.Headers["Allow"] = strings.Join(allowed, ", ")
+			return response, nil
+		}
+	default:
+		handler = r.notFound
+	}
+
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+	return handler(ctx, request)
+}
+
+// match finds the routes whose template matches path, most specific first.
+// Where templates overlap a literal segment beats a parameter, so
+// "PUT /collections/order" wins over "PUT /collections/{id}", while
+// "GET /collections/order" still reaches "GET /collections/{id}" as the
+// gateway would route it.
+func (r *Router) match(path string) ([]*compiledRoute, map[*compiledRoute]map[string]string) {
+	segments := split(path)
+
+	var matches []*compiledRoute
+	params := map[*compiledRoute]map[string]string{}
+	for _, route := range r.routes {
+		if p, ok := route.bind(segments); ok {
+			matches = append(matches, route)
+			params[route] = p
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return moreSpecific(matches[i], matches[j])
+	})
+	return matches, params
+}
+
+// bind matches segments against the route's template and collects the
+// parameter values
+func (c *compiledRoute) bind(segments []string) (map[string]string, bool) {
+	if len(segments) != len(c.segments) {
+		return nil, false
+	}
+
+	var params map[string]string
+	for i, segment := range c.segments {
+		if name, ok := paramName(segment); ok {
+			if segments[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[name] = segments[i]
+			continue
+		}
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+func moreSpecific(a, b *compiledRoute) bool {
+	for i := range a.segments {
+		_, aParam := paramName(a.segments[i])
+		_, bParam := paramName(b.segments[i])
+		if aParam != bParam {
+			return !aParam
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func paramName(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// split breaks a path into segments, ignoring a trailing slash
+func split(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+func textResponse(statusCode int) events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		},
+		Body: http.StatusText(statusCode),
+	}
+}
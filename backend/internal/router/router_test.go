@@ -0,0 +1,254 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// named answers with the route's name so tests can tell which one ran
+func named(name string) Handler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: name}, nil
+	}
+}
+
+func testRoutes() []Route {
+	return []Route{
+		{Method: "GET", Path: "/collections/{id}", Handler: named("get collection")},
+		{Method: "PUT", Path: "/collections/{id}", Handler: named("update collection")},
+		{Method: "PUT", Path: "/collections/order", Handler: named("reorder collections")},
+		{Method: "DELETE", Path: "/collections/{id}", Handler: named("delete collection")},
+		{Method: "GET", Path: "/collections/{id}/thumbnails/{thumbnailId}", Handler: named("get item")},
+		{Method: "GET", Path: "/health", Public: true, Handler: named("health")},
+	}
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		wantStatus   int
+		wantBody     string
+		wantResource string
+		wantParams   map[string]string
+		wantAllow    string
+	}{
+		{
+			name:         "static route",
+			method:       "GET",
+			path:         "/health",
+			wantStatus:   http.StatusOK,
+			wantBody:     "health",
+			wantResource: "/health",
+		},
+		{
+			name:         "trailing slash",
+			method:       "GET",
+			path:         "/health/",
+			wantStatus:   http.StatusOK,
+			wantBody:     "health",
+			wantResource: "/health",
+		},
+		{
+			name:         "path parameter",
+			method:       "GET",
+			path:         "/collections/c1",
+			wantStatus:   http.StatusOK,
+			wantBody:     "get collection",
+			wantResource: "/collections/{id}",
+			wantParams:   map[string]string{"id": "c1"},
+		},
+		{
+			name:         "several path parameters",
+			method:       "GET",
+			path:         "/collections/c1/thumbnails/t1",
+			wantStatus:   http.StatusOK,
+			wantBody:     "get item",
+			wantResource: "/collections/{id}/thumbnails/{thumbnailId}",
+			wantParams:   map[string]string{"id": "c1", "thumbnailId": "t1"},
+		},
+		{
+			name:         "static segment beats a parameter",
+			method:       "PUT",
+			path:         "/collections/order",
+			wantStatus:   http.StatusOK,
+			wantBody:     "reorder collections",
+			wantResource: "/collections/order",
+		},
+		{
+			name:         "parameter still matches under other methods",
+			method:       "GET",
+			path:         "/collections/order",
+			wantStatus:   http.StatusOK,
+			wantBody:     "get collection",
+			wantResource: "/collections/{id}",
+			wantParams:   map[string]string{"id": "order"},
+		},
+		{
+			name:       "method not allowed",
+			method:     "POST",
+			path:       "/collections/c1",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantAllow:  "DELETE, GET, PUT",
+		},
+		{
+			name:       "method not allowed across overlapping templates",
+			method:     "POST",
+			path:       "/collections/order",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantAllow:  "DELETE, GET, PUT",
+		},
+		{
+			name:       "unknown path",
+			method:     "GET",
+			path:       "/missing",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "empty parameter",
+			method:     "GET",
+			path:       "/collections//thumbnails/t1",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "extra segment",
+			method:     "GET",
+			path:       "/collections/c1/extra",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got events.APIGatewayProxyRequest
+			record := func(next Handler) Handler {
+				return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+					got = request
+					return next(ctx, request)
+				}
+			}
+			// The recorder sits behind the router, so it sees what the
+			// handler sees
+			routes := testRoutes()
+			for i := range routes {
+				routes[i].Handler = record(routes[i].Handler)
+			}
+			r := New(Config{Routes: routes})
+
+			response, err := r.Handle(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: tt.method, Path: tt.path})
+			if err != nil {
+				t.Fatalf("Handle: %v", err)
+			}
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", response.StatusCode, tt.wantStatus)
+			}
+			if response.Headers["Allow"] != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", response.Headers["Allow"], tt.wantAllow)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if response.Body != tt.wantBody {
+				t.Errorf("handler = %q, want %q", response.Body, tt.wantBody)
+			}
+			if got.Resource != tt.wantResource {
+				t.Errorf("Resource = %q, want %q", got.Resource, tt.wantResource)
+			}
+			if len(got.PathParameters) != len(tt.wantParams) {
+				t.Errorf("PathParameters = %v, want %v", got.PathParameters, tt.wantParams)
+			}
+			for name, want := range tt.wantParams {
+				if got.PathParameters[name] != want {
+					t.Errorf("PathParameters[%q] = %q, want %q", name, got.PathParameters[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleCustomResponses(t *testing.T) {
+	r := New(Config{
+		Routes: testRoutes(),
+		NotFound: func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusNotFound, Body: "custom not found"}, nil
+		},
+		MethodNotAllowed: func(allowed []string) events.APIGatewayProxyResponse {
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusMethodNotAllowed, Body: strings.Join(allowed, " ")}
+		},
+	})
+
+	response, _ := r.Handle(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/missing"})
+	if response.Body != "custom not found" {
+		t.Errorf("not found body = %q, want the custom handler's", response.Body)
+	}
+
+	response, _ = r.Handle(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/health"})
+	if response.Body != "GET" || response.Headers["Allow"] != "GET" {
+		t.Errorf("method not allowed = %q with Allow %q, want %q for both", response.Body, response.Headers["Allow"], "GET")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+				route := "none"
+				if matched := RouteFromContext(ctx); matched != nil {
+					route = matched.Path
+				}
+				calls = append(calls, name+" "+route)
+				return next(ctx, request)
+			}
+		}
+	}
+
+	routes := testRoutes()
+	routes = append(routes, Route{Method: "GET", Path: "/trace", Handler: func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		calls = append(calls, "handler")
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	}})
+	r := New(Config{
+		Routes:     routes,
+		Middleware: []Middleware{trace("outer"), trace("inner")},
+	})
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/trace", want: "outer /trace, inner /trace, handler"},
+		// Middleware runs for unmatched requests too, without a route
+		{path: "/missing", want: "outer none, inner none"},
+	}
+
+	for _, tt := range tests {
+		calls = nil
+		if _, err := r.Handle(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: tt.path}); err != nil {
+			t.Fatalf("Handle %s: %v", tt.path, err)
+		}
+		if got := strings.Join(calls, ", "); got != tt.want {
+			t.Errorf("calls for %s = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	specs := New(Config{Routes: testRoutes()}).Routes()
+
+	if len(specs) != len(testRoutes()) {
+		t.Fatalf("Routes returned %d specs, want %d", len(specs), len(testRoutes()))
+	}
+	if specs[2] != (Spec{Method: "PUT", Path: "/collections/order"}) {
+		t.Errorf("Routes()[2] = %+v, want table order", specs[2])
+	}
+	if last := specs[len(specs)-1]; !last.Public {
+		t.Errorf("Routes() dropped the public flag: %+v", last)
+	}
+}
@@ -0,0 +1,324 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/models"
+)
+
+var ErrEmptyQuery = errors.New("search query has no searchable words")
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 50
+	// MaxQueryTerms caps the words of a query that are looked up
+	MaxQueryTerms = 8
+	// maxEntriesPerTerm bounds how many index entries one prefix can pull in
+	maxEntriesPerTerm = 1000
+	// prefixFactor discounts a prefix match against an exact word match
+	prefixFactor = 0.5
+	// batchSize is the most items a BatchWriteItem call accepts
+	batchSize = 25
+)
+
+// entry is one row of the inverted index. Entries are keyed by user and then
+// "token#thumbnailId", so a prefix search is a single begins_with query.
+type entry struct {
+	UserID      string `dynamodbav:"userId"`
+	Entry       string `dynamodbav:"entry"`
+	Token       string `dynamodbav:"token"`
+	ThumbnailID string `dynamodbav:"thumbnailId"`
+	Weight      int    `dynamodbav:"weight"`
+}
+
+// Hit is one matching thumbnail and its relevance
+type Hit struct {
+	ThumbnailID string
+	Score       float64
+}
+
+type IndexConfig struct {
+	DynamoClient *dynamodb.Client
+	TableName    string
+}
+
+// Index is an inverted index over thumbnail text stored in DynamoDB
+type Index struct {
+	dynamoClient *dynamodb.Client
+	tableName    string
+}
+
+func NewIndex(config IndexConfig) *Index {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("SEARCH_INDEX_TABLE")
+	}
+
+	return &Index{
+		dynamoClient: config.DynamoClient,
+		tableName:    tableName,
+	}
+}
+
+// Index writes a thumbnail's terms, replacing whatever was indexed for it
+// before
+func (i *Index) Index(ctx context.Context, thumbnail *models.Thumbnail) error {
+	existing, err := i.entriesFor(ctx, thumbnail.ID)
+	if err != nil {
+		return err
+	}
+
+	terms := Terms(thumbnail)
+	var requests []types.WriteRequest
+	for _, old := range existing {
+		if _, ok := terms[old.Token]; ok && old.UserID == thumbnail.UserID {
+			continue
+		}
+		requests = append(requests, deleteRequest(old))
+	}
+	for token, weight := range terms {
+		item, err := attributevalue.MarshalMap(entry{
+			UserID:      thumbnail.UserID,
+			Entry:       token + "#" + thumbnail.ID,
+			Token:       token,
+			ThumbnailID: thumbnail.ID,
+			Weight:      weight,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal index entry: %w", err)
+		}
+		requests = append(requests, types.WriteRequest{
+			PutRequest: &types.PutRequest{Item: item},
+		})
+	}
+
+	return i.batchWrite(ctx, requests)
+}
+
+// Remove drops every index entry of a thumbnail
+func (i *Index) Remove(ctx context.Context, thumbnailID string) error {
+	existing, err := i.entriesFor(ctx, thumbnailID)
+	if err != nil {
+		return err
+	}
+
+	requests := make([]types.WriteRequest, 0, len(existing))
+	for _, old := range existing {
+		requests = append(requests, deleteRequest(old))
+	}
+
+	return i.batchWrite(ctx, requests)
+}
+
+// RemoveUser drops the user's whole index partition
+func (i *Index) RemoveUser(ctx context.Context, userID string) error {
+	var requests []types.WriteRequest
+
+	paginator := dynamodb.NewQueryPaginator(i.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(i.tableName),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userID},
+		},
+		ProjectionExpression: aws.String("userId, entry"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to query search index: %w", err)
+		}
+
+		var items []entry
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return fmt.Errorf("failed to unmarshal index entries: %w", err)
+		}
+		for _, e := range items {
+			requests = append(requests, deleteRequest(e))
+		}
+	}
+
+	return i.batchWrite(ctx, requests)
+}
+
+// Search finds the user's thumbnails matching every word of query, each word
+// also matching as a prefix of an indexed word, best match first
+func (i *Index) Search(ctx context.Context, userID, query string, limit int) ([]Hit, error) {
+	if limit < 1 || limit > MaxLimit {
+		limit = DefaultLimit
+	}
+
+	words := unique(Tokenize(query))
+	if len(words) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	if len(words) > MaxQueryTerms {
+		words = words[:MaxQueryTerms]
+	}
+
+	var scores map[string]float64
+	for _, word := range words {
+		matches, err := i.match(ctx, userID, word)
+		if err != nil {
+			return nil, err
+		}
+
+		// Every word must match, so only thumbnails matched so far survive
+		if scores == nil {
+			scores = matches
+			continue
+		}
+		for id, score := range scores {
+			if extra, ok := matches[id]; ok {
+				scores[id] = score + extra
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ThumbnailID: id, Score: score})
+	}
+	sort.Slice(hits, func(a, b int) bool {
+		if hits[a].Score != hits[b].Score {
+			return hits[a].Score > hits[b].Score
+		}
+		return hits[a].ThumbnailID < hits[b].ThumbnailID
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	return hits, nil
+}
+
+// match scores every thumbnail with a token starting with word. An exact
+// match scores its full weight; a longer word scores less the more of it the
+// prefix leaves out.
+func (i *Index) match(ctx context.Context, userID, word string) (map[string]float64, error) {
+	scores := map[string]float64{}
+
+	paginator := dynamodb.NewQueryPaginator(i.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(i.tableName),
+		KeyConditionExpression: aws.String("userId = :userId AND begins_with(entry, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userID},
+			":prefix": &types.AttributeValueMemberS{Value: word},
+		},
+	})
+
+	read := 0
+	for paginator.HasMorePages() && read < maxEntriesPerTerm {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query search index: %w", err)
+		}
+
+		var entries []entry
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &entries); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal index entries: %w", err)
+		}
+		read += len(entries)
+
+		for _, e := range entries {
+			score := float64(e.Weight)
+			if e.Token != word {
+				score *= prefixFactor * float64(len(word)) / float64(len(e.Token))
+			}
+			if score > scores[e.ThumbnailID] {
+				scores[e.ThumbnailID] = score
+			}
+		}
+	}
+
+	return scores, nil
+}
+
+func (i *Index) entriesFor(ctx context.Context, thumbnailID string) ([]entry, error) {
+	entries := []entry{}
+
+	paginator := dynamodb.NewQueryPaginator(i.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(i.tableName),
+		IndexName:              aws.String("byThumbnail"),
+		KeyConditionExpression: aws.String("thumbnailId = :thumbnailId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":thumbnailId": &types.AttributeValueMemberS{Value: thumbnailID},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query search index: %w", err)
+		}
+
+		var items []entry
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal index entries: %w", err)
+		}
+		entries = append(entries, items...)
+	}
+
+	return entries, nil
+}
+
+// batchWrite sends requests in batches, resending anything DynamoDB reports
+// as unprocessed
+func (i *Index) batchWrite(ctx context.Context, requests []types.WriteRequest) error {
+	for len(requests) > 0 {
+		n := batchSize
+		if len(requests) < n {
+			n = len(requests)
+		}
+
+		pending := requests[:n]
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == 3 {
+				return fmt.Errorf("failed to update search index: %d unprocessed writes", len(pending))
+			}
+
+			resp, err := i.dynamoClient.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]types.WriteRequest{i.tableName: pending},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to update search index: %w", err)
+			}
+			pending = resp.UnprocessedItems[i.tableName]
+		}
+
+		requests = requests[n:]
+	}
+
+	return nil
+}
+
+func deleteRequest(e entry) types.WriteRequest {
+	return types.WriteRequest{
+		DeleteRequest: &types.DeleteRequest{
+			Key: map[string]types.AttributeValue{
+				"userId": &types.AttributeValueMemberS{Value: e.UserID},
+				"entry":  &types.AttributeValueMemberS{Value: e.Entry},
+			},
+		},
+	}
+}
+
+func unique(words []string) []string {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(words))
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			out = append(out, word)
+		}
+	}
+	return out
+}
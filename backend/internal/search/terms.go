@@ -0,0 +1,111 @@
+package search
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"github.com/celebthumb-ai/internal/models"
+)
+
+// Field weights: a match in the title or a tag counts for more than one buried
+// in the description
+const (
+	weightTitle       = 3
+	weightTag         = 3
+	weightAITag       = 2
+	weightOverlayText = 2
+	weightDescription = 1
+)
+
+// maxTermsPerThumbnail bounds the index entries written for one thumbnail
+const maxTermsPerThumbnail = 200
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "how": true, "in": true,
+	"is": true, "it": true, "of": true, "on": true, "or": true, "that": true,
+	"the": true, "this": true, "to": true, "was": true, "with": true,
+}
+
+// Tokenize lowercases text and splits it into searchable words. Stop words
+// and single letters are dropped; single digits are kept.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := make([]string, 0, len(words))
+	for _, word := range words {
+		if stopWords[word] {
+			continue
+		}
+		if len([]rune(word)) < 2 && !unicode.IsDigit([]rune(word)[0]) {
+			continue
+		}
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
+
+// Terms maps every token of a thumbnail to its weight. A token found in
+// several fields scores for each of them.
+func Terms(thumbnail *models.Thumbnail) map[string]int {
+	terms := map[string]int{}
+	add := func(text string, weight int) {
+		seen := map[string]bool{}
+		for _, token := range Tokenize(text) {
+			if seen[token] {
+				continue
+			}
+			seen[token] = true
+			if _, ok := terms[token]; !ok && len(terms) >= maxTermsPerThumbnail {
+				continue
+			}
+			terms[token] += weight
+		}
+	}
+
+	add(thumbnail.VideoTitle, weightTitle)
+	add(strings.Join(thumbnail.Tags, " "), weightTag)
+	add(strings.Join(thumbnail.AITags, " "), weightAITag)
+	add(strings.Join(OverlayText(thumbnail.LayerDocument), " "), weightOverlayText)
+	add(thumbnail.Description, weightDescription)
+
+	return terms
+}
+
+// OverlayText collects the text drawn on a thumbnail: every string stored
+// under a "text" key anywhere in the editor's layer document
+func OverlayText(layers json.RawMessage) []string {
+	if len(layers) == 0 {
+		return nil
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(layers, &document); err != nil {
+		return nil
+	}
+
+	var texts []string
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				if text, ok := child.(string); ok && key == "text" {
+					texts = append(texts, text)
+					continue
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(document)
+
+	return texts
+}
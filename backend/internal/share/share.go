@@ -0,0 +1,413 @@
+// Package share manages links that let people without an account view a
+// thumbnail or collection.
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/collection"
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/celebthumb-ai/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrShareNotFound also covers revoked links, so a revoked token can't be
+	// told apart from one that never existed
+	ErrShareNotFound = errors.New("share link not found")
+	ErrShareExpired  = errors.New("share link has expired")
+	// ErrViewLimitReached means the link has been viewed MaxViews times
+	ErrViewLimitReached = errors.New("share link view limit reached")
+	ErrPasswordRequired = errors.New("share link password required")
+	ErrInvalidPassword  = errors.New("invalid share link password")
+	ErrInvalidShare     = errors.New("invalid share link settings")
+	ErrTargetNotFound   = errors.New("shared item not found")
+)
+
+// tokenBytes of randomness make tokens unguessable
+const tokenBytes = 24
+
+type ShareConfig struct {
+	DynamoClient *dynamodb.Client
+	TableName    string
+	Storage      *storage.StorageService
+	Collections  *collection.CollectionService
+}
+
+type ShareService struct {
+	dynamoClient *dynamodb.Client
+	tableName    string
+	storage      *storage.StorageService
+	collections  *collection.CollectionService
+}
+
+// Request describes a new link. Zero values mean no password, no expiry and
+// unlimited views.
+type Request struct {
+	Kind      string
+	TargetID  string
+	Password  string
+	ExpiresAt *time.Time
+	MaxViews  int
+}
+
+func NewShareService(config ShareConfig) *ShareService {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("SHARES_TABLE")
+	}
+
+	return &ShareService{
+		dynamoClient: config.DynamoClient,
+		tableName:    tableName,
+		storage:      config.Storage,
+		collections:  config.Collections,
+	}
+}
+
+// CreateShare makes a link to one of the user's thumbnails or collections
+func (s *ShareService) CreateShare(ctx context.Context, userID string, req Request) (*models.ShareLink, error) {
+	now := time.Now().UTC()
+	if req.TargetID == "" || req.MaxViews < 0 || (req.ExpiresAt != nil && !req.ExpiresAt.After(now)) {
+		return nil, ErrInvalidShare
+	}
+	if err := s.checkTarget(ctx, userID, req.Kind, req.TargetID); err != nil {
+		return nil, err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	link := &models.ShareLink{
+		Token:     token,
+		UserID:    userID,
+		Kind:      req.Kind,
+		TargetID:  req.TargetID,
+		ExpiresAt: req.ExpiresAt,
+		MaxViews:  req.MaxViews,
+		CreatedAt: now,
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		link.PasswordHash = string(hash)
+		link.PasswordProtected = true
+	}
+
+	item, err := attributevalue.MarshalMap(link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal share link: %w", err)
+	}
+
+	_, err = s.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#token)"),
+		ExpressionAttributeNames: map[string]string{
+			"#token": "token",
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save share link: %w", err)
+	}
+
+	return link, nil
+}
+
+// ListShares returns the user's links, revoked and expired ones included, so
+// their view counts stay visible
+func (s *ShareService) ListShares(ctx context.Context, userID string) ([]*models.ShareLink, error) {
+	links := []*models.ShareLink{}
+
+	paginator := dynamodb.NewQueryPaginator(s.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String("byUser"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userID},
+		},
+		ScanIndexForward: aws.Bool(false),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query share links: %w", err)
+		}
+
+		var items []*models.ShareLink
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal share links: %w", err)
+		}
+		for _, link := range items {
+			link.PasswordProtected = link.PasswordHash != ""
+		}
+		links = append(links, items...)
+	}
+
+	return links, nil
+}
+
+// RevokeShare stops a link working and removes its previews. The record is
+// kept so the owner can still see how often it was viewed.
+func (s *ShareService) RevokeShare(ctx context.Context, userID, token string) (*models.ShareLink, error) {
+	link, err := s.get(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if link.UserID != userID {
+		return nil, ErrShareNotFound
+	}
+
+	if link.RevokedAt == nil {
+		now := time.Now().UTC()
+		revokedAt, err := attributevalue.Marshal(now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal revocation time: %w", err)
+		}
+
+		_, err = s.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(s.tableName),
+			Key: map[string]types.AttributeValue{
+				"token": &types.AttributeValueMemberS{Value: token},
+			},
+			UpdateExpression: aws.String("SET revokedAt = :now"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": revokedAt,
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to revoke share link: %w", err)
+		}
+		link.RevokedAt = &now
+	}
+
+	if err := s.storage.DeleteSharePreviews(ctx, userID, token); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// OpenShare checks the link's settings and password, counts the view and
+// returns what the link shows
+func (s *ShareService) OpenShare(ctx context.Context, token, password string) (*models.SharedView, error) {
+	link, err := s.get(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if link.RevokedAt != nil {
+		return nil, ErrShareNotFound
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return nil, ErrShareExpired
+	}
+	if link.PasswordHash != "" {
+		if password == "" {
+			return nil, ErrPasswordRequired
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)); err != nil {
+			return nil, ErrInvalidPassword
+		}
+	}
+	if link.MaxViews > 0 && link.Views >= link.MaxViews {
+		return nil, ErrViewLimitReached
+	}
+
+	if err := s.recordView(ctx, link); err != nil {
+		return nil, err
+	}
+
+	return s.view(ctx, link)
+}
+
+// DeleteUserShares removes the records of all the user's links. Their
+// previews live under the user's shares/ prefix and are deleted with it.
+func (s *ShareService) DeleteUserShares(ctx context.Context, userID string) error {
+	links, err := s.ListShares(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		_, err := s.dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.tableName),
+			Key: map[string]types.AttributeValue{
+				"token": &types.AttributeValueMemberS{Value: link.Token},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete share link: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// recordView counts a view, refusing it if the link was revoked or used up in
+// the meantime
+func (s *ShareService) recordView(ctx context.Context, link *models.ShareLink) error {
+	now := time.Now().UTC()
+	viewedAt, err := attributevalue.Marshal(now)
+	if err != nil {
+		return fmt.Errorf("failed to marshal view time: %w", err)
+	}
+
+	_, err = s.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"token": &types.AttributeValueMemberS{Value: link.Token},
+		},
+		UpdateExpression:    aws.String("SET #views = #views + :one, lastViewedAt = :now"),
+		ConditionExpression: aws.String("attribute_not_exists(revokedAt) AND (maxViews = :zero OR #views < maxViews)"),
+		ExpressionAttributeNames: map[string]string{
+			"#views": "views",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":  &types.AttributeValueMemberN{Value: "1"},
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+			":now":  viewedAt,
+		},
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			// Revocation is rare, so a limited link is assumed to be used up
+			if link.MaxViews > 0 {
+				return ErrViewLimitReached
+			}
+			return ErrShareNotFound
+		}
+		return fmt.Errorf("failed to record share link view: %w", err)
+	}
+
+	link.Views++
+	link.LastViewedAt = &now
+	return nil
+}
+
+// view builds the read-only view of the link's target. Thumbnails that were
+// deleted since the link was made are left out.
+func (s *ShareService) view(ctx context.Context, link *models.ShareLink) (*models.SharedView, error) {
+	view := &models.SharedView{
+		Kind:       link.Kind,
+		Thumbnails: []*models.SharedThumbnail{},
+		ExpiresAt:  link.ExpiresAt,
+	}
+
+	var thumbnails []*models.Thumbnail
+	switch link.Kind {
+	case models.ShareKindThumbnail:
+		thumbnail, err := s.storage.GetThumbnailRecord(ctx, link.UserID, link.TargetID)
+		if errors.Is(err, storage.ErrThumbnailNotFound) {
+			return nil, ErrTargetNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		view.Title = thumbnail.VideoTitle
+		thumbnails = []*models.Thumbnail{thumbnail}
+	case models.ShareKindCollection:
+		c, err := s.collections.GetCollection(ctx, link.UserID, link.TargetID)
+		if errors.Is(err, collection.ErrCollectionNotFound) {
+			return nil, ErrTargetNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		view.Title = c.Name
+		if thumbnails, err = s.storage.SharedThumbnails(ctx, link.UserID, link.TargetID); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrTargetNotFound
+	}
+
+	for _, thumbnail := range thumbnails {
+		preview, err := s.storage.SharePreview(ctx, link.Token, thumbnail)
+		if errors.Is(err, storage.ErrThumbnailNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		view.Thumbnails = append(view.Thumbnails, &models.SharedThumbnail{
+			ID:         thumbnail.ID,
+			VideoTitle: thumbnail.VideoTitle,
+			Style:      thumbnail.Style,
+			CreatedAt:  thumbnail.CreatedAt,
+			PreviewURL: preview.URL,
+		})
+	}
+
+	return view, nil
+}
+
+// checkTarget makes sure the item to share exists and belongs to the user
+func (s *ShareService) checkTarget(ctx context.Context, userID, kind, targetID string) error {
+	var err error
+	switch kind {
+	case models.ShareKindThumbnail:
+		_, err = s.storage.GetThumbnailRecord(ctx, userID, targetID)
+		if errors.Is(err, storage.ErrThumbnailNotFound) {
+			return ErrTargetNotFound
+		}
+	case models.ShareKindCollection:
+		_, err = s.collections.GetCollection(ctx, userID, targetID)
+		if errors.Is(err, collection.ErrCollectionNotFound) {
+			return ErrTargetNotFound
+		}
+	default:
+		return ErrInvalidShare
+	}
+	return err
+}
+
+func (s *ShareService) get(ctx context.Context, token string) (*models.ShareLink, error) {
+	if token == "" {
+		return nil, ErrShareNotFound
+	}
+
+	resp, err := s.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"token": &types.AttributeValueMemberS{Value: token},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get share link: %w", err)
+	}
+	if resp.Item == nil {
+		return nil, ErrShareNotFound
+	}
+
+	var link models.ShareLink
+	if err := attributevalue.UnmarshalMap(resp.Item, &link); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal share link: %w", err)
+	}
+	link.PasswordProtected = link.PasswordHash != ""
+
+	return &link, nil
+}
+
+// newToken returns a random URL-safe token
+func newToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate share token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/celebthumb-ai/internal/models"
+)
+
+// userPrefixes are the object prefixes that only hold one user's data
+var userPrefixes = []string{"thumbnails/%s/", "uploads/%s/", "exports/%s/", "shares/%s/"}
+
+// DeleteUserData permanently removes every thumbnail a user owns, including
+// trashed ones, then sweeps the user's object prefixes for anything no record
+// points at, such as abandoned uploads and finished exports. It is safe to run
+// again after a failure.
+func (s *StorageService) DeleteUserData(ctx context.Context, userID string) error {
+	for _, status := range []string{"", models.ThumbnailStatusDeleted} {
+		opts := ListOptions{Limit: MaxPageSize, Status: status}
+		for {
+			page, err := s.repository.List(ctx, userID, opts)
+			if err != nil {
+				return err
+			}
+			for _, thumbnail := range page.Items {
+				// The index lags behind the table, so a record purged by an
+				// earlier attempt may still be listed
+				err := s.purgeThumbnail(ctx, thumbnail)
+				if err != nil && !errors.Is(err, ErrThumbnailNotFound) {
+					return err
+				}
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			opts.Cursor = page.NextCursor
+		}
+	}
+
+	for _, prefix := range userPrefixes {
+		if err := s.deletePrefix(ctx, fmt.Sprintf(prefix, userID)); err != nil {
+			return err
+		}
+	}
+
+	if s.usage != nil {
+		return s.usage.Delete(ctx, userID)
+	}
+	return nil
+}
+
+// deletePrefix deletes every object under prefix
+func (s *StorageService) deletePrefix(ctx context.Context, prefix string) error {
+	opts := blob.ListOptions{}
+	for {
+		result, err := s.store.List(ctx, prefix, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		for _, object := range result.Objects {
+			if err := s.store.Delete(ctx, object.Key); err != nil {
+				return fmt.Errorf("failed to delete object: %w", err)
+			}
+		}
+
+		if result.NextToken == "" {
+			return nil
+		}
+		opts.Token = result.NextToken
+	}
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/celebthumb-ai/internal/models"
+)
+
+// Archive describes a finished ZIP export
+type Archive struct {
+	Key        string
+	Size       int64
+	Thumbnails int
+}
+
+// ArchiveFile is an extra file written at the root of an archive, such as a
+// dump of the user's account data
+type ArchiveFile struct {
+	Name string
+	Data []byte
+}
+
+// manifestEntry is one thumbnail's row in manifest.json and manifest.csv
+type manifestEntry struct {
+	ID            string    `json:"id"`
+	VideoTitle    string    `json:"videoTitle"`
+	Description   string    `json:"description"`
+	Style         string    `json:"style"`
+	TemplateID    string    `json:"templateId,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`
+	AITags        []string  `json:"aiTags,omitempty"`
+	CollectionIDs []string  `json:"collectionIds,omitempty"`
+	Revision      int       `json:"revision"`
+	Prompt        string    `json:"prompt,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	Files         []string  `json:"files"`
+}
+
+var manifestColumns = []string{
+	"id", "videoTitle", "description", "style", "templateId", "tags", "aiTags",
+	"collectionIds", "revision", "prompt", "createdAt", "files",
+}
+
+// WriteArchive streams a ZIP of the user's thumbnails matching opts to key.
+// Each thumbnail gets a folder with its renditions and layer document, and
+// manifest.json and manifest.csv describe them all. Objects are copied one at
+// a time into the streamed upload, so memory use doesn't grow with the
+// library; only the manifest rows are held until the end. Any extra files are
+// added next to the manifest.
+func (s *StorageService) WriteArchive(ctx context.Context, userID string, opts ListOptions, key string, extra ...ArchiveFile) (*Archive, error) {
+	w, err := s.store.NewWriter(ctx, key, blob.PutOptions{ContentType: "application/zip"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	entries, err := s.writeArchiveEntries(ctx, w, userID, opts, extra)
+	if err != nil {
+		if abortErr := w.Abort(); abortErr != nil {
+			log.Printf("failed to abort archive %s: %v", key, abortErr)
+		}
+		return nil, err
+	}
+
+	info, err := w.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to store archive: %w", err)
+	}
+
+	return &Archive{
+		Key:        key,
+		Size:       info.Size,
+		Thumbnails: entries,
+	}, nil
+}
+
+func (s *StorageService) writeArchiveEntries(ctx context.Context, w io.Writer, userID string, opts ListOptions, extra []ArchiveFile) (int, error) {
+	zw := zip.NewWriter(w)
+	manifest := []manifestEntry{}
+
+	opts.Limit = MaxPageSize
+	opts.Cursor = ""
+	for {
+		page, err := s.repository.List(ctx, userID, opts)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, thumbnail := range page.Items {
+			files, err := s.archiveThumbnail(ctx, zw, thumbnail)
+			if err != nil {
+				return 0, err
+			}
+			manifest = append(manifest, newManifestEntry(thumbnail, files))
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+
+	if err := writeManifest(zw, manifest); err != nil {
+		return 0, err
+	}
+	for _, file := range extra {
+		entry, err := zw.Create(file.Name)
+		if err != nil {
+			return 0, fmt.Errorf("failed to write %s: %w", file.Name, err)
+		}
+		if _, err := entry.Write(file.Data); err != nil {
+			return 0, fmt.Errorf("failed to write %s: %w", file.Name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return 0, fmt.Errorf("failed to finish archive: %w", err)
+	}
+
+	return len(manifest), nil
+}
+
+// archiveThumbnail copies a thumbnail's images and layer document into the
+// archive and returns the paths written. Images that have gone missing are
+// skipped rather than failing the whole export.
+func (s *StorageService) archiveThumbnail(ctx context.Context, zw *zip.Writer, thumbnail *models.Thumbnail) ([]string, error) {
+	files := []string{}
+
+	for _, key := range objectKeys(thumbnail) {
+		name := thumbnail.ID + "/" + path.Base(key)
+
+		object, err := s.store.Get(ctx, key, blob.GetOptions{})
+		if errors.Is(err, blob.ErrNotFound) {
+			log.Printf("skipping missing object %s in export", key)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read thumbnail: %w", err)
+		}
+
+		// Images are already compressed, so they are stored as-is
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Store,
+			Modified: thumbnail.CreatedAt,
+		})
+		if err == nil {
+			_, err = io.Copy(entry, object.Body)
+		}
+		object.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to write thumbnail to archive: %w", err)
+		}
+		files = append(files, name)
+	}
+
+	if len(thumbnail.LayerDocument) > 0 {
+		name := thumbnail.ID + "/layers.json"
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: thumbnail.CreatedAt,
+		})
+		if err == nil {
+			_, err = entry.Write(thumbnail.LayerDocument)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to write layers to archive: %w", err)
+		}
+		files = append(files, name)
+	}
+
+	return files, nil
+}
+
+func newManifestEntry(thumbnail *models.Thumbnail, files []string) manifestEntry {
+	return manifestEntry{
+		ID:            thumbnail.ID,
+		VideoTitle:    thumbnail.VideoTitle,
+		Description:   thumbnail.Description,
+		Style:         thumbnail.Style,
+		TemplateID:    thumbnail.TemplateID,
+		Tags:          thumbnail.Tags,
+		AITags:        thumbnail.AITags,
+		CollectionIDs: thumbnail.CollectionIDs,
+		Revision:      thumbnail.Revision,
+		Prompt:        thumbnail.Prompt,
+		CreatedAt:     thumbnail.CreatedAt,
+		Files:         files,
+	}
+}
+
+func writeManifest(zw *zip.Writer, manifest []manifestEntry) error {
+	entry, err := zw.Create("manifest.json")
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	entry, err = zw.Create("manifest.csv")
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	// List values such as tags are joined with semicolons
+	cw := csv.NewWriter(entry)
+	cw.Write(manifestColumns)
+	for _, m := range manifest {
+		cw.Write([]string{
+			m.ID,
+			m.VideoTitle,
+			m.Description,
+			m.Style,
+			m.TemplateID,
+			strings.Join(m.Tags, ";"),
+			strings.Join(m.AITags, ";"),
+			strings.Join(m.CollectionIDs, ";"),
+			strconv.Itoa(m.Revision),
+			m.Prompt,
+			m.CreatedAt.Format(time.RFC3339),
+			strings.Join(m.Files, ";"),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return nil
+}
+
+// PresignDownload returns a time-limited URL for an object such as an export
+// archive
+func (s *StorageService) PresignDownload(ctx context.Context, key string, expiry time.Duration) (*blob.PresignedRequest, error) {
+	req, err := s.store.PresignGet(ctx, key, expiry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign download: %w", err)
+	}
+	return req, nil
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+	ErrUploadTooLarge         = errors.New("upload too large")
+)
+
+const (
+	DefaultURLExpiry = 15 * time.Minute
+	// MaxUploadSize caps source image uploads
+	MaxUploadSize = 10 << 20
+)
+
+// uploadContentTypes are the source image formats clients may upload. They
+// match what rendition.Sanitize can check and re-encode.
+var uploadContentTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// Upload describes a presigned PUT the client performs itself. Headers must be
+// sent exactly as given or S3 rejects the signature.
+type Upload struct {
+	ID        string            `json:"id"`
+	Key       string            `json:"key"`
+	URL       string            `json:"url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+// urlExpiryFromEnv reads THUMBNAIL_URL_EXPIRY as a Go duration, e.g. "15m"
+func urlExpiryFromEnv() time.Duration {
+	expiry, err := time.ParseDuration(os.Getenv("THUMBNAIL_URL_EXPIRY"))
+	if err != nil || expiry <= 0 {
+		return DefaultURLExpiry
+	}
+	return expiry
+}
+
+// PresignThumbnail sets time-limited download URLs on thumbnail and its
+// renditions
+func (s *StorageService) PresignThumbnail(ctx context.Context, thumbnail *models.Thumbnail) error {
+	req, err := s.store.PresignGet(ctx, thumbnail.ObjectKey, s.urlExpiry)
+	if err != nil {
+		return fmt.Errorf("failed to presign thumbnail: %w", err)
+	}
+
+	thumbnail.URL = req.URL
+	thumbnail.URLExpiresAt = &req.ExpiresAt
+
+	for i := range thumbnail.Renditions {
+		req, err := s.store.PresignGet(ctx, thumbnail.Renditions[i].Key, s.urlExpiry)
+		if err != nil {
+			return fmt.Errorf("failed to presign rendition: %w", err)
+		}
+		thumbnail.Renditions[i].URL = req.URL
+	}
+
+	return nil
+}
+
+func (s *StorageService) presignThumbnails(ctx context.Context, thumbnails []*models.Thumbnail) error {
+	for _, thumbnail := range thumbnails {
+		if err := s.PresignThumbnail(ctx, thumbnail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PresignUpload returns a presigned PUT for a source image under
+// uploads/{userID}/. The signature covers the content type and length so the
+// client can't upload something else.
+func (s *StorageService) PresignUpload(ctx context.Context, userID, contentType string, size int64) (*Upload, error) {
+	ext, ok := uploadContentTypes[contentType]
+	if !ok {
+		return nil, ErrUnsupportedContentType
+	}
+	if size <= 0 || size > MaxUploadSize {
+		return nil, ErrUploadTooLarge
+	}
+
+	id := uuid.New().String()
+	key := fmt.Sprintf("uploads/%s/%s%s", userID, id, ext)
+
+	req, err := s.store.PresignPut(ctx, key, blob.PutOptions{
+		ContentType:   contentType,
+		ContentLength: size,
+	}, s.urlExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign upload: %w", err)
+	}
+
+	return &Upload{
+		ID:        id,
+		Key:       key,
+		URL:       req.URL,
+		Method:    req.Method,
+		Headers:   req.Headers,
+		ExpiresAt: req.ExpiresAt,
+	}, nil
+}
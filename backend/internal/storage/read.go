@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/celebthumb-ai/internal/blob"
+)
+
+var ErrInvalidRange = blob.ErrInvalidRange
+
+// ReadOptions carries the conditional and range headers of a read
+type ReadOptions = blob.GetOptions
+
+// OpenThumbnail starts a streaming read of a thumbnail owned by userID. An
+// If-None-Match that matches the current ETag yields NotModified, and a single
+// byte range yields a Partial object. Unsupported range forms are ignored and
+// the full object is returned, as RFC 9110 allows. Size and Checksum always
+// describe the whole thumbnail, even for ranged reads.
+func (s *StorageService) OpenThumbnail(ctx context.Context, userID, thumbnailID string, opts ReadOptions) (*blob.Object, error) {
+	thumbnail, err := s.GetThumbnailRecord(ctx, userID, thumbnailID)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := s.store.Get(ctx, thumbnail.ObjectKey, opts)
+	switch {
+	case errors.Is(err, blob.ErrNotFound):
+		return nil, ErrThumbnailNotFound
+	case errors.Is(err, blob.ErrInvalidRange):
+		return nil, err
+	case err != nil:
+		return nil, fmt.Errorf("failed to get thumbnail: %w", err)
+	}
+
+	if thumbnail.Size > 0 {
+		object.Size = thumbnail.Size
+	}
+	if thumbnail.Checksum != "" {
+		object.Checksum = thumbnail.Checksum
+	}
+
+	return object, nil
+}
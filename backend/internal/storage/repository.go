@@ -0,0 +1,495 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/models"
+)
+
+var (
+	ErrThumbnailNotFound = errors.New("thumbnail not found")
+	ErrInvalidCursor     = errors.New("invalid cursor")
+)
+
+const (
+	DefaultPageSize = 25
+	MaxPageSize     = 100
+
+	// queryBatchSize is how many index items each List query evaluates. The
+	// filters can drop most of them, e.g. when a run of thumbnails is trashed.
+	queryBatchSize = 200
+)
+
+// ListOptions selects a page of a user's thumbnails. Zero values mean no
+// filter, except that trashed thumbnails are left out unless Status asks for
+// them; results are newest first unless Ascending is set.
+type ListOptions struct {
+	Limit      int
+	Cursor     string
+	Ascending  bool
+	Style      string
+	TemplateID string
+	// CollectionID limits results to members of one collection
+	CollectionID string
+	Status       string
+	From         time.Time
+	To           time.Time
+}
+
+// ThumbnailPage is one page of results. NextCursor is empty on the last page.
+type ThumbnailPage struct {
+	Items      []*models.Thumbnail `json:"items"`
+	NextCursor string              `json:"nextCursor,omitempty"`
+}
+
+// DynamoAPI is the part of the DynamoDB client the repositories use
+type DynamoAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+type RepositoryConfig struct {
+	DynamoClient DynamoAPI
+	TableName    string
+}
+
+// ThumbnailRepository stores thumbnail metadata records in the ThumbnailsTable
+type ThumbnailRepository struct {
+	dynamoClient DynamoAPI
+	tableName    string
+}
+
+func NewThumbnailRepository(config RepositoryConfig) *ThumbnailRepository {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("THUMBNAILS_TABLE")
+	}
+
+	return &ThumbnailRepository{
+		dynamoClient: config.DynamoClient,
+		tableName:    tableName,
+	}
+}
+
+// Put creates or replaces a thumbnail record
+func (r *ThumbnailRepository) Put(ctx context.Context, thumbnail *models.Thumbnail) error {
+	item, err := attributevalue.MarshalMap(thumbnail)
+	if err != nil {
+		return fmt.Errorf("failed to marshal thumbnail: %w", err)
+	}
+
+	_, err = r.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(r.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save thumbnail record: %w", err)
+	}
+
+	return nil
+}
+
+// PutIfRevision replaces a thumbnail record only if the stored one is still at
+// revision previous
+func (r *ThumbnailRepository) PutIfRevision(ctx context.Context, thumbnail *models.Thumbnail, previous int) error {
+	item, err := attributevalue.MarshalMap(thumbnail)
+	if err != nil {
+		return fmt.Errorf("failed to marshal thumbnail: %w", err)
+	}
+
+	condition := "attribute_exists(id) AND revision = :previous"
+	if previous == 0 {
+		// Records written before revisions existed have no revision attribute
+		condition = "attribute_exists(id) AND (attribute_not_exists(revision) OR revision = :previous)"
+	}
+
+	_, err = r.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(r.tableName),
+		Item:                item,
+		ConditionExpression: aws.String(condition),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":previous": &types.AttributeValueMemberN{Value: strconv.Itoa(previous)},
+		},
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return ErrRevisionConflict
+		}
+		return fmt.Errorf("failed to save thumbnail record: %w", err)
+	}
+
+	return nil
+}
+
+func (r *ThumbnailRepository) Get(ctx context.Context, thumbnailID string) (*models.Thumbnail, error) {
+	resp, err := r.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: thumbnailID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thumbnail record: %w", err)
+	}
+	if resp.Item == nil {
+		return nil, ErrThumbnailNotFound
+	}
+
+	var thumbnail models.Thumbnail
+	if err := attributevalue.UnmarshalMap(resp.Item, &thumbnail); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal thumbnail: %w", err)
+	}
+
+	return &thumbnail, nil
+}
+
+// ListByUser returns every thumbnail record owned by userID, newest first
+func (r *ThumbnailRepository) ListByUser(ctx context.Context, userID string) ([]*models.Thumbnail, error) {
+	thumbnails := []*models.Thumbnail{}
+
+	opts := ListOptions{Limit: MaxPageSize}
+	for {
+		page, err := r.List(ctx, userID, opts)
+		if err != nil {
+			return nil, err
+		}
+		thumbnails = append(thumbnails, page.Items...)
+
+		if page.NextCursor == "" {
+			return thumbnails, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// List returns a page of userID's thumbnails from the byUser index, which is
+// sorted by createdAt. Non-key filters are applied by DynamoDB after reading,
+// so List keeps querying until the page is full or the index is exhausted.
+func (r *ThumbnailRepository) List(ctx context.Context, userID string, opts ListOptions) (*ThumbnailPage, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	startKey, err := decodeCursor(opts.Cursor, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		IndexName:              aws.String("byUser"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userID},
+		},
+		ScanIndexForward: aws.Bool(opts.Ascending),
+	}
+
+	switch {
+	case !opts.From.IsZero() && !opts.To.IsZero():
+		input.KeyConditionExpression = aws.String("userId = :userId AND createdAt BETWEEN :from AND :to")
+	case !opts.From.IsZero():
+		input.KeyConditionExpression = aws.String("userId = :userId AND createdAt >= :from")
+	case !opts.To.IsZero():
+		input.KeyConditionExpression = aws.String("userId = :userId AND createdAt <= :to")
+	}
+	if !opts.From.IsZero() {
+		input.ExpressionAttributeValues[":from"] = &types.AttributeValueMemberS{Value: formatTime(opts.From)}
+	}
+	if !opts.To.IsZero() {
+		input.ExpressionAttributeValues[":to"] = &types.AttributeValueMemberS{Value: formatTime(opts.To)}
+	}
+
+	var filters []string
+	if opts.Style != "" {
+		filters = append(filters, "style = :style")
+		input.ExpressionAttributeValues[":style"] = &types.AttributeValueMemberS{Value: opts.Style}
+	}
+	if opts.TemplateID != "" {
+		filters = append(filters, "templateId = :templateId")
+		input.ExpressionAttributeValues[":templateId"] = &types.AttributeValueMemberS{Value: opts.TemplateID}
+	}
+	if opts.CollectionID != "" {
+		filters = append(filters, "contains(collectionIds, :collectionId)")
+		input.ExpressionAttributeValues[":collectionId"] = &types.AttributeValueMemberS{Value: opts.CollectionID}
+	}
+	if opts.Status != "" {
+		filters = append(filters, "#status = :status")
+		input.ExpressionAttributeValues[":status"] = &types.AttributeValueMemberS{Value: opts.Status}
+	} else {
+		// Trashed thumbnails only show up when asked for
+		filters = append(filters, "#status <> :deleted")
+		input.ExpressionAttributeValues[":deleted"] = &types.AttributeValueMemberS{Value: models.ThumbnailStatusDeleted}
+	}
+	input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+
+	page := &ThumbnailPage{Items: []*models.Thumbnail{}}
+	input.Limit = aws.Int32(queryBatchSize)
+	for {
+		input.ExclusiveStartKey = startKey
+
+		resp, err := r.dynamoClient.Query(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query thumbnails: %w", err)
+		}
+
+		for i, item := range resp.Items {
+			var thumbnail models.Thumbnail
+			if err := attributevalue.UnmarshalMap(item, &thumbnail); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal thumbnail: %w", err)
+			}
+			page.Items = append(page.Items, &thumbnail)
+
+			// A full page resumes after its last item unless that was the
+			// last one in the index
+			if len(page.Items) == limit {
+				if i == len(resp.Items)-1 && len(resp.LastEvaluatedKey) == 0 {
+					return page, nil
+				}
+				return page, page.setCursor(item)
+			}
+		}
+
+		startKey = resp.LastEvaluatedKey
+		if len(startKey) == 0 {
+			return page, nil
+		}
+	}
+}
+
+// setCursor points the page's cursor just after item, using its byUser index
+// key
+func (p *ThumbnailPage) setCursor(item map[string]types.AttributeValue) error {
+	key := make(map[string]types.AttributeValue, 3)
+	for _, name := range []string{"id", "userId", "createdAt"} {
+		key[name] = item[name]
+	}
+
+	cursor, err := encodeCursor(key)
+	if err != nil {
+		return err
+	}
+	p.NextCursor = cursor
+	return nil
+}
+
+// formatTime matches the encoding attributevalue uses for time.Time
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// encodeCursor turns a LastEvaluatedKey into an opaque URL-safe token. All
+// byUser key attributes are strings.
+func encodeCursor(key map[string]types.AttributeValue) (string, error) {
+	values := make(map[string]string, len(key))
+	for name, value := range key {
+		s, ok := value.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", fmt.Errorf("failed to encode cursor: unexpected type for %s", name)
+		}
+		values[name] = s.Value
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor reverses encodeCursor, rejecting cursors for another user's
+// listing
+func decodeCursor(cursor, userID string) (map[string]types.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if values["userId"] != userID || values["id"] == "" || values["createdAt"] == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	key := make(map[string]types.AttributeValue, len(values))
+	for name, value := range values {
+		key[name] = &types.AttributeValueMemberS{Value: value}
+	}
+
+	return key, nil
+}
+
+// SetTrashed moves a thumbnail record into the trash. The deletedAt attribute
+// also puts it into the sparse trash index the purge job reads.
+func (r *ThumbnailRepository) SetTrashed(ctx context.Context, thumbnailID string, deletedAt time.Time) error {
+	_, err := r.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: thumbnailID},
+		},
+		UpdateExpression:    aws.String("SET #status = :deleted, deletedAt = :deletedAt"),
+		ConditionExpression: aws.String("attribute_exists(id) AND #status <> :deleted"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":deleted":   &types.AttributeValueMemberS{Value: models.ThumbnailStatusDeleted},
+			":deletedAt": &types.AttributeValueMemberS{Value: formatTime(deletedAt)},
+		},
+	})
+	return statusUpdateError(err)
+}
+
+// ClearTrashed takes a thumbnail record back out of the trash
+func (r *ThumbnailRepository) ClearTrashed(ctx context.Context, thumbnailID string) error {
+	_, err := r.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: thumbnailID},
+		},
+		UpdateExpression:    aws.String("SET #status = :ready REMOVE deletedAt"),
+		ConditionExpression: aws.String("attribute_exists(id) AND #status = :deleted"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":ready":   &types.AttributeValueMemberS{Value: models.ThumbnailStatusReady},
+			":deleted": &types.AttributeValueMemberS{Value: models.ThumbnailStatusDeleted},
+		},
+	})
+	return statusUpdateError(err)
+}
+
+// SetTags replaces a live thumbnail's user tags
+func (r *ThumbnailRepository) SetTags(ctx context.Context, thumbnailID string, tags []string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: thumbnailID},
+		},
+		UpdateExpression:    aws.String("REMOVE tags"),
+		ConditionExpression: aws.String("attribute_exists(id) AND #status <> :deleted"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":deleted": &types.AttributeValueMemberS{Value: models.ThumbnailStatusDeleted},
+		},
+	}
+
+	if len(tags) > 0 {
+		values := make([]types.AttributeValue, 0, len(tags))
+		for _, tag := range tags {
+			values = append(values, &types.AttributeValueMemberS{Value: tag})
+		}
+		input.UpdateExpression = aws.String("SET tags = :tags")
+		input.ExpressionAttributeValues[":tags"] = &types.AttributeValueMemberL{Value: values}
+	}
+
+	_, err := r.dynamoClient.UpdateItem(ctx, input)
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return ErrThumbnailNotFound
+		}
+		return fmt.Errorf("failed to update tags: %w", err)
+	}
+
+	return nil
+}
+
+func statusUpdateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrThumbnailNotFound
+	}
+	return fmt.Errorf("failed to update thumbnail status: %w", err)
+}
+
+// ListTrashedBefore returns every thumbnail trashed before cutoff, across all
+// users, from the trash index
+func (r *ThumbnailRepository) ListTrashedBefore(ctx context.Context, cutoff time.Time) ([]*models.Thumbnail, error) {
+	thumbnails := []*models.Thumbnail{}
+
+	paginator := dynamodb.NewQueryPaginator(r.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		IndexName:              aws.String("trash"),
+		KeyConditionExpression: aws.String("#status = :deleted AND deletedAt < :cutoff"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":deleted": &types.AttributeValueMemberS{Value: models.ThumbnailStatusDeleted},
+			":cutoff":  &types.AttributeValueMemberS{Value: formatTime(cutoff)},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query trash: %w", err)
+		}
+
+		var items []*models.Thumbnail
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal thumbnails: %w", err)
+		}
+		thumbnails = append(thumbnails, items...)
+	}
+
+	return thumbnails, nil
+}
+
+// Delete removes a thumbnail record, failing with ErrThumbnailNotFound if it
+// doesn't exist
+func (r *ThumbnailRepository) Delete(ctx context.Context, thumbnailID string) error {
+	_, err := r.dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: thumbnailID},
+		},
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return ErrThumbnailNotFound
+		}
+		return fmt.Errorf("failed to delete thumbnail record: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/models"
+)
+
+// fakeIndex answers List's queries the way the byUser index would for a
+// single user: newest first, Limit items evaluated before the status filter
+type fakeIndex struct {
+	DynamoAPI
+	items   []map[string]types.AttributeValue
+	queries int
+}
+
+func (f *fakeIndex) Query(ctx context.Context, input *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	f.queries++
+
+	start := 0
+	if input.ExclusiveStartKey != nil {
+		id := input.ExclusiveStartKey["id"].(*types.AttributeValueMemberS).Value
+		for i, item := range f.items {
+			if item["id"].(*types.AttributeValueMemberS).Value == id {
+				start = i + 1
+			}
+		}
+	}
+
+	end := start + int(aws.ToInt32(input.Limit))
+	if end > len(f.items) {
+		end = len(f.items)
+	}
+
+	out := &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{}}
+	for _, item := range f.items[start:end] {
+		if item["status"].(*types.AttributeValueMemberS).Value != models.ThumbnailStatusDeleted {
+			out.Items = append(out.Items, item)
+		}
+	}
+	if end < len(f.items) {
+		last := f.items[end-1]
+		out.LastEvaluatedKey = map[string]types.AttributeValue{
+			"id":        last["id"],
+			"userId":    last["userId"],
+			"createdAt": last["createdAt"],
+		}
+	}
+	return out, nil
+}
+
+// newFakeIndex holds count thumbnails, newest first, of which those that
+// trashed reports true for are in the trash
+func newFakeIndex(t *testing.T, count int, trashed func(i int) bool) *fakeIndex {
+	t.Helper()
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	index := &fakeIndex{}
+	for i := 0; i < count; i++ {
+		status := models.ThumbnailStatusReady
+		if trashed(i) {
+			status = models.ThumbnailStatusDeleted
+		}
+		item, err := attributevalue.MarshalMap(models.Thumbnail{
+			ID:        fmt.Sprintf("thumb-%04d", i),
+			UserID:    "user",
+			Status:    status,
+			CreatedAt: start.Add(-time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("MarshalMap: %v", err)
+		}
+		index.items = append(index.items, item)
+	}
+	return index
+}
+
+func TestListPages(t *testing.T) {
+	tests := []struct {
+		name        string
+		count       int
+		trashed     func(i int) bool
+		limit       int
+		wantPages   []int
+		wantQueries int
+	}{
+		{
+			name:        "exact pages end without a cursor",
+			count:       50,
+			trashed:     func(int) bool { return false },
+			limit:       25,
+			wantPages:   []int{25, 25},
+			wantQueries: 2,
+		},
+		{
+			name:        "partial last page",
+			count:       60,
+			trashed:     func(int) bool { return false },
+			limit:       25,
+			wantPages:   []int{25, 25, 10},
+			wantQueries: 3,
+		},
+		{
+			name:        "trashed run is skipped in one query",
+			count:       150,
+			trashed:     func(i int) bool { return i >= 20 && i < 140 },
+			limit:       25,
+			wantPages:   []int{25, 5},
+			wantQueries: 2,
+		},
+		{
+			name:        "filtered batches keep the page going",
+			count:       1000,
+			trashed:     func(i int) bool { return i%10 != 0 },
+			limit:       100,
+			wantPages:   []int{100},
+			wantQueries: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := newFakeIndex(t, tt.count, tt.trashed)
+			repo := NewThumbnailRepository(RepositoryConfig{DynamoClient: index, TableName: "thumbnails"})
+
+			var pages []int
+			seen := map[string]bool{}
+			opts := ListOptions{Limit: tt.limit}
+			for {
+				page, err := repo.List(context.Background(), "user", opts)
+				if err != nil {
+					t.Fatalf("List: %v", err)
+				}
+				pages = append(pages, len(page.Items))
+				for _, thumbnail := range page.Items {
+					if seen[thumbnail.ID] {
+						t.Fatalf("%s listed twice", thumbnail.ID)
+					}
+					seen[thumbnail.ID] = true
+				}
+
+				if page.NextCursor == "" {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+
+			if fmt.Sprint(pages) != fmt.Sprint(tt.wantPages) {
+				t.Errorf("page sizes = %v, want %v", pages, tt.wantPages)
+			}
+			if index.queries != tt.wantQueries {
+				t.Errorf("queries = %d, want %d", index.queries, tt.wantQueries)
+			}
+		})
+	}
+}
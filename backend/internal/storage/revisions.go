@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/models"
+)
+
+var (
+	ErrRevisionNotFound = errors.New("revision not found")
+	// ErrRevisionConflict means the thumbnail changed while a revision was
+	// being made
+	ErrRevisionConflict = errors.New("thumbnail was modified concurrently")
+)
+
+// RevisionInput describes what produced a new revision
+type RevisionInput struct {
+	Prompt        string
+	LayerDocument []byte
+	ChangedBy     string
+}
+
+// RevisionRepository stores revision records keyed by thumbnail ID and
+// revision number
+type RevisionRepository struct {
+	dynamoClient DynamoAPI
+	tableName    string
+}
+
+func NewRevisionRepository(config RepositoryConfig) *RevisionRepository {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("THUMBNAIL_REVISIONS_TABLE")
+	}
+
+	return &RevisionRepository{
+		dynamoClient: config.DynamoClient,
+		tableName:    tableName,
+	}
+}
+
+// Create writes a revision, failing with ErrRevisionConflict if that number is
+// already taken
+func (r *RevisionRepository) Create(ctx context.Context, revision *models.Revision) error {
+	item, err := attributevalue.MarshalMap(revision)
+	if err != nil {
+		return fmt.Errorf("failed to marshal revision: %w", err)
+	}
+
+	_, err = r.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(r.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(thumbnailId)"),
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return ErrRevisionConflict
+		}
+		return fmt.Errorf("failed to save revision: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RevisionRepository) Get(ctx context.Context, thumbnailID string, number int) (*models.Revision, error) {
+	resp, err := r.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key:       revisionKey(thumbnailID, number),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revision: %w", err)
+	}
+	if resp.Item == nil {
+		return nil, ErrRevisionNotFound
+	}
+
+	var revision models.Revision
+	if err := attributevalue.UnmarshalMap(resp.Item, &revision); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal revision: %w", err)
+	}
+
+	return &revision, nil
+}
+
+// List returns every revision of a thumbnail, newest first
+func (r *RevisionRepository) List(ctx context.Context, thumbnailID string) ([]*models.Revision, error) {
+	revisions := []*models.Revision{}
+
+	paginator := dynamodb.NewQueryPaginator(r.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		KeyConditionExpression: aws.String("thumbnailId = :thumbnailId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":thumbnailId": &types.AttributeValueMemberS{Value: thumbnailID},
+		},
+		ScanIndexForward: aws.Bool(false),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query revisions: %w", err)
+		}
+
+		var items []*models.Revision
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal revisions: %w", err)
+		}
+		revisions = append(revisions, items...)
+	}
+
+	return revisions, nil
+}
+
+func (r *RevisionRepository) Delete(ctx context.Context, thumbnailID string, number int) error {
+	_, err := r.dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.tableName),
+		Key:       revisionKey(thumbnailID, number),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete revision: %w", err)
+	}
+
+	return nil
+}
+
+func revisionKey(thumbnailID string, number int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"thumbnailId": &types.AttributeValueMemberS{Value: thumbnailID},
+		"revision":    &types.AttributeValueMemberN{Value: strconv.Itoa(number)},
+	}
+}
+
+// ListRevisions returns the revisions of a thumbnail owned by userID, newest
+// first
+func (s *StorageService) ListRevisions(ctx context.Context, userID, thumbnailID string) ([]*models.Revision, error) {
+	if _, err := s.GetThumbnailRecord(ctx, userID, thumbnailID); err != nil {
+		return nil, err
+	}
+
+	return s.revisions.List(ctx, thumbnailID)
+}
+
+// GetRevision returns one revision with presigned URLs for its images
+func (s *StorageService) GetRevision(ctx context.Context, userID, thumbnailID string, number int) (*models.Revision, error) {
+	if _, err := s.GetThumbnailRecord(ctx, userID, thumbnailID); err != nil {
+		return nil, err
+	}
+
+	revision, err := s.revisions.Get(ctx, thumbnailID, number)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := s.store.PresignGet(ctx, revision.ObjectKey, s.urlExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign revision: %w", err)
+	}
+	revision.URL = req.URL
+
+	for i := range revision.Renditions {
+		req, err := s.store.PresignGet(ctx, revision.Renditions[i].Key, s.urlExpiry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to presign rendition: %w", err)
+		}
+		revision.Renditions[i].URL = req.URL
+	}
+
+	return revision, nil
+}
+
+// ReviseThumbnail stores data as a new revision and makes it current. retain
+// is the owner's plan retention; older revisions beyond it are pruned.
+func (s *StorageService) ReviseThumbnail(ctx context.Context, userID, thumbnailID string, data []byte, input RevisionInput, retain int) (*models.Thumbnail, error) {
+	current, err := s.GetThumbnailRecord(ctx, userID, thumbnailID)
+	if err != nil {
+		return nil, err
+	}
+
+	next := *current
+	next.Revision = current.Revision + 1
+	next.Prompt = input.Prompt
+	next.LayerDocument = input.LayerDocument
+
+	keys, err := s.storeRenditions(ctx, &next, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.commitRevision(ctx, &next, current.Revision, models.NewRevision(&next, input.ChangedBy)); err != nil {
+		s.removeObjects(ctx, keys)
+		return nil, err
+	}
+
+	s.pruneRevisions(ctx, &next, retain)
+	s.index(ctx, &next)
+
+	return &next, s.PresignThumbnail(ctx, &next)
+}
+
+// RestoreRevision makes an old revision current again by recording a new
+// revision that shares its images
+func (s *StorageService) RestoreRevision(ctx context.Context, userID, thumbnailID string, number int, changedBy string, retain int) (*models.Thumbnail, error) {
+	current, err := s.GetThumbnailRecord(ctx, userID, thumbnailID)
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := s.revisions.Get(ctx, thumbnailID, number)
+	if err != nil {
+		return nil, err
+	}
+
+	next := *current
+	next.Revision = current.Revision + 1
+	next.Prompt = old.Prompt
+	next.LayerDocument = old.LayerDocument
+	next.ObjectKey = old.ObjectKey
+	next.Size = old.Size
+	next.Checksum = old.Checksum
+	next.Renditions = old.Renditions
+	next.PerceptualHash = old.PerceptualHash
+
+	revision := models.NewRevision(&next, changedBy)
+	revision.RestoredFrom = old.Number
+
+	if err := s.commitRevision(ctx, &next, current.Revision, revision); err != nil {
+		return nil, err
+	}
+
+	s.pruneRevisions(ctx, &next, retain)
+	s.index(ctx, &next)
+
+	return &next, s.PresignThumbnail(ctx, &next)
+}
+
+// commitRevision records revision and then moves the thumbnail to it, provided
+// nobody else has moved it from previous in the meantime
+func (s *StorageService) commitRevision(ctx context.Context, thumbnail *models.Thumbnail, previous int, revision *models.Revision) error {
+	if err := s.revisions.Create(ctx, revision); err != nil {
+		return err
+	}
+
+	if err := s.repository.PutIfRevision(ctx, thumbnail, previous); err != nil {
+		if deleteErr := s.revisions.Delete(ctx, revision.ThumbnailID, revision.Number); deleteErr != nil {
+			log.Printf("failed to remove revision %d of %s: %v", revision.Number, revision.ThumbnailID, deleteErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// pruneRevisions deletes the oldest revisions beyond retain, skipping objects
+// a kept revision still uses. Failures are logged; the next revision retries.
+func (s *StorageService) pruneRevisions(ctx context.Context, thumbnail *models.Thumbnail, retain int) {
+	if retain < 1 {
+		retain = 1
+	}
+
+	revisions, err := s.revisions.List(ctx, thumbnail.ID)
+	if err != nil {
+		log.Printf("failed to list revisions of %s: %v", thumbnail.ID, err)
+		return
+	}
+	if len(revisions) <= retain {
+		return
+	}
+
+	kept := map[string]bool{}
+	for _, key := range objectKeys(thumbnail) {
+		kept[key] = true
+	}
+	for _, revision := range revisions[:retain] {
+		for _, key := range revisionObjectKeys(revision) {
+			kept[key] = true
+		}
+	}
+
+	for _, revision := range revisions[retain:] {
+		if revision.Number == thumbnail.Revision {
+			continue
+		}
+
+		var unused []string
+		for _, key := range revisionObjectKeys(revision) {
+			if !kept[key] {
+				unused = append(unused, key)
+			}
+		}
+		s.removeObjects(ctx, unused)
+
+		if err := s.revisions.Delete(ctx, thumbnail.ID, revision.Number); err != nil {
+			log.Printf("failed to prune revision %d of %s: %v", revision.Number, thumbnail.ID, err)
+		}
+	}
+}
+
+// deleteRevisions removes every revision of a thumbnail and its objects
+func (s *StorageService) deleteRevisions(ctx context.Context, thumbnailID string) error {
+	revisions, err := s.revisions.List(ctx, thumbnailID)
+	if err != nil {
+		return err
+	}
+
+	for _, revision := range revisions {
+		for _, key := range revisionObjectKeys(revision) {
+			if err := s.deleteObject(ctx, key); err != nil {
+				return fmt.Errorf("failed to delete revision object: %w", err)
+			}
+		}
+		if err := s.revisions.Delete(ctx, thumbnailID, revision.Number); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func revisionObjectKeys(revision *models.Revision) []string {
+	return objectKeys(&models.Thumbnail{
+		ObjectKey:  revision.ObjectKey,
+		Renditions: revision.Renditions,
+	})
+}
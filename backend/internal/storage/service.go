@@ -3,72 +3,242 @@ package storage
 import (
 	"context"
 	"fmt"
+	"io"
+	"log"
+	"os"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/celebthumb-ai/internal/blob"
 	"github.com/celebthumb-ai/internal/models"
+	"github.com/celebthumb-ai/internal/rendition"
 )
 
 type StorageService struct {
-	s3Client *s3.Client
-	bucket   string
+	store      blob.Store
+	urlExpiry  time.Duration
+	repository *ThumbnailRepository
+	revisions  *RevisionRepository
+	usage      *UsageRepository
+	quotas     QuotaPolicy
+	indexer    Indexer
+	assets     AssetReleaser
+}
+
+// AssetReleaser drops the references a thumbnail holds on source assets once
+// it is gone for good
+type AssetReleaser interface {
+	ReleaseThumbnail(ctx context.Context, thumbnailID string) error
 }
 
 type StorageConfig struct {
-	S3Client *s3.Client
-	Bucket   string
+	// Store holds the image objects. When nil an S3 store is built from
+	// S3Client and Bucket.
+	Store           blob.Store
+	S3Client        *s3.Client
+	Bucket          string
+	DynamoClient    DynamoAPI
+	ThumbnailsTable string
+	RevisionsTable  string
+	// URLExpiry is how long presigned URLs stay valid
+	URLExpiry time.Duration
+	// Indexer, when set, is told about every thumbnail change for search
+	Indexer Indexer
+	// UsageTable enables per-user storage accounting; Quotas, when also set,
+	// enforces limits on it
+	UsageTable string
+	Quotas     QuotaPolicy
+	// Assets, when set, is told when a thumbnail is purged
+	Assets AssetReleaser
 }
 
 func NewStorageService(config StorageConfig) *StorageService {
+	urlExpiry := config.URLExpiry
+	if urlExpiry <= 0 {
+		urlExpiry = urlExpiryFromEnv()
+	}
+
+	store := config.Store
+	if store == nil {
+		store = blob.NewS3Store(blob.S3StoreConfig{
+			S3Client: config.S3Client,
+			Bucket:   config.Bucket,
+		})
+	}
+
+	usageTable := config.UsageTable
+	if usageTable == "" {
+		usageTable = os.Getenv("USAGE_TABLE")
+	}
+
+	var usage *UsageRepository
+	if usageTable != "" {
+		usage = NewUsageRepository(RepositoryConfig{
+			DynamoClient: config.DynamoClient,
+			TableName:    usageTable,
+		})
+	}
+
 	return &StorageService{
-		s3Client: config.S3Client,
-		bucket:   config.Bucket,
+		store:     store,
+		urlExpiry: urlExpiry,
+		repository: NewThumbnailRepository(RepositoryConfig{
+			DynamoClient: config.DynamoClient,
+			TableName:    config.ThumbnailsTable,
+		}),
+		revisions: NewRevisionRepository(RepositoryConfig{
+			DynamoClient: config.DynamoClient,
+			TableName:    config.RevisionsTable,
+		}),
+		usage:   usage,
+		quotas:  config.Quotas,
+		indexer: config.Indexer,
+		assets:  config.Assets,
 	}
 }
 
-// SaveThumbnail stores the generated thumbnail in S3 and returns the URL
+// renditionKey gives every revision its own objects so older revisions stay
+// intact when a thumbnail is edited
+func renditionKey(thumbnail *models.Thumbnail, r *rendition.Rendition) string {
+	return fmt.Sprintf("thumbnails/%s/%s/r%d/%s%s", thumbnail.UserID, thumbnail.ID, thumbnail.Revision, r.Name, r.Extension())
+}
+
+// SaveThumbnail renders the image into its renditions, stores them as revision
+// 1, records the thumbnail's metadata and sets a presigned URL for the JPEG
+// master. If any write fails the objects already written are removed again so
+// no orphaned images are left behind. It fails with ErrQuotaExceeded when the
+// renditions don't fit in the owner's storage quota.
 func (s *StorageService) SaveThumbnail(ctx context.Context, thumbnail *models.Thumbnail, data []byte) error {
-	key := fmt.Sprintf("thumbnails/%s/%s.jpg", thumbnail.UserID, thumbnail.ID)
-
-	// Upload to S3
-	_, err := s.s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(s.bucket),
-		Key:         aws.String(key),
-		Body:        aws.NewReadSeekCloser(data),
-		ContentType: aws.String("image/jpeg"),
-		Metadata: map[string]string{
-			"userId":      thumbnail.UserID,
-			"videoTitle":  thumbnail.VideoTitle,
-			"style":       thumbnail.Style,
-			"created":     thumbnail.CreatedAt.Format(time.RFC3339),
-		},
-	})
+	if thumbnail.Status == "" {
+		thumbnail.Status = models.ThumbnailStatusReady
+	}
+	// Stored in UTC so createdAt sorts correctly in the byUser index
+	thumbnail.CreatedAt = thumbnail.CreatedAt.UTC()
+	thumbnail.Revision = 1
+
+	keys, err := s.storeRenditions(ctx, thumbnail, data)
 	if err != nil {
-		return fmt.Errorf("failed to upload thumbnail: %w", err)
+		return err
 	}
 
-	// Set the public URL
-	thumbnail.URL = fmt.Sprintf("https://%s.s3.amazonaws.com/%s", s.bucket, key)
-	return nil
+	revision := models.NewRevision(thumbnail, thumbnail.UserID)
+	if err := s.revisions.Create(ctx, revision); err != nil {
+		s.removeObjects(ctx, keys)
+		return err
+	}
+
+	if err := s.repository.Put(ctx, thumbnail); err != nil {
+		if deleteErr := s.revisions.Delete(ctx, thumbnail.ID, revision.Number); deleteErr != nil {
+			log.Printf("failed to remove revision of %s: %v", thumbnail.ID, deleteErr)
+		}
+		s.removeObjects(ctx, keys)
+		return err
+	}
+	s.index(ctx, thumbnail)
+
+	return s.PresignThumbnail(ctx, thumbnail)
 }
 
-// GetThumbnail retrieves a thumbnail from S3
-func (s *StorageService) GetThumbnail(ctx context.Context, userID, thumbnailID string) ([]byte, error) {
-	key := fmt.Sprintf("thumbnails/%s/%s.jpg", userID, thumbnailID)
+// storeRenditions writes every rendition of data and points thumbnail at them.
+// It returns the keys written so the caller can undo them.
+func (s *StorageService) storeRenditions(ctx context.Context, thumbnail *models.Thumbnail, data []byte) ([]string, error) {
+	src, err := rendition.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render thumbnail: %w", err)
+	}
+	renditions, err := rendition.RenderImage(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render thumbnail: %w", err)
+	}
+
+	var size int64
+	for _, r := range renditions {
+		size += int64(len(r.Data))
+	}
+	if err := s.checkQuota(ctx, thumbnail.UserID, size, len(renditions)); err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]string{
+		"userId":     thumbnail.UserID,
+		"videoTitle": thumbnail.VideoTitle,
+		"style":      thumbnail.Style,
+		"created":    thumbnail.CreatedAt.Format(time.RFC3339),
+	}
+
+	keys := make([]string, 0, len(renditions))
+	records := make([]models.Rendition, 0, len(renditions))
+	for _, r := range renditions {
+		key := renditionKey(thumbnail, r)
+		info, err := s.putObject(ctx, key, r.Data, blob.PutOptions{
+			ContentType: r.ContentType,
+			Metadata:    metadata,
+		})
+		if err != nil {
+			s.removeObjects(ctx, keys)
+			return nil, fmt.Errorf("failed to upload thumbnail: %w", err)
+		}
+		keys = append(keys, key)
+
+		records = append(records, models.Rendition{
+			Name:        r.Name,
+			Format:      r.Format,
+			ContentType: r.ContentType,
+			Key:         key,
+			Width:       r.Width,
+			Height:      r.Height,
+			Size:        info.Size,
+		})
+
+		// The JPEG master is what GET /thumbnails/{id} serves by default
+		if r.Name == rendition.Master.Name && r.Format == rendition.FormatJPEG {
+			thumbnail.ObjectKey = key
+			thumbnail.Size = info.Size
+			thumbnail.Checksum = info.Checksum
+		}
+	}
+	thumbnail.Renditions = records
+	thumbnail.PerceptualHash = rendition.FormatHash(rendition.DifferenceHash(src))
+
+	return keys, nil
+}
 
-	result, err := s.s3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
-	})
+// GetThumbnailRecord returns the metadata of a thumbnail owned by userID.
+// Thumbnails owned by someone else or in the trash are reported as not found.
+func (s *StorageService) GetThumbnailRecord(ctx context.Context, userID, thumbnailID string) (*models.Thumbnail, error) {
+	thumbnail, err := s.ownedThumbnail(ctx, userID, thumbnailID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get thumbnail: %w", err)
+		return nil, err
+	}
+	if thumbnail.Status == models.ThumbnailStatusDeleted {
+		return nil, ErrThumbnailNotFound
 	}
-	defer result.Body.Close()
 
-	// Read the entire body
-	data := make([]byte, *result.ContentLength)
-	_, err = result.Body.Read(data)
+	return thumbnail, nil
+}
+
+// ownedThumbnail returns a thumbnail owned by userID whatever its status
+func (s *StorageService) ownedThumbnail(ctx context.Context, userID, thumbnailID string) (*models.Thumbnail, error) {
+	thumbnail, err := s.repository.Get(ctx, thumbnailID)
+	if err != nil {
+		return nil, err
+	}
+	if thumbnail.UserID != userID {
+		return nil, ErrThumbnailNotFound
+	}
+
+	return thumbnail, nil
+}
+
+// GetThumbnail reads a whole thumbnail into memory
+func (s *StorageService) GetThumbnail(ctx context.Context, userID, thumbnailID string) ([]byte, error) {
+	object, err := s.OpenThumbnail(ctx, userID, thumbnailID, ReadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Body.Close()
+
+	data, err := io.ReadAll(object.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read thumbnail data: %w", err)
 	}
@@ -78,42 +248,112 @@ func (s *StorageService) GetThumbnail(ctx context.Context, userID, thumbnailID s
 
 // ListUserThumbnails gets all thumbnails for a user
 func (s *StorageService) ListUserThumbnails(ctx context.Context, userID string) ([]*models.Thumbnail, error) {
-	prefix := fmt.Sprintf("thumbnails/%s/", userID)
-
-	result, err := s.s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
-		Bucket: aws.String(s.bucket),
-		Prefix: aws.String(prefix),
-	})
+	thumbnails, err := s.repository.ListByUser(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list thumbnails: %w", err)
+		return nil, err
 	}
 
-	thumbnails := make([]*models.Thumbnail, 0, len(result.Contents))
-	for _, obj := range result.Contents {
-		// Parse metadata from object
-		thumbnail := &models.Thumbnail{
-			ID:        *obj.Key,
-			UserID:    userID,
-			URL:       fmt.Sprintf("https://%s.s3.amazonaws.com/%s", s.bucket, *obj.Key),
-			CreatedAt: *obj.LastModified,
-		}
-		thumbnails = append(thumbnails, thumbnail)
+	if err := s.presignThumbnails(ctx, thumbnails); err != nil {
+		return nil, err
 	}
-
 	return thumbnails, nil
 }
 
-// DeleteThumbnail removes a thumbnail from storage
-func (s *StorageService) DeleteThumbnail(ctx context.Context, userID, thumbnailID string) error {
-	key := fmt.Sprintf("thumbnails/%s/%s.jpg", userID, thumbnailID)
+// ListThumbnails gets one page of a user's thumbnails
+func (s *StorageService) ListThumbnails(ctx context.Context, userID string, opts ListOptions) (*ThumbnailPage, error) {
+	page, err := s.repository.List(ctx, userID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.presignThumbnails(ctx, page.Items); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
 
-	_, err := s.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
-	})
+// DeleteThumbnail moves a thumbnail to the trash. It disappears from listings
+// and reads until it is restored, and its objects are kept until it is purged.
+func (s *StorageService) DeleteThumbnail(ctx context.Context, userID, thumbnailID string) error {
+	thumbnail, err := s.GetThumbnailRecord(ctx, userID, thumbnailID)
 	if err != nil {
-		return fmt.Errorf("failed to delete thumbnail: %w", err)
+		return err
+	}
+
+	return s.repository.SetTrashed(ctx, thumbnail.ID, time.Now())
+}
+
+// purgeThumbnail permanently removes a thumbnail's record, its objects and its
+// revisions. The record goes first so a thumbnail is never listed without an
+// image; if an object can't be deleted the record is restored.
+func (s *StorageService) purgeThumbnail(ctx context.Context, thumbnail *models.Thumbnail) error {
+	if err := s.repository.Delete(ctx, thumbnail.ID); err != nil {
+		return err
+	}
+
+	if err := s.deleteObjects(ctx, thumbnail); err != nil {
+		// Deleting a missing object succeeds, so a retry after the restore
+		// finishes the job
+		if restoreErr := s.repository.Put(ctx, thumbnail); restoreErr != nil {
+			log.Printf("failed to restore thumbnail record %s: %v", thumbnail.ID, restoreErr)
+		}
+		return err
 	}
+	s.unindex(ctx, thumbnail.ID)
+	s.releaseAssets(ctx, thumbnail.ID)
 
 	return nil
-}
\ No newline at end of file
+}
+
+// releaseAssets drops a purged thumbnail's asset references. A failure only
+// keeps an asset alive, so it is logged rather than failing the purge.
+func (s *StorageService) releaseAssets(ctx context.Context, thumbnailID string) {
+	if s.assets == nil {
+		return
+	}
+	if err := s.assets.ReleaseThumbnail(ctx, thumbnailID); err != nil {
+		log.Printf("failed to release assets of thumbnail %s: %v", thumbnailID, err)
+	}
+}
+
+// deleteObjects removes a thumbnail's current objects and all its revisions
+func (s *StorageService) deleteObjects(ctx context.Context, thumbnail *models.Thumbnail) error {
+	for _, key := range objectKeys(thumbnail) {
+		if err := s.deleteObject(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete thumbnail: %w", err)
+		}
+	}
+
+	return s.deleteRevisions(ctx, thumbnail.ID)
+}
+
+// objectKeys lists every object a thumbnail record points at
+func objectKeys(thumbnail *models.Thumbnail) []string {
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, key := range append([]string{thumbnail.ObjectKey}, renditionKeys(thumbnail.Renditions)...) {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func renditionKeys(renditions []models.Rendition) []string {
+	keys := make([]string, 0, len(renditions))
+	for _, r := range renditions {
+		keys = append(keys, r.Key)
+	}
+	return keys
+}
+
+// removeObjects deletes objects written by a failed save. Failures are only
+// logged since the caller is already returning the original error.
+func (s *StorageService) removeObjects(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.deleteObject(ctx, key); err != nil {
+			log.Printf("failed to remove orphaned object %s: %v", key, err)
+		}
+	}
+}
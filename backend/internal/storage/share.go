@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/celebthumb-ai/internal/rendition"
+)
+
+// sharePrefix holds the watermarked previews rendered for one share link
+func sharePrefix(userID, token string) string {
+	return fmt.Sprintf("shares/%s/%s/", userID, token)
+}
+
+// SharePreview returns a presigned URL for a watermarked preview of
+// thumbnail. The preview is rendered the first time a link shows it and kept
+// under the link's prefix; a new revision gets a new preview.
+func (s *StorageService) SharePreview(ctx context.Context, token string, thumbnail *models.Thumbnail) (*blob.PresignedRequest, error) {
+	key := fmt.Sprintf("%s%s-r%d.jpg", sharePrefix(thumbnail.UserID, token), thumbnail.ID, thumbnail.Revision)
+
+	_, err := s.store.Head(ctx, key)
+	if errors.Is(err, blob.ErrNotFound) {
+		err = s.renderPreview(ctx, thumbnail, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := s.store.PresignGet(ctx, key, s.urlExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign preview: %w", err)
+	}
+	return req, nil
+}
+
+// DeleteSharePreviews removes every preview rendered for a share link
+func (s *StorageService) DeleteSharePreviews(ctx context.Context, userID, token string) error {
+	return s.deletePrefix(ctx, sharePrefix(userID, token))
+}
+
+// renderPreview watermarks the thumbnail's medium JPEG, or its master when
+// it has no renditions, and stores the result under key. Previews don't count
+// towards the owner's storage usage.
+func (s *StorageService) renderPreview(ctx context.Context, thumbnail *models.Thumbnail, key string) error {
+	source := thumbnail.ObjectKey
+	for _, r := range thumbnail.Renditions {
+		if r.Name == rendition.Medium.Name && r.Format == rendition.FormatJPEG {
+			source = r.Key
+		}
+	}
+
+	object, err := s.store.Get(ctx, source, blob.GetOptions{})
+	if errors.Is(err, blob.ErrNotFound) {
+		return ErrThumbnailNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get thumbnail: %w", err)
+	}
+	data, err := io.ReadAll(object.Body)
+	object.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read thumbnail data: %w", err)
+	}
+
+	src, err := rendition.Decode(data)
+	if err != nil {
+		return fmt.Errorf("failed to render preview: %w", err)
+	}
+	preview, err := rendition.Preview(src)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.store.Put(ctx, key, bytes.NewReader(preview.Data), blob.PutOptions{ContentType: preview.ContentType})
+	if err != nil {
+		return fmt.Errorf("failed to store preview: %w", err)
+	}
+	return nil
+}
+
+// SharedThumbnails returns the live thumbnails of userID's in a collection,
+// oldest first and without URLs, for a share link to preview
+func (s *StorageService) SharedThumbnails(ctx context.Context, userID, collectionID string) ([]*models.Thumbnail, error) {
+	thumbnails := []*models.Thumbnail{}
+
+	opts := ListOptions{Limit: MaxPageSize, Ascending: true, CollectionID: collectionID}
+	for {
+		page, err := s.repository.List(ctx, userID, opts)
+		if err != nil {
+			return nil, err
+		}
+		thumbnails = append(thumbnails, page.Items...)
+		if page.NextCursor == "" {
+			return thumbnails, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"sort"
+
+	"github.com/celebthumb-ai/internal/models"
+	"github.com/celebthumb-ai/internal/rendition"
+)
+
+const (
+	// NearDuplicateDistance is the largest perceptual hash distance at which
+	// two thumbnails are flagged as near-duplicates
+	NearDuplicateDistance = 6
+	// MaxSimilarDistance caps the distance FindSimilar accepts; beyond it the
+	// matches are mostly noise
+	MaxSimilarDistance = 16
+)
+
+// Similar is a thumbnail that looks like another one. Distance is the number
+// of differing perceptual hash bits.
+type Similar struct {
+	Thumbnail *models.Thumbnail `json:"thumbnail"`
+	Distance  int               `json:"distance"`
+}
+
+// FindSimilar returns the user's other thumbnails within maxDistance of
+// thumbnailID's perceptual hash, closest first. Thumbnails saved before
+// hashes were recorded are never matched.
+func (s *StorageService) FindSimilar(ctx context.Context, userID, thumbnailID string, maxDistance int) ([]*Similar, error) {
+	thumbnail, err := s.GetThumbnailRecord(ctx, userID, thumbnailID)
+	if err != nil {
+		return nil, err
+	}
+
+	similar, err := s.similarTo(ctx, thumbnail, maxDistance)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, match := range similar {
+		if err := s.PresignThumbnail(ctx, match.Thumbnail); err != nil {
+			return nil, err
+		}
+	}
+	return similar, nil
+}
+
+// NearDuplicates returns the ids of the owner's thumbnails that are
+// near-duplicates of thumbnail
+func (s *StorageService) NearDuplicates(ctx context.Context, thumbnail *models.Thumbnail) ([]string, error) {
+	similar, err := s.similarTo(ctx, thumbnail, NearDuplicateDistance)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(similar))
+	for _, match := range similar {
+		ids = append(ids, match.Thumbnail.ID)
+	}
+	return ids, nil
+}
+
+// similarTo compares thumbnail against the rest of its owner's library. A
+// 64-bit hash can't be range-queried, so the library is scanned.
+func (s *StorageService) similarTo(ctx context.Context, thumbnail *models.Thumbnail, maxDistance int) ([]*Similar, error) {
+	similar := []*Similar{}
+	if thumbnail.PerceptualHash == "" {
+		return similar, nil
+	}
+	hash, err := rendition.ParseHash(thumbnail.PerceptualHash)
+	if err != nil {
+		return similar, nil
+	}
+
+	if maxDistance < 0 || maxDistance > MaxSimilarDistance {
+		maxDistance = MaxSimilarDistance
+	}
+
+	library, err := s.repository.ListByUser(ctx, thumbnail.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, other := range library {
+		if other.ID == thumbnail.ID || other.PerceptualHash == "" {
+			continue
+		}
+		otherHash, err := rendition.ParseHash(other.PerceptualHash)
+		if err != nil {
+			continue
+		}
+		if distance := rendition.HashDistance(hash, otherHash); distance <= maxDistance {
+			similar = append(similar, &Similar{Thumbnail: other, Distance: distance})
+		}
+	}
+
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similar[i].Distance < similar[j].Distance
+	})
+	return similar, nil
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/celebthumb-ai/internal/models"
+)
+
+var ErrInvalidTags = errors.New("invalid tags")
+
+const (
+	MaxTags      = 20
+	MaxTagLength = 40
+)
+
+// Indexer keeps a search index in step with thumbnail records
+type Indexer interface {
+	Index(ctx context.Context, thumbnail *models.Thumbnail) error
+	Remove(ctx context.Context, thumbnailID string) error
+}
+
+// NormalizeTags lowercases, trims and de-duplicates tags, keeping their order.
+// Empty tags are dropped; too many or too long tags are rejected.
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len([]rune(tag)) > MaxTagLength {
+			return nil, ErrInvalidTags
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > MaxTags {
+		return nil, ErrInvalidTags
+	}
+
+	return normalized, nil
+}
+
+// SetTags replaces the user's tags on a thumbnail
+func (s *StorageService) SetTags(ctx context.Context, userID, thumbnailID string, tags []string) (*models.Thumbnail, error) {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail, err := s.GetThumbnailRecord(ctx, userID, thumbnailID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repository.SetTags(ctx, thumbnail.ID, tags); err != nil {
+		return nil, err
+	}
+	thumbnail.Tags = tags
+	s.index(ctx, thumbnail)
+
+	return thumbnail, s.PresignThumbnail(ctx, thumbnail)
+}
+
+// GetThumbnails loads the user's thumbnails with the given ids, in that order.
+// Missing, trashed or foreign ids are skipped, so a stale search hit never
+// surfaces.
+func (s *StorageService) GetThumbnails(ctx context.Context, userID string, thumbnailIDs []string) ([]*models.Thumbnail, error) {
+	thumbnails := make([]*models.Thumbnail, 0, len(thumbnailIDs))
+	for _, id := range thumbnailIDs {
+		thumbnail, err := s.GetThumbnailRecord(ctx, userID, id)
+		if errors.Is(err, ErrThumbnailNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		thumbnails = append(thumbnails, thumbnail)
+	}
+
+	if err := s.presignThumbnails(ctx, thumbnails); err != nil {
+		return nil, err
+	}
+	return thumbnails, nil
+}
+
+// Reindex rewrites the search index entries of every thumbnail the user has,
+// for thumbnails created before search existed
+func (s *StorageService) Reindex(ctx context.Context, userID string) (int, error) {
+	if s.indexer == nil {
+		return 0, nil
+	}
+
+	thumbnails, err := s.repository.ListByUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, thumbnail := range thumbnails {
+		if err := s.indexer.Index(ctx, thumbnail); err != nil {
+			return i, err
+		}
+	}
+
+	return len(thumbnails), nil
+}
+
+// index updates the search index after a thumbnail changed. The index can be
+// rebuilt with Reindex, so a failure is logged rather than failing the change.
+func (s *StorageService) index(ctx context.Context, thumbnail *models.Thumbnail) {
+	if s.indexer == nil {
+		return
+	}
+	if err := s.indexer.Index(ctx, thumbnail); err != nil {
+		log.Printf("failed to index thumbnail %s: %v", thumbnail.ID, err)
+	}
+}
+
+// unindex removes a purged thumbnail from the search index
+func (s *StorageService) unindex(ctx context.Context, thumbnailID string) {
+	if s.indexer == nil {
+		return
+	}
+	if err := s.indexer.Remove(ctx, thumbnailID); err != nil {
+		log.Printf("failed to remove thumbnail %s from search index: %v", thumbnailID, err)
+	}
+}
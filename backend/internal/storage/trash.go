@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/celebthumb-ai/internal/models"
+)
+
+// DefaultTrashRetention is how long trashed thumbnails are kept before the
+// purge job removes them
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// TrashRetentionFromEnv reads TRASH_RETENTION as a Go duration, e.g. "720h"
+func TrashRetentionFromEnv() time.Duration {
+	retention, err := time.ParseDuration(os.Getenv("TRASH_RETENTION"))
+	if err != nil || retention <= 0 {
+		return DefaultTrashRetention
+	}
+	return retention
+}
+
+// ListTrash gets one page of a user's trashed thumbnails
+func (s *StorageService) ListTrash(ctx context.Context, userID string, opts ListOptions) (*ThumbnailPage, error) {
+	opts.Status = models.ThumbnailStatusDeleted
+	return s.ListThumbnails(ctx, userID, opts)
+}
+
+// RestoreThumbnail takes a thumbnail back out of the trash
+func (s *StorageService) RestoreThumbnail(ctx context.Context, userID, thumbnailID string) (*models.Thumbnail, error) {
+	thumbnail, err := s.trashedThumbnail(ctx, userID, thumbnailID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repository.ClearTrashed(ctx, thumbnail.ID); err != nil {
+		return nil, err
+	}
+	thumbnail.Status = models.ThumbnailStatusReady
+	thumbnail.DeletedAt = nil
+
+	return thumbnail, s.PresignThumbnail(ctx, thumbnail)
+}
+
+// DeletePermanently purges a trashed thumbnail straight away
+func (s *StorageService) DeletePermanently(ctx context.Context, userID, thumbnailID string) error {
+	thumbnail, err := s.trashedThumbnail(ctx, userID, thumbnailID)
+	if err != nil {
+		return err
+	}
+
+	return s.purgeThumbnail(ctx, thumbnail)
+}
+
+// PurgeTrash permanently deletes every thumbnail trashed more than retention
+// ago. One failure doesn't stop the run; the count of purged thumbnails is
+// returned along with the first error.
+func (s *StorageService) PurgeTrash(ctx context.Context, retention time.Duration) (int, error) {
+	thumbnails, err := s.repository.ListTrashedBefore(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	var firstErr error
+	for _, thumbnail := range thumbnails {
+		if err := s.purgeThumbnail(ctx, thumbnail); err != nil {
+			log.Printf("failed to purge thumbnail %s: %v", thumbnail.ID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		purged++
+	}
+
+	return purged, firstErr
+}
+
+func (s *StorageService) trashedThumbnail(ctx context.Context, userID, thumbnailID string) (*models.Thumbnail, error) {
+	thumbnail, err := s.ownedThumbnail(ctx, userID, thumbnailID)
+	if err != nil {
+		return nil, err
+	}
+	if thumbnail.Status != models.ThumbnailStatusDeleted {
+		return nil, ErrThumbnailNotFound
+	}
+
+	return thumbnail, nil
+}
@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/celebthumb-ai/internal/blob"
+	"github.com/celebthumb-ai/internal/models"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// QuotaPolicy supplies each user's storage limits, typically from their plan
+type QuotaPolicy interface {
+	StorageQuota(ctx context.Context, userID string) (models.Quota, error)
+}
+
+// UsageRepository keeps per-user storage counters in the UsageTable. The
+// counters are adjusted as objects are written and deleted, and periodically
+// recomputed from the bucket to correct any drift.
+type UsageRepository struct {
+	dynamoClient DynamoAPI
+	tableName    string
+}
+
+func NewUsageRepository(config RepositoryConfig) *UsageRepository {
+	tableName := config.TableName
+	if tableName == "" {
+		tableName = os.Getenv("USAGE_TABLE")
+	}
+
+	return &UsageRepository{
+		dynamoClient: config.DynamoClient,
+		tableName:    tableName,
+	}
+}
+
+// Get returns a user's usage; a user who never stored anything has none
+func (r *UsageRepository) Get(ctx context.Context, userID string) (*models.Usage, error) {
+	resp, err := r.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage: %w", err)
+	}
+
+	usage := models.Usage{UserID: userID}
+	if err := attributevalue.UnmarshalMap(resp.Item, &usage); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal usage: %w", err)
+	}
+
+	return &usage, nil
+}
+
+// Add adjusts a user's counters by the given (possibly negative) amounts
+func (r *UsageRepository) Add(ctx context.Context, userID string, size int64, objects int) error {
+	_, err := r.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userID},
+		},
+		UpdateExpression: aws.String("ADD #bytes :bytes, objects :objects"),
+		ExpressionAttributeNames: map[string]string{
+			"#bytes": "bytes",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":bytes":   &types.AttributeValueMemberN{Value: strconv.FormatInt(size, 10)},
+			":objects": &types.AttributeValueMemberN{Value: strconv.Itoa(objects)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update usage: %w", err)
+	}
+
+	return nil
+}
+
+// Put replaces a user's counters
+func (r *UsageRepository) Put(ctx context.Context, usage *models.Usage) error {
+	item, err := attributevalue.MarshalMap(usage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage: %w", err)
+	}
+
+	_, err = r.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(r.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save usage: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes a user's counters
+func (r *UsageRepository) Delete(ctx context.Context, userID string) error {
+	_, err := r.dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete usage: %w", err)
+	}
+
+	return nil
+}
+
+// UserIDs lists every user with counters
+func (r *UsageRepository) UserIDs(ctx context.Context) ([]string, error) {
+	var userIDs []string
+
+	paginator := dynamodb.NewScanPaginator(r.dynamoClient, &dynamodb.ScanInput{
+		TableName:            aws.String(r.tableName),
+		ProjectionExpression: aws.String("userId"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan usage: %w", err)
+		}
+
+		var items []models.Usage
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal usage: %w", err)
+		}
+		for _, item := range items {
+			userIDs = append(userIDs, item.UserID)
+		}
+	}
+
+	return userIDs, nil
+}
+
+// usagePrefix holds every object counted against a user's quota
+const usagePrefix = "thumbnails/"
+
+// objectOwner returns the user a metered object belongs to
+func objectOwner(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, usagePrefix)
+	if !ok {
+		return "", false
+	}
+	userID, _, ok := strings.Cut(rest, "/")
+	if !ok || userID == "" {
+		return "", false
+	}
+	return userID, true
+}
+
+// GetUsage returns what the user has stored along with their quota
+func (s *StorageService) GetUsage(ctx context.Context, userID string) (*models.Usage, error) {
+	usage := &models.Usage{UserID: userID}
+	if s.usage != nil {
+		var err error
+		if usage, err = s.usage.Get(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.quotas != nil {
+		quota, err := s.quotas.StorageQuota(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		usage.Quota = &quota
+	}
+
+	return usage, nil
+}
+
+// CheckQuota fails with ErrQuotaExceeded when the user has no room left for
+// another object, so callers can refuse work before doing it
+func (s *StorageService) CheckQuota(ctx context.Context, userID string) error {
+	return s.checkQuota(ctx, userID, 1, 1)
+}
+
+// checkQuota fails with ErrQuotaExceeded if storing size more bytes in objects
+// more objects would take the user over their quota. Without usage tracking or a
+// quota policy nothing is enforced.
+func (s *StorageService) checkQuota(ctx context.Context, userID string, size int64, objects int) error {
+	if s.usage == nil || s.quotas == nil {
+		return nil
+	}
+
+	usage, err := s.GetUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	quota := usage.Quota
+	if quota.Bytes > 0 && usage.Bytes+size > quota.Bytes {
+		return ErrQuotaExceeded
+	}
+	if quota.Objects > 0 && usage.Objects+objects > quota.Objects {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+// recordUsage adjusts the owner's counters for a written or deleted object.
+// A failed update is only logged; reconciliation corrects the drift.
+func (s *StorageService) recordUsage(ctx context.Context, key string, size int64, objects int) {
+	if s.usage == nil {
+		return
+	}
+
+	userID, ok := objectOwner(key)
+	if !ok {
+		return
+	}
+
+	if err := s.usage.Add(ctx, userID, size, objects); err != nil {
+		log.Printf("failed to record usage of %s: %v", key, err)
+	}
+}
+
+// putObject writes a metered object
+func (s *StorageService) putObject(ctx context.Context, key string, data []byte, opts blob.PutOptions) (*blob.ObjectInfo, error) {
+	info, err := s.store.Put(ctx, key, bytes.NewReader(data), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordUsage(ctx, key, info.Size, 1)
+	return info, nil
+}
+
+// deleteObject deletes a metered object. Its size is read first so the
+// counters only drop for objects that actually existed.
+func (s *StorageService) deleteObject(ctx context.Context, key string) error {
+	if s.usage == nil {
+		return s.store.Delete(ctx, key)
+	}
+
+	info, err := s.store.Head(ctx, key)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	s.recordUsage(ctx, key, -info.Size, -1)
+	return nil
+}
+
+// ReconcileUsage recomputes every user's counters from the objects actually
+// in the bucket and returns how many users it updated. Writes that happen
+// while it runs may be missed until the next run.
+func (s *StorageService) ReconcileUsage(ctx context.Context) (int, error) {
+	if s.usage == nil {
+		return 0, nil
+	}
+
+	// Users with counters but no objects left are reset to zero
+	known, err := s.usage.UserIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	totals := make(map[string]*models.Usage, len(known))
+	for _, userID := range known {
+		totals[userID] = &models.Usage{UserID: userID}
+	}
+
+	opts := blob.ListOptions{}
+	for {
+		result, err := s.store.List(ctx, usagePrefix, opts)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		for _, object := range result.Objects {
+			userID, ok := objectOwner(object.Key)
+			if !ok {
+				continue
+			}
+			usage, ok := totals[userID]
+			if !ok {
+				usage = &models.Usage{UserID: userID}
+				totals[userID] = usage
+			}
+			usage.Bytes += object.Size
+			usage.Objects++
+		}
+
+		if result.NextToken == "" {
+			break
+		}
+		opts.Token = result.NextToken
+	}
+
+	now := time.Now().UTC()
+	for _, usage := range totals {
+		usage.ReconciledAt = &now
+		if err := s.usage.Put(ctx, usage); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(totals), nil
+}